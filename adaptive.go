@@ -0,0 +1,92 @@
+package cmgs3
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// AdaptiveLatencyTarget is the time to the response headers above
+	// which AdaptiveConcurrency takes a request as a sign of overload.
+	AdaptiveLatencyTarget = time.Second
+	// AdaptiveInitialRequests is the limit AdaptiveConcurrency starts
+	// from.
+	AdaptiveInitialRequests = 8
+	// AdaptiveMaxRequests bounds the limit of AdaptiveConcurrency without
+	// MaxConcurrentRequests.
+	AdaptiveMaxRequests = 256
+)
+
+// aimdLimiter bounds the requests in flight by a limit that grows by one
+// for every limit requests answered in time while at least half of it is
+// in use, and halves on overload: throttling, failures and answers slower
+// than AdaptiveLatencyTarget.
+type aimdLimiter struct {
+	gate priorityGate
+
+	mu      sync.Mutex
+	limit   float64
+	max     int
+	lastCut time.Time
+}
+
+func newAIMDLimiter(ceiling int) *aimdLimiter {
+	l := &aimdLimiter{limit: float64(min(AdaptiveInitialRequests, ceiling)), max: ceiling}
+	l.gate.limit = int(l.limit)
+	return l
+}
+
+// acquire waits for a request slot, by priority.
+func (l *aimdLimiter) acquire(ctx context.Context, prio Priority) error {
+	return l.gate.acquire(ctx, prio)
+}
+
+// release frees the slot of a request that started at start, adapting the
+// limit to whether it met overload.
+func (l *aimdLimiter) release(start time.Time, overload bool) {
+	l.gate.mu.Lock()
+	inflight := l.gate.inflight
+	l.gate.mu.Unlock()
+
+	l.mu.Lock()
+	switch {
+	case overload:
+		// Requests started before the last cut saw the same overload.
+		if start.After(l.lastCut) {
+			l.limit = max(l.limit/2, 1)
+			l.lastCut = time.Now()
+		}
+	case inflight*2 >= int(l.limit):
+		// Only a limit in use is known to be sustainable.
+		l.limit = min(l.limit+1/l.limit, float64(l.max))
+	}
+	limit := int(l.limit)
+	l.mu.Unlock()
+	l.gate.setLimit(limit)
+	l.gate.release()
+}
+
+// adaptiveTransport bounds the requests in flight with an aimdLimiter. As
+// with limitTransport, a request counts until its response body is
+// closed, while its latency is that of the response headers.
+type adaptiveTransport struct {
+	limiter *aimdLimiter
+	base    http.RoundTripper
+}
+
+func (t *adaptiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.acquire(req.Context(), PriorityFrom(req.Context())); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.limiter.release(start, req.Context().Err() == nil)
+		return nil, err
+	}
+	overload := time.Since(start) > AdaptiveLatencyTarget || resp.StatusCode >= 500 || isSlowDown(resp)
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { t.limiter.release(start, overload) }}
+	return resp, nil
+}
@@ -0,0 +1,108 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAIMDLimiter(t *testing.T) {
+	l := newAIMDLimiter(16)
+	ctx := context.Background()
+	if l.limit != float64(AdaptiveInitialRequests) {
+		t.Fatalf("initial limit %g", l.limit)
+	}
+
+	// Saturated and answered in time, the limit grows.
+	for round := 0; round < 8; round++ {
+		n := int(l.limit)
+		for i := 0; i < n; i++ {
+			l.acquire(ctx, PriorityMaintenance)
+		}
+		for i := 0; i < n; i++ {
+			l.release(time.Now(), false)
+		}
+	}
+	if l.limit < float64(AdaptiveInitialRequests+2) || l.limit > float64(AdaptiveInitialRequests+8) {
+		t.Errorf("limit %g after 8 saturated rounds, expected 2 to 8 more than %d", l.limit, AdaptiveInitialRequests)
+	}
+
+	// Idle capacity is not grown.
+	before := l.limit
+	l.acquire(ctx, PriorityMaintenance)
+	l.release(time.Now(), false)
+	if l.limit != before {
+		t.Errorf("limit grew from %g to %g without being used", before, l.limit)
+	}
+
+	// Overload halves it once for the requests in flight meanwhile.
+	before = l.limit
+	start := time.Now()
+	l.acquire(ctx, PriorityMaintenance)
+	l.acquire(ctx, PriorityMaintenance)
+	l.release(start, true)
+	l.release(start, true)
+	if l.limit != before/2 {
+		t.Errorf("limit %g after overload, expected %g", l.limit, before/2)
+	}
+	for i := 0; i < 10; i++ {
+		l.acquire(ctx, PriorityMaintenance)
+		l.release(time.Now(), true)
+	}
+	if l.limit != 1 {
+		t.Errorf("limit %g, expected to bottom out at 1", l.limit)
+	}
+
+	// Requests wait for a slot.
+	l.acquire(ctx, PriorityMaintenance)
+	wctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(wctx, PriorityMaintenance); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire() beyond the limit = %v, expected to wait", err)
+	}
+	acquired := make(chan error)
+	go func() { acquired <- l.acquire(ctx, PriorityMaintenance) }()
+	l.release(time.Now(), false)
+	if err := <-acquired; err != nil {
+		t.Errorf("acquire() after release() = %v", err)
+	}
+}
+
+func TestAdaptiveTransport(t *testing.T) {
+	var throttle atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if throttle.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, slowDownBody)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	at := &adaptiveTransport{limiter: newAIMDLimiter(64), base: http.DefaultTransport}
+	get := func() {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		resp, err := at.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	get()
+	before := at.limiter.limit
+	throttle.Store(true)
+	get()
+	if at.limiter.limit != before/2 {
+		t.Errorf("limit %g after SlowDown, expected %g", at.limiter.limit, before/2)
+	}
+	if at.limiter.gate.inflight != 0 {
+		t.Errorf("%d requests in flight after their bodies were closed", at.limiter.gate.inflight)
+	}
+}
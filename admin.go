@@ -0,0 +1,179 @@
+package cmgs3
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// adminHealthTimeout bounds the request made by the health endpoint.
+const adminHealthTimeout = 5 * time.Second
+
+// redactedOpts are the S3Opts fields whose values AdminHandler hides.
+var redactedOpts = map[string]bool{
+	"SecretAccessKey": true,
+	"SessionToken":    true,
+	"EncryptionKey":   true,
+	"ManifestKey":     true,
+}
+
+// AdminHandler returns a read-only handler serving the state of the
+// storage as JSON, to be mounted by the application, e.g. with
+// http.StripPrefix:
+//
+//	/health  whether the bucket answers, 503 if not
+//	/stats   retries, cache, quota usage, queued writes and replicas
+//	/locks   the locks held by this instance, as HeldLocks
+//	/config  the options, with secrets redacted
+//
+// It does no authentication, so it should not be exposed publicly.
+func (gs *S3Storage) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", gs.adminHealth)
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, http.StatusOK, gs.adminStats())
+	})
+	mux.HandleFunc("/locks", func(w http.ResponseWriter, r *http.Request) {
+		locks := []adminLock{}
+		for _, l := range gs.HeldLocks() {
+			locks = append(locks, adminLock{Key: l.Key, Acquired: l.Acquired, AgeSeconds: l.Age.Seconds()})
+		}
+		writeAdminJSON(w, http.StatusOK, locks)
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, http.StatusOK, redactOpts(gs.currentOpts()))
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeAdminJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+type adminHealth struct {
+	OK        bool    `json:"ok"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// adminHealth checks that the bucket answers with Ping, which needs no
+// write permission.
+func (gs *S3Storage) adminHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminHealthTimeout)
+	defer cancel()
+	start := time.Now()
+	err := gs.Ping(ctx)
+	h := adminHealth{OK: err == nil, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	code := http.StatusOK
+	if err != nil {
+		h.Error = err.Error()
+		code = http.StatusServiceUnavailable
+	}
+	writeAdminJSON(w, code, h)
+}
+
+type adminLock struct {
+	Key        string    `json:"key"`
+	Acquired   time.Time `json:"acquired"`
+	AgeSeconds float64   `json:"age_seconds"`
+}
+
+type adminStats struct {
+	HeldLocks    int            `json:"held_locks"`
+	Retries      *RetryStats    `json:"retries,omitempty"`
+	Cache        *CacheStats    `json:"cache,omitempty"`
+	Quota        *adminQuota    `json:"quota,omitempty"`
+	QueuedWrites *int           `json:"queued_writes,omitempty"`
+	Replicas     []adminReplica `json:"replicas,omitempty"`
+}
+
+type adminQuota struct {
+	Objects    int64 `json:"objects"`
+	Bytes      int64 `json:"bytes"`
+	MaxObjects int64 `json:"max_objects,omitempty"`
+	MaxBytes   int64 `json:"max_bytes,omitempty"`
+}
+
+type adminReplica struct {
+	Endpoint  string  `json:"endpoint"`
+	Bucket    string  `json:"bucket"`
+	LatencyMS float64 `json:"latency_ms"`
+	Down      bool    `json:"down"`
+}
+
+func (gs *S3Storage) adminStats() adminStats {
+	s := adminStats{HeldLocks: len(gs.HeldLocks())}
+	if gs.retries != nil {
+		rs := gs.RetryStats()
+		s.Retries = &rs
+	}
+	if gs.cache != nil {
+		cs := gs.CacheStats()
+		s.Cache = &cs
+	}
+	if q := gs.quota; q != nil {
+		q.mu.Lock()
+		s.Quota = &adminQuota{Objects: q.objects, Bytes: q.bytes, MaxObjects: q.maxObjects, MaxBytes: q.maxBytes}
+		q.mu.Unlock()
+	}
+	if q := gs.queue; q != nil {
+		q.mu.Lock()
+		n := len(q.pending) + len(q.inflight)
+		q.mu.Unlock()
+		s.QueuedWrites = &n
+	}
+	now := time.Now()
+	for _, r := range gs.replicas {
+		latency, down := r.state(now)
+		s.Replicas = append(s.Replicas, adminReplica{
+			Endpoint:  r.endpoint,
+			Bucket:    r.bucket,
+			LatencyMS: float64(latency.Microseconds()) / 1000,
+			Down:      down,
+		})
+	}
+	return s
+}
+
+// redactOpts returns the options that are set, by field name. Secrets are
+// replaced by "REDACTED", and values that do not encode, such as funcs, by
+// whether they are set.
+func redactOpts(opts S3Opts) map[string]any {
+	out := make(map[string]any)
+	v := reflect.ValueOf(opts)
+	for i := 0; i < v.NumField(); i++ {
+		name, f := v.Type().Field(i).Name, v.Field(i)
+		if f.IsZero() {
+			continue
+		}
+		switch {
+		case redactedOpts[name]:
+			out[name] = "REDACTED"
+		case name == "NamespaceEncryption":
+			ns := make(map[string]any)
+			for k, e := range opts.NamespaceEncryption {
+				ns[k] = map[string]any{"Algorithm": e.Algorithm, "Cleartext": e.Cleartext, "Key": "REDACTED"}
+			}
+			out[name] = ns
+		case f.Kind() == reflect.Func || f.Kind() == reflect.Interface || f.Kind() == reflect.Pointer:
+			out[name] = true
+		default:
+			out[name] = f.Interface()
+		}
+	}
+	return out
+}
@@ -0,0 +1,75 @@
+package cmgs3
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedactOpts(t *testing.T) {
+	opts := testOpts(true)
+	opts.NamespaceEncryption = map[string]NamespaceEncryption{"acme/": {Key: []byte("secret"), Algorithm: "aes-gcm"}}
+	opts.DedupKey = func(string) bool { return true }
+	out := redactOpts(opts)
+	buf, err := json.Marshal(out)
+	if err != nil {
+		t.Fatalf("redacted options do not encode: %v", err)
+	}
+	for _, secret := range []string{testSecretKey, string(opts.EncryptionKey), "secret"} {
+		if strings.Contains(string(buf), secret) {
+			t.Errorf("redacted options %s contain %q", buf, secret)
+		}
+	}
+	if out["Bucket"] != testBucket || out["DedupKey"] != true {
+		t.Errorf("redacted options = %s", buf)
+	}
+	if _, ok := out["Region"]; ok {
+		t.Error("redacted options contain unset fields")
+	}
+}
+
+func TestS3Storage_AdminHandler(t *testing.T) {
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+	if err := storage.Lock(ctx, "test/admin"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	defer storage.Unlock(ctx, "test/admin")
+	h := storage.AdminHandler()
+
+	get := func(path string, into any) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if into != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), into); err != nil {
+				t.Errorf("%s: %v: %s", path, err, rec.Body)
+			}
+		}
+		return rec.Code
+	}
+	var health adminHealth
+	if code := get("/health", &health); code != http.StatusOK || !health.OK {
+		t.Errorf("/health = %d %+v", code, health)
+	}
+	var locks []adminLock
+	if get("/locks", &locks); len(locks) != 1 || locks[0].Key != "test/admin" {
+		t.Errorf("/locks = %+v", locks)
+	}
+	var stats adminStats
+	if get("/stats", &stats); stats.HeldLocks != 1 {
+		t.Errorf("/stats = %+v", stats)
+	}
+	var config map[string]any
+	if get("/config", &config); config["SecretAccessKey"] != "REDACTED" || config["Endpoint"] != testEndpoint {
+		t.Errorf("/config = %v", config)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/stats", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /stats = %d, expected %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
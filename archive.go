@@ -0,0 +1,127 @@
+package cmgs3
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// headerStorageClass sets the storage class of copies.
+const headerStorageClass = "X-Amz-Storage-Class"
+
+// Archive moves the files of every site whose certificate expired more
+// than olderThan ago to ArchiveStorageClass and under ArchivePrefix, and
+// returns how many sites were archived. Objects are copied within S3
+// where possible; values that have to be encrypted again for the archive
+// key, e.g. with PerObjectKeys, are stored in the default class. Sites
+// whose certificate cannot be read are skipped.
+func (gs *S3Storage) Archive(ctx context.Context, olderThan time.Duration) (int, error) {
+	ctx = withDefaultPriority(ctx, PriorityBulk)
+	opts := gs.currentOpts()
+	if opts.ArchiveStorageClass == "" && opts.ArchivePrefix == "" {
+		return 0, errors.New("archiving needs an archive storage class or prefix")
+	}
+	if err := gs.ready(ctx); err != nil {
+		return 0, err
+	}
+	keys, err := gs.List(ctx, "certificates", true)
+	if err != nil {
+		return 0, err
+	}
+	sites := make(map[string][]string)
+	for _, key := range keys {
+		if hostnameOf(key) != "" {
+			sites[path.Dir(key)] = append(sites[path.Dir(key)], key)
+		}
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	archived := 0
+	for _, dir := range sortedKeys(sites) {
+		crt := dir + "/" + path.Base(dir) + ".crt"
+		value, err := gs.Load(ctx, crt)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return archived, err
+		}
+		expiry, err := certExpiry(value)
+		if err != nil {
+			gs.logf("Not archiving %s: %v", dir, err)
+			continue
+		}
+		if expiry.After(cutoff) {
+			continue
+		}
+		for _, key := range sites[dir] {
+			if err := gs.archive(ctx, key, opts); err != nil {
+				return archived, err
+			}
+		}
+		archived++
+	}
+	return archived, nil
+}
+
+// archive moves key to the archive.
+func (gs *S3Storage) archive(ctx context.Context, key string, opts S3Opts) error {
+	if err := gs.access("archive", key, namespaceOf(key), PermWrite); err != nil {
+		return err
+	}
+	if opts.ArchivePrefix != "" {
+		dst := strings.Trim(opts.ArchivePrefix, "/") + "/" + key
+		if err := gs.access("archive", dst, namespaceOf(dst), PermWrite); err != nil {
+			return err
+		}
+		if err := gs.copyObject(ctx, key, dst, opts.ArchiveStorageClass); err != nil {
+			return err
+		}
+		return gs.Delete(ctx, key)
+	}
+
+	// The object stays as it is, only its storage class changes.
+	oi, err := gs.s3client.StatObject(ctx, gs.bucket, gs.objName(key), minio.StatObjectOptions{})
+	if err != nil {
+		return err
+	}
+	if oi.StorageClass == opts.ArchiveStorageClass {
+		return nil
+	}
+	meta := make(map[string]string, len(oi.UserMetadata)+1)
+	for k, v := range oi.UserMetadata {
+		meta[k] = v
+	}
+	meta[headerStorageClass] = opts.ArchiveStorageClass
+	_, err = gs.s3client.CopyObject(ctx, minio.CopyDestOptions{
+		Bucket:          gs.bucket,
+		Object:          gs.objName(key),
+		UserMetadata:    meta,
+		ReplaceMetadata: true,
+	}, minio.CopySrcOptions{
+		Bucket:    gs.bucket,
+		Object:    gs.objName(key),
+		MatchETag: oi.ETag,
+	})
+	return err
+}
+
+// certExpiry returns when the first certificate of the PEM chain value
+// expires.
+func certExpiry(value []byte) (time.Time, error) {
+	block, _ := pem.Decode(value)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return time.Time{}, errors.New("no PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,95 @@
+package cmgs3
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testCertPEM(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCertExpiry(t *testing.T) {
+	notAfter := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	expiry, err := certExpiry(testCertPEM(t, notAfter))
+	if err != nil || !expiry.Equal(notAfter) {
+		t.Errorf("certExpiry() = %v, %v, expected %v", expiry, err, notAfter)
+	}
+	if _, err := certExpiry([]byte("not a certificate")); err == nil {
+		t.Error("certExpiry() of garbage succeeded")
+	}
+}
+
+func TestS3Storage_ArchiveNeedsTarget(t *testing.T) {
+	opts := testOpts(false)
+	opts.LazyInit = true
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	if _, err := storage.Archive(context.Background(), time.Hour); err == nil {
+		t.Error("Archive() without a storage class or prefix succeeded")
+	}
+}
+
+func TestS3Storage_Archive(t *testing.T) {
+	opts := testOpts(true)
+	opts.ArchivePrefix = "archive"
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	old := "certificates/ca/old.example.com/old.example.com"
+	fresh := "certificates/ca/new.example.com/new.example.com"
+	for key, value := range map[string][]byte{
+		old + ".crt":   testCertPEM(t, time.Now().Add(-60*24*time.Hour)),
+		old + ".key":   []byte("old key"),
+		fresh + ".crt": testCertPEM(t, time.Now().Add(30*24*time.Hour)),
+	} {
+		if err := storage.Store(ctx, key, value); err != nil {
+			t.Fatalf("Store(%s) failed: %v", key, err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, key := range []string{fresh + ".crt", "archive/" + old + ".crt", "archive/" + old + ".key"} {
+			storage.Delete(ctx, key)
+		}
+	})
+
+	n, err := storage.Archive(ctx, 30*24*time.Hour)
+	if err != nil || n != 1 {
+		t.Fatalf("Archive() = %d, %v, expected 1 site", n, err)
+	}
+	if _, err := storage.Load(ctx, old+".key"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Load() of an archived key = %v", err)
+	}
+	if value, err := storage.Load(ctx, "archive/"+old+".key"); err != nil || string(value) != "old key" {
+		t.Errorf("Load() of the archive = %q, %v", value, err)
+	}
+	if !storage.Exists(ctx, fresh+".crt") {
+		t.Error("a current certificate was archived")
+	}
+}
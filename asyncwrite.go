@@ -0,0 +1,193 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AsyncWriteTimeout bounds each write performed by the async write queue.
+var AsyncWriteTimeout = 30 * time.Second
+
+// DefaultAsyncKey selects OCSP staples, which certmagic can regenerate at
+// any time, for asynchronous writes.
+func DefaultAsyncKey(key string) bool {
+	return strings.HasPrefix(key, "ocsp/")
+}
+
+// writeQueue writes values in the background. Until a write has finished,
+// the queued value is served to Load, so callers read their own writes.
+type writeQueue struct {
+	gs *S3Storage
+	ch chan string
+
+	mu       sync.Mutex
+	closed   bool
+	pending  map[string][]byte
+	inflight map[string][]byte
+	tags     map[string]Tags
+	errs     []error
+	queued   int           // keys sent to run and not done yet
+	changed  chan struct{} // closed and replaced when a key is done
+	done     chan struct{}
+}
+
+func newWriteQueue(gs *S3Storage, size int) *writeQueue {
+	q := &writeQueue{
+		gs:       gs,
+		ch:       make(chan string, size),
+		pending:  make(map[string][]byte),
+		inflight: make(map[string][]byte),
+		tags:     make(map[string]Tags),
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// enqueue queues value for key. It returns false if the queue is full or
+// closed, in which case the caller has to write synchronously. The write
+// carries the Tags of ctx.
+func (q *writeQueue) enqueue(ctx context.Context, key string, value []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	value = append([]byte(nil), value...)
+	if _, ok := q.pending[key]; ok {
+		// Not picked up yet, the queued write will carry the new value.
+		q.pending[key] = value
+		q.tags[key] = TagsFrom(ctx)
+		return true
+	}
+	select {
+	case q.ch <- key:
+	default:
+		return false
+	}
+	q.pending[key] = value
+	q.tags[key] = TagsFrom(ctx)
+	q.queued++
+	return true
+}
+
+// lookup returns a value that is queued or being written for key.
+func (q *writeQueue) lookup(key string) ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if v, ok := q.pending[key]; ok {
+		return append([]byte(nil), v...), true
+	}
+	if v, ok := q.inflight[key]; ok {
+		return append([]byte(nil), v...), true
+	}
+	return nil, false
+}
+
+// drop discards a queued write for key and waits for one in flight, so a
+// following Delete cannot be overtaken by it.
+func (q *writeQueue) drop(ctx context.Context, key string) {
+	q.mu.Lock()
+	delete(q.pending, key)
+	delete(q.tags, key)
+	q.mu.Unlock()
+	q.wait(ctx, func() bool {
+		_, busy := q.inflight[key]
+		return !busy
+	})
+}
+
+func (q *writeQueue) run() {
+	defer close(q.done)
+	for key := range q.ch {
+		q.mu.Lock()
+		value, ok := q.pending[key]
+		tags := q.tags[key]
+		delete(q.pending, key)
+		delete(q.tags, key)
+		if ok {
+			q.inflight[key] = value
+		}
+		q.mu.Unlock()
+
+		if ok {
+			ctx, cancel := context.WithTimeout(WithTags(WithBackground(context.Background()), tags), AsyncWriteTimeout)
+			err := q.gs.storeSync(ctx, key, value)
+			cancel()
+
+			q.mu.Lock()
+			delete(q.inflight, key)
+			if err != nil {
+				q.gs.logf("async write of %s failed%s: %v", key, logTags(ctx), err)
+				q.errs = append(q.errs, fmt.Errorf("async write of %s: %w", key, err))
+			}
+			q.mu.Unlock()
+		}
+		q.mu.Lock()
+		q.queued--
+		close(q.changed)
+		q.changed = make(chan struct{})
+		q.mu.Unlock()
+	}
+}
+
+// wait waits until cond, called with q.mu held, is true.
+func (q *writeQueue) wait(ctx context.Context, cond func() bool) error {
+	for {
+		q.mu.Lock()
+		if cond() {
+			q.mu.Unlock()
+			return nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// flush waits until all writes queued so far are done and returns and
+// clears the errors collected since the last flush.
+func (q *writeQueue) flush(ctx context.Context) error {
+	if err := q.wait(ctx, func() bool { return q.queued == 0 }); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	err := errors.Join(q.errs...)
+	q.errs = nil
+	return err
+}
+
+func (q *writeQueue) close(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.mu.Unlock()
+
+	err := q.flush(ctx)
+	close(q.ch)
+	<-q.done
+	return err
+}
+
+// Flush waits for all queued asynchronous writes and returns the errors of
+// any that failed since the previous Flush.
+func (gs *S3Storage) Flush(ctx context.Context) error {
+	if gs.queue == nil {
+		return nil
+	}
+	return gs.queue.flush(ctx)
+}
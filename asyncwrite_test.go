@@ -0,0 +1,99 @@
+package cmgs3
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestDefaultAsyncKey(t *testing.T) {
+	if !DefaultAsyncKey("ocsp/example.com-1234") {
+		t.Errorf("OCSP staples should be written asynchronously")
+	}
+	if DefaultAsyncKey("certificates/acme/example.com/example.com.key") {
+		t.Errorf("private keys must be written synchronously")
+	}
+}
+
+func TestS3Storage_AsyncWrites(t *testing.T) {
+	opts := testOpts(true)
+	opts.AsyncQueueSize = 4
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	var keys []string
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("ocsp/example%d.com-staple", i)
+		keys = append(keys, key)
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store() failed for key %s: %v", key, err)
+		}
+		buf, err := storage.Load(ctx, key)
+		if err != nil || string(buf) != key {
+			t.Errorf("Load() did not return queued value for %s: %s, %v", key, buf, err)
+		}
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	for _, key := range keys {
+		if !storage.Exists(ctx, key) {
+			t.Errorf("key %s was not written by Close()", key)
+		}
+	}
+
+	if err := storage.Store(ctx, keys[0], []byte("after close")); err != nil {
+		t.Fatalf("Store() after Close() failed: %v", err)
+	}
+	buf, err := storage.Load(ctx, keys[0])
+	if err != nil || string(buf) != "after close" {
+		t.Errorf("Store() after Close() was not written synchronously: %s, %v", buf, err)
+	}
+}
+
+func TestWriteQueue_FlushWhileEnqueuing(t *testing.T) {
+	opts := testOpts(false)
+	opts.LazyInit = true
+	opts.MaxRetries = 1
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	// The writes fail without S3, which is all the queue needs.
+	q := newWriteQueue(storage, 4)
+	ctx := context.Background()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			q.enqueue(ctx, fmt.Sprintf("ocsp/%d", i%8), []byte("staple"))
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		q.flush(ctx)
+	}
+	close(stop)
+	<-done
+	q.close(ctx)
+	if q.queued != 0 {
+		t.Errorf("%d writes left after close()", q.queued)
+	}
+
+	// Dropping a key keeps the errors of the others for Flush.
+	q = newWriteQueue(storage, 4)
+	q.enqueue(ctx, "ocsp/failing", []byte("staple"))
+	q.wait(ctx, func() bool { return q.queued == 0 })
+	q.drop(ctx, "ocsp/other")
+	if err := q.flush(ctx); err == nil {
+		t.Error("flush() after drop() lost the error of another key")
+	}
+	q.close(ctx)
+}
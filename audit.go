@@ -0,0 +1,95 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// AuditRecord describes one mutation of the storage. Value holds the
+// SHA-256 of the stored plaintext, so records can be checked against the
+// data without revealing it.
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	Host   string    `json:"host,omitempty"`
+	Op     string    `json:"op"`
+	Key    string    `json:"key"`
+	From   string    `json:"from,omitempty"`
+	Size   int       `json:"size,omitempty"`
+	SHA256 string    `json:"sha256,omitempty"`
+	Tags   Tags      `json:"tags,omitempty"`
+}
+
+// auditLog writes AuditRecords into a bucket with Object Lock enabled, one
+// object per record, so records cannot be altered or removed before their
+// retention expires.
+type auditLog struct {
+	client    *minio.Client
+	bucket    string
+	prefix    string
+	retention time.Duration
+	host      string
+}
+
+func newAuditLog(ctx context.Context, client *minio.Client, bucket, prefix string, retention time.Duration) (*auditLog, error) {
+	enabled, mode, _, _, err := client.GetObjectLockConfig(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("audit bucket %s: %w", bucket, err)
+	}
+	if enabled != "Enabled" {
+		return nil, fmt.Errorf("audit bucket %s does not have object lock enabled", bucket)
+	}
+	if retention <= 0 && (mode == nil || *mode != minio.Compliance) {
+		return nil, fmt.Errorf("audit bucket %s has no default compliance retention and no audit retention is set", bucket)
+	}
+	host, _ := os.Hostname()
+	return &auditLog{client: client, bucket: bucket, prefix: prefix, retention: retention, host: host}, nil
+}
+
+func (a *auditLog) record(ctx context.Context, op, key string, value []byte) error {
+	rec := AuditRecord{Op: op, Key: key}
+	if value != nil {
+		sum := sha256.Sum256(value)
+		rec.Size = len(value)
+		rec.SHA256 = hex.EncodeToString(sum[:])
+	}
+	return a.write(ctx, rec)
+}
+
+// recordCopy records a server-side copy, whose value is never seen.
+func (a *auditLog) recordCopy(ctx context.Context, src, dst string) error {
+	return a.write(ctx, AuditRecord{Op: "copy", Key: dst, From: src})
+}
+
+func (a *auditLog) write(ctx context.Context, rec AuditRecord) error {
+	rec.Time, rec.Host, rec.Tags = time.Now().UTC(), a.host, TagsFrom(ctx)
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	// Names sort by time, so listing the prefix yields the trail in order.
+	obj := fmt.Sprintf("%s/%s-%s.json", a.prefix, rec.Time.Format("20060102T150405.000000000Z"), hex.EncodeToString(id[:]))
+	opts := minio.PutObjectOptions{ContentType: "application/json"}
+	if a.retention > 0 {
+		opts.Mode = minio.Compliance
+		opts.RetainUntilDate = rec.Time.Add(a.retention)
+	}
+	_, err = a.client.PutObject(ctx, a.bucket, obj, bytes.NewReader(buf), int64(len(buf)), opts)
+	if err != nil {
+		return fmt.Errorf("writing audit record: %w", err)
+	}
+	return nil
+}
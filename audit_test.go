@@ -0,0 +1,88 @@
+package cmgs3
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+const testAuditBucket = "certmagic-test-audit"
+
+func TestS3Storage_Audit(t *testing.T) {
+	opts := testOpts(true)
+	opts.AuditBucket = testAuditBucket
+	opts.AuditRetention = time.Minute
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	start := time.Now().UTC().Add(-time.Second)
+	key := "test/audited.key"
+	value := []byte("key material")
+	if err := storage.Store(ctx, key, value); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+
+	var ops []string
+	for obj := range storage.s3client.ListObjects(ctx, testAuditBucket, minio.ListObjectsOptions{
+		Prefix:     testPrefix + "/",
+		StartAfter: testPrefix + "/" + start.Format("20060102T150405.000000000Z"),
+	}) {
+		if obj.Err != nil {
+			t.Fatalf("listing audit records failed: %v", obj.Err)
+		}
+		r, err := storage.s3client.GetObject(ctx, testAuditBucket, obj.Key, minio.GetObjectOptions{})
+		if err != nil {
+			t.Fatalf("GetObject() failed: %v", err)
+		}
+		buf, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("reading audit record failed: %v", err)
+		}
+		var rec AuditRecord
+		if err := json.Unmarshal(buf, &rec); err != nil {
+			t.Fatalf("parsing audit record failed: %v", err)
+		}
+		if rec.Key != key {
+			continue
+		}
+		ops = append(ops, rec.Op)
+		if rec.Op == "store" && (rec.Size != len(value) || rec.SHA256 == "") {
+			t.Errorf("store record = %+v, expected size and hash of value", rec)
+		}
+		if strings.Contains(string(buf), string(value)) {
+			t.Errorf("audit record contains the stored value")
+		}
+
+		info, err := storage.s3client.StatObject(ctx, testAuditBucket, obj.Key, minio.StatObjectOptions{})
+		if err != nil {
+			t.Fatalf("StatObject() failed: %v", err)
+		}
+		err = storage.s3client.RemoveObject(ctx, testAuditBucket, obj.Key, minio.RemoveObjectOptions{VersionID: info.VersionID})
+		if err == nil {
+			t.Errorf("RemoveObject() on audit record succeeded, expected retention to prevent it")
+		}
+	}
+	if strings.Join(ops, ",") != "store,delete" {
+		t.Errorf("audit ops = %v, expected store and delete", ops)
+	}
+}
+
+func TestNewS3Storage_AuditBucketWithoutObjectLock(t *testing.T) {
+	setupTestStorage(t, false)
+
+	opts := testOpts(false)
+	opts.AuditBucket = testBucket2
+	opts.AuditRetention = time.Minute
+	if _, err := NewS3Storage(opts); err == nil {
+		t.Error("NewS3Storage() succeeded with an audit bucket lacking object lock")
+	}
+}
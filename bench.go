@@ -0,0 +1,203 @@
+package cmgs3
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sam-lord/certmagic"
+)
+
+// BenchOpts configures a Bench run.
+type BenchOpts struct {
+	// Concurrency is the number of goroutines issuing operations.
+	Concurrency int
+	// Duration is how long operations are issued.
+	Duration time.Duration
+	// Ops are the operations each worker cycles through: "store", "load",
+	// "stat", "exists", "list" and "lock" (Lock followed by Unlock).
+	// Defaults to store, load and stat.
+	Ops []string
+	// ValueSize is the size of stored values, 4 KiB by default.
+	ValueSize int
+	// Keys is the number of keys each worker cycles through, 16 by default.
+	Keys int
+	// Prefix holds the keys, "bench" by default. It is removed afterwards.
+	Prefix string
+}
+
+// BenchResult summarizes the latencies of one operation.
+type BenchResult struct {
+	Op     string
+	Count  int
+	Errors int
+	P50    time.Duration
+	P90    time.Duration
+	P99    time.Duration
+	Max    time.Duration
+	// PerSecond is the throughput over the whole run.
+	PerSecond float64
+}
+
+func (r BenchResult) String() string {
+	return fmt.Sprintf("%-6s %8d ops %8.1f/s  p50 %-10v p90 %-10v p99 %-10v max %-10v errors %d",
+		r.Op, r.Count, r.PerSecond, r.P50, r.P90, r.P99, r.Max, r.Errors)
+}
+
+// BenchReport is the outcome of a Bench run, with results in the order of
+// BenchOpts.Ops.
+type BenchReport struct {
+	Elapsed time.Duration
+	Results []BenchResult
+	// FirstError is the first error of any operation, if there were errors.
+	FirstError error
+}
+
+var benchOps = map[string]func(ctx context.Context, s certmagic.Storage, key string, value []byte) error{
+	"store": func(ctx context.Context, s certmagic.Storage, key string, value []byte) error {
+		return s.Store(ctx, key, value)
+	},
+	"load": func(ctx context.Context, s certmagic.Storage, key string, _ []byte) error {
+		_, err := s.Load(ctx, key)
+		return err
+	},
+	"stat": func(ctx context.Context, s certmagic.Storage, key string, _ []byte) error {
+		_, err := s.Stat(ctx, key)
+		return err
+	},
+	"exists": func(ctx context.Context, s certmagic.Storage, key string, _ []byte) error {
+		s.Exists(ctx, key)
+		return nil
+	},
+	"list": func(ctx context.Context, s certmagic.Storage, key string, _ []byte) error {
+		_, err := s.List(ctx, path.Dir(key), false)
+		return err
+	},
+	"lock": func(ctx context.Context, s certmagic.Storage, key string, _ []byte) error {
+		if err := s.Lock(ctx, key); err != nil {
+			return err
+		}
+		return s.Unlock(ctx, key)
+	},
+}
+
+// Bench measures the latency and throughput of storage operations at the
+// given concurrency, to validate that a provider sustains the expected
+// renewal and handshake volume. Values are stored before measuring, so
+// loads hit existing keys.
+func Bench(ctx context.Context, s certmagic.Storage, opts BenchOpts) (BenchReport, error) {
+	ctx = withDefaultPriority(ctx, PriorityBulk)
+	if opts.Concurrency <= 0 || opts.Duration <= 0 {
+		return BenchReport{}, errors.New("concurrency and duration must be positive")
+	}
+	if len(opts.Ops) == 0 {
+		opts.Ops = []string{"store", "load", "stat"}
+	}
+	for _, op := range opts.Ops {
+		if benchOps[op] == nil {
+			return BenchReport{}, fmt.Errorf("unknown operation %q", op)
+		}
+	}
+	if opts.ValueSize <= 0 {
+		opts.ValueSize = 4 << 10
+	}
+	if opts.Keys <= 0 {
+		opts.Keys = 16
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "bench"
+	}
+	value := make([]byte, opts.ValueSize)
+	if _, err := rand.Read(value); err != nil {
+		return BenchReport{}, err
+	}
+	benchKey := func(w, i int) string {
+		return fmt.Sprintf("%s/%d/%d", opts.Prefix, w, i%opts.Keys)
+	}
+	for w := 0; w < opts.Concurrency; w++ {
+		for i := 0; i < opts.Keys; i++ {
+			if err := s.Store(ctx, benchKey(w, i), value); err != nil {
+				return BenchReport{}, err
+			}
+		}
+	}
+
+	var (
+		mu        sync.Mutex
+		latencies = make(map[string][]time.Duration)
+		errs      = make(map[string]int)
+		firstErr  error
+		wg        sync.WaitGroup
+	)
+	start := time.Now()
+	deadline := start.Add(opts.Duration)
+	for w := 0; w < opts.Concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			local := make(map[string][]time.Duration)
+			for i := 0; time.Now().Before(deadline) && ctx.Err() == nil; i++ {
+				op := opts.Ops[i%len(opts.Ops)]
+				t := time.Now()
+				err := benchOps[op](ctx, s, benchKey(w, i/len(opts.Ops)), value)
+				local[op] = append(local[op], time.Since(t))
+				if err != nil {
+					mu.Lock()
+					errs[op]++
+					if firstErr == nil {
+						firstErr = fmt.Errorf("%s: %w", op, err)
+					}
+					mu.Unlock()
+				}
+			}
+			mu.Lock()
+			for op, l := range local {
+				latencies[op] = append(latencies[op], l...)
+			}
+			mu.Unlock()
+		}(w)
+	}
+	wg.Wait()
+
+	rep := BenchReport{Elapsed: time.Since(start), FirstError: firstErr}
+	for _, op := range opts.Ops {
+		if containsResult(rep.Results, op) {
+			continue
+		}
+		l := latencies[op]
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		r := BenchResult{Op: op, Count: len(l), Errors: errs[op], PerSecond: float64(len(l)) / rep.Elapsed.Seconds()}
+		if len(l) > 0 {
+			r.P50, r.P90, r.P99, r.Max = percentile(l, 50), percentile(l, 90), percentile(l, 99), l[len(l)-1]
+		}
+		rep.Results = append(rep.Results, r)
+	}
+
+	// Do not let a cancelled run leave its keys behind.
+	cctx := context.WithoutCancel(ctx)
+	for w := 0; w < opts.Concurrency; w++ {
+		for i := 0; i < opts.Keys; i++ {
+			s.Delete(cctx, benchKey(w, i))
+		}
+	}
+	return rep, nil
+}
+
+func containsResult(rs []BenchResult, op string) bool {
+	for _, r := range rs {
+		if r.Op == op {
+			return true
+		}
+	}
+	return false
+}
+
+// percentile returns the p-th percentile of the sorted latencies l.
+func percentile(l []time.Duration, p int) time.Duration {
+	return l[(len(l)-1)*p/100]
+}
@@ -0,0 +1,89 @@
+package cmgs3
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBench(t *testing.T) {
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+	rep, err := Bench(ctx, storage, BenchOpts{
+		Concurrency: 2,
+		Duration:    200 * time.Millisecond,
+		Ops:         []string{"store", "load", "list", "load"},
+		ValueSize:   100,
+		Keys:        2,
+		Prefix:      "test/bench",
+	})
+	if err != nil {
+		t.Fatalf("Bench() failed: %v", err)
+	}
+	if rep.FirstError != nil {
+		t.Errorf("Bench() operations failed: %v", rep.FirstError)
+	}
+	if len(rep.Results) != 3 {
+		t.Fatalf("Bench() returned %d results, expected 3", len(rep.Results))
+	}
+	for _, r := range rep.Results {
+		if r.Count == 0 || r.P50 <= 0 || r.P50 > r.P99 || r.P99 > r.Max {
+			t.Errorf("Bench() result %v implausible", r)
+		}
+	}
+	if keys, _ := storage.List(ctx, "test/bench", true); len(keys) != 0 {
+		t.Errorf("Bench() left keys behind: %v", keys)
+	}
+
+	if _, err := Bench(ctx, storage, BenchOpts{Concurrency: 1, Duration: time.Second, Ops: []string{"delete"}}); err == nil {
+		t.Error("Bench() with unknown operation succeeded")
+	}
+}
+
+func benchmarkStorage(b *testing.B, op string, size int) {
+	storage, err := NewS3Storage(testOpts(true))
+	if err != nil {
+		b.Skipf("Skipping benchmark due to S3 setup error: %v", err)
+	}
+	ctx := context.Background()
+	value := make([]byte, size)
+	if err := storage.Store(ctx, "test/benchmark", value); err != nil {
+		b.Skipf("Skipping benchmark due to S3 error: %v", err)
+	}
+	defer storage.Delete(ctx, "test/benchmark")
+
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := benchOps[op](ctx, storage, "test/benchmark", value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkS3Storage(b *testing.B) {
+	for _, op := range []string{"store", "load", "stat"} {
+		for _, size := range []int{1 << 10, 64 << 10} {
+			b.Run(fmt.Sprintf("%s/%d", op, size), func(b *testing.B) {
+				benchmarkStorage(b, op, size)
+			})
+		}
+	}
+}
+
+func BenchmarkOpen(b *testing.B) {
+	var key [32]byte
+	for _, s := range []sealer{&SecretBoxIO{SecretKey: key}, &AESGCMIO{SecretKey: key}} {
+		raw := mustRead(b, s.ByteReader(make([]byte, 4<<10)))
+		b.Run(fmt.Sprintf("%T", s), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.open(raw); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
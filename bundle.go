@@ -0,0 +1,284 @@
+package cmgs3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+	"github.com/sam-lord/certmagic"
+)
+
+// BundleCacheTTL is how long a bundle loaded for one file of a site serves
+// loads of its other files, see S3Opts.Bundle.
+var BundleCacheTTL = 2 * time.Second
+
+const bundleSuffix = ".bundle"
+
+// bundleExts are the extensions of the site files kept in a bundle.
+var bundleExts = []string{".crt", ".key", ".json"}
+
+// bundleFile is a file of a site within its bundle.
+type bundleFile struct {
+	Value    []byte    `json:"value"`
+	Modified time.Time `json:"modified"`
+}
+
+// bundleOf returns the key of the bundle holding key and the extension of
+// key within it, if key is the certificate, private key or metadata of a
+// site: certificates/<issuer>/<site>/<site>.crt and so on.
+func bundleOf(key string) (bundle, ext string, ok bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 || parts[0] != shardedNamespace {
+		return "", "", false
+	}
+	site := parts[2]
+	for _, ext := range bundleExts {
+		if parts[3] == site+ext {
+			return strings.Join(parts[:3], "/") + "/" + site + bundleSuffix, ext, true
+		}
+	}
+	return "", "", false
+}
+
+// isBundleKey reports whether key is the key of a bundle.
+func isBundleKey(key string) bool {
+	parts := strings.Split(key, "/")
+	return len(parts) == 4 && parts[0] == shardedNamespace && parts[3] == parts[2]+bundleSuffix
+}
+
+// bundled returns the bundle of key in bundle mode.
+func (gs *S3Storage) bundled(key string) (bundle, ext string, ok bool) {
+	if !gs.bundles {
+		return "", "", false
+	}
+	return bundleOf(key)
+}
+
+// loadBundle returns the files of bundle, from the cache unless fresh.
+func (gs *S3Storage) loadBundle(ctx context.Context, bundle string, fresh bool) (map[string]bundleFile, error) {
+	var buf []byte
+	if !fresh {
+		buf, _ = gs.bundleCache.get(bundle)
+	}
+	if buf == nil {
+		var err error
+		if buf, err = gs.Load(ctx, bundle); err != nil {
+			return nil, err
+		}
+		gs.bundleCache.put(bundle, buf)
+	}
+	files := make(map[string]bundleFile)
+	if err := json.Unmarshal(buf, &files); err != nil {
+		return nil, fmt.Errorf("invalid bundle %s: %w", bundle, err)
+	}
+	return files, nil
+}
+
+// loadMember returns the file of a site from its bundle, or fs.ErrNotExist
+// if there is none.
+func (gs *S3Storage) loadMember(ctx context.Context, bundle, ext string) (bundleFile, error) {
+	files, err := gs.loadBundle(ctx, bundle, false)
+	if err != nil {
+		return bundleFile{}, err
+	}
+	f, ok := files[ext]
+	if !ok {
+		return bundleFile{}, fs.ErrNotExist
+	}
+	return f, nil
+}
+
+// bundleWriteAttempts bounds the retries of a bundle write losing the race
+// against a write of the same bundle by another instance.
+const bundleWriteAttempts = 5
+
+// lockBundle serializes the writes of bundle within the process. Those of
+// other instances are fenced by the ETag of the bundle they replace.
+func (gs *S3Storage) lockBundle(ctx context.Context, bundle string) (unlock func(), err error) {
+	gs.bundleMu.Lock()
+	ch, ok := gs.bundleLocks[bundle]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		gs.bundleLocks[bundle] = ch
+	}
+	gs.bundleMu.Unlock()
+
+	select {
+	case ch <- struct{}{}:
+		return func() { <-ch }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readBundle returns the files of bundle as stored in the bucket itself,
+// bypassing caches and replicas, and the ETag to write them back over. A
+// missing bundle has no files and no ETag.
+func (gs *S3Storage) readBundle(ctx context.Context, bundle string) (map[string]bundleFile, string, error) {
+	files := make(map[string]bundleFile)
+	body := getBuffer()
+	defer putBuffer(body)
+	raw, oi, err := gs.getObjectFrom(ctx, gs.s3client, gs.bucket, gs.objName(bundle), body)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return files, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	buf, err := gs.decode(ctx, bundle, raw, oi, body)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := json.Unmarshal(buf, &files); err != nil {
+		return nil, "", fmt.Errorf("invalid bundle %s: %w", bundle, err)
+	}
+	return files, oi.ETag, nil
+}
+
+// updateBundle applies update, which reports whether it changed the files,
+// to bundle. The write is conditional on the bundle read, and retried with
+// the bundle read again if another instance wrote it meanwhile.
+func (gs *S3Storage) updateBundle(ctx context.Context, bundle string, update func(files map[string]bundleFile) bool) error {
+	unlock, err := gs.lockBundle(ctx, bundle)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for i := 0; ; i++ {
+		files, etag, err := gs.readBundle(ctx, bundle)
+		if err != nil {
+			return err
+		}
+		if !update(files) {
+			return nil
+		}
+		err = gs.storeBundle(ctx, bundle, files, etag)
+		if !isPreconditionFailed(err) || i == bundleWriteAttempts-1 {
+			return err
+		}
+	}
+}
+
+// storeBundle writes the files of bundle over the bundle with etag, or
+// where there is none if etag is empty, and deletes it if there are none.
+// S3 has no conditional delete, so the bundle is emptied conditionally
+// first, which leaves the writes of other instances only the time until
+// the delete to race with.
+func (gs *S3Storage) storeBundle(ctx context.Context, bundle string, files map[string]bundleFile, etag string) error {
+	gs.bundleCache.invalidate(bundle)
+	buf, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	// The token belongs to the write of one file, not the bundle.
+	if err := gs.Store(withMatchETag(WithIdempotencyKey(ctx, ""), etag), bundle, buf); err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return gs.Delete(ctx, bundle)
+	}
+	gs.bundleCache.put(bundle, buf)
+	return nil
+}
+
+// storeMember stores the file key of a site in its bundle. The file stored
+// on its own before bundle mode is removed.
+func (gs *S3Storage) storeMember(ctx context.Context, key, bundle, ext string, value []byte) error {
+	err := gs.updateBundle(ctx, bundle, func(files map[string]bundleFile) bool {
+		files[ext] = bundleFile{Value: value, Modified: time.Now().UTC()}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return gs.removeUnbundled(ctx, key)
+}
+
+// deleteMember deletes the file key of a site from its bundle and on its
+// own.
+func (gs *S3Storage) deleteMember(ctx context.Context, key, bundle, ext string) error {
+	var found bool
+	err := gs.updateBundle(ctx, bundle, func(files map[string]bundleFile) bool {
+		_, found = files[ext]
+		delete(files, ext)
+		return found
+	})
+	if err != nil {
+		return err
+	}
+
+	oi, err := gs.s3client.StatObject(ctx, gs.bucket, gs.objName(key), minio.StatObjectOptions{})
+	if err == nil {
+		ki, _ := gs.keyInfo(key, oi)
+		return gs.deleteObject(ctx, key, ki.Size)
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return err
+	}
+	if !found && gs.deleteMissingError {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// removeUnbundled removes the object key was stored in before bundle mode.
+func (gs *S3Storage) removeUnbundled(ctx context.Context, key string) error {
+	oi, err := gs.s3client.StatObject(ctx, gs.bucket, gs.objName(key), minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	ki, _ := gs.keyInfo(key, oi)
+	return gs.deleteObject(ctx, key, ki.Size)
+}
+
+// statMember returns the KeyInfo of a file in a bundle.
+func (gs *S3Storage) statMember(ctx context.Context, key, bundle, ext string) (certmagic.KeyInfo, error) {
+	f, err := gs.loadMember(ctx, bundle, ext)
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+	return certmagic.KeyInfo{Key: key, Modified: f.Modified, Size: int64(len(f.Value)), IsTerminal: true}, nil
+}
+
+// expandBundles replaces the bundles among keys by the keys of their
+// files, keeping keys sorted.
+func (gs *S3Storage) expandBundles(ctx context.Context, keys []string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	expanded := false
+	for _, k := range keys {
+		if !isBundleKey(k) {
+			if !seen[k] {
+				out = append(out, k)
+				seen[k] = true
+			}
+			continue
+		}
+		files, err := gs.loadBundle(ctx, k, false)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		base := strings.TrimSuffix(k, bundleSuffix)
+		for ext := range files {
+			if !seen[base+ext] {
+				out = append(out, base+ext)
+				seen[base+ext] = true
+			}
+		}
+		expanded = true
+	}
+	if expanded {
+		sort.Strings(out)
+	}
+	return out, nil
+}
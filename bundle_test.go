@@ -0,0 +1,148 @@
+package cmgs3
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestBundleOf(t *testing.T) {
+	tests := []struct {
+		key, bundle, ext string
+	}{
+		{"certificates/acme/example.com/example.com.crt", "certificates/acme/example.com/example.com.bundle", ".crt"},
+		{"certificates/acme/example.com/example.com.key", "certificates/acme/example.com/example.com.bundle", ".key"},
+		{"certificates/acme/example.com/example.com.json", "certificates/acme/example.com/example.com.bundle", ".json"},
+		{"certificates/acme/example.com/other.crt", "", ""},
+		{"certificates/acme/example.com/example.com.ocsp", "", ""},
+		{"ocsp/example.com.crt", "", ""},
+	}
+	for _, tt := range tests {
+		bundle, ext, ok := bundleOf(tt.key)
+		if bundle != tt.bundle || ext != tt.ext || ok != (tt.bundle != "") {
+			t.Errorf("bundleOf(%q) = %q, %q, %v", tt.key, bundle, ext, ok)
+		}
+		if ok && !isBundleKey(bundle) {
+			t.Errorf("isBundleKey(%q) = false", bundle)
+		}
+	}
+}
+
+func TestS3Storage_Bundle(t *testing.T) {
+	opts := testOpts(true)
+	opts.ObjPrefix = testPrefix + "-bundled"
+	plain := setupTestStorageOpts(t, opts)
+	opts.Bundle = true
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	ctx := context.Background()
+	// Bundle mode is recorded in the layout, which would keep the plain
+	// storage from starting the next time.
+	t.Cleanup(func() {
+		storage.s3client.RemoveObject(ctx, storage.bucket, storage.layoutName(), minio.RemoveObjectOptions{})
+	})
+	site := "certificates/acme/example.com/example.com"
+
+	// A file stored before bundle mode is still read.
+	if err := plain.Store(ctx, site+".json", []byte("old meta")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if buf, err := storage.Load(ctx, site+".json"); err != nil || string(buf) != "old meta" {
+		t.Errorf("Load() of an unbundled file = %q, %v", buf, err)
+	}
+
+	files := map[string]string{".key": "private key", ".crt": "certificate", ".json": "meta"}
+	for _, ext := range []string{".key", ".crt", ".json"} {
+		if err := storage.Store(ctx, site+ext, []byte(files[ext])); err != nil {
+			t.Fatalf("Store(%s) failed: %v", ext, err)
+		}
+	}
+	var objects []string
+	for obj := range storage.s3client.ListObjects(ctx, testBucket, minio.ListObjectsOptions{Prefix: storage.objName("certificates/acme/example.com/")}) {
+		objects = append(objects, obj.Key)
+	}
+	if want := []string{storage.objName(site + ".bundle")}; !reflect.DeepEqual(objects, want) {
+		t.Errorf("objects = %v, expected %v", objects, want)
+	}
+
+	keys, err := storage.List(ctx, "certificates/acme/example.com", false)
+	if want := []string{site + ".crt", site + ".json", site + ".key"}; err != nil || !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() = %v, %v, expected %v", keys, err, want)
+	}
+	for ext, value := range files {
+		if buf, err := storage.Load(ctx, site+ext); err != nil || string(buf) != value {
+			t.Errorf("Load(%s) = %q, %v, expected %q", ext, buf, err, value)
+		}
+		if ki, err := storage.Stat(ctx, site+ext); err != nil || ki.Size != int64(len(value)) || ki.Modified.IsZero() {
+			t.Errorf("Stat(%s) = %+v, %v", ext, ki, err)
+		}
+		if !storage.Exists(ctx, site+ext) {
+			t.Errorf("Exists(%s) = false", ext)
+		}
+	}
+
+	if err := storage.Delete(ctx, site+".key"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if storage.Exists(ctx, site+".key") {
+		t.Error("Exists() of a deleted file = true")
+	}
+	if buf, err := storage.Load(ctx, site+".crt"); err != nil || string(buf) != "certificate" {
+		t.Errorf("Load() after deleting another file = %q, %v", buf, err)
+	}
+
+	// Deleting the site directory deletes the bundle.
+	if err := storage.Delete(ctx, "certificates/acme/example.com"); err != nil {
+		t.Fatalf("Delete() of the site failed: %v", err)
+	}
+	if _, err := storage.s3client.StatObject(ctx, testBucket, storage.objName(site+".bundle"), minio.StatObjectOptions{}); err == nil {
+		t.Error("bundle left after deleting all its files")
+	}
+
+	opts.Conflict = ConflictFail
+	if _, err := NewS3Storage(opts); err == nil {
+		t.Error("NewS3Storage() with a bundle and ConflictFail succeeded")
+	}
+}
+
+func TestS3Storage_BundleConcurrentInstances(t *testing.T) {
+	opts := testOpts(true)
+	opts.ObjPrefix = testPrefix + "-bundled"
+	opts.Bundle = true
+	first := setupTestStorageOpts(t, opts)
+	second, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	ctx := context.Background()
+	t.Cleanup(func() {
+		first.s3client.RemoveObject(ctx, first.bucket, first.layoutName(), minio.RemoveObjectOptions{})
+	})
+	site := "certificates/acme/race.example.com/race.example.com"
+
+	// Each instance stores other files of the same site at once.
+	var wg sync.WaitGroup
+	for i, storage := range []*S3Storage{first, second} {
+		for _, ext := range [][]string{{".crt", ".key"}, {".json"}}[i] {
+			wg.Add(1)
+			go func(storage *S3Storage, ext string) {
+				defer wg.Done()
+				if err := storage.Store(ctx, site+ext, []byte(ext)); err != nil {
+					t.Errorf("Store(%s) failed: %v", ext, err)
+				}
+			}(storage, ext)
+		}
+	}
+	wg.Wait()
+	for _, ext := range []string{".crt", ".key", ".json"} {
+		if buf, err := first.Load(ctx, site+ext); err != nil || string(buf) != ext {
+			t.Errorf("Load(%s) = %q, %v", ext, buf, err)
+		}
+	}
+	first.Delete(ctx, "certificates/acme/race.example.com")
+}
@@ -0,0 +1,228 @@
+package cmgs3
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrefetchConcurrency bounds the parallel GETs issued by Prefetch.
+var PrefetchConcurrency = 8
+
+type cacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// CacheStats describes the read cache.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Evictions counts values dropped to stay within S3Opts.CacheMaxBytes.
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+}
+
+// HitRatio returns the share of lookups answered from the cache.
+func (s CacheStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// readCache keeps recently loaded values for the TTL of their namespace.
+// Beyond maxBytes, if set, the least recently used values are evicted.
+type readCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	nsTTL    map[string]time.Duration
+	maxBytes int64
+	lru      *list.List // of *cacheEntry, most recently used first
+	entries  map[string]*list.Element
+	stats    CacheStats
+}
+
+func newReadCache(ttl time.Duration) *readCache {
+	return &readCache{ttl: ttl, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *readCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	c.stats.Hits++
+	return append([]byte(nil), e.value...), true
+}
+
+func (c *readCache) put(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	ttl, ok := c.nsTTL[namespaceOf(key)]
+	if !ok {
+		ttl = c.ttl
+	}
+	if ttl <= 0 || c.maxBytes > 0 && int64(len(value)) > c.maxBytes {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		value:   append([]byte(nil), value...),
+		expires: time.Now().Add(ttl),
+	})
+	c.stats.Entries++
+	c.stats.Bytes += int64(len(value))
+	for c.maxBytes > 0 && c.stats.Bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *readCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *readCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.stats.Entries--
+	c.stats.Bytes -= int64(len(e.value))
+}
+
+// CacheStats returns the statistics of the read cache, zero if it is
+// disabled.
+func (gs *S3Storage) CacheStats() CacheStats {
+	if gs.cache == nil {
+		return CacheStats{}
+	}
+	gs.cache.mu.Lock()
+	defer gs.cache.mu.Unlock()
+	return gs.cache.stats
+}
+
+// Prefetch loads every key below the given prefixes into the read cache, so
+// the first requests after startup do not all go to S3. It requires
+// the read cache to be enabled and returns the first error encountered after
+// attempting all keys.
+func (gs *S3Storage) Prefetch(ctx context.Context, prefixes ...string) error {
+	ctx = withDefaultPriority(ctx, PriorityMaintenance)
+	if gs.cache == nil {
+		return errors.New("read cache is disabled")
+	}
+
+	var keys []string
+	for _, p := range prefixes {
+		ks, err := gs.List(ctx, p, true)
+		if err != nil {
+			return err
+		}
+		for _, k := range ks {
+			if !strings.HasSuffix(k, ".lock") {
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		sem      = make(chan struct{}, PrefetchConcurrency)
+	)
+	for _, k := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(k string) {
+			defer func() { <-sem; wg.Done() }()
+			if _, err := gs.Load(ctx, k); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(k)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+type listEntry struct {
+	keys    []string
+	expires time.Time
+}
+
+// listCache keeps List results for a fixed TTL. Writes through this
+// instance invalidate the listings they could have changed.
+type listCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[listArgs]listEntry
+}
+
+type listArgs struct {
+	prefix    string
+	recursive bool
+}
+
+func newListCache(ttl time.Duration) *listCache {
+	return &listCache{ttl: ttl, entries: make(map[listArgs]listEntry)}
+}
+
+func (c *listCache) get(prefix string, recursive bool) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	args := listArgs{prefix, recursive}
+	e, ok := c.entries[args]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, args)
+		return nil, false
+	}
+	return append([]string(nil), e.keys...), true
+}
+
+func (c *listCache) put(prefix string, recursive bool, keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[listArgs{prefix, recursive}] = listEntry{
+		keys:    append([]string(nil), keys...),
+		expires: time.Now().Add(c.ttl),
+	}
+}
+
+// invalidate drops the listings of every directory containing key.
+func (c *listCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for args := range c.entries {
+		if args.prefix == "" || strings.HasPrefix(key, args.prefix+"/") {
+			delete(c.entries, args)
+		}
+	}
+}
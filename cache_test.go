@@ -0,0 +1,157 @@
+package cmgs3
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadCache(t *testing.T) {
+	c := newReadCache(50 * time.Millisecond)
+
+	if _, ok := c.get("a"); ok {
+		t.Errorf("get() returned value for missing key")
+	}
+
+	c.put("a", []byte("value"))
+	buf, ok := c.get("a")
+	if !ok || string(buf) != "value" {
+		t.Errorf("get() = %s, %v, expected cached value", buf, ok)
+	}
+
+	buf[0] = 'X'
+	if buf, _ := c.get("a"); string(buf) != "value" {
+		t.Errorf("cached value was modified through returned slice: %s", buf)
+	}
+
+	c.invalidate("a")
+	if _, ok := c.get("a"); ok {
+		t.Errorf("get() returned value after invalidate")
+	}
+
+	c.put("b", []byte("value"))
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := c.get("b"); ok {
+		t.Errorf("get() returned expired value")
+	}
+}
+
+func TestS3Storage_PrefetchWithoutCache(t *testing.T) {
+	storage := &S3Storage{}
+	if err := storage.Prefetch(context.Background(), "certificates"); err == nil {
+		t.Errorf("Prefetch() expected error with disabled cache")
+	}
+}
+
+func TestS3Storage_Prefetch(t *testing.T) {
+	opts := testOpts(true)
+	opts.CacheTTL = time.Minute
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	testKey := "certificates/acme/example.com/example.com.crt"
+	if err := storage.Store(ctx, testKey, []byte("cert")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	storage.cache.invalidate(testKey)
+
+	if err := storage.Lock(ctx, "certificates/acme/example.com/issuing"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	defer storage.Unlock(ctx, "certificates/acme/example.com/issuing")
+
+	if err := storage.Prefetch(ctx, "certificates"); err != nil {
+		t.Fatalf("Prefetch() failed: %v", err)
+	}
+	buf, ok := storage.cache.get(testKey)
+	if !ok || string(buf) != "cert" {
+		t.Errorf("Prefetch() did not populate cache for %s", testKey)
+	}
+}
+
+func TestListCacheInvalidate(t *testing.T) {
+	c := newListCache(time.Minute)
+	c.put("", true, []string{"a/b"})
+	c.put("a", false, []string{"a/b"})
+	c.put("ab", false, []string{"ab/c"})
+
+	c.invalidate("a/c")
+	if _, ok := c.get("", true); ok {
+		t.Error("root listing not invalidated")
+	}
+	if _, ok := c.get("a", false); ok {
+		t.Error("listing of the key's directory not invalidated")
+	}
+	if _, ok := c.get("ab", false); !ok {
+		t.Error("listing of an unrelated directory invalidated")
+	}
+}
+
+func TestS3Storage_ListCache(t *testing.T) {
+	opts := testOpts(false)
+	opts.ListCacheTTL = time.Minute
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "test/list/a", []byte("a")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if keys, _ := storage.List(ctx, "test/list", true); len(keys) != 1 {
+		t.Fatalf("List() = %v, expected one key", keys)
+	}
+
+	// Written behind the storage's back, the cached listing is served.
+	other, err := NewS3Storage(testOpts(false))
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	if err := other.Store(ctx, "test/list/b", []byte("b")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if keys, _ := storage.List(ctx, "test/list", true); len(keys) != 1 {
+		t.Errorf("List() = %v, expected cached result", keys)
+	}
+
+	if err := storage.Store(ctx, "test/list/c", []byte("c")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if keys, _ := storage.List(ctx, "test/list", true); len(keys) != 3 {
+		t.Errorf("List() after local Store = %v, expected fresh result", keys)
+	}
+}
+
+func TestReadCache_LRU(t *testing.T) {
+	c := newReadCache(time.Minute)
+	c.maxBytes = 10
+	c.nsTTL = map[string]time.Duration{"certificates/": time.Hour, "locks/": 0}
+
+	c.put("certificates/a", []byte("aaaa"))
+	c.put("b", []byte("bbbb"))
+	c.get("certificates/a")
+	c.put("c", []byte("cccc"))
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used value was not evicted")
+	}
+	if _, ok := c.get("certificates/a"); !ok {
+		t.Error("recently used value was evicted")
+	}
+	c.put("big", []byte("more than ten bytes"))
+	if _, ok := c.get("big"); ok {
+		t.Error("value beyond the size limit was cached")
+	}
+	c.put("locks/x", []byte("x"))
+	if _, ok := c.get("locks/x"); ok {
+		t.Error("value of a namespace with zero TTL was cached")
+	}
+	if e := c.entries["certificates/a"].Value.(*cacheEntry); time.Until(e.expires) < 59*time.Minute {
+		t.Errorf("value expires in %v, expected the namespace TTL", time.Until(e.expires))
+	}
+
+	s := c.stats
+	if s.Hits != 2 || s.Misses != 3 || s.Evictions != 1 || s.Entries != 2 || s.Bytes != 8 {
+		t.Errorf("stats = %+v", s)
+	}
+	if r := s.HitRatio(); r != 0.4 {
+		t.Errorf("HitRatio() = %v, expected 0.4", r)
+	}
+}
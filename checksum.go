@@ -0,0 +1,59 @@
+package cmgs3
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// ErrChecksumMismatch is returned by Load when an object's content does not
+// match the checksum recorded by S3 at upload.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+var checksumTypes = map[string]minio.ChecksumType{
+	"CRC32":  minio.ChecksumCRC32,
+	"CRC32C": minio.ChecksumCRC32C,
+	"SHA1":   minio.ChecksumSHA1,
+	"SHA256": minio.ChecksumSHA256,
+}
+
+func parseChecksum(name string) (minio.ChecksumType, error) {
+	if name == "" {
+		return minio.ChecksumNone, nil
+	}
+	ct, ok := checksumTypes[strings.ToUpper(name)]
+	if !ok {
+		return minio.ChecksumNone, fmt.Errorf("unsupported checksum algorithm %q", name)
+	}
+	return ct, nil
+}
+
+// verifyChecksum compares raw with the checksum S3 returned for it. Objects
+// without a checksum of type ct, such as ones written before checksums were
+// enabled, and composite checksums of multipart uploads are not verified.
+func verifyChecksum(ct minio.ChecksumType, oi minio.ObjectInfo, raw []byte) error {
+	var want string
+	switch ct {
+	case minio.ChecksumCRC32:
+		want = oi.ChecksumCRC32
+	case minio.ChecksumCRC32C:
+		want = oi.ChecksumCRC32C
+	case minio.ChecksumSHA1:
+		want = oi.ChecksumSHA1
+	case minio.ChecksumSHA256:
+		want = oi.ChecksumSHA256
+	}
+	if want == "" || strings.Contains(want, "-") || oi.ChecksumMode == "COMPOSITE" {
+		return nil
+	}
+
+	h := ct.Hasher()
+	h.Write(raw)
+	if base64.StdEncoding.EncodeToString(h.Sum(nil)) != want {
+		return fmt.Errorf("%s: %w", oi.Key, ErrChecksumMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package cmgs3
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"hash/crc32"
+	"testing"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestParseChecksum(t *testing.T) {
+	for name, want := range map[string]minio.ChecksumType{
+		"":       minio.ChecksumNone,
+		"crc32c": minio.ChecksumCRC32C,
+		"SHA256": minio.ChecksumSHA256,
+	} {
+		got, err := parseChecksum(name)
+		if err != nil || got != want {
+			t.Errorf("parseChecksum(%q) = %v, %v, expected %v", name, got, err, want)
+		}
+	}
+	if _, err := parseChecksum("md4"); err == nil {
+		t.Errorf("parseChecksum() expected error for unsupported algorithm")
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	raw := []byte("ciphertext")
+	sum := crc32.Checksum(raw, crc32.MakeTable(crc32.Castagnoli))
+	good := base64.StdEncoding.EncodeToString([]byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)})
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"matching", good, false},
+		{"missing", "", false},
+		{"composite", "AAAAAA==-3", false},
+		{"mismatch", "AAAAAA==", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyChecksum(minio.ChecksumCRC32C, minio.ObjectInfo{ChecksumCRC32C: tt.value}, raw)
+			if tt.wantErr != errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("verifyChecksum() = %v, expected mismatch: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestS3Storage_Checksum(t *testing.T) {
+	opts := testOpts(true)
+	opts.Checksum = "CRC32C"
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	testKey := "test/checksum.pem"
+	testValue := []byte("checksummed value")
+	if err := storage.Store(ctx, testKey, testValue); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	oi, err := storage.s3client.StatObject(ctx, testBucket, storage.objName(testKey), minio.StatObjectOptions{Checksum: true})
+	if err != nil {
+		t.Fatalf("StatObject() failed: %v", err)
+	}
+	if oi.ChecksumCRC32C == "" {
+		t.Errorf("object was stored without CRC32C checksum")
+	}
+
+	if buf, err := storage.Load(ctx, testKey); err != nil || string(buf) != string(testValue) {
+		t.Errorf("Load() = %q, %v, expected %q", buf, err, testValue)
+	}
+}
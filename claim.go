@@ -0,0 +1,103 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// ErrPrefixClaimed means ObjPrefix is claimed by another cluster, see
+// S3Opts.ClusterID.
+var ErrPrefixClaimed = errors.New("prefix claimed by another cluster")
+
+// ErrForeignObject is returned by Store and Copy for objects written by
+// another cluster, see S3Opts.ProtectForeign.
+var ErrForeignObject = errors.New("object written by another cluster")
+
+// claimFile records the cluster using a prefix. It is stored in cleartext,
+// so instances with another key can read it.
+type claimFile struct {
+	ClusterID string    `json:"cluster_id"`
+	KeyID     string    `json:"key_id,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+func (gs *S3Storage) claimName() string {
+	return gs.prefix + ".claim.json"
+}
+
+// claimPrefix claims the prefix for cluster, or checks that the first
+// instance to claim it belongs to the same cluster.
+func (gs *S3Storage) claimPrefix(ctx context.Context, cluster string) error {
+	for attempt := 0; ; attempt++ {
+		obj, err := gs.s3client.GetObject(ctx, gs.bucket, gs.claimName(), minio.GetObjectOptions{})
+		if err != nil {
+			return err
+		}
+		buf, err := ioutil.ReadAll(obj)
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			err = gs.putClaim(ctx, cluster)
+			if isPreconditionFailed(err) && attempt == 0 {
+				// Another instance claimed it meanwhile, check whose.
+				continue
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		var c claimFile
+		if err := json.Unmarshal(buf, &c); err != nil {
+			return fmt.Errorf("invalid claim file %s: %w", gs.claimName(), err)
+		}
+		if c.ClusterID == cluster {
+			return nil
+		}
+		key := c.KeyID
+		if key == "" {
+			key = "none"
+		}
+		return fmt.Errorf("%w: %s is used by cluster %s since %s, encryption key %s",
+			ErrPrefixClaimed, gs.prefix, c.ClusterID, c.Created.Format(time.RFC3339), key)
+	}
+}
+
+func (gs *S3Storage) putClaim(ctx context.Context, cluster string) error {
+	c := claimFile{ClusterID: cluster, Created: gs.clock.now().UTC()}
+	if s, ok := gs.keys.get().(sealer); ok {
+		c.KeyID = keyID(s.secretKey())
+	}
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	opts := minio.PutObjectOptions{ContentType: "application/json", DisableContentSha256: gs.unsignedPayload}
+	opts.SetMatchETagExcept("*")
+	_, err = gs.s3client.PutObject(ctx, gs.bucket, gs.claimName(), bytes.NewReader(buf), int64(len(buf)), opts)
+	return err
+}
+
+// checkOwner returns ErrForeignObject if the object of key was written by
+// another cluster. Objects written without a ClusterID belong to anyone.
+func (gs *S3Storage) checkOwner(ctx context.Context, key string) error {
+	if !gs.protectForeign {
+		return nil
+	}
+	oi, err := gs.s3client.StatObject(ctx, gs.bucket, gs.objName(key), minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if owner := oi.UserMetadata[metaCluster]; owner != "" && owner != gs.clusterID {
+		return fmt.Errorf("%s: %w %s", key, ErrForeignObject, owner)
+	}
+	return nil
+}
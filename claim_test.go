@@ -0,0 +1,81 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestS3Storage_ClusterID(t *testing.T) {
+	opts := testOpts(true)
+	opts.ClusterID = "cluster-a"
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+	defer storage.s3client.RemoveObject(ctx, testBucket, storage.claimName(), minio.RemoveObjectOptions{})
+
+	// The claiming cluster starts again, other clusters are warned or
+	// rejected.
+	if _, err := NewS3Storage(opts); err != nil {
+		t.Fatalf("NewS3Storage() of the claiming cluster failed: %v", err)
+	}
+	other := testOpts(false)
+	other.ClusterID = "cluster-b"
+	if _, err := NewS3Storage(other); err != nil {
+		t.Errorf("NewS3Storage() of another cluster failed without RejectClaimed: %v", err)
+	}
+	other.RejectClaimed = true
+	if _, err := NewS3Storage(other); !errors.Is(err, ErrPrefixClaimed) {
+		t.Errorf("NewS3Storage() of another cluster = %v, expected ErrPrefixClaimed", err)
+	}
+	other.ObjPrefix = testPrefix + "-other"
+	s, err := NewS3Storage(other)
+	if err != nil {
+		t.Fatalf("NewS3Storage() with another prefix failed: %v", err)
+	}
+	s.s3client.RemoveObject(ctx, testBucket, s.claimName(), minio.RemoveObjectOptions{})
+}
+
+func TestS3Storage_ProtectForeign(t *testing.T) {
+	opts := testOpts(false)
+	opts.ClusterID = "cluster-a"
+	opts.ProtectForeign = true
+	other := opts
+	other.ClusterID = "cluster-b"
+	b, err := NewS3Storage(other)
+	if err != nil {
+		t.Skipf("Skipping test due to S3 setup error: %v", err)
+	}
+	a := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+	defer a.s3client.RemoveObject(ctx, testBucket, a.claimName(), minio.RemoveObjectOptions{})
+
+	if err := a.Store(ctx, "test/owned", []byte("a")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	oi, err := a.s3client.StatObject(ctx, testBucket, a.objName("test/owned"), minio.StatObjectOptions{})
+	if err != nil || oi.UserMetadata[metaCluster] != "cluster-a" {
+		t.Errorf("object stamped with %q, %v, expected cluster-a", oi.UserMetadata[metaCluster], err)
+	}
+	if err := a.Store(ctx, "test/owned", []byte("a2")); err != nil {
+		t.Errorf("Store() over an own object failed: %v", err)
+	}
+	if err := b.Store(ctx, "test/owned", []byte("b")); !errors.Is(err, ErrForeignObject) {
+		t.Errorf("Store() over a foreign object = %v, expected ErrForeignObject", err)
+	}
+	if err := b.Store(ctx, "test/other", []byte("b")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if err := b.Copy(ctx, "test/other", "test/owned"); !errors.Is(err, ErrForeignObject) {
+		t.Errorf("Copy() over a foreign object = %v, expected ErrForeignObject", err)
+	}
+	if buf, err := a.Load(ctx, "test/owned"); err != nil || string(buf) != "a2" {
+		t.Errorf("Load() = %q, %v, expected a2", buf, err)
+	}
+
+	opts.ClusterID = ""
+	if _, err := NewS3Storage(opts); err == nil {
+		t.Error("NewS3Storage() with ProtectForeign but no ClusterID succeeded")
+	}
+}
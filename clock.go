@@ -0,0 +1,43 @@
+package cmgs3
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// serverClock estimates the S3 server's time from the Date headers of its
+// responses, so decisions shared between nodes do not depend on how well
+// their local clocks agree.
+type serverClock struct {
+	offset atomic.Int64 // server time minus local time, in nanoseconds
+}
+
+// now returns the server's estimated current time, or the local time
+// before any response was seen.
+func (c *serverClock) now() time.Time {
+	return time.Now().Add(time.Duration(c.offset.Load()))
+}
+
+func (c *serverClock) observe(date string, local time.Time) {
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return
+	}
+	// Date has a resolution of one second, and is sent before the request
+	// completes; the error is small against lock expiration.
+	c.offset.Store(int64(t.Sub(local)))
+}
+
+type clockTransport struct {
+	clock *serverClock
+	base  http.RoundTripper
+}
+
+func (t *clockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.clock.observe(resp.Header.Get("Date"), time.Now())
+	}
+	return resp, err
+}
@@ -0,0 +1,67 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestServerClock(t *testing.T) {
+	c := &serverClock{}
+	if d := time.Since(c.now()); d < -time.Second || d > time.Second {
+		t.Errorf("now() before observing = %v off local time", d)
+	}
+	local := time.Now()
+	c.observe(local.Add(time.Hour).UTC().Format(http.TimeFormat), local)
+	if d := time.Until(c.now()); d < 59*time.Minute || d > 61*time.Minute {
+		t.Errorf("now() = %v ahead, expected an hour", d)
+	}
+	c.observe("garbage", local)
+	if d := time.Until(c.now()); d < 59*time.Minute {
+		t.Errorf("unparsable Date changed the offset")
+	}
+}
+
+// putSkewedLock writes a lock file as a node whose clock is off by skew.
+func putSkewedLock(t *testing.T, storage *S3Storage, key string, skew time.Duration) {
+	body := []byte(time.Now().Add(skew).Format(time.RFC3339))
+	_, err := storage.s3client.PutObject(context.Background(), storage.bucket, storage.objLockName(key),
+		bytes.NewReader(body), int64(len(body)), minio.PutObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestS3Storage_LockClockSkew(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping lock expiry test in short mode")
+	}
+	storage := setupTestStorage(t, false)
+
+	// A node far behind: its fresh lock must not be taken as expired.
+	putSkewedLock(t, storage, "test/skew-behind", -time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	if err := storage.Lock(ctx, "test/skew-behind"); err == nil {
+		t.Error("Lock() broke a fresh lock written by a node with a slow clock")
+		storage.Unlock(context.Background(), "test/skew-behind")
+	}
+
+	// A node far ahead: its lock must still expire.
+	expiration := LockExpiration
+	LockExpiration = time.Second
+	defer func() { LockExpiration = expiration }()
+	putSkewedLock(t, storage, "test/skew-ahead", time.Hour)
+	time.Sleep(2500 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := storage.Lock(ctx, "test/skew-ahead"); err != nil {
+		t.Errorf("Lock() did not break an expired lock written by a node with a fast clock: %v", err)
+	}
+	storage.Unlock(context.Background(), "test/skew-ahead")
+	storage.Unlock(context.Background(), "test/skew-behind")
+}
@@ -0,0 +1,103 @@
+// Command cmgs3-proxy serves an S3 storage to cmgs3.ProxyClients over TLS,
+// requiring client certificates signed by -client-ca, so nodes share the
+// storage without holding its credentials. Credentials are read from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, or with
+// -credential-chain from instance metadata too.
+//
+//	cmgs3-proxy -endpoint s3.example.com -bucket certs \
+//		-cert proxy.crt -key proxy.key -client-ca nodes.crt
+//
+// Nodes talk to it with JSON over HTTP/2 rather than gRPC, see
+// cmgs3.ProxyHandler. Locks are released only by the node that
+// took them, or once LockExpiration passed. The proxy is experimental and
+// its protocol may change.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	cmgs3 "github.com/sam-lord/certmagic-generic-s3"
+)
+
+func main() {
+	log.SetFlags(0)
+	var (
+		opts            cmgs3.S3Opts
+		listen          string
+		cert, key, ca   string
+		shutdownTimeout time.Duration
+	)
+	flag.StringVar(&opts.Endpoint, "endpoint", "", "S3 endpoint host")
+	flag.StringVar(&opts.Bucket, "bucket", "", "bucket")
+	flag.StringVar(&opts.Provider, "provider", "", "provider defaults: aws, minio, r2, b2, gcs, wasabi, spaces or ceph")
+	flag.StringVar(&opts.ObjPrefix, "prefix", "", "object prefix")
+	flag.BoolVar(&opts.CredentialChain, "credential-chain", false, "look up credentials like AWS SDKs, e.g. from instance metadata")
+	flag.StringVar(&opts.EncryptionKeyFile, "encryption-key-file", "", "file holding the encryption key")
+	flag.StringVar(&listen, "listen", ":8443", "address to listen on")
+	flag.StringVar(&cert, "cert", "", "server certificate")
+	flag.StringVar(&key, "key", "", "server private key")
+	flag.StringVar(&ca, "client-ca", "", "CA certificates of the clients")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "time to finish requests and drain the storage on shutdown")
+	flag.Parse()
+	if opts.Endpoint == "" && opts.Provider == "" || opts.Bucket == "" {
+		log.Fatal("-endpoint or -provider and -bucket are required")
+	}
+	if cert == "" || key == "" || ca == "" {
+		log.Fatal("-cert, -key and -client-ca are required")
+	}
+	opts.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	opts.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	opts.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+
+	pem, err := os.ReadFile(ca)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		log.Fatalf("no certificates in %s", ca)
+	}
+	storage, err := cmgs3.NewS3Storage(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:    listen,
+		Handler: cmgs3.ProxyHandler(storage),
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+		},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+	log.Printf("serving %s on %s", opts.Bucket, listen)
+	if err := srv.ListenAndServeTLS(cert, key); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := storage.Drain(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
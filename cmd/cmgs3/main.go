@@ -0,0 +1,78 @@
+// Command cmgs3 runs tools against an S3 storage. Credentials are read from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+//
+//	cmgs3 bench -endpoint s3.example.com -bucket certs -c 16 -d 30s
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	cmgs3 "github.com/sam-lord/certmagic-generic-s3"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 || os.Args[1] != "bench" {
+		fmt.Fprintln(os.Stderr, "usage: cmgs3 bench [flags]")
+		os.Exit(2)
+	}
+
+	fl := flag.NewFlagSet("bench", flag.ExitOnError)
+	var (
+		opts    cmgs3.S3Opts
+		bench   cmgs3.BenchOpts
+		ops     string
+		encrypt bool
+	)
+	fl.StringVar(&opts.Endpoint, "endpoint", "", "S3 endpoint host")
+	fl.StringVar(&opts.Bucket, "bucket", "", "bucket")
+	fl.StringVar(&opts.Provider, "provider", "", "provider defaults: aws, minio, r2, b2, gcs, wasabi, spaces or ceph")
+	fl.StringVar(&opts.ObjPrefix, "prefix", "cmgs3-bench", "object prefix, cleaned up afterwards")
+	fl.BoolVar(&encrypt, "encrypt", true, "encrypt values with a random key")
+	fl.IntVar(&bench.Concurrency, "c", 8, "concurrent workers")
+	fl.DurationVar(&bench.Duration, "d", 10*time.Second, "duration")
+	fl.IntVar(&bench.ValueSize, "size", 4<<10, "value size in bytes")
+	fl.IntVar(&bench.Keys, "keys", 16, "keys per worker")
+	fl.StringVar(&ops, "ops", "store,load,stat", "operations: store, load, stat, exists, list, lock")
+	fl.Parse(os.Args[2:])
+	if opts.Endpoint == "" && opts.Provider == "" || opts.Bucket == "" {
+		log.Fatal("-endpoint or -provider and -bucket are required")
+	}
+	bench.Ops = strings.Split(ops, ",")
+	opts.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	opts.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	opts.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	if encrypt {
+		opts.EncryptionKey = make([]byte, 32)
+		rand.Read(opts.EncryptionKey)
+	}
+
+	storage, err := cmgs3.NewS3Storage(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer storage.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	rep, err := cmgs3.Bench(ctx, storage, bench)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d workers, %v, %d byte values\n", bench.Concurrency, rep.Elapsed.Round(time.Millisecond), bench.ValueSize)
+	for _, r := range rep.Results {
+		fmt.Println(r)
+	}
+	if rep.FirstError != nil {
+		fmt.Printf("first error: %v\n", rep.FirstError)
+		os.Exit(1)
+	}
+}
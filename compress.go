@@ -0,0 +1,63 @@
+package cmgs3
+
+import (
+	_ "embed"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDict was trained on certificate chains, private keys and certmagic's
+// certificate and account metadata. Values compressed with it need it to be
+// read, so it must never change; a new dictionary needs a new ID and both
+// must be loaded by the decoder.
+//
+//go:embed zstd.dict
+var zstdDict []byte
+
+// maxDecompressedSize bounds the values decompressed by Load, against
+// objects crafted to expand without limit.
+const maxDecompressedSize = 64 << 20
+
+// encodingZstd is the metaEncoding of values compressed with zstdDict.
+const encodingZstd = "zstd"
+
+var zstdCodec = sync.OnceValues(func() (*zstd.Encoder, *zstd.Decoder) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderDict(zstdDict), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		panic(err)
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderDicts(zstdDict), zstd.WithDecoderMaxMemory(maxDecompressedSize), zstd.WithDecoderConcurrency(0))
+	if err != nil {
+		panic(err)
+	}
+	return enc, dec
+})
+
+func compress(value []byte) []byte {
+	enc, _ := zstdCodec()
+	return enc.EncodeAll(value, nil)
+}
+
+func decompress(key string, buf []byte) ([]byte, error) {
+	_, dec := zstdCodec()
+	out, err := dec.DecodeAll(buf, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s: %w", key, err)
+	}
+	return out, nil
+}
+
+// maybeCompress returns the compressed value, or nil if compression is off
+// or would not make it smaller.
+func (gs *S3Storage) maybeCompress(value []byte) []byte {
+	if !gs.compress {
+		return nil
+	}
+	c := compress(value)
+	if len(c) >= len(value) {
+		return nil
+	}
+	return c
+}
@@ -0,0 +1,114 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func TestCompressDictionary(t *testing.T) {
+	value := []byte(`{
+	"sans": [
+		"example.com"
+	],
+	"issuer_data": {
+		"url": "https://acme-v02.api.letsencrypt.org/acme/cert/04a5c1b2d3e4f5",
+		"ca": "https://acme-v02.api.letsencrypt.org/directory"
+	}
+}`)
+	c := compress(value)
+	if len(c) >= len(value)/2 {
+		t.Errorf("compressed %d bytes to %d, expected better than half", len(value), len(c))
+	}
+	got, err := decompress("test", c)
+	if err != nil || !bytes.Equal(got, value) {
+		t.Errorf("decompress() = %q, %v, expected %q", got, err, value)
+	}
+	if _, err := decompress("test", []byte("not zstd")); err == nil {
+		t.Error("decompress() of garbage succeeded")
+	}
+}
+
+func TestS3Storage_Compress(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		opts := testOpts(encrypted)
+		opts.Compress = true
+		storage := setupTestStorageOpts(t, opts)
+		ctx := context.Background()
+		value := []byte(`{"sans":["example.com"],"issuer_data":{"ca":"https://acme-v02.api.letsencrypt.org/directory"}}` + strings.Repeat(" ", 100))
+		if err := storage.Store(ctx, "test/compressed", value); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+
+		oi, err := storage.s3client.StatObject(ctx, testBucket, storage.objName("test/compressed"), minio.StatObjectOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if oi.Size >= int64(len(value)) {
+			t.Errorf("stored %d bytes for a value of %d", oi.Size, len(value))
+		}
+		if ki, err := storage.Stat(ctx, "test/compressed"); err != nil || ki.Size != int64(len(value)) {
+			t.Errorf("Stat() = %+v, %v, expected size %d", ki, err, len(value))
+		}
+		if got, err := storage.LoadRange(ctx, "test/compressed", 2, 4); err != nil || string(got) != "sans" {
+			t.Errorf("LoadRange() = %q, %v, expected %q", got, err, "sans")
+		}
+
+		// Reading compressed values needs no option.
+		opts.Compress = false
+		reader, err := NewS3Storage(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := reader.Load(ctx, "test/compressed"); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("Load() = %q, %v, expected %q", got, err, value)
+		}
+	}
+}
+
+func TestS3Storage_LoadRangeCompressedLimited(t *testing.T) {
+	value := strings.Repeat("compressible ", 100)
+	body := compress([]byte(value))
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"0123456789abcdef"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("X-Amz-Meta-"+metaEncoding, encodingZstd)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	opts := testOpts(false)
+	opts.LazyInit = true
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	// A single request slot, which the range request must free before
+	// the value is loaded as a whole.
+	limited := &limitTransport{gate: &priorityGate{limit: 1}, base: srv.Client().Transport}
+	storage.s3client, err = minio.New(strings.TrimPrefix(srv.URL, "https://"), &minio.Options{
+		Creds:     credentials.NewStaticV4("access", "secret", ""),
+		Secure:    true,
+		Region:    "us-east-1",
+		Transport: limited,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.connected.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if got, err := storage.LoadRange(ctx, "test/compressed", 0, 12); err != nil || string(got) != "compressible" {
+		t.Errorf("LoadRange() = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,73 @@
+package cmgs3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// ErrConfirmation is returned by destructive bulk operations whose token
+// does not match a preview of what they would do now.
+var ErrConfirmation = errors.New("confirmation token does not match operation")
+
+// BulkPreview lists the keys a destructive bulk operation would affect.
+// Passing Token back performs the operation, as long as it would still
+// affect exactly these keys, so a reviewer can approve what automation is
+// about to do and a bug cannot wipe a prefix in one call.
+type BulkPreview struct {
+	Op    string
+	Keys  []string
+	Token string
+}
+
+func newBulkPreview(op string, keys []string) BulkPreview {
+	sort.Strings(keys)
+	h := sha256.New()
+	h.Write([]byte(op))
+	for _, k := range keys {
+		h.Write([]byte{0})
+		h.Write([]byte(k))
+	}
+	return BulkPreview{Op: op, Keys: keys, Token: hex.EncodeToString(h.Sum(nil))}
+}
+
+// PreviewDeleteAll returns what DeleteAll of prefix would delete.
+func (gs *S3Storage) PreviewDeleteAll(ctx context.Context, prefix string) (BulkPreview, error) {
+	prefix = strings.Trim(prefix, "/")
+	listed, err := gs.List(ctx, prefix, true)
+	if err != nil {
+		return BulkPreview{}, err
+	}
+	var keys []string
+	for _, k := range listed {
+		if !strings.HasSuffix(k, ".lock") {
+			keys = append(keys, k)
+		}
+	}
+	return newBulkPreview("delete-all "+prefix, keys), nil
+}
+
+// DeleteAll deletes every key below prefix, given the Token of a
+// PreviewDeleteAll of it, and returns how many keys were deleted.
+func (gs *S3Storage) DeleteAll(ctx context.Context, prefix, token string) (int, error) {
+	p, err := gs.PreviewDeleteAll(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	if token != p.Token {
+		return 0, ErrConfirmation
+	}
+	var n int
+	for _, k := range p.Keys {
+		if err := gs.Delete(ctx, k); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return n, fmt.Errorf("deleting %s: %w", k, err)
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,50 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestS3Storage_DeleteAll(t *testing.T) {
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+	for _, k := range []string{"test/bulk/a", "test/bulk/sub/b", "test/keep"} {
+		if err := storage.Store(ctx, k, []byte("value")); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+
+	p, err := storage.PreviewDeleteAll(ctx, "test/bulk")
+	if err != nil {
+		t.Fatalf("PreviewDeleteAll() failed: %v", err)
+	}
+	if len(p.Keys) != 2 || p.Token == "" {
+		t.Fatalf("PreviewDeleteAll() = %+v", p)
+	}
+	if _, err := storage.DeleteAll(ctx, "test/bulk", "guess"); !errors.Is(err, ErrConfirmation) {
+		t.Errorf("DeleteAll() with wrong token error = %v, expected ErrConfirmation", err)
+	}
+	if _, err := storage.DeleteAll(ctx, "test", p.Token); !errors.Is(err, ErrConfirmation) {
+		t.Errorf("DeleteAll() of other prefix error = %v, expected ErrConfirmation", err)
+	}
+
+	// The preview no longer matches once the affected keys change.
+	if err := storage.Store(ctx, "test/bulk/c", []byte("value")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if _, err := storage.DeleteAll(ctx, "test/bulk", p.Token); !errors.Is(err, ErrConfirmation) {
+		t.Errorf("DeleteAll() with stale token error = %v, expected ErrConfirmation", err)
+	}
+
+	if p, err = storage.PreviewDeleteAll(ctx, "test/bulk"); err != nil {
+		t.Fatalf("PreviewDeleteAll() failed: %v", err)
+	}
+	n, err := storage.DeleteAll(ctx, "test/bulk", p.Token)
+	if err != nil || n != 3 {
+		t.Fatalf("DeleteAll() = %d, %v, expected 3 deleted", n, err)
+	}
+	if keys, _ := storage.List(ctx, "test", true); len(keys) != 1 || keys[0] != "test/keep" {
+		t.Errorf("List() after DeleteAll() = %v, expected only test/keep", keys)
+	}
+}
@@ -0,0 +1,48 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// ConflictPolicy decides what Store does when the key exists, see
+// S3Opts.Conflict.
+type ConflictPolicy uint8
+
+const (
+	// ConflictOverwrite replaces the existing value.
+	ConflictOverwrite ConflictPolicy = iota
+	// ConflictFail fails with an error wrapping fs.ErrExist. The write is
+	// conditional, so of concurrent Stores of a new key only one succeeds.
+	ConflictFail
+	// ConflictKeepBoth copies the existing value to VersionKey of the time
+	// it was stored before replacing it.
+	ConflictKeepBoth
+)
+
+// versionTimeFormat sorts versions of a key by time.
+const versionTimeFormat = "20060102T150405.000000000Z"
+
+// VersionKey returns the key that ConflictKeepBoth keeps the value of key
+// stored at modified under.
+func VersionKey(key string, modified time.Time) string {
+	return key + ".v" + modified.UTC().Format(versionTimeFormat)
+}
+
+// keepVersion copies the value of key, if any, to its VersionKey.
+func (gs *S3Storage) keepVersion(ctx context.Context, key string) error {
+	ki, err := gs.Stat(ctx, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err = gs.Copy(ctx, key, VersionKey(key, ki.Modified))
+	if errors.Is(err, fs.ErrNotExist) {
+		// Deleted meanwhile, nothing to keep.
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVersionKey(t *testing.T) {
+	mt := time.Date(2026, 10, 14, 19, 27, 0, 5, time.FixedZone("CEST", 2*3600))
+	if got := VersionKey("certs/a.crt", mt); got != "certs/a.crt.v20261014T172700.000000005Z" {
+		t.Errorf("VersionKey() = %q", got)
+	}
+	if VersionKey("k", mt) >= VersionKey("k", mt.Add(time.Millisecond)) {
+		t.Error("versions do not sort by time")
+	}
+}
+
+func TestS3Storage_ConflictFail(t *testing.T) {
+	opts := testOpts(true)
+	opts.Conflict = ConflictFail
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "test/conflict", []byte("first")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := storage.Store(ctx, "test/conflict", []byte("second")); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("Store() of an existing key = %v, expected fs.ErrExist", err)
+		}
+	}
+	if buf, err := storage.Load(ctx, "test/conflict"); err != nil || string(buf) != "first" {
+		t.Errorf("Load() = %q, %v, expected first", buf, err)
+	}
+	if err := storage.Store(ctx, "test/conflict-other", []byte("other")); err != nil {
+		t.Errorf("Store() of a new key failed: %v", err)
+	}
+}
+
+func TestS3Storage_ConflictKeepBoth(t *testing.T) {
+	opts := testOpts(true)
+	opts.Conflict = ConflictKeepBoth
+	opts.PerObjectKeys = true
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "test/versioned", []byte("first")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	ki, err := storage.Stat(ctx, "test/versioned")
+	if err != nil {
+		t.Fatalf("Stat() failed: %v", err)
+	}
+	if err := storage.Store(ctx, "test/versioned", []byte("second")); err != nil {
+		t.Fatalf("Store() over an existing key failed: %v", err)
+	}
+	if buf, err := storage.Load(ctx, "test/versioned"); err != nil || string(buf) != "second" {
+		t.Errorf("Load() = %q, %v, expected second", buf, err)
+	}
+	version := VersionKey("test/versioned", ki.Modified)
+	if buf, err := storage.Load(ctx, version); err != nil || string(buf) != "first" {
+		t.Errorf("Load() of %s = %q, %v, expected first", version, buf, err)
+	}
+	keys, err := storage.List(ctx, "test", false)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	versions := 0
+	for _, k := range keys {
+		if strings.HasPrefix(k, "test/versioned.v") {
+			versions++
+		}
+	}
+	if versions != 1 {
+		t.Errorf("List() = %v, expected one version", keys)
+	}
+}
@@ -0,0 +1,151 @@
+package cmgs3
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// Copy stores the value of src at dst as well. Where the object of src can
+// be reused as is, it is copied within S3 without transferring the value;
+// otherwise, e.g. with PerObjectKeys or when only one of the keys is stored
+// in cleartext, the value is loaded and stored again, encrypted for dst.
+func (gs *S3Storage) Copy(ctx context.Context, src, dst string) error {
+	ctx = withDefaultPriority(ctx, PriorityMaintenance)
+	if err := gs.access("load", src, namespaceOf(src), PermRead); err != nil {
+		return err
+	}
+	if err := gs.access("store", dst, namespaceOf(dst), PermWrite); err != nil {
+		return err
+	}
+	return gs.copyObject(ctx, src, dst, "")
+}
+
+// copyObject copies src to dst, into storageClass if set and the object
+// is copied within S3.
+func (gs *S3Storage) copyObject(ctx context.Context, src, dst, storageClass string) error {
+	if !gs.copyable(src, dst) {
+		value, err := gs.Load(ctx, src)
+		if err != nil {
+			return err
+		}
+		return gs.Store(ctx, dst, value)
+	}
+	// As Store, which the other way goes through.
+	if !gs.writes.enter() {
+		return ErrDraining
+	}
+	defer gs.writes.leave()
+	if err := gs.ready(ctx); err != nil {
+		return err
+	}
+
+	oi, err := gs.s3client.StatObject(ctx, gs.bucket, gs.objName(src), minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fs.ErrNotExist
+	} else if err != nil {
+		return err
+	}
+	ki, _ := gs.keyInfo(src, oi)
+	if limit := gs.maxStoreSize.Load(); limit > 0 && ki.Size > limit {
+		return fmt.Errorf("%s has %d bytes: %w", src, ki.Size, ErrObjectTooLarge)
+	}
+	release := func() {}
+	if gs.quota != nil {
+		if release, err = gs.reserveQuota(ctx, dst, ki.Size); err != nil {
+			return err
+		}
+	}
+	if err := gs.checkOwner(ctx, dst); err != nil {
+		return err
+	}
+	if gs.queue != nil {
+		// A pending write would overwrite the copy.
+		gs.queue.drop(ctx, dst)
+	}
+
+	// The metadata describes the object, which is unchanged, except that
+	// the copy is a new write, of this cluster.
+	meta := make(map[string]string, len(oi.UserMetadata)+2)
+	for k, v := range oi.UserMetadata {
+		meta[k] = v
+	}
+	mt := time.Now().UTC()
+	meta[metaModified] = mt.Format(time.RFC3339Nano)
+	if gs.clusterID != "" {
+		meta[metaCluster] = gs.clusterID
+	} else {
+		delete(meta, metaCluster)
+	}
+	delete(meta, metaIdempotencyKey)
+	if storageClass != "" {
+		meta[headerStorageClass] = storageClass
+	}
+	_, err = gs.s3client.CopyObject(ctx, minio.CopyDestOptions{
+		Bucket:          gs.bucket,
+		Object:          gs.objName(dst),
+		UserMetadata:    meta,
+		ReplaceMetadata: true,
+	}, minio.CopySrcOptions{
+		Bucket:    gs.bucket,
+		Object:    gs.objName(src),
+		MatchETag: oi.ETag,
+	})
+	if err != nil {
+		release()
+		return err
+	}
+	// After the copy, so lookups racing with it are not recorded as
+	// missing in the negative cache.
+	gs.forget(dst)
+	if gs.index != nil {
+		gs.index.update(ctx, dst, &indexEntry{Size: ki.Size, Modified: mt})
+	}
+	if gs.audit != nil {
+		return gs.audit.recordCopy(ctx, src, dst)
+	}
+	return nil
+}
+
+// copyable reports whether the object of src is valid at dst.
+func (gs *S3Storage) copyable(src, dst string) bool {
+	if gs.queue != nil {
+		if _, ok := gs.queue.lookup(src); ok {
+			return false
+		}
+	}
+	if gs.isCleartextKey(src) != gs.isCleartextKey(dst) || gs.isDedupKey(src) != gs.isDedupKey(dst) {
+		return false
+	}
+	if gs.keyringFor(src) != gs.keyringFor(dst) {
+		return false
+	}
+	if _, _, ok := gs.bundled(src); ok {
+		return false
+	}
+	if _, _, ok := gs.bundled(dst); ok {
+		return false
+	}
+	// Per-object keys are derived from the key, dedup pointers are
+	// cleartext.
+	return !gs.deriveKeys || gs.isCleartextKey(src) || gs.isDedupKey(src)
+}
+
+// Move copies src to dst and deletes src. It is not atomic: if deleting
+// fails, the value exists at both keys.
+func (gs *S3Storage) Move(ctx context.Context, src, dst string) error {
+	ctx = withDefaultPriority(ctx, PriorityMaintenance)
+	if err := gs.access("delete", src, namespaceOf(src), PermWrite); err != nil {
+		return err
+	}
+	if src == dst {
+		return nil
+	}
+	if err := gs.Copy(ctx, src, dst); err != nil {
+		return err
+	}
+	return gs.Delete(ctx, src)
+}
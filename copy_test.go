@@ -0,0 +1,66 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestS3Storage_Copy(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts func(*S3Opts)
+	}{
+		{"server-side", func(*S3Opts) {}},
+		{"per-object keys", func(o *S3Opts) { o.PerObjectKeys = true }},
+		{"cleartext destination", func(o *S3Opts) {
+			o.CleartextKeys = func(key string) bool { return key == "test/copy/dst" }
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := testOpts(true)
+			tc.opts(&opts)
+			storage := setupTestStorageOpts(t, opts)
+			ctx := context.Background()
+			value := []byte("copied value")
+			if err := storage.Store(ctx, "test/copy/src", value); err != nil {
+				t.Fatalf("Store() failed: %v", err)
+			}
+
+			if err := storage.Copy(ctx, "test/copy/src", "test/copy/dst"); err != nil {
+				t.Fatalf("Copy() failed: %v", err)
+			}
+			for _, k := range []string{"test/copy/src", "test/copy/dst"} {
+				if got, err := storage.Load(ctx, k); err != nil || !bytes.Equal(got, value) {
+					t.Errorf("Load(%s) = %q, %v, expected %q", k, got, err, value)
+				}
+			}
+			if ki, err := storage.Stat(ctx, "test/copy/dst"); err != nil || ki.Size != int64(len(value)) {
+				t.Errorf("Stat() = %+v, %v", ki, err)
+			}
+
+			if err := storage.Move(ctx, "test/copy/dst", "test/copy/moved"); err != nil {
+				t.Fatalf("Move() failed: %v", err)
+			}
+			if storage.Exists(ctx, "test/copy/dst") {
+				t.Error("source exists after Move()")
+			}
+			if got, err := storage.Load(ctx, "test/copy/moved"); err != nil || !bytes.Equal(got, value) {
+				t.Errorf("Load() after Move() = %q, %v, expected %q", got, err, value)
+			}
+		})
+	}
+}
+
+func TestS3Storage_CopyMissing(t *testing.T) {
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+	if err := storage.Copy(ctx, "test/copy/missing", "test/copy/dst"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Copy() of missing key error = %v, expected fs.ErrNotExist", err)
+	}
+	if err := storage.Move(ctx, "test/copy/missing", "test/copy/dst"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Move() of missing key error = %v, expected fs.ErrNotExist", err)
+	}
+}
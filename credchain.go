@@ -0,0 +1,16 @@
+package cmgs3
+
+import "github.com/minio/minio-go/v7/pkg/credentials"
+
+// newCredentialChain returns the credentials of S3Opts.CredentialChain:
+// those of the AWS environment variables, then of IRSA web identity
+// tokens or the ECS or EC2 metadata service, found at iamEndpoint if not
+// empty, and the static keys last. Credentials of the metadata services
+// are refreshed before they expire.
+func newCredentialChain(static *staticCreds, iamEndpoint string) *credentials.Credentials {
+	return credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.IAM{Endpoint: iamEndpoint},
+		static,
+	})
+}
@@ -0,0 +1,91 @@
+package cmgs3
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// clearAWSEnv unsets the variables the credential chain reads.
+func clearAWSEnv(t *testing.T) {
+	for _, v := range []string{"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY",
+		"AWS_SESSION_TOKEN", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"} {
+		t.Setenv(v, "")
+	}
+}
+
+// fakeIMDS serves the EC2 instance metadata of a role, or nothing if
+// creds is false.
+func fakeIMDS(t *testing.T, creds bool) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !creds:
+			http.NotFound(w, r)
+		case r.URL.Path == "/latest/api/token":
+			io.WriteString(w, "token")
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			io.WriteString(w, "certmagic")
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/certmagic":
+			json.NewEncoder(w).Encode(map[string]any{
+				"Code":            "Success",
+				"AccessKeyId":     "ASIAINSTANCE",
+				"SecretAccessKey": "instance-secret",
+				"Token":           "instance-token",
+				"Expiration":      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestCredentialChain(t *testing.T) {
+	clearAWSEnv(t)
+	static := newStaticCreds("static-access", "static-secret", "")
+
+	v, err := newCredentialChain(static, fakeIMDS(t, true)).Get()
+	if err != nil || v.AccessKeyID != "ASIAINSTANCE" || v.SessionToken != "instance-token" {
+		t.Errorf("instance metadata credentials = %+v, %v", v, err)
+	}
+
+	v, err = newCredentialChain(static, fakeIMDS(t, false)).Get()
+	if err != nil || v.AccessKeyID != "static-access" {
+		t.Errorf("fallback credentials = %+v, %v, expected the static keys", v, err)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-access")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	v, err = newCredentialChain(static, fakeIMDS(t, true)).Get()
+	if err != nil || v.AccessKeyID != "env-access" {
+		t.Errorf("credentials = %+v, %v, expected those of the environment first", v, err)
+	}
+}
+
+func TestS3Storage_CredentialChain(t *testing.T) {
+	clearAWSEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-access")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	opts := testOpts(false)
+	opts.LazyInit = true
+	opts.CredentialChain = true
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	if v, err := storage.clientOpts.Creds.Get(); err != nil || v.AccessKeyID != "env-access" {
+		t.Errorf("credentials = %+v, %v", v, err)
+	}
+
+	opts.Bucket = "arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap"
+	var ce *ConfigError
+	if err := opts.Validate(); !errors.As(err, &ce) || ce.Field != "CredentialChain" {
+		t.Errorf("Validate() of CredentialChain with a Multi-Region Access Point = %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package cmgs3
+
+import "context"
+
+type backgroundKey struct{}
+
+// WithBackground returns a context whose operations are background work,
+// bounded by S3Opts.BackgroundTimeout instead of InteractiveTimeout. Their
+// requests are of class PriorityBulk, unless WithPriority sets another.
+func WithBackground(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, true)
+}
+
+// IsBackground reports whether ctx was marked with WithBackground.
+func IsBackground(ctx context.Context) bool {
+	b, _ := ctx.Value(backgroundKey{}).(bool)
+	return b
+}
+
+// withDeadline bounds an operation with ctx by the timeout of its class.
+func (gs *S3Storage) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	d := gs.interactiveTimeout
+	if IsBackground(ctx) {
+		d = gs.backgroundTimeout
+	}
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
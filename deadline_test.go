@@ -0,0 +1,36 @@
+package cmgs3
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestS3Storage_WithDeadline(t *testing.T) {
+	gs := &S3Storage{interactiveTimeout: time.Second, backgroundTimeout: time.Hour}
+
+	ctx, cancel := gs.withDeadline(context.Background())
+	defer cancel()
+	if d, ok := ctx.Deadline(); !ok || time.Until(d) > time.Second {
+		t.Errorf("interactive deadline = %v, %v", d, ok)
+	}
+	ctx, cancel = gs.withDeadline(WithBackground(context.Background()))
+	defer cancel()
+	if d, ok := ctx.Deadline(); !ok || time.Until(d) < time.Minute {
+		t.Errorf("background deadline = %v, %v", d, ok)
+	}
+
+	// An earlier deadline of the caller stays.
+	parent, cancelParent := context.WithTimeout(WithBackground(context.Background()), time.Millisecond)
+	defer cancelParent()
+	ctx, cancel = gs.withDeadline(parent)
+	defer cancel()
+	if d, _ := ctx.Deadline(); time.Until(d) > time.Millisecond {
+		t.Errorf("deadline = %v, expected the caller's", d)
+	}
+
+	gs.interactiveTimeout = 0
+	if ctx, _ := gs.withDeadline(context.Background()); ctx != context.Background() {
+		t.Error("withDeadline() without a timeout changed the context")
+	}
+}
@@ -0,0 +1,195 @@
+package cmgs3
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// debugRequests is the number of recent requests whose IDs are kept for
+// DebugBundle.
+var debugRequests = 50
+
+// debugRequest is a request to S3 as listed in a debug bundle. Its IDs
+// identify it to the provider's support.
+type debugRequest struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	HostID    string    `json:"host_id,omitempty"`
+}
+
+// requestLog keeps the last debugRequests requests.
+type requestLog struct {
+	mu   sync.Mutex
+	reqs []debugRequest
+	next int
+}
+
+func (l *requestLog) add(r debugRequest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.reqs) < debugRequests {
+		l.reqs = append(l.reqs, r)
+		return
+	}
+	l.reqs[l.next] = r
+	l.next = (l.next + 1) % len(l.reqs)
+}
+
+// recent returns the requests, oldest first.
+func (l *requestLog) recent() []debugRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append(append([]debugRequest{}, l.reqs[l.next:]...), l.reqs[:l.next]...)
+}
+
+// requestIDTransport records the IDs S3 answers requests with.
+type requestIDTransport struct {
+	log  *requestLog
+	base http.RoundTripper
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	r := debugRequest{Time: time.Now().UTC(), Method: req.Method, Path: req.URL.Path}
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.Status = resp.StatusCode
+		r.RequestID = resp.Header.Get("X-Amz-Request-Id")
+		r.HostID = resp.Header.Get("X-Amz-Id-2")
+	}
+	t.log.add(r)
+	return resp, err
+}
+
+// debugProbe is the outcome of a capability probe of a debug bundle.
+type debugProbe struct {
+	Name       string  `json:"name"`
+	Result     string  `json:"result,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// DebugBundle writes a zip archive for attaching to bug reports to w: the
+// options with secrets redacted, the stats and metrics of the storage, the
+// locks it holds, the results of probing the bucket for the features the
+// storage uses, and the IDs of its recent requests. Probing conditional
+// writes writes and removes an object under SelfTestPrefix. The storage
+// need not be connected, the bundle is most useful when it cannot. Text in
+// the bundle is scrubbed like log lines.
+func (gs *S3Storage) DebugBundle(ctx context.Context, w io.Writer) error {
+	var metrics bytes.Buffer
+	if err := gs.WriteMetrics(&metrics); err != nil {
+		return err
+	}
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"config.json", redactOpts(gs.currentOpts())},
+		{"stats.json", gs.adminStats()},
+		{"metrics.txt", metrics.String()},
+		{"locks.json", gs.HeldLocks()},
+		{"probes.json", gs.probe(ctx)},
+		{"requests.json", gs.requests.recent()},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		buf, ok := f.v.(string)
+		if !ok {
+			b, err := json.MarshalIndent(f.v, "", "  ")
+			if err != nil {
+				return err
+			}
+			buf = string(b)
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, gs.scrub.scrub(buf)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// probe checks the bucket for the features the storage relies on.
+func (gs *S3Storage) probe(ctx context.Context) []debugProbe {
+	var probes []debugProbe
+	run := func(name string, f func() (string, error)) {
+		start := time.Now()
+		res, err := f()
+		p := debugProbe{Name: name, Result: res, DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+		if err != nil {
+			p.Error = err.Error()
+		}
+		probes = append(probes, p)
+	}
+
+	run("bucket", func() (string, error) {
+		ok, err := gs.s3client.BucketExists(ctx, gs.bucket)
+		if err != nil || !ok {
+			return "missing", err
+		}
+		return "exists", nil
+	})
+	run("clock-skew", func() (string, error) {
+		return time.Duration(gs.clock.offset.Load()).String(), nil
+	})
+	run("versioning", func() (string, error) {
+		cfg, err := gs.s3client.GetBucketVersioning(ctx, gs.bucket)
+		if err != nil {
+			return "", err
+		}
+		if cfg.Status == "" {
+			return "unversioned", nil
+		}
+		return cfg.Status, nil
+	})
+	run("object-lock", func() (string, error) {
+		enabled, _, _, _, err := gs.s3client.GetObjectLockConfig(ctx, gs.bucket)
+		if minio.ToErrorResponse(err).Code == "ObjectLockConfigurationNotFoundError" {
+			return "disabled", nil
+		}
+		return enabled, err
+	})
+	run("conditional-writes", func() (string, error) {
+		var id [8]byte
+		rand.Read(id[:])
+		obj := gs.objName(SelfTestPrefix + "/" + hex.EncodeToString(id[:]) + "/probe")
+		put := func() error {
+			opts := minio.PutObjectOptions{DisableContentSha256: gs.unsignedPayload}
+			opts.SetMatchETagExcept("*")
+			_, err := gs.s3client.PutObject(ctx, gs.bucket, obj, bytes.NewReader(nil), 0, opts)
+			return err
+		}
+		if err := put(); err != nil {
+			return "", err
+		}
+		defer gs.s3client.RemoveObject(ctx, gs.bucket, obj, minio.RemoveObjectOptions{})
+		err := put()
+		if isPreconditionFailed(err) {
+			return "supported", nil
+		} else if err != nil {
+			return "", err
+		}
+		return "ignored", nil
+	})
+	return probes
+}
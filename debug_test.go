@@ -0,0 +1,103 @@
+package cmgs3
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestLog(t *testing.T) {
+	l := &requestLog{}
+	for i := 0; i < debugRequests+5; i++ {
+		l.add(debugRequest{RequestID: fmt.Sprint(i)})
+	}
+	reqs := l.recent()
+	if len(reqs) != debugRequests {
+		t.Fatalf("recent() returned %d requests, expected %d", len(reqs), debugRequests)
+	}
+	if reqs[0].RequestID != "5" || reqs[len(reqs)-1].RequestID != fmt.Sprint(debugRequests+4) {
+		t.Errorf("recent() = %s..%s, expected oldest first", reqs[0].RequestID, reqs[len(reqs)-1].RequestID)
+	}
+}
+
+func TestS3Storage_DebugBundle(t *testing.T) {
+	opts := testOpts(true)
+	opts.LazyInit = true
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	storage.requests.add(debugRequest{Method: "GET", Path: "/bucket/key", Status: 500, RequestID: "4442587FB7D0A2F9"})
+	storage.localMu.Lock()
+	storage.held["certificates/example.com"] = time.Now()
+	storage.localMu.Unlock()
+
+	// The probes fail without S3, which the bundle reports.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var buf bytes.Buffer
+	if err := storage.DebugBundle(ctx, &buf); err != nil {
+		t.Fatalf("DebugBundle() failed: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("bundle is not a zip: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(b)
+	}
+
+	for _, name := range []string{"config.json", "stats.json", "metrics.txt", "locks.json", "probes.json", "requests.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("bundle lacks %s", name)
+		}
+	}
+	for name, content := range files {
+		if strings.Contains(content, opts.SecretAccessKey) || strings.Contains(content, string(opts.EncryptionKey)) {
+			t.Errorf("%s contains a secret:\n%s", name, content)
+		}
+	}
+	if !strings.Contains(files["config.json"], opts.Bucket) {
+		t.Errorf("config.json lacks the bucket:\n%s", files["config.json"])
+	}
+	if !strings.Contains(files["locks.json"], "certificates/example.com") {
+		t.Errorf("locks.json lacks the held lock:\n%s", files["locks.json"])
+	}
+	if !strings.Contains(files["requests.json"], "4442587FB7D0A2F9") {
+		t.Errorf("requests.json lacks the request ID:\n%s", files["requests.json"])
+	}
+	var probes []debugProbe
+	if err := json.Unmarshal([]byte(files["probes.json"]), &probes); err != nil {
+		t.Fatalf("invalid probes.json: %v", err)
+	}
+	if len(probes) == 0 || probes[0].Name != "bucket" {
+		t.Errorf("probes = %+v", probes)
+	}
+}
+
+func TestS3Storage_DebugBundleProbes(t *testing.T) {
+	storage := setupTestStorage(t, false)
+	defer storage.Close()
+	probes := storage.probe(context.Background())
+	for _, p := range probes {
+		if p.Error != "" {
+			t.Errorf("probe %s failed: %s", p.Name, p.Error)
+		}
+	}
+	if len(storage.requests.recent()) == 0 {
+		t.Error("requests of the probes not recorded")
+	}
+}
@@ -0,0 +1,111 @@
+package cmgs3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// BlobGCGrace protects blobs younger than this from CollectBlobs, so a blob
+// written by a concurrent Store is not removed before its pointer exists.
+var BlobGCGrace = time.Hour
+
+// DefaultDedupKey selects certificate chains, which are public and often
+// identical across many names.
+func DefaultDedupKey(key string) bool {
+	return strings.HasSuffix(key, ".crt")
+}
+
+func (gs *S3Storage) isDedupKey(key string) bool {
+	return gs.dedupKey != nil && gs.dedupKey(key)
+}
+
+// blobKey is the key blobs are sealed for, so per-object keys are derived
+// per blob.
+func blobKey(id string) string {
+	return ".blobs/" + id
+}
+
+// blobIDLen is the length of the hex encoded blob IDs.
+const blobIDLen = 2 * sha256.Size
+
+func (gs *S3Storage) blobName(id string) string {
+	return gs.prefix + ".blobs/" + id
+}
+
+// blobID names the blob of value by its SHA-256, or by its HMAC under the
+// encryption key, so stored names do not confirm guesses of the content.
+func (gs *S3Storage) blobID(value []byte) string {
+	if s, ok := gs.keys.get().(sealer); ok {
+		k := s.secretKey()
+		mac := hmac.New(sha256.New, k[:])
+		mac.Write(value)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// storeBlob writes value under its content address and returns the ID. The
+// blob is rewritten even if it exists, which renews its age for
+// CollectBlobs.
+func (gs *S3Storage) storeBlob(ctx context.Context, value []byte) (string, error) {
+	id := gs.blobID(value)
+	r := gs.ioFor(blobKey(id)).ByteReader(value)
+	err := gs.putObject(ctx, gs.blobName(id), r, map[string]string{
+		metaPlaintextLength: strconv.Itoa(len(value)),
+	}, false, "")
+	return id, err
+}
+
+// CollectBlobs removes blobs no longer referenced by any key and returns
+// how many were removed.
+func (gs *S3Storage) CollectBlobs(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	used := make(map[string]bool)
+	for obj := range gs.s3client.ListObjects(ctx, gs.bucket, minio.ListObjectsOptions{
+		Prefix:    gs.prefix + "/",
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return 0, obj.Err
+		}
+		if strings.HasSuffix(obj.Key, ".lock") || obj.Size != blobIDLen {
+			continue
+		}
+		oi, err := gs.s3client.StatObject(ctx, gs.bucket, obj.Key, minio.StatObjectOptions{})
+		if err != nil {
+			return 0, err
+		}
+		if id := oi.UserMetadata[metaBlob]; id != "" {
+			used[id] = true
+		}
+	}
+
+	removed := 0
+	for obj := range gs.s3client.ListObjects(ctx, gs.bucket, minio.ListObjectsOptions{
+		Prefix:    gs.blobName(""),
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return removed, obj.Err
+		}
+		id := strings.TrimPrefix(obj.Key, gs.blobName(""))
+		if used[id] || time.Since(obj.LastModified) < BlobGCGrace {
+			continue
+		}
+		if err := gs.s3client.RemoveObject(ctx, gs.bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,97 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestS3Storage_Dedup(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		opts := testOpts(encrypted)
+		opts.Dedup = true
+		storage := setupTestStorageOpts(t, opts)
+		ctx := context.Background()
+		blobs := func() int {
+			n := 0
+			for obj := range storage.s3client.ListObjects(ctx, testBucket, minio.ListObjectsOptions{Prefix: storage.blobName(""), Recursive: true}) {
+				if obj.Err == nil {
+					n++
+				}
+			}
+			return n
+		}
+		grace := BlobGCGrace
+		BlobGCGrace = 0
+		storage.CollectBlobs(ctx)
+		BlobGCGrace = grace
+
+		chain := []byte("-----BEGIN CERTIFICATE-----\nissuer\n-----END CERTIFICATE-----\n")
+		for _, key := range []string{"certificates/a/a.crt", "certificates/b/b.crt"} {
+			if err := storage.Store(ctx, key, chain); err != nil {
+				t.Fatalf("Store() failed: %v", err)
+			}
+		}
+		if err := storage.Store(ctx, "certificates/a/a.key", []byte("private")); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+		if n := blobs(); n != 1 {
+			t.Errorf("encrypted=%v: %d blobs stored, expected 1", encrypted, n)
+		}
+
+		for _, key := range []string{"certificates/a/a.crt", "certificates/b/b.crt", "certificates/a/a.key"} {
+			buf, err := storage.Load(ctx, key)
+			if err != nil {
+				t.Fatalf("Load(%s) failed: %v", key, err)
+			}
+			if key != "certificates/a/a.key" && !bytes.Equal(buf, chain) {
+				t.Errorf("Load(%s) = %q, expected chain", key, buf)
+			}
+		}
+		if part, err := storage.LoadRange(ctx, "certificates/b/b.crt", 0, 5); err != nil || string(part) != "-----" {
+			t.Errorf("LoadRange() = %q, %v", part, err)
+		}
+		ki, err := storage.Stat(ctx, "certificates/b/b.crt")
+		if err != nil || ki.Size != int64(len(chain)) {
+			t.Errorf("Stat() = %+v, %v, expected size %d", ki, err, len(chain))
+		}
+
+		storage.Delete(ctx, "certificates/a/a.crt")
+		if n, err := storage.CollectBlobs(ctx); err != nil || n != 0 {
+			t.Errorf("CollectBlobs() = %d, %v, expected referenced blob kept", n, err)
+		}
+		storage.Delete(ctx, "certificates/b/b.crt")
+		BlobGCGrace = 0
+		n, err := storage.CollectBlobs(ctx)
+		BlobGCGrace = grace
+		if err != nil || n != 1 {
+			t.Errorf("CollectBlobs() = %d, %v, expected 1 removed", n, err)
+		}
+		if n := blobs(); n != 0 {
+			t.Errorf("%d blobs left after CollectBlobs", n)
+		}
+	}
+}
+
+func TestS3Storage_CollectBlobsGrace(t *testing.T) {
+	opts := testOpts(false)
+	opts.Dedup = true
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "certificates/c/c.crt", []byte("young")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	storage.Delete(ctx, "certificates/c/c.crt")
+	grace := BlobGCGrace
+	BlobGCGrace = time.Hour
+	defer func() { BlobGCGrace = grace }()
+	if n, err := storage.CollectBlobs(ctx); err != nil || n != 0 {
+		t.Errorf("CollectBlobs() = %d, %v, expected young blob kept", n, err)
+	}
+	BlobGCGrace = 0
+	storage.CollectBlobs(ctx)
+}
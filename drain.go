@@ -0,0 +1,87 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrDraining is returned by writes and locks started after Drain.
+var ErrDraining = errors.New("storage is draining")
+
+// drainGate tracks the writes in flight, so Drain can wait for them, and
+// refuses new ones once draining.
+type drainGate struct {
+	mu       sync.Mutex
+	draining bool
+	active   int
+	idle     chan struct{} // closed when the last write leaves while draining
+}
+
+// enter admits a write, or reports false when draining.
+func (g *drainGate) enter() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining {
+		return false
+	}
+	g.active++
+	return true
+}
+
+func (g *drainGate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.active == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// drain refuses new writes and waits for those in flight.
+func (g *drainGate) drain(ctx context.Context) error {
+	g.mu.Lock()
+	g.draining = true
+	if g.active == 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	if g.idle == nil {
+		g.idle = make(chan struct{})
+	}
+	idle := g.idle
+	g.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drain shuts the storage down for a restart of the application: it
+// refuses new writes and locks with ErrDraining, waits for those in
+// flight, releases the locks held by this instance, writes the queued
+// asynchronous writes and then closes the storage. Reads keep working
+// meanwhile. It is meant to be called from the application's shutdown
+// path once certmagic stopped issuing certificates, with ctx bounding the
+// time it may take; the storage is closed even if ctx expires, but
+// writes and locks not finished by then may be lost or left to expire.
+func (gs *S3Storage) Drain(ctx context.Context) error {
+	var errs []error
+	if err := gs.writes.drain(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("waiting for writes: %w", err))
+	}
+	if err := gs.UnlockAll(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("releasing locks: %w", err))
+	}
+	if gs.queue != nil {
+		if err := gs.queue.close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("flushing queued writes: %w", err))
+		}
+	}
+	errs = append(errs, gs.Close())
+	return errors.Join(errs...)
+}
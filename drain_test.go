@@ -0,0 +1,94 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDrainGate(t *testing.T) {
+	var g drainGate
+	if !g.enter() {
+		t.Fatal("enter() refused before draining")
+	}
+	drained := make(chan error)
+	go func() { drained <- g.drain(context.Background()) }()
+	select {
+	case <-drained:
+		t.Fatal("drain() returned with a write in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if g.enter() {
+		t.Error("enter() admitted a write while draining")
+	}
+	g.leave()
+	if err := <-drained; err != nil {
+		t.Errorf("drain() failed: %v", err)
+	}
+
+	g = drainGate{}
+	g.enter()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("drain() with a stuck write = %v, expected the deadline", err)
+	}
+}
+
+func TestS3Storage_Drain(t *testing.T) {
+	opts := testOpts(false)
+	opts.LazyInit = true
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	if err := storage.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain() failed: %v", err)
+	}
+	ctx := context.Background()
+	if err := storage.Store(ctx, "certificates/a", []byte("a")); !errors.Is(err, ErrDraining) {
+		t.Errorf("Store() after Drain() = %v, expected ErrDraining", err)
+	}
+	if err := storage.Delete(ctx, "certificates/a"); !errors.Is(err, ErrDraining) {
+		t.Errorf("Delete() after Drain() = %v, expected ErrDraining", err)
+	}
+	if err := storage.Copy(ctx, "certificates/a", "certificates/b"); !errors.Is(err, ErrDraining) {
+		t.Errorf("Copy() after Drain() = %v, expected ErrDraining", err)
+	}
+	if err := storage.Lock(ctx, "certificates/a"); !errors.Is(err, ErrDraining) {
+		t.Errorf("Lock() after Drain() = %v, expected ErrDraining", err)
+	}
+}
+
+func TestS3Storage_DrainLocksAndQueue(t *testing.T) {
+	opts := testOpts(false)
+	opts.AsyncQueueSize = 8
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if err := storage.Lock(ctx, "drain"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if err := storage.Store(ctx, "ocsp/drain", []byte("staple")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if err := storage.Drain(ctx); err != nil {
+		t.Fatalf("Drain() failed: %v", err)
+	}
+	if n := len(storage.HeldLocks()); n != 0 {
+		t.Errorf("%d locks held after Drain()", n)
+	}
+
+	other := setupTestStorage(t, false)
+	defer other.Close()
+	if v, err := other.Load(ctx, "ocsp/drain"); err != nil || string(v) != "staple" {
+		t.Errorf("queued write lost: %q, %v", v, err)
+	}
+	lctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := other.Lock(lctx, "drain"); err != nil {
+		t.Errorf("lock not released by Drain(): %v", err)
+	}
+	other.Unlock(ctx, "drain")
+}
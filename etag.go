@@ -0,0 +1,104 @@
+package cmgs3
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+	"github.com/sam-lord/certmagic"
+)
+
+// KeyInfoExt is the KeyInfo of a key together with the provider's
+// identification of the object holding its value.
+type KeyInfoExt struct {
+	certmagic.KeyInfo
+	// ETag changes with every write of the object.
+	ETag string
+	// VersionID is the version of the object in versioned buckets.
+	VersionID string
+}
+
+// LoaderStat is implemented by storages that return the KeyInfoExt of
+// keys, e.g. to validate a cache of their values by ETag, or to detect
+// that a value changed between a read and a write.
+type LoaderStat interface {
+	// LoadExt loads the value of key and the KeyInfoExt of that value.
+	LoadExt(ctx context.Context, key string) ([]byte, KeyInfoExt, error)
+	// StatExt returns the KeyInfoExt of key.
+	StatExt(ctx context.Context, key string) (KeyInfoExt, error)
+}
+
+var _ LoaderStat = (*S3Storage)(nil)
+
+// LoadExt loads the value of key from S3, bypassing the caches, so it
+// matches the ETag returned. Pending asynchronous writes are flushed
+// first. Keys stored in bundles have no ETag of their own and fail.
+func (gs *S3Storage) LoadExt(ctx context.Context, key string) (_ []byte, ki KeyInfoExt, err error) {
+	defer gs.observe("load", namespaceOf(key), time.Now(), &err)
+	ctx = withDefaultPriority(ctx, PriorityCritical)
+	ctx, cancel := gs.withDeadline(ctx)
+	defer cancel()
+	if err := gs.extable(ctx, "load", key); err != nil {
+		return nil, ki, err
+	}
+
+	body := getBuffer()
+	defer putBuffer(body)
+	// Not from a replica, which may lag behind.
+	raw, oi, err := gs.getObjectFrom(ctx, gs.s3client, gs.bucket, gs.objName(key), body)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, ki, fs.ErrNotExist
+	} else if err != nil {
+		return nil, ki, err
+	}
+	buf, err := gs.decode(ctx, key, raw, oi, body)
+	if err != nil {
+		return nil, ki, err
+	}
+	return buf, gs.keyInfoExt(key, oi), nil
+}
+
+// StatExt returns the KeyInfoExt of key from S3, bypassing the caches and
+// the index. Keys stored in bundles have no ETag of their own and fail.
+func (gs *S3Storage) StatExt(ctx context.Context, key string) (ki KeyInfoExt, err error) {
+	defer gs.observe("stat", namespaceOf(key), time.Now(), &err)
+	ctx, cancel := gs.withDeadline(ctx)
+	defer cancel()
+	if err := gs.extable(ctx, "stat", key); err != nil {
+		return ki, err
+	}
+	oi, err := gs.s3client.StatObject(ctx, gs.bucket, gs.objName(key), minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ki, fs.ErrNotExist
+	} else if err != nil {
+		return ki, err
+	}
+	return gs.keyInfoExt(key, oi), nil
+}
+
+// extable checks that the object of key can be read for op, and holds
+// its latest value.
+func (gs *S3Storage) extable(ctx context.Context, op, key string) error {
+	if err := gs.access(op, key, namespaceOf(key), PermRead); err != nil {
+		return err
+	}
+	if _, _, ok := gs.bundled(key); ok {
+		return fmt.Errorf("%s is stored in a bundle, without an ETag of its own", key)
+	}
+	if err := gs.ready(ctx); err != nil {
+		return err
+	}
+	if gs.queue != nil {
+		if _, ok := gs.queue.lookup(key); ok {
+			return gs.Flush(ctx)
+		}
+	}
+	return nil
+}
+
+func (gs *S3Storage) keyInfoExt(key string, oi minio.ObjectInfo) KeyInfoExt {
+	ki, _ := gs.keyInfo(key, oi)
+	return KeyInfoExt{KeyInfo: ki, ETag: oi.ETag, VersionID: oi.VersionID}
+}
@@ -0,0 +1,52 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestS3Storage_ExtBundled(t *testing.T) {
+	opts := testOpts(false)
+	opts.LazyInit = true
+	opts.Bundle = true
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	key := "certificates/ca/a.com/a.com.crt"
+	if _, err := storage.StatExt(context.Background(), key); err == nil || !strings.Contains(err.Error(), "bundle") {
+		t.Errorf("StatExt() of a bundled key = %v", err)
+	}
+}
+
+func TestS3Storage_LoadExt(t *testing.T) {
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+	key := "test/etag.pem"
+	if err := storage.Store(ctx, key, []byte("first")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	defer storage.Delete(ctx, key)
+
+	value, loaded, err := storage.LoadExt(ctx, key)
+	if err != nil || string(value) != "first" || loaded.ETag == "" {
+		t.Fatalf("LoadExt() = %q, %+v, %v", value, loaded, err)
+	}
+	stated, err := storage.StatExt(ctx, key)
+	if err != nil || stated.ETag != loaded.ETag || stated.Size != 5 {
+		t.Errorf("StatExt() = %+v, %v, expected ETag %s", stated, err, loaded.ETag)
+	}
+
+	if err := storage.Store(ctx, key, []byte("second")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if stated, err := storage.StatExt(ctx, key); err != nil || stated.ETag == loaded.ETag {
+		t.Errorf("StatExt() after a write = %+v, %v, expected a new ETag", stated, err)
+	}
+	if _, _, err := storage.LoadExt(ctx, "test/missing-etag"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadExt() of a missing key = %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package cmgs3
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"io/fs"
+	"sync"
+)
+
+// opCounters count the storage operations and their errors by operation,
+// as published with expvar.
+type opCounters struct {
+	ops    *expvar.Map
+	errors *expvar.Map
+}
+
+// expvarMu serializes publishing, as expvar panics on names used twice.
+var expvarMu sync.Mutex
+
+// publishCounters returns the counters published under name. Storages
+// using the same name, e.g. one replacing another, share them.
+func publishCounters(name string) (*opCounters, error) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	v := expvar.Get(name)
+	if v == nil {
+		m := expvar.NewMap(name)
+		m.Set("ops", new(expvar.Map))
+		m.Set("errors", new(expvar.Map))
+		v = m
+	}
+	m, _ := v.(*expvar.Map)
+	if m == nil {
+		return nil, fmt.Errorf("expvar %q is already published", name)
+	}
+	ops, _ := m.Get("ops").(*expvar.Map)
+	errs, _ := m.Get("errors").(*expvar.Map)
+	if ops == nil || errs == nil {
+		return nil, fmt.Errorf("expvar %q is already published", name)
+	}
+	return &opCounters{ops: ops, errors: errs}, nil
+}
+
+// count counts an operation that returned err. Keys that do not exist are
+// an answer, not an error.
+func (c *opCounters) count(op string, err error) {
+	c.ops.Add(op, 1)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		c.errors.Add(op, 1)
+	}
+}
@@ -0,0 +1,34 @@
+package cmgs3
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"io/fs"
+	"testing"
+)
+
+func TestOpCounters(t *testing.T) {
+	counters, err := publishCounters("cmgs3_test_ops")
+	if err != nil {
+		t.Fatalf("publishCounters() failed: %v", err)
+	}
+	for _, err := range []error{nil, fmt.Errorf("k: %w", fs.ErrNotExist), errors.New("down")} {
+		counters.count("load", err)
+	}
+	if n := counters.ops.Get("load").String(); n != "3" {
+		t.Errorf("load ops = %s, expected 3", n)
+	}
+	if n := counters.errors.Get("load").String(); n != "1" {
+		t.Errorf("load errors = %s, expected 1", n)
+	}
+
+	shared, err := publishCounters("cmgs3_test_ops")
+	if err != nil || shared.ops != counters.ops {
+		t.Errorf("publishCounters() again = %v, %v, expected the same counters", shared, err)
+	}
+	expvar.NewInt("cmgs3_test_int")
+	if _, err := publishCounters("cmgs3_test_int"); err == nil {
+		t.Error("publishCounters() over another variable succeeded")
+	}
+}
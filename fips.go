@@ -0,0 +1,24 @@
+package cmgs3
+
+import (
+	"os"
+	"strings"
+)
+
+// fipsRequired reports whether the environment demands FIPS-approved
+// cryptography: a boringcrypto toolchain, GOFIPS as understood by
+// FIPS-enabled Go distributions, or Go's native fips140 GODEBUG setting.
+func fipsRequired() bool {
+	if boringCrypto {
+		return true
+	}
+	if v := os.Getenv("GOFIPS"); v != "" && v != "0" {
+		return true
+	}
+	for _, kv := range strings.Split(os.Getenv("GODEBUG"), ",") {
+		if kv == "fips140=on" || kv == "fips140=only" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,5 @@
+//go:build !boringcrypto
+
+package cmgs3
+
+const boringCrypto = false
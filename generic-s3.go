@@ -3,11 +3,20 @@ package cmgs3
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
-	"log"
+	"iter"
+	"log/slog"
+	"maps"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	minio "github.com/minio/minio-go/v7"
@@ -16,177 +25,1893 @@ import (
 )
 
 type S3Opts struct {
-	Endpoint        string
+	// Provider applies the known-good defaults of an S3 implementation:
+	// "aws", "minio", "r2", "b2", "gcs", "wasabi", "spaces" or "ceph". It
+	// fills in the endpoint and region where they are fixed, the addressing
+	// style, retry tuning and ConditionalLocks, and rejects options the
+	// provider does not support. Empty applies none.
+	Provider string
+
+	Endpoint string
+	// Bucket is the bucket name, or the ARN of a Multi-Region Access Point,
+	// which is then addressed via its global endpoint, the default with an
+	// empty Endpoint, and signed with SigV4A.
 	Bucket          string
 	AccessKeyID     string
 	SecretAccessKey string
+	// SessionToken is the token of temporary credentials, e.g. those
+	// issued by AWS STS, which need it besides the keys.
+	SessionToken string
+	// CredentialChain looks up credentials the way AWS SDKs do, e.g. on
+	// EC2, ECS or EKS without keys in the configuration: from the
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables,
+	// then IRSA web identity tokens, then the ECS or EC2 instance metadata
+	// service. AccessKeyID and SecretAccessKey are used only if none of
+	// these provides credentials.
+	CredentialChain bool
+
+	// Region is optional. If empty, it is looked up from the bucket, once
+	// per endpoint and bucket for all storages of the process.
+	Region string
+	// MaxRetries is the number of attempts the S3 client makes per request.
+	// Zero keeps the client default of 10.
+	MaxRetries int
+	// RetryBudget limits retries across all operations to this many per
+	// second, with bursts of up to RetryBudgetBurst, instead of retrying
+	// each request up to MaxRetries times regardless. Failures beyond the
+	// budget return ErrRetryBudgetExhausted.
+	RetryBudget float64
+	// InteractiveTimeout bounds Store, Load, Delete, Exists, List and Stat,
+	// e.g. the loads of TLS handshakes, unless their context has an earlier
+	// deadline. BackgroundTimeout does so instead for operations whose
+	// context is marked with WithBackground, e.g. maintenance. Zero means
+	// no bound.
+	InteractiveTimeout time.Duration
+	BackgroundTimeout  time.Duration
 
 	ObjPrefix string
 
+	// ClusterID claims ObjPrefix for this cluster with a file next to it,
+	// and is recorded with each object written. An instance finding the
+	// prefix claimed by another cluster, which would corrupt values sealed
+	// with its key, logs a warning, or fails with ErrPrefixClaimed if
+	// RejectClaimed is set.
+	ClusterID     string
+	RejectClaimed bool
+	// ProtectForeign makes Store and Copy refuse to overwrite objects
+	// written by another ClusterID with ErrForeignObject. It costs a Stat
+	// per write.
+	ProtectForeign bool
+
+	// LockPrefix holds the lock files instead of ObjPrefix, so listings of
+	// the stored keys, e.g. by backup tools, never see them. It must not be
+	// within ObjPrefix.
+	LockPrefix string
+	// LifecycleTags tags lock files, self-test sentinels and OCSP staples
+	// with LifecycleTagKey, so bucket lifecycle rules can expire those left
+	// behind; see LifecycleConfig. The provider must support object tags.
+	LifecycleTags bool
+
+	// Logger receives the log lines of the storage, instead of the standard
+	// logger; e.g. zap's through go.uber.org/zap/exp/zapslog. Lines never
+	// contain the credentials or encryption keys of the options.
+	Logger *slog.Logger
+
+	// TagHeader sends the Tags of each operation's context in this request
+	// header, e.g. for attribution in proxy or provider access logs.
+	TagHeader string
+
+	// TracePropagator adds the trace context of each operation's context to
+	// its requests, e.g. a W3C traceparent, so traces of the provider can be
+	// correlated with those of the application.
+	TracePropagator TracePropagator
+
+	// TransferAcceleration sends object requests to the S3 Transfer
+	// Acceleration endpoint, for servers far from the bucket's region. It
+	// must be enabled on the bucket, and is only offered by AWS; other
+	// providers' accelerated endpoints are set as Endpoint instead.
+	TransferAcceleration bool
+
+	// ReadReplicas are replicas of the bucket that Load reads from when
+	// they answer faster, failing over between them and the bucket. Writes
+	// go to the bucket only, so reads may briefly return older values.
+	ReadReplicas []ReadReplica
+	// HedgeDelay sends a second request for an object Load has not
+	// received within the delay, to the next of the ReadReplicas if any,
+	// and takes the first answer, trading requests for tail latency when
+	// the provider occasionally stalls. Zero disables hedging.
+	HedgeDelay time.Duration
+
+	// Resolver resolves the endpoint host instead of the system resolver.
+	Resolver *net.Resolver
+	// EndpointIPs are dialed, in order, instead of resolving the endpoint
+	// host at all, e.g. for split-horizon DNS. The host name is still used
+	// for TLS verification.
+	EndpointIPs []string
+	// IPFamily restricts connections to "ipv4" or "ipv6", e.g. on IPv6-only
+	// networks. By default both are used, preferring IPv6.
+	IPFamily string
+	// FallbackDelay is how long an IPv6 connection attempt runs before
+	// IPv4 is tried in parallel, 300ms by default. Negative disables it.
+	FallbackDelay time.Duration
+	// DisableDualStack uses the IPv4-only AWS endpoints instead of the
+	// dual-stack ones, which are reachable over IPv6 too.
+	DisableDualStack bool
+
+	// DedicatedTransport gives the storage connections of its own. By
+	// default, storages connecting to the same endpoint the same way share
+	// one connection pool.
+	DedicatedTransport bool
+
+	// PinnedSPKI restricts the endpoint to certificate chains containing a
+	// public key with one of these pins, as returned by SPKIPin, on top of
+	// the usual verification. Pin a backup key too.
+	PinnedSPKI []string
+
+	// MaxConcurrentRequests limits the S3 requests in flight across all
+	// operations, including prefetching, scans and background writes.
+	// Requests waiting for a slot get one by Priority.
+	MaxConcurrentRequests int
+	// AdaptiveConcurrency adapts the limit of requests in flight to what
+	// the provider handles, up to MaxConcurrentRequests if set: it grows
+	// while requests are answered within AdaptiveLatencyTarget and halves
+	// on throttling, server errors and slower answers.
+	AdaptiveConcurrency bool
+
+	// ExpvarName publishes counters of the storage operations and of their
+	// failures, by operation, under this name with expvar, for /debug/vars.
+	// Storages with the same name share the counters.
+	ExpvarName string
+
+	// LazyInit has NewS3Storage make no requests, e.g. when it runs before
+	// the network is up. The bucket is checked, and background work
+	// started, on the first operation or Ping instead, which return the
+	// errors NewS3Storage would have; later ones try again.
+	LazyInit bool
+
+	// CheckPublicAccess inspects the bucket policy and tries an anonymous
+	// listing at startup, logging a warning if the bucket appears to be
+	// publicly readable.
+	CheckPublicAccess bool
+
+	// AuditBucket mirrors a record of every Store and Delete into this bucket,
+	// which must have Object Lock enabled, giving a tamper-evident trail. A
+	// mutation whose record cannot be written returns an error.
+	AuditBucket string
+	// AuditRetention keeps each record in compliance mode for this long. If
+	// zero, the bucket's default compliance retention applies.
+	AuditRetention time.Duration
+
+	// ManifestKey enables periodic signed integrity manifests, written every
+	// ManifestInterval; see WriteManifest and VerifyManifest.
+	ManifestKey      ed25519.PrivateKey
+	ManifestInterval time.Duration
+
+	// InventoryInterval enables periodic inventory reports in
+	// InventoryFormat, "csv" (the default) or "json"; see WriteInventory.
+	InventoryInterval time.Duration
+	InventoryFormat   string
+
+	// ArchiveStorageClass and ArchivePrefix tell Archive where to move the
+	// certificates of long-expired sites: to the storage class, e.g.
+	// STANDARD_IA or GLACIER_IR, and under the prefix if set. Classes that
+	// need a restore before reading, like GLACIER, make archived values
+	// unreadable to Load.
+	ArchiveStorageClass string
+	ArchivePrefix       string
+
+	// Compress stores values compressed with zstd and a dictionary for
+	// PEM and certmagic's JSON, where that makes them smaller. Values are
+	// compressed before encryption; reading them needs no option set.
+	Compress bool
+
+	// Dedup stores the values of keys selected by DedupKey once per content,
+	// under the prefix's ".blobs/" sibling, with small pointer objects at the
+	// keys. Deleting a key leaves its blob; run CollectBlobs to remove
+	// unreferenced ones.
+	Dedup bool
+	// DedupKey defaults to DefaultDedupKey.
+	DedupKey func(key string) bool
+
+	// MaxLoadSize refuses to load objects larger than this many bytes, and
+	// MaxStoreSize to store larger values, with ErrObjectTooLarge.
+	MaxLoadSize  int64
+	MaxStoreSize int64
+
+	// MaxObjects and MaxBytes limit what may be stored under ObjPrefix;
+	// Store fails with a *QuotaError beyond them. Usage is counted from the
+	// bucket every QuotaRefreshInterval and is approximate in between.
+	MaxObjects int64
+	MaxBytes   int64
+
+	// Namespaces restricts the key namespaces, the first path element with
+	// its slash, this instance may access; operations on others fail with a
+	// *PermissionError. Top-level keys are in namespace "", locks in
+	// LockNamespace. Nil allows everything.
+	Namespaces map[string]Permission
+
+	// NamespaceEncryption seals the given namespaces with their own key or
+	// algorithm, or stores them in cleartext, e.g. the strongest settings
+	// for account and private keys. Values written before a namespace got
+	// its own key stay readable; the same caveat as for CleartextKeys
+	// applies to Cleartext namespaces.
+	NamespaceEncryption map[string]NamespaceEncryption
+
+	// CoalesceScans keeps the metadata that recursive listings return for
+	// ScanTTL, answering Stat and the existence check of Load from it. A
+	// maintenance cycle then costs a listing and one GET per key instead of
+	// three requests per key. Writes of other instances may be missed
+	// within ScanTTL.
+	CoalesceScans bool
+
+	// Locker provides locks instead of lock files in the bucket, e.g. from a
+	// coordination service the cluster runs anyway.
+	Locker Locker
+
+	// FairLocks has instances waiting for a lock queue up with small ticket
+	// objects, and a released lock go to the one waiting longest, instead
+	// of whoever polls first. It costs a LIST request per acquisition.
+	FairLocks bool
+
+	// ConditionalLocks takes lock files with conditional writes, so of
+	// instances racing for a free or expired lock only one wins. The
+	// provider must support If-None-Match and If-Match on PUT.
+	ConditionalLocks bool
+
+	// Undecryptable is how values sealed with an unknown key are treated.
+	// Listings only leave them out as far as the provider returns metadata
+	// with them.
+	Undecryptable UndecryptablePolicy
+
+	// Conflict decides what Store does when the key exists. Policies other
+	// than ConflictOverwrite do not queue asynchronous Stores.
+	Conflict ConflictPolicy
+
+	// UnlockOnClose has Close release the locks this instance still holds,
+	// so a restart does not wait for them to expire. See also
+	// ReleaseLocksOnSignal.
+	UnlockOnClose bool
+
+	// DeleteMissingError has Delete return fs.ErrNotExist for keys that do
+	// not exist, instead of succeeding like certmagic's file storage.
+	DeleteMissingError bool
+
+	// ShardCertificates spreads the certificates/ namespace across 256 hashed
+	// sub-prefixes to avoid hot partitions with very many certificates. It
+	// changes the object layout, so it must not be toggled on existing data.
+	ShardCertificates bool
+
+	// Bundle stores the certificate, private key and metadata of each site
+	// as one object, <site>.bundle in the site's directory. A load of one
+	// file serves the others for BundleCacheTTL, so loading a certificate
+	// takes one request instead of three. The files remain keys of their
+	// own to Load, Store, Stat, Exists, List and Delete. Files stored
+	// before are still read, and moved into the bundle when stored again.
+	// Bundles are written conditionally on the version read, so instances
+	// storing files of the same site at once do not drop each other's;
+	// only deleting the last file of a site races with them.
+	Bundle bool
+
+	// CacheTTL enables an in-memory read cache holding loaded values for this
+	// long. Other instances' writes become visible only after expiry.
+	CacheTTL time.Duration
+	// CacheNamespaceTTL overrides CacheTTL for the given namespaces, e.g.
+	// longer for "certificates/" and shorter for bookkeeping. Zero does not
+	// cache a namespace. Setting it enables the cache too.
+	CacheNamespaceTTL map[string]time.Duration
+	// CacheMaxBytes bounds the size of the cached values, evicting the least
+	// recently used beyond it. Zero does not bound it.
+	CacheMaxBytes int64
+	// ListCacheTTL keeps List results for this long, for the repeated
+	// identical listings of a maintenance pass. Local writes invalidate
+	// them; other instances' writes show after expiry.
+	ListCacheTTL time.Duration
+	// NegativeCacheTTL remembers keys found missing for this long, answering
+	// Load, Stat and Exists of them without a request, e.g. for the bursts
+	// of probes of on-demand TLS for unknown names. Writes through this
+	// instance, including copies and moves, clear them; those of other
+	// instances show after expiry.
+	NegativeCacheTTL time.Duration
+
+	// HostnamePolicy is asked before a Load of a certificate key that is not
+	// answered from memory goes to S3, with the key's hostname. An error
+	// refuses the load with it, e.g. for names on-demand TLS should never
+	// serve, so scanning them costs no requests.
+	HostnamePolicy func(hostname string) error
+	// HostnameLoadRate limits those loads to this many per second for each
+	// hostname, with bursts of up to HostnameLoadBurst; loads beyond it fail
+	// with ErrHostnameThrottled.
+	HostnameLoadRate  float64
+	HostnameLoadBurst int
+	// Index keeps the size and modification time of every key in a single
+	// object beside the prefix, updated on each write, so List and Stat of
+	// large storages need one GET instead of many LIST pages. It is
+	// reconciled with the bucket every IndexReconcileInterval.
+	Index bool
+
+	// AsyncQueueSize enables asynchronous writes of non-critical keys through
+	// a queue of this size. Store returns once the value is queued, or writes
+	// synchronously when the queue is full. Call Close to flush on shutdown.
+	AsyncQueueSize int
+	// AsyncKey selects the keys eligible for asynchronous writes. It
+	// defaults to DefaultAsyncKey; certificates and keys should never match.
+	AsyncKey func(key string) bool
+
+	// DisableContentSHA256 sends uploads as UNSIGNED-PAYLOAD instead of
+	// hashing every body for the request signature. Integrity in transit is
+	// still protected by TLS.
+	DisableContentSHA256 bool
+
+	// Checksum has uploads carry a trailing checksum of this algorithm
+	// (CRC32C, CRC32, SHA1 or SHA256), which S3 verifies and stores; Load
+	// verifies downloads against it. The provider must support trailing
+	// checksums.
+	Checksum string
+
+	// MultipartThreshold uploads objects of at least this many bytes in parts
+	// of this size (minimum 5 MiB). Zero keeps the client default of 16 MiB.
+	MultipartThreshold uint64
+	// MultipartConcurrency is the number of parts uploaded in parallel.
+	MultipartConcurrency uint
+
 	// EncryptionKey is optional. If you do not wish to encrypt your certficates and key inside the S3 bucket, leave it empty.
 	EncryptionKey []byte
+	// EncryptionKeyFile reads the encryption key from a file instead, holding
+	// 32 raw bytes or their hex or base64 encoding. It may list several hex
+	// or base64 encoded keys, one per line: the first seals new values, the
+	// others only open values written with them. The file is re-read on
+	// SIGHUP and polled for changes every KeyFilePollInterval. Keys dropped
+	// from it stay readable only until the process restarts, so keep the
+	// previous key listed until all values sealed with it were stored again.
+	EncryptionKeyFile string
+	// PreviousEncryptionKeys open values written with earlier keys, after
+	// the encryption key was rotated. New values are never sealed with them.
+	PreviousEncryptionKeys [][]byte
+	// FIPSMode restricts encryption to FIPS-approved primitives, sealing
+	// values with AES-256-GCM instead of NaCl secretbox. The formats are not
+	// compatible. It is forced on by GOFIPS=1, GODEBUG=fips140=on or only, and
+	// boringcrypto builds.
+	FIPSMode bool
+	// PerObjectKeys seals every value with its own key, derived from
+	// EncryptionKey and the certmagic key with HKDF-SHA256. Values written
+	// before it was enabled remain readable.
+	PerObjectKeys bool
+	// EncryptLocks encrypts lock files as well. By default they are kept in
+	// clear text, so operators can inspect them; they hold no secrets.
+	EncryptLocks bool
+	// CleartextKeys selects keys that are stored unencrypted even though an
+	// EncryptionKey is set, for non-sensitive bookkeeping objects. It must
+	// not change while data exists, or those objects become unreadable.
+	CleartextKeys func(key string) bool
 }
 
 type S3Storage struct {
-	prefix   string
-	bucket   string
-	s3client *minio.Client
+	prefix     string
+	lockPrefix string
+	bucket     string
+	s3client   *minio.Client
+	shardCerts bool
+
+	endpoint   string
+	clientOpts minio.Options
+
+	keys          *keyring
+	nsKeys        map[string]*keyring
+	nsCleartext   map[string]bool
+	nsPolicies    map[string]NamespaceEncryption
+	fips          bool
+	encryptLocks  bool
+	cleartextKeys func(key string) bool
+	deriveKeys    bool
+
+	keyFile    string
+	keyFileMu  sync.Mutex
+	keyFileSum [32]byte
+	cache      *readCache
+	lists      *listCache
+	index      *metaIndex
 
-	iowrap IO
+	partSize        uint64
+	partThreads     uint
+	unsignedPayload bool
+	lifecycleTags   bool
+	checksum        minio.ChecksumType
+
+	interactiveTimeout time.Duration
+	backgroundTimeout  time.Duration
+
+	queue    *writeQueue
+	asyncKey func(key string) bool
+	audit    *auditLog
+	dedupKey func(key string) bool
+	compress bool
+	quota    *quota
+	perms    map[string]Permission
+	scans    *scanCache
+	retries  *retryBudget
+	replicas []*replica
+	counters *opCounters
+	latency  latencyMetrics
+	negative *negCache
+
+	hostnamePolicy func(hostname string) error
+	hostLimits     *hostLimiter
+
+	undecryptablePolicy UndecryptablePolicy
+
+	deleteMissingError bool
+
+	maxLoadSize  atomic.Int64
+	maxStoreSize atomic.Int64
+
+	clock            *serverClock
+	owner            string
+	fairLocks        bool
+	conditionalLocks bool
+	clusterID        string
+	optsMu           sync.Mutex
+	opts             S3Opts // for AdminHandler and Reload
+	creds            *staticCreds
+	transports       *transportPool
+	conflict         ConflictPolicy
+	bundles          bool
+	migrating        bool
+	bundleCache      *readCache
+	bundleMu         sync.Mutex
+	bundleLocks      map[string]chan struct{}
+	protectForeign   bool
+	unlockOnClose    bool
+	locker           Locker
+	localMu          sync.Mutex
+	localLocks       map[string]chan struct{}
+	held             map[string]time.Time
+
+	connectMu sync.Mutex
+	connected atomic.Bool
+
+	logger   *slog.Logger
+	scrub    *scrubber
+	requests *requestLog
+	writes   drainGate
+
+	hedgeDelay time.Duration
+	hedges     hedgeStats
+
+	stop      chan struct{}
+	closeOnce sync.Once
 }
 
 func NewS3Storage(opts S3Opts) (*S3Storage, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts, lookup, err := applyProvider(opts)
+	if err != nil {
+		return nil, err
+	}
 	gs3 := &S3Storage{
-		prefix: opts.ObjPrefix,
-		bucket: opts.Bucket,
+		opts:       opts,
+		prefix:     opts.ObjPrefix,
+		lockPrefix: opts.LockPrefix,
+		bucket:     opts.Bucket,
+		shardCerts: opts.ShardCertificates,
+		hedgeDelay: opts.HedgeDelay,
+		logger:     opts.Logger,
+		scrub:      &scrubber{},
+		requests:   &requestLog{},
+		localLocks: make(map[string]chan struct{}),
+		held:       make(map[string]time.Time),
+		owner:      newOwnerID(),
+		clock:      &serverClock{},
+		stop:       make(chan struct{}),
+	}
+	gs3.scrub.add(opts)
+	if !opts.DedicatedTransport {
+		gs3.transports = &sharedTransports
+	}
+	if opts.CacheTTL > 0 || len(opts.CacheNamespaceTTL) != 0 {
+		gs3.cache = newReadCache(opts.CacheTTL)
+		gs3.cache.nsTTL = opts.CacheNamespaceTTL
+		gs3.cache.maxBytes = opts.CacheMaxBytes
+	}
+	if opts.ListCacheTTL > 0 {
+		gs3.lists = newListCache(opts.ListCacheTTL)
+	}
+	if opts.NegativeCacheTTL > 0 {
+		gs3.negative = newNegCache(opts.NegativeCacheTTL)
+	}
+	gs3.hostnamePolicy = opts.HostnamePolicy
+	if opts.HostnameLoadRate > 0 {
+		gs3.hostLimits = newHostLimiter(opts.HostnameLoadRate, opts.HostnameLoadBurst)
+	}
+	if opts.Index {
+		gs3.index = &metaIndex{gs: gs3}
+	}
+	gs3.partSize = opts.MultipartThreshold
+	gs3.partThreads = opts.MultipartConcurrency
+	gs3.unsignedPayload = opts.DisableContentSHA256
+	gs3.lifecycleTags = opts.LifecycleTags
+	gs3.interactiveTimeout, gs3.backgroundTimeout = opts.InteractiveTimeout, opts.BackgroundTimeout
+	gs3.perms = opts.Namespaces
+	gs3.deleteMissingError = opts.DeleteMissingError
+	gs3.fairLocks = opts.FairLocks
+	gs3.conditionalLocks = opts.ConditionalLocks
+	gs3.clusterID = opts.ClusterID
+	gs3.protectForeign = opts.ProtectForeign
+	gs3.unlockOnClose = opts.UnlockOnClose
+	gs3.undecryptablePolicy = opts.Undecryptable
+	gs3.conflict = opts.Conflict
+	if opts.Bundle {
+		gs3.bundles = true
+		gs3.bundleCache = newReadCache(BundleCacheTTL)
+		gs3.bundleLocks = make(map[string]chan struct{})
+	}
+	gs3.locker = opts.Locker
+	if opts.CoalesceScans {
+		gs3.scans = newScanCache()
+	}
+	if opts.ExpvarName != "" {
+		if gs3.counters, err = publishCounters(opts.ExpvarName); err != nil {
+			return nil, err
+		}
+	}
+	gs3.compress = opts.Compress
+	gs3.maxLoadSize.Store(opts.MaxLoadSize)
+	gs3.maxStoreSize.Store(opts.MaxStoreSize)
+	if opts.MaxObjects > 0 || opts.MaxBytes > 0 {
+		gs3.quota = &quota{maxObjects: opts.MaxObjects, maxBytes: opts.MaxBytes}
+	}
+	if opts.Dedup {
+		gs3.dedupKey = opts.DedupKey
+		if gs3.dedupKey == nil {
+			gs3.dedupKey = DefaultDedupKey
+		}
+	}
+	gs3.checksum, err = parseChecksum(opts.Checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	gs3.fips = opts.FIPSMode
+	if !gs3.fips && fipsRequired() {
+		gs3.logf("FIPS mode required by environment, using AES-GCM encryption")
+		gs3.fips = true
 	}
 
-	if opts.EncryptionKey == nil || len(opts.EncryptionKey) == 0 {
-		log.Println("Clear text certificate storage active")
-		gs3.iowrap = &CleartextIO{}
-	} else if len(opts.EncryptionKey) != 32 {
+	encryptionKey := opts.EncryptionKey
+	previousKeys := opts.PreviousEncryptionKeys
+	if opts.EncryptionKeyFile != "" {
+		keys, sum, err := readKeyFile(opts.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			gs3.scrub.addKey(key)
+		}
+		encryptionKey = keys[0]
+		previousKeys = append(keys[1:], previousKeys...)
+		gs3.keyFile, gs3.keyFileSum = opts.EncryptionKeyFile, sum
+	}
+
+	var iowrap IO
+	if encryptionKey == nil || len(encryptionKey) == 0 {
+		gs3.logf("Clear text certificate storage active")
+		iowrap = &CleartextIO{}
+	} else if len(encryptionKey) != 32 {
 		return nil, errors.New("encryption key must have exactly 32 bytes")
 	} else {
-		log.Println("Encrypted certificate storage active")
-		sb := &SecretBoxIO{}
-		copy(sb.SecretKey[:], opts.EncryptionKey)
-		gs3.iowrap = sb
+		gs3.logf("Encrypted certificate storage active")
+		iowrap = newSealer(encryptionKey, gs3.fips)
 	}
-
-	var err error
-	gs3.s3client, err = minio.New(opts.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(opts.AccessKeyID, opts.SecretAccessKey, ""),
-		Secure: true,
-	})
+	gs3.keys = &keyring{current: iowrap}
+	gs3.nsKeys, gs3.nsCleartext, err = newNamespaceKeys(opts.NamespaceEncryption, encryptionKey, gs3.fips)
 	if err != nil {
 		return nil, err
 	}
+	gs3.nsPolicies = maps.Clone(opts.NamespaceEncryption)
+	for _, key := range previousKeys {
+		if len(key) != 32 {
+			return nil, errors.New("previous encryption keys must have exactly 32 bytes")
+		}
+		gs3.retireKey(key)
+	}
+	gs3.encryptLocks = opts.EncryptLocks
+	gs3.cleartextKeys = opts.CleartextKeys
+	_, gs3.deriveKeys = iowrap.(sealer)
+	gs3.deriveKeys = gs3.deriveKeys && opts.PerObjectKeys
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	ok, err := gs3.s3client.BucketExists(ctx, opts.Bucket)
+	alias, mrap := parseMRAP(opts.Bucket)
+	if mrap {
+		gs3.bucket = alias
+		if opts.Endpoint == "" {
+			opts.Endpoint = mrapEndpoint
+		}
+	}
+	gs3.endpoint = opts.Endpoint
+	region := opts.Region
+	if region == "" {
+		region = cachedRegion(opts.Endpoint, opts.Bucket)
+	}
+	gs3.creds = newStaticCreds(opts.AccessKeyID, opts.SecretAccessKey, opts.SessionToken)
+	creds := credentials.New(gs3.creds)
+	if opts.CredentialChain {
+		creds = newCredentialChain(gs3.creds, "")
+	}
+	gs3.clientOpts = minio.Options{
+		Creds:           creds,
+		Secure:          true,
+		Region:          region,
+		TrailingHeaders: gs3.checksum.IsSet(),
+		MaxRetries:      opts.MaxRetries,
+		BucketLookup:    lookup,
+	}
+	if mrap {
+		// Signed by the transport, access points have no region to look up.
+		gs3.clientOpts.Creds = credentials.NewStatic("", "", "", credentials.SignatureAnonymous)
+		gs3.clientOpts.Region = "us-east-1"
+		gs3.clientOpts.BucketLookup = minio.BucketLookupDNS
+	}
+	if opts.RetryBudget > 0 {
+		gs3.retries = newRetryBudget(opts.RetryBudget, opts.MaxRetries)
+		gs3.clientOpts.MaxRetries = 1
+	}
+	gs3.clientOpts.Transport, err = newTransport(opts, gs3.clock, gs3.retries, gs3.transports)
 	if err != nil {
 		return nil, err
 	}
-	if !ok {
-		return nil, fmt.Errorf("s3 bucket %s does not exist", opts.Bucket)
+	gs3.clientOpts.Transport = &requestIDTransport{log: gs3.requests, base: gs3.clientOpts.Transport}
+	clientOpts := gs3.clientOpts
+	gs3.s3client, err = minio.New(opts.Endpoint, &clientOpts)
+	if err != nil {
+		return nil, err
+	}
+	gs3.s3client.SetS3EnableDualstack(!opts.DisableDualStack)
+
+	if len(opts.ReadReplicas) != 0 {
+		if gs3.replicas, err = gs3.newReplicas(opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.AsyncQueueSize > 0 {
+		gs3.asyncKey = opts.AsyncKey
+		if gs3.asyncKey == nil {
+			gs3.asyncKey = DefaultAsyncKey
+		}
+		gs3.queue = newWriteQueue(gs3, opts.AsyncQueueSize)
 	}
+	if gs3.keyFile != "" {
+		go gs3.watchKeyFile()
+	}
+	if opts.LazyInit {
+		return gs3, nil
+	}
+	if err := gs3.connect(context.Background()); err != nil {
+		// Stops the write queue and key file watcher started above.
+		gs3.Close()
+		return nil, err
+	}
+	gs3.connected.Store(true)
 	return gs3, nil
 }
 
+// connect checks the bucket and sets up what needs requests to it, then
+// starts the background work.
+func (gs *S3Storage) connect(ctx context.Context) error {
+	opts := gs.currentOpts()
+	_, mrap := parseMRAP(opts.Bucket)
+	// The initialization is bounded by InitTimeout instead.
+	initCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if !mrap {
+		ok, err := gs.s3client.BucketExists(ctx, opts.Bucket)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("s3 bucket %s does not exist", opts.Bucket)
+		}
+	}
+	if gs.clientOpts.Region == "" {
+		// Answered from the client's cache filled by BucketExists.
+		if loc, err := gs.s3client.GetBucketLocation(ctx, opts.Bucket); err == nil {
+			cacheRegion(opts.Endpoint, opts.Bucket, loc)
+		}
+	}
+	if opts.ClusterID != "" {
+		if err := gs.claimPrefix(ctx, opts.ClusterID); err != nil {
+			if opts.RejectClaimed || !errors.Is(err, ErrPrefixClaimed) {
+				return err
+			}
+			gs.warnf("%v", err)
+		}
+	}
+	if err := gs.checkLayout(ctx, opts); err != nil {
+		return err
+	}
+	if err := gs.initPrefix(initCtx, opts); err != nil {
+		return err
+	}
+	if opts.TransferAcceleration {
+		// Enabled after the bucket checks, which it does not serve.
+		if opts.DisableDualStack {
+			gs.s3client.SetS3TransferAccelerate(accelerateEndpoint)
+		} else {
+			gs.s3client.SetS3TransferAccelerate(accelerateDualStackEndpoint)
+		}
+	}
+	if opts.CheckPublicAccess {
+		gs.warnPublicAccess(ctx)
+	}
+	if opts.AuditBucket != "" {
+		var err error
+		gs.audit, err = newAuditLog(ctx, gs.s3client, opts.AuditBucket, opts.ObjPrefix, opts.AuditRetention)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.ManifestKey != nil && opts.ManifestInterval > 0 {
+		go gs.writeManifests(opts.ManifestKey, opts.ManifestInterval)
+	}
+	if opts.InventoryInterval > 0 {
+		format := opts.InventoryFormat
+		if format == "" {
+			format = "csv"
+		}
+		go gs.writeInventories(format, opts.InventoryInterval)
+	}
+	if gs.index != nil {
+		go gs.reconcileIndex()
+	}
+	if gs.replicas != nil {
+		go gs.probeReplicas()
+	}
+	return nil
+}
+
+// Object metadata written by Store. The length is that of the value before
+// encryption, the modification time is taken from the writer's clock.
+const (
+	metaPlaintextLength = "Plaintext-Length"
+	metaModified        = "Modified"
+	metaBlob            = "Blob"
+	metaKeyID           = "Key-Id"
+	metaEncoding        = "Encoding"
+	metaCluster         = "Cluster-Id"
+)
+
+// objectKeyInfo is the HKDF info prefix for per-object keys.
+const objectKeyInfo = "cmgs3 object key v1\x00"
+
+// ErrObjectTooLarge is returned for values beyond S3Opts.MaxLoadSize or
+// S3Opts.MaxStoreSize.
+var ErrObjectTooLarge = errors.New("object too large")
+
+// The endpoints of S3 Transfer Acceleration.
+const (
+	accelerateEndpoint          = "s3-accelerate.amazonaws.com"
+	accelerateDualStackEndpoint = "s3-accelerate.dualstack.amazonaws.com"
+)
+
+// minPartSize is the smallest part size S3 accepts for multipart uploads.
+const minPartSize = 5 << 20
+
+// Close stops background work of the storage: the async write queue is
+// flushed, the encryption key file is no longer watched and manifests are
+// no longer written. Later writes are performed synchronously. With
+// UnlockOnClose, held locks are released after the queue is flushed. See
+// Drain for shutting down while writes may be in flight.
+func (gs *S3Storage) Close() error {
+	gs.closeOnce.Do(func() {
+		if gs.stop != nil {
+			close(gs.stop)
+		}
+	})
+	var err error
+	if gs.queue != nil {
+		err = gs.queue.close(context.Background())
+	}
+	if gs.unlockOnClose {
+		ctx, cancel := context.WithTimeout(context.Background(), unlockAllTimeout)
+		defer cancel()
+		err = errors.Join(err, gs.UnlockAll(ctx))
+	}
+	return err
+}
+
 var (
 	LockExpiration   = 2 * time.Minute
 	LockPollInterval = 1 * time.Second
 	LockTimeout      = 15 * time.Second
+	// LockMaxPollInterval bounds the polls of locks held for long, which
+	// back off from LockPollInterval with the lock's age.
+	LockMaxPollInterval = 10 * time.Second
 )
 
-func (gs *S3Storage) Lock(ctx context.Context, key string) error {
+func (gs *S3Storage) Lock(ctx context.Context, key string) (err error) {
+	defer gs.observe("lock", LockNamespace, time.Now(), &err)
+	ctx = withDefaultPriority(ctx, PriorityLock)
+	if err := gs.access("lock", key, LockNamespace, PermWrite); err != nil {
+		return err
+	}
+	if !gs.writes.enter() {
+		return ErrDraining
+	}
+	defer gs.writes.leave()
+	if err := gs.ready(ctx); err != nil {
+		return err
+	}
 	var startedAt = time.Now()
 
+	// Serialize goroutines of this instance first, the lock file below only
+	// has to arbitrate between instances.
+	if err := gs.localLock(ctx, key); err != nil {
+		return err
+	}
+	if gs.locker != nil {
+		lctx, cancel := context.WithTimeout(ctx, LockTimeout-time.Since(startedAt))
+		err = gs.locker.Lock(lctx, key)
+		cancel()
+	} else {
+		err = gs.lockRemote(ctx, key, startedAt)
+	}
+	if err != nil {
+		gs.localUnlock(key)
+		return err
+	}
+	gs.localMu.Lock()
+	gs.held[key] = time.Now()
+	gs.localMu.Unlock()
+	return nil
+}
+
+func (gs *S3Storage) lockRemote(ctx context.Context, key string, startedAt time.Time) error {
+	queued := false
+	defer func() {
+		if queued {
+			gs.s3client.RemoveObject(context.WithoutCancel(ctx), gs.bucket, gs.lockTicket(key), minio.RemoveObjectOptions{})
+		}
+	}()
+	take := func(etag string) error {
+		if !gs.fairLocks {
+			return gs.putLockFile(ctx, key, etag)
+		}
+		// Without the queue, fall back to taking the lock when free.
+		if first, err := gs.firstInLine(ctx, key); err != nil || first {
+			return gs.putLockFile(ctx, key, etag)
+		}
+		return errNotFirst
+	}
+
 	for {
+		delay := LockPollInterval
 		obj, err := gs.s3client.GetObject(ctx, gs.bucket, gs.objLockName(key), minio.GetObjectOptions{})
-		if err == nil {
-			return gs.putLockFile(key)
+		if err != nil {
+			return err
 		}
+		// GetObject is lazy, a missing lock file only surfaces on read.
 		buf, err := ioutil.ReadAll(obj)
-		if err != nil {
-			// Retry
-			continue
+		var oi minio.ObjectInfo
+		if err == nil {
+			oi, err = obj.Stat()
 		}
-		lt, err := time.Parse(time.RFC3339, string(buf))
-		if err != nil {
-			// Lock file does not make sense, overwrite.
-			return gs.putLockFile(key)
+		obj.Close()
+		var taken error = errNotFirst
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			taken = take("")
+		}
+		if err == nil {
+			buf, derr := ioutil.ReadAll(gs.lockIO().WrapReader(bytes.NewReader(buf)))
+			lf, perr := ParseLockFile(buf)
+			lt := lf.Created
+			// The writer's timestamp is only for operators, freshness is
+			// judged by the server's clock, which all nodes share.
+			if !oi.LastModified.IsZero() {
+				lt = oi.LastModified
+			}
+			now := gs.clock.now()
+			if derr != nil || perr != nil {
+				// Lock file does not make sense, overwrite.
+				taken = take(oi.ETag)
+			} else if lt.Add(LockExpiration).Before(now) {
+				// Existing lock file expired, overwrite.
+				taken = take(oi.ETag)
+			} else {
+				delay = lockPollDelay(lt, now)
+			}
+		}
+		if taken != errNotFirst {
+			return taken
 		}
-		if lt.Add(LockTimeout).Before(time.Now()) {
-			// Existing lock file expired, overwrite.
-			return gs.putLockFile(key)
+		if gs.fairLocks && !queued {
+			// Queue up, so instances arriving later wait for this one.
+			if err := gs.putLockTicket(ctx, key); err == nil {
+				queued = true
+			}
 		}
 
-		if startedAt.Add(LockTimeout).Before(time.Now()) {
+		deadline := startedAt.Add(LockTimeout)
+		if deadline.Before(time.Now()) {
 			return errors.New("acquiring lock failed")
 		}
-		time.Sleep(LockPollInterval)
+		// Check a last time right before giving up.
+		if d := time.Until(deadline); d < delay {
+			delay = d
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// errNotFirst means a free lock is left to an instance waiting longer.
+var errNotFirst = errors.New("other instance is first in line")
+
+// lockTicket is the ticket object of this instance for the lock of key,
+// named like lock files so listings skip it.
+func (gs *S3Storage) lockTicket(key string) string {
+	return gs.lockBase(key) + ".ticket-" + gs.owner + ".lock"
+}
+
+func (gs *S3Storage) putLockTicket(ctx context.Context, key string) error {
+	_, err := gs.s3client.PutObject(context.WithoutCancel(ctx), gs.bucket, gs.lockTicket(key), bytes.NewReader(nil), 0, minio.PutObjectOptions{
+		DisableContentSha256: gs.unsignedPayload,
+		UserTags:             gs.transientTags(LifecycleLock),
+	})
+	return err
+}
+
+// firstInLine reports whether no other instance has waited longer for the
+// lock of key. Tickets are ordered by the server's clock; those older than
+// twice LockTimeout belong to waiters that died and are removed.
+func (gs *S3Storage) firstInLine(ctx context.Context, key string) (bool, error) {
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stale := gs.clock.now().Add(-2 * LockTimeout)
+	var first minio.ObjectInfo
+	for obj := range gs.s3client.ListObjects(lctx, gs.bucket, minio.ListObjectsOptions{
+		Prefix: gs.lockBase(key) + ".ticket-",
+	}) {
+		if obj.Err != nil {
+			return false, obj.Err
+		}
+		if obj.LastModified.Before(stale) {
+			gs.s3client.RemoveObject(ctx, gs.bucket, obj.Key, minio.RemoveObjectOptions{})
+			continue
+		}
+		if first.Key == "" || obj.LastModified.Before(first.LastModified) ||
+			obj.LastModified.Equal(first.LastModified) && obj.Key < first.Key {
+			first = obj
+		}
+	}
+	return first.Key == "" || first.Key == gs.lockTicket(key), nil
+}
+
+// lockPollDelay returns when to check again a lock taken at lt. The longer
+// a lock is held, the less likely it is released soon, as with long
+// issuances, so polls back off up to LockMaxPollInterval, but one falls on
+// its expiry.
+func lockPollDelay(lt, now time.Time) time.Duration {
+	d := now.Sub(lt) / 4
+	if d < LockPollInterval {
+		d = LockPollInterval
+	}
+	if d > LockMaxPollInterval {
+		d = LockMaxPollInterval
+	}
+	if left := lt.Add(LockExpiration).Sub(now); left < d {
+		d = left
 	}
+	return d
 }
 
-func (gs *S3Storage) putLockFile(key string) error {
-	// Object does not exist, we're creating a lock file.
-	r := bytes.NewReader([]byte(time.Now().Format(time.RFC3339)))
-	_, err := gs.s3client.PutObject(context.Background(), gs.bucket, gs.objLockName(key), r, int64(r.Len()), minio.PutObjectOptions{})
+// putLockFile writes the lock file of key, replacing the one with etag, or
+// none if it is empty. With conditional locks, losing the race to another
+// instance returns errNotFirst.
+func (gs *S3Storage) putLockFile(ctx context.Context, key, etag string) error {
+	buf, err := gs.newLockFile()
+	if err != nil {
+		return err
+	}
+	// Seekable, so the client can resend it on a connection the server
+	// closed after refusing a conditional write unread.
+	raw, err := ioutil.ReadAll(gs.lockIO().ByteReader(buf))
+	if err != nil {
+		return err
+	}
+	opts := minio.PutObjectOptions{
+		DisableContentSha256: gs.unsignedPayload,
+		UserTags:             gs.transientTags(LifecycleLock),
+	}
+	if gs.conditionalLocks {
+		if etag == "" {
+			opts.SetMatchETagExcept("*")
+		} else {
+			opts.SetMatchETag(etag)
+		}
+	}
+	_, err = gs.s3client.PutObject(context.WithoutCancel(ctx), gs.bucket, gs.objLockName(key), bytes.NewReader(raw), int64(len(raw)), opts)
+	if isPreconditionFailed(err) {
+		return errNotFirst
+	}
 	return err
 }
 
-func (gs *S3Storage) Unlock(ctx context.Context, key string) error {
+func (gs *S3Storage) Unlock(ctx context.Context, key string) (err error) {
+	defer gs.observe("unlock", LockNamespace, time.Now(), &err)
+	ctx = withDefaultPriority(ctx, PriorityLock)
+	if err := gs.access("unlock", key, LockNamespace, PermWrite); err != nil {
+		return err
+	}
+	if err := gs.ready(ctx); err != nil {
+		return err
+	}
+	defer gs.localUnlock(key)
+	gs.localMu.Lock()
+	delete(gs.held, key)
+	gs.localMu.Unlock()
+	if gs.locker != nil {
+		return gs.locker.Unlock(ctx, key)
+	}
 	return gs.s3client.RemoveObject(ctx, gs.bucket, gs.objLockName(key), minio.RemoveObjectOptions{})
 }
 
-func (gs *S3Storage) Store(ctx context.Context, key string, value []byte) error {
-	r := gs.iowrap.ByteReader(value)
-	_, err := gs.s3client.PutObject(ctx,
-		gs.bucket,
-		gs.objName(key),
-		r,
-		int64(r.Len()),
-		minio.PutObjectOptions{},
-	)
+func (gs *S3Storage) localLock(ctx context.Context, key string) error {
+	gs.localMu.Lock()
+	ch, ok := gs.localLocks[key]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		gs.localLocks[key] = ch
+	}
+	gs.localMu.Unlock()
+
+	timer := time.NewTimer(LockTimeout)
+	defer timer.Stop()
+	select {
+	case ch <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errors.New("acquiring lock failed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (gs *S3Storage) localUnlock(key string) {
+	gs.localMu.Lock()
+	ch := gs.localLocks[key]
+	gs.localMu.Unlock()
+	if ch == nil {
+		return
+	}
+	select {
+	case <-ch:
+	default:
+	}
+}
+
+func (gs *S3Storage) Store(ctx context.Context, key string, value []byte) (err error) {
+	defer gs.observe("store", namespaceOf(key), time.Now(), &err)
+	ctx = withDefaultPriority(ctx, PriorityMaintenance)
+	ctx, cancel := gs.withDeadline(ctx)
+	defer cancel()
+	if err := gs.access("store", key, namespaceOf(key), PermWrite); err != nil {
+		return err
+	}
+	if !gs.writes.enter() {
+		return ErrDraining
+	}
+	defer gs.writes.leave()
+	if err := gs.ready(ctx); err != nil {
+		return err
+	}
+	if limit := gs.maxStoreSize.Load(); limit > 0 && int64(len(value)) > limit {
+		return fmt.Errorf("%s has %d bytes: %w", key, len(value), ErrObjectTooLarge)
+	}
+	if gs.negative != nil {
+		gs.negative.invalidate(key)
+	}
+	if bundle, ext, ok := gs.bundled(key); ok {
+		return gs.storeMember(ctx, key, bundle, ext, value)
+	}
+	release := func() {}
+	if gs.quota != nil {
+		var err error
+		if release, err = gs.reserveQuota(ctx, key, int64(len(value))); err != nil {
+			return err
+		}
+	}
+	// Queued writes do not keep the context, nor report conflicts.
+	_, conditional := matchETagFrom(ctx)
+	async := gs.conflict == ConflictOverwrite && IdempotencyKeyFrom(ctx) == "" && !conditional
+	if gs.queue != nil && async && gs.asyncKey(key) && gs.queue.enqueue(ctx, key, value) {
+		return nil
+	}
+	err = gs.storeSync(ctx, key, value)
+	if err != nil {
+		release()
+	}
 	return err
 }
 
-func (gs *S3Storage) Load(ctx context.Context, key string) ([]byte, error) {
-	if !gs.Exists(ctx, key) {
+func (gs *S3Storage) storeSync(ctx context.Context, key string, value []byte) error {
+	if err := gs.checkOwner(ctx, key); err != nil {
+		return err
+	}
+	token := IdempotencyKeyFrom(ctx)
+	if token != "" {
+		if done, err := gs.storedWith(ctx, key, token); err != nil || done {
+			return err
+		}
+	}
+	if gs.conflict == ConflictKeepBoth {
+		if err := gs.keepVersion(ctx, key); err != nil {
+			return err
+		}
+	}
+	meta := map[string]string{
+		metaPlaintextLength: strconv.Itoa(len(value)),
+		metaModified:        time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if gs.clusterID != "" {
+		meta[metaCluster] = gs.clusterID
+	}
+	if token != "" {
+		meta[metaIdempotencyKey] = token
+	}
+	if id := gs.keyIDFor(key); id != "" {
+		meta[metaKeyID] = id
+	}
+	var err error
+	var r Reader
+	if gs.isDedupKey(key) {
+		// The pointer carries the ID in its body as well, so its ETag
+		// changes with the content.
+		meta[metaBlob], err = gs.storeBlob(ctx, value)
+		r = (&CleartextIO{}).ByteReader([]byte(meta[metaBlob]))
+	} else if c := gs.maybeCompress(value); c != nil {
+		meta[metaEncoding] = encodingZstd
+		r = gs.ioFor(key).ByteReader(c)
+	} else {
+		r = gs.ioFor(key).ByteReader(value)
+	}
+	if err == nil {
+		etag, match := matchETagFrom(ctx)
+		ifAbsent := gs.conflict == ConflictFail
+		if match {
+			ifAbsent = etag == ""
+		}
+		err = gs.putObject(ctx, gs.objName(key), r, meta, ifAbsent, etag)
+		if isPreconditionFailed(err) && !match {
+			err = fmt.Errorf("%s: %w", key, fs.ErrExist)
+		}
+	}
+	if gs.cache != nil {
+		if err == nil {
+			gs.cache.put(key, value)
+		} else {
+			gs.cache.invalidate(key)
+		}
+	}
+	if gs.scans != nil {
+		gs.scans.invalidate(key)
+	}
+	if gs.lists != nil {
+		gs.lists.invalidate(key)
+	}
+	if gs.negative != nil {
+		// Again, for lookups that raced with the write.
+		gs.negative.invalidate(key)
+	}
+	if err == nil && gs.index != nil {
+		mt, _ := time.Parse(time.RFC3339Nano, meta[metaModified])
+		gs.index.update(ctx, key, &indexEntry{Size: int64(len(value)), Modified: mt})
+	}
+	if err == nil && gs.audit != nil {
+		err = gs.audit.record(ctx, "store", key, value)
+	}
+	return err
+}
+
+type matchETagKey struct{}
+
+// withMatchETag makes the Store with ctx write only over the object with
+// etag, or where there is none if etag is empty, and otherwise fail with
+// PreconditionFailed. Such writes are never queued.
+func withMatchETag(ctx context.Context, etag string) context.Context {
+	return context.WithValue(ctx, matchETagKey{}, etag)
+}
+
+func matchETagFrom(ctx context.Context) (string, bool) {
+	etag, ok := ctx.Value(matchETagKey{}).(string)
+	return etag, ok
+}
+
+// putObject writes obj, if ifAbsent only when it does not exist, and if
+// ifMatch is set only over the object with that ETag.
+func (gs *S3Storage) putObject(ctx context.Context, obj string, r Reader, meta map[string]string, ifAbsent bool, ifMatch string) error {
+	opts := minio.PutObjectOptions{
+		UserMetadata:         meta,
+		PartSize:             gs.partSize,
+		NumThreads:           gs.partThreads,
+		DisableContentSha256: gs.unsignedPayload,
+		Checksum:             gs.checksum,
+	}
+	gs.setLifecycle(obj, &opts)
+	var body io.Reader = r
+	if ifAbsent || ifMatch != "" {
+		if ifAbsent {
+			opts.SetMatchETagExcept("*")
+		} else {
+			opts.SetMatchETag(ifMatch)
+		}
+		// Seekable, see putLockFile.
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(buf)
+	}
+	_, err := gs.s3client.PutObject(ctx, gs.bucket, obj, body, int64(r.Len()), opts)
+	if err != nil && gs.isMultipart(r.Len()) {
+		gs.abortUpload(ctx, obj)
+	}
+	return err
+}
+
+func (gs *S3Storage) isMultipart(size int64) bool {
+	threshold := gs.partSize
+	if threshold == 0 {
+		threshold = 16 << 20
+	}
+	return uint64(size) >= threshold
+}
+
+// abortUpload removes the parts of failed multipart uploads, which would
+// otherwise be billed until a lifecycle rule cleans them up. It does not use
+// the caller's deadline, as that is often the reason the upload failed.
+func (gs *S3Storage) abortUpload(ctx context.Context, obj string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	if err := gs.s3client.RemoveIncompleteUpload(ctx, gs.bucket, obj); err != nil {
+		gs.logf("aborting multipart upload of %s failed%s: %v", obj, logTags(ctx), err)
+	}
+}
+
+func (gs *S3Storage) Load(ctx context.Context, key string) (_ []byte, err error) {
+	defer gs.observe("load", namespaceOf(key), time.Now(), &err)
+	ctx = withDefaultPriority(ctx, PriorityCritical)
+	ctx, cancel := gs.withDeadline(ctx)
+	defer cancel()
+	if err := gs.access("load", key, namespaceOf(key), PermRead); err != nil {
+		return nil, err
+	}
+	if err := gs.ready(ctx); err != nil {
+		return nil, err
+	}
+	if bundle, ext, ok := gs.bundled(key); ok {
+		// Files not in the bundle may still be stored on their own.
+		if f, err := gs.loadMember(ctx, bundle, ext); !errors.Is(err, fs.ErrNotExist) {
+			return f.Value, err
+		}
+	}
+	if gs.queue != nil {
+		if buf, ok := gs.queue.lookup(key); ok {
+			return buf, nil
+		}
+	}
+	if gs.cache != nil {
+		if buf, ok := gs.cache.get(key); ok {
+			return buf, nil
+		}
+	}
+	if gs.knownMissing(key) {
 		return nil, fs.ErrNotExist
 	}
+	since := gs.missingGeneration()
+	if err := gs.guardHostname(key); err != nil {
+		return nil, err
+	}
+	// With replicas, reads should not wait for the bucket itself.
+	if gs.replicas == nil && !gs.scanned(key) {
+		// Not counted as an operation of its own.
+		if ok, err := gs.existsErr(ctx, key); err != nil {
+			// Not fs.ErrNotExist, which would have certmagic replace
+			// what may well exist.
+			return nil, err
+		} else if !ok {
+			return nil, fs.ErrNotExist
+		}
+	}
 
-	r, err := gs.s3client.GetObject(ctx, gs.bucket, gs.objName(key), minio.GetObjectOptions{})
+	body := getBuffer()
+	defer putBuffer(body)
+	raw, oi, err := gs.getObject(ctx, gs.objName(key), body)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		gs.recordMissing(key, since)
+		return nil, fs.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	buf, err := gs.decode(ctx, key, raw, oi, body)
 	if err != nil {
 		return nil, err
 	}
+	if gs.cache != nil {
+		gs.cache.put(key, buf)
+	}
+	return buf, nil
+}
+
+// decode returns the value of key from raw, the body of its object oi,
+// reading the blob of deduplicated values into body.
+func (gs *S3Storage) decode(ctx context.Context, key string, raw []byte, oi minio.ObjectInfo, body *bytes.Buffer) ([]byte, error) {
+	sealedAs := key
+	if blob := oi.UserMetadata[metaBlob]; blob != "" {
+		var err error
+		sealedAs = blobKey(blob)
+		raw, _, err = gs.getObject(ctx, gs.blobName(blob), body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	buf, err := gs.open(sealedAs, raw)
+	if err != nil {
+		return nil, gs.undecryptable(key, err)
+	}
+	if oi.UserMetadata[metaEncoding] == encodingZstd {
+		if buf, err = decompress(key, buf); err != nil {
+			return nil, err
+		}
+		if limit := gs.maxLoadSize.Load(); limit > 0 && int64(len(buf)) > limit {
+			return nil, fmt.Errorf("%s: %w", key, ErrObjectTooLarge)
+		}
+	}
+	return buf, nil
+}
+
+// getObject downloads obj into into, verifying its checksum if configured.
+// The returned bytes alias into.
+func (gs *S3Storage) getObject(ctx context.Context, obj string, into *bytes.Buffer) ([]byte, minio.ObjectInfo, error) {
+	if gs.hedgeDelay > 0 {
+		return gs.getHedged(ctx, obj, into)
+	}
+	if gs.replicas != nil {
+		return gs.getReplicated(ctx, gs.readOrder(), obj, into)
+	}
+	return gs.getObjectFrom(ctx, gs.s3client, gs.bucket, obj, into)
+}
+
+func (gs *S3Storage) getObjectFrom(ctx context.Context, client *minio.Client, bucket, obj string, into *bytes.Buffer) ([]byte, minio.ObjectInfo, error) {
+	r, err := client.GetObject(ctx, bucket, obj, minio.GetObjectOptions{
+		Checksum: gs.checksum.IsSet(),
+	})
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
 	defer r.Close()
-	buf, err := ioutil.ReadAll(gs.iowrap.WrapReader(r))
+	oi, err := r.Stat()
 	if err != nil {
+		return nil, oi, err
+	}
+	var body io.Reader = r
+	maxLoad := gs.maxLoadSize.Load()
+	if maxLoad > 0 {
+		if oi.Size > maxLoad {
+			return nil, oi, fmt.Errorf("%s has %d bytes: %w", obj, oi.Size, ErrObjectTooLarge)
+		}
+		// The object may be replaced between the request and the read.
+		body = io.LimitReader(r, maxLoad+1)
+	}
+	into.Reset()
+	into.Grow(int(oi.Size) + bytes.MinRead)
+	if _, err := into.ReadFrom(body); err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+	raw := into.Bytes()
+	if maxLoad > 0 && int64(len(raw)) > maxLoad {
+		return nil, minio.ObjectInfo{}, fmt.Errorf("%s: %w", obj, ErrObjectTooLarge)
+	}
+	oi, err = r.Stat()
+	if err != nil {
+		return nil, oi, err
+	}
+	if gs.checksum.IsSet() {
+		if err := verifyChecksum(gs.checksum, oi, raw); err != nil {
+			return nil, oi, err
+		}
+	}
+	return raw, oi, nil
+}
+
+// open decrypts raw, the stored form of the value at key. Besides the
+// current key it tries retired keys and, with per-object keys, the master
+// keys themselves, which sealed values written before the option was set.
+// The result never aliases raw, which may be reused.
+func (gs *S3Storage) open(key string, raw []byte) ([]byte, error) {
+	if gs.isCleartextKey(key) {
+		return bytes.Clone(raw), nil
+	}
+	var err error
+	for _, master := range gs.openKeys(key) {
+		candidates := []IO{master}
+		if s, ok := master.(sealer); ok && gs.deriveKeys {
+			candidates = []IO{s.deriveIO(objectKeyInfo + key), master}
+		}
+		for _, wrap := range candidates {
+			var buf []byte
+			if s, ok := wrap.(sealer); ok {
+				buf, err = s.open(raw)
+			} else {
+				buf, err = ioutil.ReadAll(wrap.WrapReader(bytes.NewReader(raw)))
+			}
+			if err == nil {
+				return buf, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", key, err)
+}
+
+// LoadRange retrieves length bytes of the value at key, starting at off.
+// For cleartext storage only the range is downloaded. Encrypted values can
+// only be authenticated as a whole, so they are loaded completely, as are
+// deduplicated and bundled values.
+func (gs *S3Storage) LoadRange(ctx context.Context, key string, off, length int64) (_ []byte, err error) {
+	defer gs.observe("load_range", namespaceOf(key), time.Now(), &err)
+	ctx = withDefaultPriority(ctx, PriorityCritical)
+	ctx, cancel := gs.withDeadline(ctx)
+	defer cancel()
+	if off < 0 || length <= 0 {
+		return nil, fmt.Errorf("invalid range: offset %d, length %d", off, length)
+	}
+	if err := gs.access("load", key, namespaceOf(key), PermRead); err != nil {
+		return nil, err
+	}
+	if err := gs.ready(ctx); err != nil {
+		return nil, err
+	}
+	_, _, bundled := gs.bundled(key)
+	if _, plain := gs.ioFor(key).(*CleartextIO); !plain || gs.isDedupKey(key) || bundled {
+		buf, err := gs.Load(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return sliceRange(buf, off, length), nil
+	}
+	if gs.queue != nil {
+		if buf, ok := gs.queue.lookup(key); ok {
+			return sliceRange(buf, off, length), nil
+		}
+	}
+	if gs.cache != nil {
+		if buf, ok := gs.cache.get(key); ok {
+			return sliceRange(buf, off, length), nil
+		}
+	}
+
+	var opts minio.GetObjectOptions
+	if err := opts.SetRange(off, off+length-1); err != nil {
+		return nil, err
+	}
+	r, err := gs.s3client.GetObject(ctx, gs.bucket, gs.objName(key), opts)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := ioutil.ReadAll(r)
+	oi, serr := r.Stat()
+	// Closed before loading the whole value below, which may wait for the
+	// request slot the range request holds until then.
+	r.Close()
+	if code := minio.ToErrorResponse(err).Code; code == "NoSuchKey" {
+		return nil, fs.ErrNotExist
+	} else if code == "InvalidRange" {
+		return []byte{}, nil
+	} else if err != nil {
 		return nil, err
 	}
+	if serr == nil && oi.UserMetadata[metaEncoding] != "" {
+		// Offsets are into the value, not its compressed form.
+		buf, err := gs.Load(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return sliceRange(buf, off, length), nil
+	}
 	return buf, nil
 }
 
-func (gs *S3Storage) Delete(ctx context.Context, key string) error {
-	return gs.s3client.RemoveObject(ctx, gs.bucket, gs.objName(key), minio.RemoveObjectOptions{})
+func sliceRange(buf []byte, off, length int64) []byte {
+	if off >= int64(len(buf)) {
+		return []byte{}
+	}
+	end := off + length
+	if end > int64(len(buf)) {
+		end = int64(len(buf))
+	}
+	return buf[off:end]
+}
+
+// Delete removes key. If key is a directory, all keys under it are removed.
+// Deleting a key that does not exist succeeds, as with certmagic's file
+// storage, unless S3Opts.DeleteMissingError is set.
+func (gs *S3Storage) Delete(ctx context.Context, key string) (err error) {
+	defer gs.observe("delete", namespaceOf(key), time.Now(), &err)
+	ctx = withDefaultPriority(ctx, PriorityMaintenance)
+	ctx, cancel := gs.withDeadline(ctx)
+	defer cancel()
+	if err := gs.access("delete", key, namespaceOf(key), PermWrite); err != nil {
+		return err
+	}
+	if !gs.writes.enter() {
+		return ErrDraining
+	}
+	defer gs.writes.leave()
+	if err := gs.ready(ctx); err != nil {
+		return err
+	}
+	if bundle, ext, ok := gs.bundled(key); ok {
+		return gs.deleteMember(ctx, key, bundle, ext)
+	}
+	if gs.queue != nil {
+		gs.queue.drop(ctx, key)
+	}
+	oi, err := gs.s3client.StatObject(ctx, gs.bucket, gs.objName(key), minio.StatObjectOptions{})
+	if err == nil {
+		ki, _ := gs.keyInfo(key, oi)
+		return gs.deleteObject(ctx, key, ki.Size)
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return gs.deleteObject(ctx, key, -1)
+	}
+
+	keys, err := gs.List(ctx, key, true)
+	if err != nil {
+		return err
+	}
+	var deleted bool
+	for _, k := range keys {
+		if strings.HasSuffix(k, ".lock") {
+			continue
+		}
+		if err := gs.Delete(ctx, k); err != nil {
+			return err
+		}
+		deleted = true
+	}
+	if !deleted {
+		gs.forget(key)
+		if gs.deleteMissingError {
+			return fs.ErrNotExist
+		}
+	}
+	return nil
 }
 
+// deleteObject removes the object of key, which has size bytes before
+// encryption, or -1 if unknown.
+func (gs *S3Storage) deleteObject(ctx context.Context, key string, size int64) error {
+	gs.forget(key)
+	err := gs.s3client.RemoveObject(ctx, gs.bucket, gs.objName(key), minio.RemoveObjectOptions{})
+	if err == nil && gs.quota != nil && size >= 0 {
+		gs.quota.add(-1, -size)
+	}
+	if err == nil && gs.index != nil {
+		gs.index.update(ctx, key, nil)
+	}
+	if err == nil && gs.audit != nil {
+		err = gs.audit.record(ctx, "delete", key, nil)
+	}
+	return err
+}
+
+// forget drops what the caches know about key.
+func (gs *S3Storage) forget(key string) {
+	if gs.cache != nil {
+		gs.cache.invalidate(key)
+	}
+	if gs.negative != nil {
+		gs.negative.invalidate(key)
+	}
+	if gs.scans != nil {
+		gs.scans.invalidate(key)
+	}
+	if gs.lists != nil {
+		gs.lists.invalidate(key)
+	}
+}
+
+// Exists reports whether key exists. Errors other than the key being
+// absent are logged; use ExistsErr to handle them.
 func (gs *S3Storage) Exists(ctx context.Context, key string) bool {
+	ok, err := gs.ExistsErr(ctx, key)
+	if err != nil && !errors.Is(err, fs.ErrPermission) {
+		gs.logf("checking existence of %s failed%s: %v", key, logTags(ctx), err)
+	}
+	return ok
+}
+
+// ExistsErr reports whether key exists, and an error if that could not be
+// determined, e.g. because S3 is unreachable.
+func (gs *S3Storage) ExistsErr(ctx context.Context, key string) (_ bool, err error) {
+	defer gs.observe("exists", namespaceOf(key), time.Now(), &err)
+	ctx, cancel := gs.withDeadline(ctx)
+	defer cancel()
+	return gs.existsErr(ctx, key)
+}
+
+func (gs *S3Storage) existsErr(ctx context.Context, key string) (bool, error) {
+	if err := gs.access("stat", key, namespaceOf(key), PermRead); err != nil {
+		return false, err
+	}
+	if err := gs.ready(ctx); err != nil {
+		return false, err
+	}
+	if gs.knownMissing(key) {
+		return false, nil
+	}
+	since := gs.missingGeneration()
+	if bundle, ext, ok := gs.bundled(key); ok {
+		if _, err := gs.loadMember(ctx, bundle, ext); !errors.Is(err, fs.ErrNotExist) {
+			return err == nil, err
+		}
+	}
 	_, err := gs.s3client.StatObject(ctx, gs.bucket, gs.objName(key), minio.StatObjectOptions{})
-	return err == nil
+	if err == nil {
+		return true, nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		gs.recordMissing(key, since)
+		return false, nil
+	}
+	return false, err
+}
+
+func (gs *S3Storage) List(ctx context.Context, prefix string, recursive bool) (_ []string, err error) {
+	prefix = strings.Trim(prefix, "/")
+	ns := ""
+	if prefix != "" {
+		ns = namespaceOf(prefix + "/")
+	}
+	defer gs.observe("list", ns, time.Now(), &err)
+	ctx, cancel := gs.withDeadline(ctx)
+	defer cancel()
+	if prefix != "" {
+		if err := gs.access("list", prefix, ns, PermRead); err != nil {
+			return nil, err
+		}
+	}
+	if err := gs.ready(ctx); err != nil {
+		return nil, err
+	}
+	if gs.lists != nil {
+		if keys, ok := gs.lists.get(prefix, recursive); ok {
+			return keys, nil
+		}
+	}
+	var keys []string
+	if ikeys, ok := gs.indexList(ctx, prefix, recursive); ok {
+		keys = ikeys
+	} else if gs.shardCerts {
+		keys, err = gs.listSharded(ctx, prefix, recursive)
+	} else {
+		keys, err = gs.listObjects(ctx, prefix, recursive)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if gs.bundles {
+		if keys, err = gs.expandBundles(ctx, keys); err != nil {
+			return nil, err
+		}
+	}
+	if prefix == "" {
+		keys = gs.readable(keys)
+	}
+	if gs.lists != nil {
+		gs.lists.put(prefix, recursive, keys)
+	}
+	return keys, nil
+}
+
+// ListIter is like List, but yields keys as they are listed, and stops
+// listing when the loop is left early. Keys are not deduplicated or sorted
+// across the shards of ShardCertificates, which is listed completely first,
+// as are bundles.
+func (gs *S3Storage) ListIter(ctx context.Context, prefix string, recursive bool) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		prefix = strings.Trim(prefix, "/")
+		if gs.shardCerts || gs.bundles {
+			keys, err := gs.List(ctx, prefix, recursive)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, k := range keys {
+				if !yield(k, nil) {
+					return
+				}
+			}
+			return
+		}
+		if prefix != "" {
+			if err := gs.access("list", prefix, namespaceOf(prefix+"/"), PermRead); err != nil {
+				yield("", err)
+				return
+			}
+		}
+		if err := gs.ready(ctx); err != nil {
+			yield("", err)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		filter := gs.undecryptablePolicy != UndecryptableFail
+		for obj := range gs.s3client.ListObjects(ctx, gs.bucket, minio.ListObjectsOptions{
+			Prefix:       gs.objListPrefix(prefix),
+			Recursive:    recursive,
+			WithMetadata: filter,
+		}) {
+			if obj.Err != nil {
+				yield("", obj.Err)
+				return
+			}
+			if filter {
+				listedMetadata(&obj)
+				if gs.sealedWithUnknownKey(obj) {
+					continue
+				}
+			}
+			k := gs.keyName(obj.Key)
+			if prefix == "" && len(gs.readable([]string{k})) == 0 {
+				continue
+			}
+			if !yield(k, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (gs *S3Storage) objListPrefix(prefix string) string {
+	if prefix == "" {
+		return gs.prefix + "/"
+	}
+	return gs.prefix + "/" + prefix + "/"
 }
 
-func (gs *S3Storage) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+// listObjects lists the objects below the storage key prefix and returns
+// their certmagic keys.
+func (gs *S3Storage) listObjects(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Recursive listings are how certmagic's maintenance starts, followed
+	// by Stat and Load of every key; have them carry the metadata to answer
+	// those.
+	coalesce := gs.scans != nil && recursive
+	withMetadata := coalesce || gs.undecryptablePolicy != UndecryptableFail
 	var keys []string
 	for obj := range gs.s3client.ListObjects(ctx, gs.bucket, minio.ListObjectsOptions{
-		Prefix:    gs.objName(""),
-		Recursive: true,
+		Prefix:       gs.objListPrefix(prefix),
+		Recursive:    recursive,
+		WithMetadata: withMetadata,
 	}) {
-		keys = append(keys, obj.Key)
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		key := gs.keyName(obj.Key)
+		if withMetadata {
+			listedMetadata(&obj)
+		}
+		if gs.undecryptablePolicy != UndecryptableFail && gs.sealedWithUnknownKey(obj) {
+			continue
+		}
+		keys = append(keys, key)
+		if coalesce && !strings.HasSuffix(obj.Key, ".lock") {
+			ki, exact := gs.keyInfo(key, obj)
+			gs.scans.record(key, ki, exact)
+		}
 	}
 	return keys, nil
 }
 
-func (gs *S3Storage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
-	var ki certmagic.KeyInfo
+func (gs *S3Storage) Stat(ctx context.Context, key string) (ki certmagic.KeyInfo, err error) {
+	defer gs.observe("stat", namespaceOf(key), time.Now(), &err)
+	ctx, cancel := gs.withDeadline(ctx)
+	defer cancel()
+	if err := gs.access("stat", key, namespaceOf(key), PermRead); err != nil {
+		return ki, err
+	}
+	if err := gs.ready(ctx); err != nil {
+		return ki, err
+	}
+	if gs.knownMissing(key) {
+		return ki, fs.ErrNotExist
+	}
+	since := gs.missingGeneration()
+	if bundle, ext, ok := gs.bundled(key); ok {
+		if ki, err := gs.statMember(ctx, key, bundle, ext); !errors.Is(err, fs.ErrNotExist) {
+			return ki, err
+		}
+	}
+	if gs.scans != nil {
+		if e, ok := gs.scans.lookup(key); ok && e.exact {
+			return e.ki, nil
+		}
+	}
+	if gs.index != nil {
+		if ki, ok := gs.index.stat(ctx, key); ok {
+			return ki, nil
+		}
+	}
 	oi, err := gs.s3client.StatObject(ctx, gs.bucket, gs.objName(key), minio.StatObjectOptions{})
-	if err != nil {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		gs.recordMissing(key, since)
 		return ki, fs.ErrNotExist
+	} else if err != nil {
+		return ki, err
 	}
-	ki.Key = key
-	ki.Size = oi.Size
-	ki.Modified = oi.LastModified
-	ki.IsTerminal = true
+	ki, _ = gs.keyInfo(key, oi)
 	return ki, nil
 }
 
+// ioFor returns the IO used for the value at key.
+func (gs *S3Storage) ioFor(key string) IO {
+	if gs.isCleartextKey(key) {
+		return &CleartextIO{}
+	}
+	cur := gs.keyringFor(key).get()
+	if s, ok := cur.(sealer); ok && gs.deriveKeys {
+		return s.deriveIO(objectKeyInfo + key)
+	}
+	return cur
+}
+
+func (gs *S3Storage) isCleartextKey(key string) bool {
+	if gs.nsCleartext[namespaceOf(key)] {
+		return true
+	}
+	return gs.cleartextKeys != nil && gs.cleartextKeys(key)
+}
+
+// lockIO returns the IO used for lock files.
+func (gs *S3Storage) lockIO() IO {
+	if gs.encryptLocks {
+		return gs.keys.get()
+	}
+	return &CleartextIO{}
+}
+
 func (gs *S3Storage) objName(key string) string {
+	if gs.shardCerts {
+		key = shardKey(key)
+	}
 	return gs.prefix + "/" + key
 }
 
+// keyName is the inverse of objName.
+func (gs *S3Storage) keyName(obj string) string {
+	key := strings.TrimSuffix(strings.TrimPrefix(obj, gs.prefix+"/"), "/")
+	if gs.shardCerts {
+		key = unshardKey(key)
+	}
+	return key
+}
+
 func (gs *S3Storage) objLockName(key string) string {
-	return gs.objName(key) + ".lock"
+	return gs.lockBase(key) + ".lock"
+}
+
+// lockBase is the name the lock objects of key are derived from.
+func (gs *S3Storage) lockBase(key string) string {
+	if gs.lockPrefix == "" {
+		return gs.objName(key)
+	}
+	return gs.lockPrefix + "/" + key
 }
@@ -1,13 +1,21 @@
 package cmgs3
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	minio "github.com/minio/minio-go/v7"
 	"github.com/sam-lord/certmagic"
 )
 
@@ -23,7 +31,7 @@ func TestS3StorageImplementsCertmagicStorage(t *testing.T) {
 	var _ certmagic.Storage = (*S3Storage)(nil)
 }
 
-func setupTestStorage(t *testing.T, withEncryption bool) *S3Storage {
+func testOpts(withEncryption bool) S3Opts {
 	opts := S3Opts{
 		Endpoint:        testEndpoint,
 		Bucket:          testBucket,
@@ -35,7 +43,14 @@ func setupTestStorage(t *testing.T, withEncryption bool) *S3Storage {
 	if withEncryption {
 		opts.EncryptionKey = []byte("12345678901234567890123456789012")
 	}
+	return opts
+}
+
+func setupTestStorage(t *testing.T, withEncryption bool) *S3Storage {
+	return setupTestStorageOpts(t, testOpts(withEncryption))
+}
 
+func setupTestStorageOpts(t *testing.T, opts S3Opts) *S3Storage {
 	storage, err := NewS3Storage(opts)
 	if err != nil {
 		t.Skipf("Skipping test due to S3 setup error: %v", err)
@@ -150,6 +165,26 @@ func TestS3Storage_StoreAndLoad(t *testing.T) {
 	}
 }
 
+func TestS3Storage_DisableContentSHA256(t *testing.T) {
+	opts := testOpts(true)
+	opts.DisableContentSHA256 = true
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	testKey := "test/unsigned.pem"
+	testValue := []byte("unsigned payload")
+	if err := storage.Store(ctx, testKey, testValue); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if buf, err := storage.Load(ctx, testKey); err != nil || string(buf) != string(testValue) {
+		t.Errorf("Load() = %q, %v, expected %q", buf, err, testValue)
+	}
+	if err := storage.Lock(ctx, testKey); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	storage.Unlock(ctx, testKey)
+}
+
 func TestS3Storage_Exists(t *testing.T) {
 	storage := setupTestStorage(t, false)
 	ctx := context.Background()
@@ -268,6 +303,30 @@ func TestS3Storage_Stat(t *testing.T) {
 	}
 }
 
+func TestS3Storage_StatEncrypted(t *testing.T) {
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+
+	testKey := "test/stat-encrypted.pem"
+	testValue := []byte("test data for stat")
+
+	before := time.Now().Add(-time.Second)
+	if err := storage.Store(ctx, testKey, testValue); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	info, err := storage.Stat(ctx, testKey)
+	if err != nil {
+		t.Fatalf("Stat() failed: %v", err)
+	}
+	if info.Size != int64(len(testValue)) {
+		t.Errorf("Stat() should report plaintext size. Expected: %d, Got: %d", len(testValue), info.Size)
+	}
+	if info.Modified.Before(before) {
+		t.Errorf("Stat() returned modification time %v before the Store()", info.Modified)
+	}
+}
+
 func TestS3Storage_LockUnlock(t *testing.T) {
 	storage := setupTestStorage(t, false)
 	ctx := context.Background()
@@ -279,7 +338,7 @@ func TestS3Storage_LockUnlock(t *testing.T) {
 		t.Fatalf("Lock() failed: %v", err)
 	}
 
-	lockKey := storage.objLockName(testKey)
+	lockKey := testKey + ".lock"
 	if !storage.Exists(ctx, lockKey) {
 		t.Errorf("Lock file should exist after locking")
 	}
@@ -425,7 +484,7 @@ func TestS3Storage_EncryptionKeyValidation(t *testing.T) {
 			if tt.expectError && err == nil {
 				t.Errorf("Expected error for invalid encryption key, but got none")
 			}
-			if !tt.expectError && err != nil && err.Error() == "encryption key must have exactly 32 bytes" {
+			if !tt.expectError && err != nil && strings.Contains(err.Error(), "encryption key must have exactly 32 bytes") {
 				t.Errorf("Unexpected error for valid encryption key: %v", err)
 			}
 		})
@@ -501,3 +560,635 @@ func TestS3Storage_Integration(t *testing.T) {
 		t.Errorf("Integration test: key should not exist after deletion")
 	}
 }
+
+func TestNewS3StorageMultipartThreshold(t *testing.T) {
+	opts := testOpts(false)
+	opts.MultipartThreshold = 1 << 20
+	if _, err := NewS3Storage(opts); err == nil {
+		t.Errorf("NewS3Storage() expected error for multipart threshold below 5 MiB")
+	}
+}
+
+func TestS3Storage_MultipartStore(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping multipart test in short mode")
+	}
+
+	opts := testOpts(true)
+	opts.MultipartThreshold = 5 << 20
+	opts.MultipartConcurrency = 2
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	testKey := "test/archive.tar"
+	testValue := bytes.Repeat([]byte("0123456789abcdef"), (11<<20)/16)
+
+	if err := storage.Store(ctx, testKey, testValue); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	loadedValue, err := storage.Load(ctx, testKey)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if !bytes.Equal(loadedValue, testValue) {
+		t.Errorf("Load() returned different value than stored, got %d bytes", len(loadedValue))
+	}
+}
+
+func TestSliceRange(t *testing.T) {
+	buf := []byte("0123456789")
+	tests := []struct {
+		off, length int64
+		want        string
+	}{
+		{0, 4, "0123"},
+		{8, 10, "89"},
+		{10, 1, ""},
+		{3, 1, "3"},
+	}
+	for _, tt := range tests {
+		if got := sliceRange(buf, tt.off, tt.length); string(got) != tt.want {
+			t.Errorf("sliceRange(%d, %d) = %q, expected %q", tt.off, tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestS3Storage_LoadRange(t *testing.T) {
+	tests := []struct {
+		name           string
+		withEncryption bool
+	}{
+		{"cleartext", false},
+		{"encrypted", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := setupTestStorage(t, tt.withEncryption)
+			ctx := context.Background()
+
+			testKey := "test/range.pem"
+			testValue := []byte("-----BEGIN CERTIFICATE-----\ntest certificate data\n-----END CERTIFICATE-----")
+			if err := storage.Store(ctx, testKey, testValue); err != nil {
+				t.Fatalf("Store() failed: %v", err)
+			}
+
+			buf, err := storage.LoadRange(ctx, testKey, 11, 11)
+			if err != nil {
+				t.Fatalf("LoadRange() failed: %v", err)
+			}
+			if string(buf) != "CERTIFICATE" {
+				t.Errorf("LoadRange() = %q, expected %q", buf, "CERTIFICATE")
+			}
+
+			buf, err = storage.LoadRange(ctx, testKey, int64(len(testValue))+10, 5)
+			if err != nil || len(buf) != 0 {
+				t.Errorf("LoadRange() past the end = %q, %v, expected empty result", buf, err)
+			}
+
+			if _, err := storage.LoadRange(ctx, "test/missing.pem", 0, 5); err != fs.ErrNotExist {
+				t.Errorf("LoadRange() should return fs.ErrNotExist for missing key, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestS3Storage_LockEncryption(t *testing.T) {
+	tests := []struct {
+		name         string
+		encryptLocks bool
+	}{
+		{"cleartext locks", false},
+		{"encrypted locks", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOpts(true)
+			opts.EncryptLocks = tt.encryptLocks
+			storage := setupTestStorageOpts(t, opts)
+			ctx := context.Background()
+
+			testKey := "test/lock-encryption"
+			if err := storage.Lock(ctx, testKey); err != nil {
+				t.Fatalf("Lock() failed: %v", err)
+			}
+			defer storage.Unlock(ctx, testKey)
+
+			obj, err := storage.s3client.GetObject(ctx, testBucket, storage.objLockName(testKey), minio.GetObjectOptions{})
+			if err != nil {
+				t.Fatalf("GetObject() failed: %v", err)
+			}
+			defer obj.Close()
+			buf, err := io.ReadAll(obj)
+			if err != nil {
+				t.Fatalf("reading lock file failed: %v", err)
+			}
+			lf, perr := ParseLockFile(buf)
+			if readable := perr == nil; readable == tt.encryptLocks {
+				t.Errorf("lock file readable: %v, encrypted locks: %v", readable, tt.encryptLocks)
+			}
+			if perr == nil && (lf.Version != LockFileVersion || lf.Owner != storage.owner || lf.Token == "") {
+				t.Errorf("lock file = %+v, expected version, owner and token", lf)
+			}
+			if !tt.encryptLocks {
+				return
+			}
+			buf, err = io.ReadAll(storage.lockIO().WrapReader(bytes.NewReader(buf)))
+			if err != nil {
+				t.Fatalf("decrypting lock file failed: %v", err)
+			}
+			if _, err := ParseLockFile(buf); err != nil {
+				t.Errorf("decrypted lock file invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestS3Storage_CleartextKeys(t *testing.T) {
+	opts := testOpts(true)
+	opts.CleartextKeys = func(key string) bool { return strings.HasPrefix(key, "diagnostics/") }
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	testKey := "diagnostics/marker"
+	testValue := []byte("plain bookkeeping")
+	if err := storage.Store(ctx, testKey, testValue); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	obj, err := storage.s3client.GetObject(ctx, testBucket, storage.objName(testKey), minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatalf("GetObject() failed: %v", err)
+	}
+	defer obj.Close()
+	raw, _ := io.ReadAll(obj)
+	if string(raw) != string(testValue) {
+		t.Errorf("object should be stored in clear text, got %q", raw)
+	}
+
+	buf, err := storage.Load(ctx, testKey)
+	if err != nil || string(buf) != string(testValue) {
+		t.Errorf("Load() = %q, %v, expected %q", buf, err, testValue)
+	}
+}
+
+func TestS3Storage_PerObjectKeys(t *testing.T) {
+	legacy := setupTestStorage(t, true)
+	ctx := context.Background()
+
+	legacyKey := "test/legacy.pem"
+	if err := legacy.Store(ctx, legacyKey, []byte("legacy")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	opts := testOpts(true)
+	opts.PerObjectKeys = true
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+
+	testKey := "test/derived.pem"
+	if err := storage.Store(ctx, testKey, []byte("derived")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if buf, err := storage.Load(ctx, testKey); err != nil || string(buf) != "derived" {
+		t.Errorf("Load() = %q, %v, expected %q", buf, err, "derived")
+	}
+	if buf, err := storage.Load(ctx, legacyKey); err != nil || string(buf) != "legacy" {
+		t.Errorf("Load() of value sealed with master key = %q, %v", buf, err)
+	}
+	if _, err := legacy.Load(ctx, testKey); err == nil {
+		t.Errorf("Load() with master key should fail for value sealed with derived key")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	raw := []byte("12345678901234567890123456789012")
+	tests := []struct {
+		name    string
+		in      []byte
+		wantErr bool
+	}{
+		{"raw", raw, false},
+		{"raw with newline", append(append([]byte(nil), raw...), '\n'), false},
+		{"hex", []byte(hex.EncodeToString(raw) + "\n"), false},
+		{"base64", []byte(base64.StdEncoding.EncodeToString(raw)), false},
+		{"too short", []byte("short"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseKey(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseKey() expected error but got none")
+				}
+				return
+			}
+			if err != nil || string(key) != string(raw) {
+				t.Errorf("parseKey() = %q, %v, expected %q", key, err, raw)
+			}
+		})
+	}
+}
+
+func TestS3Storage_EncryptionKeyFile(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("12345678901234567890123456789012"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testOpts(false)
+	opts.EncryptionKeyFile = keyFile
+	storage := setupTestStorageOpts(t, opts)
+	defer storage.Close()
+	ctx := context.Background()
+
+	oldKey := "test/old-key.pem"
+	if err := storage.Store(ctx, oldKey, []byte("old")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString([]byte("abcdefghijabcdefghijabcdefghij12"))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.ReloadEncryptionKey(); err != nil {
+		t.Fatalf("ReloadEncryptionKey() failed: %v", err)
+	}
+
+	newKey := "test/new-key.pem"
+	if err := storage.Store(ctx, newKey, []byte("new")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	for key, want := range map[string]string{oldKey: "old", newKey: "new"} {
+		if buf, err := storage.Load(ctx, key); err != nil || string(buf) != want {
+			t.Errorf("Load(%s) = %q, %v, expected %q", key, buf, err, want)
+		}
+	}
+
+	reopened, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	defer reopened.Close()
+	if _, err := reopened.Load(ctx, oldKey); err == nil {
+		t.Errorf("Load() with only the new key should fail for value sealed with the old key")
+	}
+
+	// Listed after the current key, the old one survives restarts.
+	keys := hex.EncodeToString([]byte("abcdefghijabcdefghijabcdefghij12")) + "\n" +
+		hex.EncodeToString([]byte("12345678901234567890123456789012")) + "\n"
+	if err := os.WriteFile(keyFile, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	defer restarted.Close()
+	if buf, err := restarted.Load(ctx, oldKey); err != nil || string(buf) != "old" {
+		t.Errorf("Load() with the old key listed = %q, %v", buf, err)
+	}
+}
+
+func TestNewS3Storage_PreviousKeys(t *testing.T) {
+	current := []byte("abcdefghijabcdefghijabcdefghij12")
+	previous := [][]byte{[]byte("12345678901234567890123456789012"), []byte("ABCDEFGHIJABCDEFGHIJABCDEFGHIJ12")}
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(current)+"\n"+base64.StdEncoding.EncodeToString(previous[0])+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testOpts(false)
+	opts.LazyInit = true
+	opts.EncryptionKeyFile = keyFile
+	opts.PreviousEncryptionKeys = previous[1:]
+	opts.NamespaceEncryption = map[string]NamespaceEncryption{"acme/": {Algorithm: "aes-gcm"}}
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	defer storage.Close()
+
+	for _, r := range []*keyring{storage.keys, storage.keyringFor("acme/x")} {
+		var got [][]byte
+		for _, io := range r.all() {
+			k := io.(sealer).secretKey()
+			got = append(got, k[:])
+		}
+		want := append([][]byte{current}, previous...)
+		if len(got) != len(want) {
+			t.Fatalf("keyring has %d keys, expected %d", len(got), len(want))
+		}
+		for i := range want {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Errorf("key %d of the keyring = %q, expected %q", i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestNewS3StorageKeyAndKeyFile(t *testing.T) {
+	opts := testOpts(true)
+	opts.EncryptionKeyFile = "/nonexistent"
+	if _, err := NewS3Storage(opts); err == nil {
+		t.Errorf("NewS3Storage() expected error with both key and key file")
+	}
+}
+
+func TestS3Storage_FIPSMode(t *testing.T) {
+	opts := testOpts(true)
+	opts.FIPSMode = true
+	opts.PerObjectKeys = true
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if _, ok := storage.keys.get().(*AESGCMIO); !ok {
+		t.Fatalf("FIPS mode should encrypt with AES-GCM, got %T", storage.keys.get())
+	}
+
+	testKey := "test/fips.pem"
+	testValue := []byte("fips protected")
+	if err := storage.Store(ctx, testKey, testValue); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if buf, err := storage.Load(ctx, testKey); err != nil || string(buf) != string(testValue) {
+		t.Errorf("Load() = %q, %v, expected %q", buf, err, testValue)
+	}
+	if info, err := storage.Stat(ctx, testKey); err != nil || info.Size != int64(len(testValue)) {
+		t.Errorf("Stat() = %+v, %v", info, err)
+	}
+}
+
+func TestS3Storage_MaxObjectSize(t *testing.T) {
+	opts := testOpts(true)
+	opts.MaxStoreSize = 64
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "test/big", make([]byte, 65)); !errors.Is(err, ErrObjectTooLarge) {
+		t.Errorf("Store() error = %v, expected ErrObjectTooLarge", err)
+	}
+	if err := storage.Store(ctx, "test/big", make([]byte, 64)); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	opts.MaxStoreSize = 0
+	opts.MaxLoadSize = 64
+	limited := setupTestStorageOpts(t, opts)
+	if err := storage.Store(ctx, "test/big", make([]byte, 64)); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	// The sealed object exceeds the limit by the encryption overhead.
+	if _, err := limited.Load(ctx, "test/big"); !errors.Is(err, ErrObjectTooLarge) {
+		t.Errorf("Load() error = %v, expected ErrObjectTooLarge", err)
+	}
+	if err := storage.Store(ctx, "test/small", make([]byte, 8)); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if _, err := limited.Load(ctx, "test/small"); err != nil {
+		t.Errorf("Load() within limit failed: %v", err)
+	}
+}
+
+func TestS3Storage_ListIter(t *testing.T) {
+	storage := setupTestStorage(t, false)
+	ctx := context.Background()
+	want := []string{"test/iter/a", "test/iter/b", "test/iter/c"}
+	for _, key := range want {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+
+	var got []string
+	for key, err := range storage.ListIter(ctx, "test/iter", true) {
+		if err != nil {
+			t.Fatalf("ListIter() failed: %v", err)
+		}
+		got = append(got, key)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("ListIter() = %v, expected %v", got, want)
+	}
+
+	n := 0
+	for range storage.ListIter(ctx, "test/iter", true) {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Errorf("ListIter() yielded %d keys after break", n)
+	}
+}
+
+func TestNewS3Storage_RegionCache(t *testing.T) {
+	setupTestStorage(t, false)
+	if r := cachedRegion(testEndpoint, testBucket); r == "" {
+		t.Error("bucket region was not cached after NewS3Storage")
+	}
+
+	opts := testOpts(false)
+	opts.MaxRetries = 1
+	storage := setupTestStorageOpts(t, opts)
+	if err := storage.Store(context.Background(), "test/region", []byte("value")); err != nil {
+		t.Errorf("Store() with cached region failed: %v", err)
+	}
+}
+
+func TestS3Storage_DeleteMissing(t *testing.T) {
+	storage := setupTestStorage(t, false)
+	ctx := context.Background()
+	if err := storage.Delete(ctx, "test/missing"); err != nil {
+		t.Errorf("Delete() of missing key = %v, expected success", err)
+	}
+
+	opts := testOpts(false)
+	opts.DeleteMissingError = true
+	strict := setupTestStorageOpts(t, opts)
+	if err := strict.Delete(ctx, "test/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Delete() of missing key = %v, expected fs.ErrNotExist", err)
+	}
+	if err := strict.Store(ctx, "test/present", []byte("value")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if err := strict.Delete(ctx, "test/present"); err != nil {
+		t.Errorf("Delete() of existing key failed: %v", err)
+	}
+}
+
+func TestS3Storage_DeleteDirectory(t *testing.T) {
+	storage := setupTestStorage(t, false)
+	ctx := context.Background()
+	keys := []string{"test/dir/a", "test/dir/sub/b", "test/dirty"}
+	for _, key := range keys {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+	if err := storage.Delete(ctx, "test/dir"); err != nil {
+		t.Fatalf("Delete() of directory failed: %v", err)
+	}
+	for _, key := range keys[:2] {
+		if storage.Exists(ctx, key) {
+			t.Errorf("%s still exists after deleting its directory", key)
+		}
+	}
+	if !storage.Exists(ctx, "test/dirty") {
+		t.Error("Delete() of directory removed a key that only shares its name prefix")
+	}
+}
+
+func TestS3Storage_ExistsErr(t *testing.T) {
+	storage := setupTestStorage(t, false)
+	ctx := context.Background()
+	if err := storage.Store(ctx, "test/exists", []byte("value")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if ok, err := storage.ExistsErr(ctx, "test/exists"); !ok || err != nil {
+		t.Errorf("ExistsErr() of stored key = %v, %v", ok, err)
+	}
+	if ok, err := storage.ExistsErr(ctx, "test/absent"); ok || err != nil {
+		t.Errorf("ExistsErr() of absent key = %v, %v, expected false without error", ok, err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if ok, err := storage.ExistsErr(cctx, "test/exists"); ok || err == nil {
+		t.Errorf("ExistsErr() with failing request = %v, %v, expected an error", ok, err)
+	}
+}
+
+func TestLockPollDelay(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		age  time.Duration
+		want time.Duration
+	}{
+		{0, LockPollInterval},
+		{20 * time.Second, 5 * time.Second},
+		{90 * time.Second, LockMaxPollInterval},
+		{LockExpiration - 3*time.Second, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := lockPollDelay(now.Add(-tt.age), now); got != tt.want {
+			t.Errorf("lockPollDelay() of a lock aged %v = %v, expected %v", tt.age, got, tt.want)
+		}
+	}
+}
+
+func TestS3Storage_FairLocks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping lock fairness test in short mode")
+	}
+	opts := testOpts(false)
+	opts.FairLocks = true
+	holder := setupTestStorageOpts(t, opts)
+	first, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	late, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	key := "test/fair"
+	if err := holder.Lock(ctx, key); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+
+	// first queues up while the lock is held.
+	locked := make(chan error, 1)
+	go func() { locked <- first.Lock(ctx, key) }()
+	time.Sleep(300 * time.Millisecond)
+	if err := holder.Unlock(ctx, key); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+
+	// late finds the lock free, but must leave it to first.
+	lctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	if err := late.Lock(lctx, key); err == nil {
+		t.Error("Lock() of a later instance overtook a waiting one")
+		late.Unlock(ctx, key)
+	}
+	if err := <-locked; err != nil {
+		t.Fatalf("Lock() of waiting instance failed: %v", err)
+	}
+	if err := first.Unlock(ctx, key); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+	if err := late.Lock(ctx, key); err != nil {
+		t.Fatalf("Lock() after the queue emptied failed: %v", err)
+	}
+	late.Unlock(ctx, key)
+}
+
+func TestS3Storage_LockPrefix(t *testing.T) {
+	opts := testOpts(false)
+	opts.LockPrefix = testPrefix + "/locks"
+	if _, err := NewS3Storage(opts); err == nil {
+		t.Error("NewS3Storage() accepted a lock prefix within the object prefix")
+	}
+	opts.LockPrefix = testPrefix + "-locks"
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	testKey := "test/lock-prefix.pem"
+	if err := storage.Lock(ctx, testKey); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if storage.Exists(ctx, testKey+".lock") {
+		t.Error("lock file is visible within the object prefix")
+	}
+	keys, err := storage.List(ctx, "", true)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	for _, k := range keys {
+		if strings.HasSuffix(k, ".lock") {
+			t.Errorf("List() returned lock file %s", k)
+		}
+	}
+	if _, err := storage.s3client.StatObject(ctx, testBucket, opts.LockPrefix+"/"+testKey+".lock", minio.StatObjectOptions{}); err != nil {
+		t.Errorf("lock file not under the lock prefix: %v", err)
+	}
+	if err := storage.Unlock(ctx, testKey); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+}
+
+func TestS3Storage_DecryptFailed(t *testing.T) {
+	opts := testOpts(true)
+	opts.EncryptionKey = []byte("abcdefghabcdefghabcdefghabcdefgh")
+	other := setupTestStorageOpts(t, opts)
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+	if err := storage.Store(ctx, "test/decrypt-failed", []byte("secret")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	_, err := other.Load(ctx, "test/decrypt-failed")
+	if !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("Load() with another key error = %v, expected ErrDecryptFailed", err)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Load() with another key reports the value as missing: %v", err)
+	}
+}
+
+func TestNewS3Storage_TransferAcceleration(t *testing.T) {
+	opts := testOpts(false)
+	opts.TransferAcceleration = true
+	if _, err := NewS3Storage(opts); err == nil {
+		t.Error("NewS3Storage() with transfer acceleration on a non-AWS endpoint succeeded")
+	}
+	opts.Endpoint = "s3.amazonaws.com"
+	opts.Bucket = "certmagic.example.com"
+	if _, err := NewS3Storage(opts); err == nil {
+		t.Error("NewS3Storage() with transfer acceleration of a bucket with dots succeeded")
+	}
+}
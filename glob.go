@@ -0,0 +1,47 @@
+package cmgs3
+
+import (
+	"context"
+	"path"
+	"strings"
+)
+
+// ListGlob returns the keys matching pattern, in path.Match syntax. A
+// pattern without a slash, like "*.crt", is matched against the last path
+// element of every key; one with a slash against the whole key. The
+// directory part of the pattern before its first wildcard is used as the
+// listing prefix, so only that part of the bucket is paged through.
+func (gs *S3Storage) ListGlob(ctx context.Context, pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	base := !strings.Contains(pattern, "/")
+	keys, err := gs.List(ctx, globPrefix(pattern), true)
+	if err != nil {
+		return nil, err
+	}
+
+	out := keys[:0]
+	for _, k := range keys {
+		name := k
+		if base {
+			name = path.Base(k)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+// globPrefix returns the directories of pattern that contain no wildcard.
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		pattern = pattern[:i]
+	}
+	i := strings.LastIndex(pattern, "/")
+	if i < 0 {
+		return ""
+	}
+	return pattern[:i]
+}
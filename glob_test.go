@@ -0,0 +1,61 @@
+package cmgs3
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGlobPrefix(t *testing.T) {
+	tests := map[string]string{
+		"*.crt":                        "",
+		"certificates/*/*.crt":         "certificates",
+		"certificates/acme/ex*/ex.crt": "certificates/acme",
+		"certificates/acme/a/a.crt":    "certificates/acme/a",
+	}
+	for pattern, want := range tests {
+		if got := globPrefix(pattern); got != want {
+			t.Errorf("globPrefix(%q) = %q, expected %q", pattern, got, want)
+		}
+	}
+}
+
+func TestS3Storage_ListGlob(t *testing.T) {
+	storage := setupTestStorage(t, false)
+	ctx := context.Background()
+	for _, key := range []string{
+		"certificates/acme/a.com/a.com.crt",
+		"certificates/acme/a.com/a.com.json",
+		"certificates/other/b.com/b.com.crt",
+		"ocsp/a.com-1234",
+	} {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.crt", []string{"certificates/acme/a.com/a.com.crt", "certificates/other/b.com/b.com.crt"}},
+		{"certificates/acme/*/*.json", []string{"certificates/acme/a.com/a.com.json"}},
+		{"ocsp/*", []string{"ocsp/a.com-1234"}},
+		{"*.key", []string{}},
+	}
+	for _, tt := range tests {
+		got, err := storage.ListGlob(ctx, tt.pattern)
+		if err != nil {
+			t.Fatalf("ListGlob(%q) failed: %v", tt.pattern, err)
+		}
+		if len(got) != 0 || len(tt.want) != 0 {
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListGlob(%q) = %v, expected %v", tt.pattern, got, tt.want)
+			}
+		}
+	}
+
+	if _, err := storage.ListGlob(ctx, "[bad"); err == nil {
+		t.Error("ListGlob() expected error for malformed pattern")
+	}
+}
@@ -0,0 +1,103 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// hedgeStats count the hedged reads.
+type hedgeStats struct {
+	sent atomic.Int64 // second requests sent
+	won  atomic.Int64 // second requests answering first
+}
+
+type hedgeResult struct {
+	raw    []byte
+	oi     minio.ObjectInfo
+	err    error
+	hedged bool
+}
+
+// final reports whether the result settles the read, so the other request
+// need not be waited for.
+func (r hedgeResult) final() bool {
+	return r.err == nil || minio.ToErrorResponse(r.err).Code == "NoSuchKey" || errors.Is(r.err, ErrObjectTooLarge)
+}
+
+// getHedged reads obj like getObject, sending a second request if the
+// first did not answer within HedgeDelay, to the next replica if any.
+// The first success wins and the other request is canceled.
+func (gs *S3Storage) getHedged(ctx context.Context, obj string, into *bytes.Buffer) ([]byte, minio.ObjectInfo, error) {
+	get := func(ctx context.Context, into *bytes.Buffer, hedge bool) ([]byte, minio.ObjectInfo, error) {
+		if gs.replicas == nil {
+			return gs.getObjectFrom(ctx, gs.s3client, gs.bucket, obj, into)
+		}
+		order := gs.readOrder()
+		if hedge {
+			order = append(order[1:], order[0])
+		}
+		return gs.getReplicated(ctx, order, obj, into)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan hedgeResult, 2)
+	go func() {
+		raw, oi, err := get(ctx, into, false)
+		results <- hedgeResult{raw, oi, err, false}
+	}()
+	timer := time.NewTimer(gs.hedgeDelay)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.raw, r.oi, r.err
+	case <-timer.C:
+	}
+
+	gs.hedges.sent.Add(1)
+	spare := getBuffer()
+	defer putBuffer(spare)
+	go func() {
+		raw, oi, err := get(ctx, spare, true)
+		results <- hedgeResult{raw, oi, err, true}
+	}()
+	// Both requests write into buffers, so both are done before returning.
+	var rs [2]hedgeResult
+	win := -1
+	for i := range rs {
+		rs[i] = <-results
+		if win < 0 && rs[i].final() {
+			win = i
+			cancel()
+		}
+	}
+	if win < 0 {
+		win = 0
+	}
+	r := rs[win]
+	if r.err != nil || !r.hedged {
+		return r.raw, r.oi, r.err
+	}
+	gs.hedges.won.Add(1)
+	into.Reset()
+	into.Write(r.raw)
+	return into.Bytes(), r.oi, nil
+}
+
+// writeHedgeMetrics writes the hedged read counters in the Prometheus text
+// format.
+func (gs *S3Storage) writeHedgeMetrics(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP cmgs3_hedged_reads_total Second requests sent for slow loads.\n"+
+		"# TYPE cmgs3_hedged_reads_total counter\ncmgs3_hedged_reads_total %d\n"+
+		"# HELP cmgs3_hedged_reads_won_total Second requests answering before the first.\n"+
+		"# TYPE cmgs3_hedged_reads_won_total counter\ncmgs3_hedged_reads_won_total %d\n",
+		gs.hedges.sent.Load(), gs.hedges.won.Load())
+	return err
+}
@@ -0,0 +1,86 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// stallingS3 answers requests for objects with value, except that the
+// first stalls until canceled. Reads are a HEAD and a GET.
+func stallingS3(t *testing.T, value string) (*minio.Client, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("ETag", `"0123456789abcdef"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte(value))
+	}))
+	t.Cleanup(srv.Close)
+	client, err := minio.New(strings.TrimPrefix(srv.URL, "https://"), &minio.Options{
+		Creds:     credentials.NewStaticV4("access", "secret", ""),
+		Secure:    true,
+		Region:    "us-east-1",
+		Transport: srv.Client().Transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, &calls
+}
+
+func TestS3Storage_HedgedRead(t *testing.T) {
+	opts := testOpts(false)
+	opts.LazyInit = true
+	opts.HedgeDelay = 20 * time.Millisecond
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	var calls *atomic.Int32
+	storage.s3client, calls = stallingS3(t, "value")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	into := new(bytes.Buffer)
+	raw, _, err := storage.getObject(ctx, "obj", into)
+	if err != nil || string(raw) != "value" {
+		t.Fatalf("getObject() = %q, %v, expected the hedge's answer", raw, err)
+	}
+	if !bytes.Equal(into.Bytes(), raw) {
+		t.Error("result does not alias the buffer")
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d requests, expected the stalled one and those of the hedge", n)
+	}
+	if sent, won := storage.hedges.sent.Load(), storage.hedges.won.Load(); sent != 1 || won != 1 {
+		t.Errorf("%d hedges sent and %d won, expected 1 and 1", sent, won)
+	}
+
+	var metrics bytes.Buffer
+	storage.WriteMetrics(&metrics)
+	if !strings.Contains(metrics.String(), "cmgs3_hedged_reads_won_total 1") {
+		t.Errorf("metrics lack the hedged reads:\n%s", metrics.String())
+	}
+
+	// Answers within the delay are not hedged.
+	raw, _, err = storage.getObject(ctx, "obj", into)
+	if err != nil || string(raw) != "value" {
+		t.Fatalf("getObject() = %q, %v", raw, err)
+	}
+	if n := calls.Load(); n != 5 {
+		t.Errorf("%d requests, expected 5", n)
+	}
+}
@@ -0,0 +1,101 @@
+package cmgs3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrHostnameThrottled is returned by Load for certificate keys of a
+// hostname that went to S3 more often than S3Opts.HostnameLoadRate allows.
+var ErrHostnameThrottled = errors.New("too many loads for hostname")
+
+// HostnameLimiterMaxHosts bounds the number of hostnames whose loads are
+// tracked for S3Opts.HostnameLoadRate.
+var HostnameLimiterMaxHosts = 10000
+
+// hostnameOf returns the hostname of a certmagic certificate key,
+// certificates/<issuer>/<hostname>/<file>, or "" for other keys.
+func hostnameOf(key string) string {
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 || parts[0] != "certificates" {
+		return ""
+	}
+	return parts[2]
+}
+
+// guardHostname checks a load of key that is about to go to S3 against
+// the hostname policy and rate limit.
+func (gs *S3Storage) guardHostname(key string) error {
+	host := hostnameOf(key)
+	if host == "" {
+		return nil
+	}
+	if gs.hostnamePolicy != nil {
+		if err := gs.hostnamePolicy(host); err != nil {
+			return err
+		}
+	}
+	if gs.hostLimits != nil && !gs.hostLimits.allow(host, time.Now()) {
+		return fmt.Errorf("%s: %w", host, ErrHostnameThrottled)
+	}
+	return nil
+}
+
+type hostBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// hostLimiter is a token bucket per hostname.
+type hostLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*hostBucket
+}
+
+func newHostLimiter(rate float64, burst int) *hostLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &hostLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*hostBucket)}
+}
+
+func (l *hostLimiter) allow(host string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[host]
+	if b == nil {
+		if len(l.buckets) >= HostnameLimiterMaxHosts {
+			l.prune(now)
+		}
+		b = &hostBucket{tokens: l.burst, last: now}
+		l.buckets[host] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune drops the buckets that refilled, which are as good as new, and
+// arbitrary ones if that is not enough.
+func (l *hostLimiter) prune(now time.Time) {
+	for host, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, host)
+		}
+	}
+	for host := range l.buckets {
+		if len(l.buckets) < HostnameLimiterMaxHosts {
+			break
+		}
+		delete(l.buckets, host)
+	}
+}
@@ -0,0 +1,73 @@
+package cmgs3
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHostnameOf(t *testing.T) {
+	for key, want := range map[string]string{
+		"certificates/acme-v02/example.com/example.com.crt": "example.com",
+		"certificates/acme-v02/example.com":                 "",
+		"acme/acme-v02/users/a@example.com/a.json":          "",
+		"ocsp/example.com-abc":                              "",
+	} {
+		if got := hostnameOf(key); got != want {
+			t.Errorf("hostnameOf(%q) = %q, expected %q", key, got, want)
+		}
+	}
+}
+
+func TestHostLimiter(t *testing.T) {
+	l := newHostLimiter(1, 2)
+	now := time.Now()
+	if !l.allow("a", now) || !l.allow("a", now) {
+		t.Error("allow() refused within the burst")
+	}
+	if l.allow("a", now) {
+		t.Error("allow() beyond the burst succeeded")
+	}
+	if !l.allow("b", now) {
+		t.Error("allow() of another hostname was refused")
+	}
+	if !l.allow("a", now.Add(time.Second)) {
+		t.Error("allow() after refill was refused")
+	}
+
+	defer func(n int) { HostnameLimiterMaxHosts = n }(HostnameLimiterMaxHosts)
+	HostnameLimiterMaxHosts = 10
+	for i := 0; i < 100; i++ {
+		l.allow(fmt.Sprintf("scan-%d.example.com", i), now)
+	}
+	if n := len(l.buckets); n > HostnameLimiterMaxHosts {
+		t.Errorf("limiter tracks %d hostnames, expected at most %d", n, HostnameLimiterMaxHosts)
+	}
+}
+
+func TestS3Storage_GuardHostname(t *testing.T) {
+	denied := errors.New("denied")
+	gs := &S3Storage{
+		hostnamePolicy: func(host string) error {
+			if host == "evil.example.com" {
+				return denied
+			}
+			return nil
+		},
+		hostLimits: newHostLimiter(0.001, 1),
+	}
+	if err := gs.guardHostname("certificates/ca/evil.example.com/evil.example.com.crt"); err != denied {
+		t.Errorf("guardHostname() of a denied hostname = %v", err)
+	}
+	key := "certificates/ca/example.com/example.com.crt"
+	if err := gs.guardHostname(key); err != nil {
+		t.Errorf("guardHostname() = %v", err)
+	}
+	if err := gs.guardHostname(key); !errors.Is(err, ErrHostnameThrottled) {
+		t.Errorf("guardHostname() beyond the rate = %v, expected ErrHostnameThrottled", err)
+	}
+	if err := gs.guardHostname("acme/ca/users/a/a.json"); err != nil {
+		t.Errorf("guardHostname() of a key without hostname = %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// metaIdempotencyKey records the idempotency key of the Store that wrote
+// an object.
+const metaIdempotencyKey = "Idempotency-Key"
+
+// maxIdempotencyKey bounds the metadata an idempotency key takes up.
+const maxIdempotencyKey = 128
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a context whose Stores are idempotent for
+// token: a Store finding the object written by a Store with the same token
+// succeeds without writing again. Retrying a Store that failed ambiguously,
+// e.g. on a timeout, with the same token thus neither fails under
+// ConflictFail nor keeps a second version under ConflictKeepBoth. Tokens
+// are printable ASCII of up to 128 bytes, and must be unique per write.
+func WithIdempotencyKey(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, token)
+}
+
+// IdempotencyKeyFrom returns the idempotency key attached to ctx.
+func IdempotencyKeyFrom(ctx context.Context) string {
+	token, _ := ctx.Value(idempotencyKey{}).(string)
+	return token
+}
+
+func validIdempotencyKey(token string) error {
+	if len(token) > maxIdempotencyKey {
+		return errors.New("idempotency key too long")
+	}
+	for i := 0; i < len(token); i++ {
+		if token[i] < ' ' || token[i] > '~' {
+			return errors.New("idempotency key must be printable ASCII")
+		}
+	}
+	return nil
+}
+
+// storedWith reports whether the object of key was written with token.
+func (gs *S3Storage) storedWith(ctx context.Context, key, token string) (bool, error) {
+	if err := validIdempotencyKey(token); err != nil {
+		return false, fmt.Errorf("%s: %w", key, err)
+	}
+	oi, err := gs.s3client.StatObject(ctx, gs.bucket, gs.objName(key), minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return oi.UserMetadata[metaIdempotencyKey] == token, nil
+}
@@ -0,0 +1,58 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestS3Storage_IdempotencyKey(t *testing.T) {
+	opts := testOpts(true)
+	opts.Conflict = ConflictFail
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	first := WithIdempotencyKey(ctx, "req-1")
+	if err := storage.Store(first, "test/idempotent", []byte("first")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if err := storage.Store(first, "test/idempotent", []byte("first")); err != nil {
+		t.Errorf("retried Store() = %v, expected it to be deduplicated", err)
+	}
+	if err := storage.Store(WithIdempotencyKey(ctx, "req-2"), "test/idempotent", []byte("second")); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Store() with another idempotency key = %v, expected fs.ErrExist", err)
+	}
+	if err := storage.Store(WithIdempotencyKey(ctx, "req\n3"), "test/idempotent-other", nil); err == nil {
+		t.Error("Store() with an invalid idempotency key succeeded")
+	}
+	if err := storage.Store(WithIdempotencyKey(ctx, strings.Repeat("x", maxIdempotencyKey+1)), "test/idempotent-other", nil); err == nil {
+		t.Error("Store() with a too long idempotency key succeeded")
+	}
+	if buf, err := storage.Load(ctx, "test/idempotent"); err != nil || string(buf) != "first" {
+		t.Errorf("Load() = %q, %v, expected first", buf, err)
+	}
+}
+
+func TestS3Storage_IdempotencyKeyKeepBoth(t *testing.T) {
+	opts := testOpts(false)
+	opts.Conflict = ConflictKeepBoth
+	storage := setupTestStorageOpts(t, opts)
+	ctx := WithIdempotencyKey(context.Background(), "req-1")
+
+	for i := 0; i < 2; i++ {
+		if err := storage.Store(ctx, "test/idempotent", []byte("value")); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+	keys, err := storage.List(ctx, "test", false)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	for _, k := range keys {
+		if strings.HasPrefix(k, "test/idempotent.v") {
+			t.Errorf("retried Store() kept version %s", k)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+	"github.com/sam-lord/certmagic"
+)
+
+// IndexRefreshInterval is how often the index is re-read, picking up the
+// writes of other instances. IndexReconcileInterval is how often it is
+// rebuilt from a listing of the bucket.
+var (
+	IndexRefreshInterval   = 30 * time.Second
+	IndexReconcileInterval = time.Hour
+)
+
+// indexUpdateAttempts bounds the retries of an index update losing the race
+// against other writers.
+const indexUpdateAttempts = 5
+
+type indexEntry struct {
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// metaIndex is a single object summarizing all keys with their size and
+// modification time, kept beside the prefix. Writes update it with
+// conditional PUTs, so concurrent updates of several instances are not
+// lost. If an update fails, the index is not used until it is reconciled.
+type metaIndex struct {
+	gs *S3Storage
+
+	mu      sync.Mutex
+	entries map[string]indexEntry
+	etag    string
+	loaded  time.Time
+	dirty   bool
+}
+
+func (gs *S3Storage) indexName() string {
+	return gs.prefix + ".index.json"
+}
+
+func isPreconditionFailed(err error) bool {
+	return minio.ToErrorResponse(err).Code == "PreconditionFailed"
+}
+
+// load reads the index, which must be locked.
+func (x *metaIndex) load(ctx context.Context) error {
+	r, err := x.gs.s3client.GetObject(ctx, x.gs.bucket, x.gs.indexName(), minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	buf, err := io.ReadAll(r)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		x.entries, x.etag, x.loaded = make(map[string]indexEntry), "", time.Now()
+		return nil
+	} else if err != nil {
+		return err
+	}
+	oi, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]indexEntry)
+	if err := json.Unmarshal(buf, &entries); err != nil {
+		return err
+	}
+	x.entries, x.etag, x.loaded = entries, oi.ETag, time.Now()
+	return nil
+}
+
+// save writes the index if nobody else did since it was loaded.
+func (x *metaIndex) save(ctx context.Context) error {
+	buf, err := json.Marshal(x.entries)
+	if err != nil {
+		return err
+	}
+	opts := minio.PutObjectOptions{ContentType: "application/json", DisableContentSha256: x.gs.unsignedPayload}
+	if x.etag == "" {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(x.etag)
+	}
+	info, err := x.gs.s3client.PutObject(ctx, x.gs.bucket, x.gs.indexName(), bytes.NewReader(buf), int64(len(buf)), opts)
+	if err != nil {
+		return err
+	}
+	x.etag, x.loaded = info.ETag, time.Now()
+	return nil
+}
+
+// update sets the entry of key, or removes it if e is nil.
+func (x *metaIndex) update(ctx context.Context, key string, e *indexEntry) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.dirty {
+		return
+	}
+
+	err := errors.New("index not loaded")
+	for i := 0; i < indexUpdateAttempts; i++ {
+		if x.entries == nil || i > 0 {
+			if err = x.load(ctx); err != nil {
+				break
+			}
+		}
+		if e != nil {
+			x.entries[key] = *e
+		} else {
+			delete(x.entries, key)
+		}
+		if err = x.save(ctx); !isPreconditionFailed(err) {
+			break
+		}
+	}
+	if err != nil {
+		x.gs.logf("updating index for %s failed, not using it until reconciled%s: %v", key, logTags(ctx), err)
+		x.dirty = true
+	}
+}
+
+// fresh ensures the index is usable and recently loaded, which must be
+// locked.
+func (x *metaIndex) fresh(ctx context.Context) bool {
+	if x.dirty {
+		return false
+	}
+	if x.entries == nil || time.Since(x.loaded) > IndexRefreshInterval {
+		if err := x.load(ctx); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// list answers List from the index, like a listing of the bucket but
+// without lock files. It reports false if the index cannot be used.
+func (x *metaIndex) list(ctx context.Context, prefix string, recursive bool) ([]string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if !x.fresh(ctx) {
+		return nil, false
+	}
+
+	dir := ""
+	if prefix != "" {
+		dir = prefix + "/"
+	}
+	seen := make(map[string]bool)
+	for k := range x.entries {
+		if !strings.HasPrefix(k, dir) {
+			continue
+		}
+		if i := strings.Index(k[len(dir):], "/"); i >= 0 && !recursive {
+			k = k[:len(dir)+i]
+		}
+		seen[k] = true
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, true
+}
+
+func (gs *S3Storage) indexList(ctx context.Context, prefix string, recursive bool) ([]string, bool) {
+	if gs.index == nil {
+		return nil, false
+	}
+	return gs.index.list(ctx, prefix, recursive)
+}
+
+func (x *metaIndex) stat(ctx context.Context, key string) (certmagic.KeyInfo, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if !x.fresh(ctx) {
+		return certmagic.KeyInfo{}, false
+	}
+	e, ok := x.entries[key]
+	if !ok {
+		return certmagic.KeyInfo{}, false
+	}
+	return certmagic.KeyInfo{Key: key, Size: e.Size, Modified: e.Modified, IsTerminal: true}, true
+}
+
+// ReconcileIndex rebuilds the index from a listing of the bucket, which
+// also makes a failed index usable again.
+func (gs *S3Storage) ReconcileIndex(ctx context.Context) error {
+	ctx = withDefaultPriority(ctx, PriorityBulk)
+	if gs.index == nil {
+		return errors.New("index is disabled")
+	}
+	infos, err := gs.Scan(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	x := gs.index
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for i := 0; ; i++ {
+		if err := x.load(ctx); err != nil {
+			return err
+		}
+		x.entries = make(map[string]indexEntry, len(infos))
+		for _, ki := range infos {
+			x.entries[ki.Key] = indexEntry{Size: ki.Size, Modified: ki.Modified}
+		}
+		err := x.save(ctx)
+		if err == nil {
+			x.dirty = false
+			return nil
+		}
+		if !isPreconditionFailed(err) || i == indexUpdateAttempts-1 {
+			return err
+		}
+	}
+}
+
+// reconcileIndex runs ReconcileIndex every IndexReconcileInterval until
+// the storage is closed.
+func (gs *S3Storage) reconcileIndex() {
+	tick := time.NewTicker(IndexReconcileInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-gs.stop:
+			return
+		case <-tick.C:
+		}
+		ctx, cancel := context.WithTimeout(WithBackground(context.Background()), IndexReconcileInterval)
+		if err := gs.ReconcileIndex(ctx); err != nil {
+			gs.logf("Reconciling index failed: %v", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,114 @@
+package cmgs3
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func setupIndexedStorage(t *testing.T) *S3Storage {
+	// Cleaned up without the index, which does not know about the keys of
+	// earlier tests.
+	setupTestStorage(t, true)
+	opts := testOpts(true)
+	opts.Index = true
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+	storage.s3client.RemoveObject(ctx, testBucket, storage.indexName(), minio.RemoveObjectOptions{})
+	t.Cleanup(func() {
+		storage.s3client.RemoveObject(ctx, testBucket, storage.indexName(), minio.RemoveObjectOptions{})
+		storage.Close()
+	})
+	return storage
+}
+
+func TestS3Storage_Index(t *testing.T) {
+	storage := setupIndexedStorage(t)
+	ctx := context.Background()
+	for _, k := range []string{"test/index/a", "test/index/sub/b", "test/index/sub/c"} {
+		if err := storage.Store(ctx, k, []byte("value of "+k)); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+	if err := storage.Delete(ctx, "test/index/sub/c"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+
+	// Removed behind the storage's back, List and Stat are answered from
+	// the index.
+	if err := storage.s3client.RemoveObject(ctx, testBucket, storage.objName("test/index/a"), minio.RemoveObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := storage.List(ctx, "test/index", false)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if want := []string{"test/index/a", "test/index/sub"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() = %v, expected %v", keys, want)
+	}
+	keys, err = storage.List(ctx, "test", true)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if want := []string{"test/index/a", "test/index/sub/b"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("recursive List() = %v, expected %v", keys, want)
+	}
+	ki, err := storage.Stat(ctx, "test/index/a")
+	if err != nil || ki.Size != int64(len("value of test/index/a")) {
+		t.Errorf("Stat() = %+v, %v, expected entry from index", ki, err)
+	}
+
+	if err := storage.ReconcileIndex(ctx); err != nil {
+		t.Fatalf("ReconcileIndex() failed: %v", err)
+	}
+	if keys, _ := storage.List(ctx, "test", true); !reflect.DeepEqual(keys, []string{"test/index/sub/b"}) {
+		t.Errorf("List() after ReconcileIndex = %v", keys)
+	}
+	if _, err := storage.Stat(ctx, "test/index/a"); err == nil {
+		t.Error("Stat() of removed key succeeded after ReconcileIndex")
+	}
+}
+
+func TestS3Storage_IndexConcurrentWriters(t *testing.T) {
+	storage := setupIndexedStorage(t)
+	opts := testOpts(true)
+	opts.Index = true
+	other, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i, s := range []*S3Storage{storage, other} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				if err := s.Store(ctx, fmt.Sprintf("test/writers/%d-%d", i, j), []byte("value")); err != nil {
+					t.Errorf("Store() failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A fresh instance reads the index both wrote to.
+	reader, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	keys, err := reader.List(ctx, "test/writers", true)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if len(keys) != 6 {
+		t.Errorf("List() = %v, expected the keys of both writers", keys)
+	}
+}
@@ -0,0 +1,175 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"slices"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// InitTimeout is how long instances starting at once wait for the one
+// initializing the prefix, after which they take over from it.
+var InitTimeout = time.Minute
+
+// initPollInterval is how often waiting instances check whether the
+// initialization finished.
+var initPollInterval = time.Second
+
+// initFile fences the one-time initialization of a prefix. The instance
+// that writes it with its Owner runs the tasks, then records them in Done
+// and clears Owner; the others wait meanwhile.
+type initFile struct {
+	Owner   string    `json:"owner,omitempty"`
+	Started time.Time `json:"started"`
+	Done    []string  `json:"done,omitempty"`
+}
+
+// initTask is a task of the initialization, run once per prefix.
+type initTask struct {
+	name string
+	run  func(ctx context.Context, gs *S3Storage, opts S3Opts) error
+}
+
+// initTasks returns the tasks opts need. The layout is not among them: it
+// is checked against the options of every instance on connect.
+func initTasks(opts S3Opts) []initTask {
+	var tasks []initTask
+	if opts.Index {
+		tasks = append(tasks, initTask{"index", func(ctx context.Context, gs *S3Storage, opts S3Opts) error {
+			return gs.ReconcileIndex(ctx)
+		}})
+	}
+	return tasks
+}
+
+func (gs *S3Storage) initName() string {
+	return gs.prefix + ".init.json"
+}
+
+// readInit returns the fence and its ETag, or fs.ErrNotExist.
+func (gs *S3Storage) readInit(ctx context.Context) (*initFile, string, error) {
+	obj, err := gs.s3client.GetObject(ctx, gs.bucket, gs.initName(), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer obj.Close()
+	buf, err := ioutil.ReadAll(obj)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, "", fs.ErrNotExist
+	} else if err != nil {
+		return nil, "", err
+	}
+	oi, err := obj.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	var f initFile
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return nil, "", fmt.Errorf("invalid init file %s: %w", gs.initName(), err)
+	}
+	return &f, oi.ETag, nil
+}
+
+// writeInit writes f if the fence is still the one with etag, or does not
+// exist if etag is empty, and returns the new ETag.
+func (gs *S3Storage) writeInit(ctx context.Context, f *initFile, etag string) (string, error) {
+	buf, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+	opts := minio.PutObjectOptions{ContentType: "application/json", DisableContentSha256: gs.unsignedPayload}
+	if etag == "" {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(etag)
+	}
+	info, err := gs.s3client.PutObject(ctx, gs.bucket, gs.initName(), bytes.NewReader(buf), int64(len(buf)), opts)
+	if err != nil {
+		return "", err
+	}
+	return info.ETag, nil
+}
+
+// initPrefix runs the initialization tasks of opts not yet done for the
+// prefix, in exactly one of the instances starting at once. The others
+// wait for it, or take over once it took longer than InitTimeout, so the
+// tasks run bounded by InitTimeout. Tasks recorded as done but not needed
+// by opts are forgotten: an index not maintained meanwhile is stale once
+// it is enabled again.
+func (gs *S3Storage) initPrefix(ctx context.Context, opts S3Opts) error {
+	if gs.migrating {
+		return nil
+	}
+	tasks := initTasks(opts)
+	for {
+		f, etag, err := gs.readInit(ctx)
+		if errors.Is(err, fs.ErrNotExist) {
+			f, etag = &initFile{}, ""
+		} else if err != nil {
+			return err
+		}
+		var todo []initTask
+		for _, t := range tasks {
+			if !slices.Contains(f.Done, t.name) {
+				todo = append(todo, t)
+			}
+		}
+		done := slices.DeleteFunc(slices.Clone(f.Done), func(name string) bool {
+			return !slices.ContainsFunc(tasks, func(t initTask) bool { return t.name == name })
+		})
+		if len(todo) == 0 && len(done) == len(f.Done) {
+			return nil
+		}
+		if f.Owner != "" && gs.clock.now().Sub(f.Started) < InitTimeout {
+			// Another instance is initializing.
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(initPollInterval):
+			}
+			continue
+		}
+
+		f.Done = done
+		if len(todo) == 0 {
+			// Only forgetting tasks, which needs no fencing.
+			if _, err := gs.writeInit(ctx, f, etag); isPreconditionFailed(err) {
+				continue
+			} else if err != nil {
+				gs.logf("Initialization of %s skipped: %v", gs.prefix, err)
+			}
+			return nil
+		}
+		f.Owner, f.Started = gs.owner, gs.clock.now().UTC()
+		etag, err = gs.writeInit(ctx, f, etag)
+		if isPreconditionFailed(err) {
+			// Another instance was faster.
+			continue
+		} else if err != nil {
+			// E.g. read-only credentials, which cannot initialize anyway.
+			gs.logf("Initialization of %s skipped: %v", gs.prefix, err)
+			return nil
+		}
+		runCtx, cancel := context.WithTimeout(ctx, InitTimeout)
+		defer cancel()
+		for _, t := range todo {
+			if err := t.run(runCtx, gs, opts); err != nil {
+				// Released, so the next instance tries without waiting.
+				f.Owner = ""
+				gs.writeInit(ctx, f, etag)
+				return fmt.Errorf("initializing %s: %s: %w", gs.prefix, t.name, err)
+			}
+			f.Done = append(f.Done, t.name)
+		}
+		f.Owner = ""
+		_, err = gs.writeInit(ctx, f, etag)
+		return err
+	}
+}
@@ -0,0 +1,74 @@
+package cmgs3
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestInitTasks(t *testing.T) {
+	var names []string
+	for _, task := range initTasks(S3Opts{Index: true}) {
+		names = append(names, task.name)
+	}
+	if len(names) != 1 || names[0] != "index" {
+		t.Errorf("initTasks() = %v", names)
+	}
+	if tasks := initTasks(S3Opts{}); len(tasks) != 0 {
+		t.Errorf("initTasks() without an index = %d tasks", len(tasks))
+	}
+}
+
+func TestS3Storage_InitPrefix(t *testing.T) {
+	opts := testOpts(false)
+	opts.ObjPrefix = testPrefix + "-init"
+	opts.Index = true
+	first := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		for _, obj := range []string{first.initName(), first.layoutName(), first.indexName()} {
+			first.s3client.RemoveObject(ctx, first.bucket, obj, minio.RemoveObjectOptions{})
+		}
+	})
+
+	// Instances starting at once all wait for one initialization.
+	first.s3client.RemoveObject(ctx, first.bucket, first.initName(), minio.RemoveObjectOptions{})
+	opts.LazyInit = true
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			storage, err := NewS3Storage(opts)
+			if err == nil {
+				err = storage.Ping(ctx)
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("starting an instance failed: %v", err)
+		}
+	}
+	f, _, err := first.readInit(ctx)
+	if err != nil {
+		t.Fatalf("readInit() failed: %v", err)
+	}
+	if f.Owner != "" || len(f.Done) != 1 {
+		t.Errorf("init file = %+v, expected the index task done", f)
+	}
+
+	// Without the index it is forgotten, to be reconciled once re-enabled.
+	opts.Index = false
+	if err := first.initPrefix(ctx, opts); err != nil {
+		t.Fatalf("initPrefix() without the index failed: %v", err)
+	}
+	if f, _, err = first.readInit(ctx); err != nil || len(f.Done) != 0 {
+		t.Errorf("init file = %+v, %v, expected no tasks done", f, err)
+	}
+}
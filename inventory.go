@@ -0,0 +1,160 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// InventoryRecord describes one stored key. Checksum is the S3 checksum
+// recorded at upload, prefixed with its algorithm, if any. KeyID is that of
+// the key the value was sealed with, see KeyID, and empty for cleartext
+// values and values written before IDs were recorded.
+type InventoryRecord struct {
+	Key      string        `json:"key"`
+	Size     int64         `json:"size"`
+	Modified time.Time     `json:"modified"`
+	Age      time.Duration `json:"age"`
+	ETag     string        `json:"etag"`
+	Checksum string        `json:"checksum,omitempty"`
+	KeyID    string        `json:"key_id,omitempty"`
+}
+
+// inventoryPrefix is kept beside the prefix rather than under it, so List
+// does not report reports as keys.
+func (gs *S3Storage) inventoryPrefix() string {
+	return gs.prefix + ".inventory/"
+}
+
+// Inventory returns a record of every stored key, using one HEAD request
+// per key.
+func (gs *S3Storage) Inventory(ctx context.Context) ([]InventoryRecord, error) {
+	ctx = withDefaultPriority(ctx, PriorityBulk)
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	now := time.Now()
+	var recs []InventoryRecord
+	for obj := range gs.s3client.ListObjects(lctx, gs.bucket, minio.ListObjectsOptions{
+		Prefix:    gs.prefix + "/",
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if strings.HasSuffix(obj.Key, ".lock") {
+			continue
+		}
+		oi, err := gs.s3client.StatObject(ctx, gs.bucket, obj.Key, minio.StatObjectOptions{Checksum: true})
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			// Removed since it was listed.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		key := gs.keyName(obj.Key)
+		ki, _ := gs.keyInfo(key, oi)
+		recs = append(recs, InventoryRecord{
+			Key:      key,
+			Size:     ki.Size,
+			Modified: ki.Modified,
+			Age:      now.Sub(ki.Modified),
+			ETag:     oi.ETag,
+			Checksum: objectChecksum(oi),
+			KeyID:    oi.UserMetadata[metaKeyID],
+		})
+	}
+	return recs, nil
+}
+
+func objectChecksum(oi minio.ObjectInfo) string {
+	for _, c := range []struct{ alg, sum string }{
+		{"SHA256", oi.ChecksumSHA256},
+		{"SHA1", oi.ChecksumSHA1},
+		{"CRC32C", oi.ChecksumCRC32C},
+		{"CRC32", oi.ChecksumCRC32},
+	} {
+		if c.sum != "" {
+			return c.alg + ":" + c.sum
+		}
+	}
+	return ""
+}
+
+// WriteInventory stores an Inventory in format "csv" or "json" and returns
+// the name of the object, which is in cleartext below the inventory/ prefix
+// beside the storage prefix.
+func (gs *S3Storage) WriteInventory(ctx context.Context, format string) (string, error) {
+	ctx = withDefaultPriority(ctx, PriorityBulk)
+	recs, err := gs.Inventory(ctx)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv"
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"key", "size", "modified", "age_seconds", "etag", "checksum", "key_id"})
+		for _, r := range recs {
+			w.Write([]string{
+				r.Key,
+				strconv.FormatInt(r.Size, 10),
+				r.Modified.UTC().Format(time.RFC3339Nano),
+				strconv.FormatInt(int64(r.Age/time.Second), 10),
+				r.ETag,
+				r.Checksum,
+				r.KeyID,
+			})
+		}
+		w.Flush()
+		err = w.Error()
+	case "json":
+		contentType = "application/json"
+		err = json.NewEncoder(&buf).Encode(recs)
+	default:
+		return "", fmt.Errorf("unsupported inventory format %q", format)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Names sort by time, so the latest report is listed last.
+	name := gs.inventoryPrefix() + time.Now().UTC().Format("20060102T150405Z") + "." + format
+	_, err = gs.s3client.PutObject(ctx, gs.bucket, name, &buf, int64(buf.Len()), minio.PutObjectOptions{
+		ContentType:          contentType,
+		DisableContentSha256: gs.unsignedPayload,
+	})
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// writeInventories writes an inventory every interval until the storage is
+// closed.
+func (gs *S3Storage) writeInventories(format string, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-gs.stop:
+			return
+		case <-tick.C:
+		}
+		ctx, cancel := context.WithTimeout(WithBackground(context.Background()), interval)
+		if _, err := gs.WriteInventory(ctx, format); err != nil {
+			gs.logf("Writing inventory failed: %v", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,83 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"testing"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestS3Storage_Inventory(t *testing.T) {
+	opts := testOpts(true)
+	opts.Checksum = "SHA256"
+	opts.CleartextKeys = func(key string) bool { return key == "test/inventory/plain" }
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+	for _, k := range []string{"test/inventory/sealed", "test/inventory/plain"} {
+		if err := storage.Store(ctx, k, []byte("value")); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+
+	recs, err := storage.Inventory(ctx)
+	if err != nil {
+		t.Fatalf("Inventory() failed: %v", err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("Inventory() returned %d records, expected 2", len(recs))
+	}
+	wantID, err := KeyID(opts.EncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range recs {
+		if r.Size != 5 || r.Modified.IsZero() || r.ETag == "" || r.Checksum == "" {
+			t.Errorf("Inventory() record %+v incomplete", r)
+		}
+		if want := map[string]string{"test/inventory/sealed": wantID}[r.Key]; r.KeyID != want {
+			t.Errorf("KeyID of %s = %q, expected %q", r.Key, r.KeyID, want)
+		}
+	}
+
+	for _, format := range []string{"csv", "json"} {
+		name, err := storage.WriteInventory(ctx, format)
+		if err != nil {
+			t.Fatalf("WriteInventory(%s) failed: %v", format, err)
+		}
+		defer storage.s3client.RemoveObject(ctx, testBucket, name, minio.RemoveObjectOptions{})
+		obj, err := storage.s3client.GetObject(ctx, testBucket, name, minio.GetObjectOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := io.ReadAll(obj)
+		obj.Close()
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", name, err)
+		}
+		var n int
+		if format == "csv" {
+			rows, err := csv.NewReader(bytes.NewReader(buf)).ReadAll()
+			if err != nil {
+				t.Fatalf("parsing %s failed: %v", name, err)
+			}
+			n = len(rows) - 1
+		} else {
+			var got []InventoryRecord
+			if err := json.Unmarshal(buf, &got); err != nil {
+				t.Fatalf("parsing %s failed: %v", name, err)
+			}
+			n = len(got)
+		}
+		if n != 2 {
+			t.Errorf("%s inventory has %d records, expected 2", format, n)
+		}
+	}
+
+	if _, err := storage.WriteInventory(ctx, "xml"); err == nil {
+		t.Error("WriteInventory() with unknown format succeeded")
+	}
+}
@@ -2,11 +2,16 @@ package cmgs3
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"errors"
 	"io"
 	"io/ioutil"
+	"sync"
 
+	"golang.org/x/crypto/hkdf"
 	"golang.org/x/crypto/nacl/secretbox"
 )
 
@@ -30,6 +35,18 @@ func (r Reader) Read(buf []byte) (int, error) {
 	return r.r.Read(buf)
 }
 
+// ReadAt allows the S3 client to upload parts of large objects in parallel.
+func (r Reader) ReadAt(buf []byte, off int64) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	ra, ok := r.r.(io.ReaderAt)
+	if !ok {
+		return 0, errors.New("reader does not support ReadAt")
+	}
+	return ra.ReadAt(buf, off)
+}
+
 func (r *Reader) Len() int64 {
 	return r.l
 }
@@ -44,10 +61,65 @@ func (ci *CleartextIO) ByteReader(buf []byte) Reader {
 	return Reader{bytes.NewReader(buf), int64(len(buf)), nil}
 }
 
+// sealer is implemented by the encrypting IOs.
+type sealer interface {
+	IO
+	// deriveIO returns the IO for a key derived with HKDF-SHA256.
+	deriveIO(info string) IO
+	// overhead is the size difference between ciphertext and plaintext.
+	overhead() int64
+	secretKey() [32]byte
+	// open decrypts a whole sealed value, without the copies of WrapReader.
+	open(raw []byte) ([]byte, error)
+}
+
+// ErrDecryptFailed is returned when a value exists but cannot be
+// decrypted, because it was sealed with another key or is corrupt. It is
+// distinct from fs.ErrNotExist, so certmagic does not mistake such a
+// certificate for a missing one and obtain a new one.
+var ErrDecryptFailed = errors.New("decryption failed")
+
+// newSealer returns the encrypting IO for key, AES-GCM in FIPS mode and
+// secretbox otherwise.
+func newSealer(key []byte, fips bool) sealer {
+	if fips {
+		ag := &AESGCMIO{}
+		copy(ag.SecretKey[:], key)
+		return ag
+	}
+	sb := &SecretBoxIO{}
+	copy(sb.SecretKey[:], key)
+	return sb
+}
+
+func deriveKey(key [32]byte, info string) [32]byte {
+	var out [32]byte
+	r := hkdf.New(sha256.New, key[:], nil, []byte(info))
+	if _, err := io.ReadFull(r, out[:]); err != nil {
+		// Only possible when requesting more than 255 hash lengths.
+		panic(err)
+	}
+	return out
+}
+
+// secretBoxOverhead is the size difference between a SecretBoxIO ciphertext
+// and its plaintext: the prepended nonce plus the authenticator.
+const secretBoxOverhead = 24 + secretbox.Overhead
+
 type SecretBoxIO struct {
 	SecretKey [32]byte
 }
 
+// DeriveKey returns a SecretBoxIO whose key is derived from SecretKey and
+// info with HKDF-SHA256.
+func (sb *SecretBoxIO) DeriveKey(info string) *SecretBoxIO {
+	return &SecretBoxIO{SecretKey: deriveKey(sb.SecretKey, info)}
+}
+
+func (sb *SecretBoxIO) deriveIO(info string) IO { return sb.DeriveKey(info) }
+func (sb *SecretBoxIO) overhead() int64         { return secretBoxOverhead }
+func (sb *SecretBoxIO) secretKey() [32]byte     { return sb.SecretKey }
+
 func (sb *SecretBoxIO) readNonce(r io.Reader) ([24]byte, error) {
 	var (
 		nonce = make([]byte, 24)
@@ -76,15 +148,123 @@ func (sb *SecretBoxIO) WrapReader(r io.Reader) io.Reader {
 	buf, _ := ioutil.ReadAll(r)
 	bout, ok := secretbox.Open(nil, buf, &nonce, &sb.SecretKey)
 	if !ok {
-		return Reader{nil, 0, errors.New("decryption failed")}
+		return Reader{nil, 0, ErrDecryptFailed}
 	}
 	return bytes.NewReader(bout)
 }
 
+func (sb *SecretBoxIO) open(raw []byte) ([]byte, error) {
+	if len(raw) < 24 {
+		return nil, ErrDecryptFailed
+	}
+	var nonce [24]byte
+	copy(nonce[:], raw)
+	out, ok := secretbox.Open(make([]byte, 0, len(raw)-24), raw[24:], &nonce, &sb.SecretKey)
+	if !ok {
+		return nil, ErrDecryptFailed
+	}
+	return out, nil
+}
+
 func (sb *SecretBoxIO) ByteReader(msg []byte) Reader {
 	nonce, err := sb.makeNonce()
-	out := make([]byte, len(nonce))
+	out := make([]byte, len(nonce), len(nonce)+len(msg)+secretbox.Overhead)
 	copy(out, nonce[:])
 	out = secretbox.Seal(out, msg, &nonce, &sb.SecretKey)
 	return Reader{bytes.NewReader(out), int64(len(out)), err}
 }
+
+// aesGCMOverhead is the size difference between an AESGCMIO ciphertext and
+// its plaintext: the prepended nonce plus the tag.
+const aesGCMOverhead = 12 + 16
+
+// AESGCMIO encrypts with AES-256-GCM, for environments restricted to
+// FIPS-approved primitives.
+type AESGCMIO struct {
+	SecretKey [32]byte
+}
+
+// DeriveKey returns an AESGCMIO whose key is derived from SecretKey and
+// info with HKDF-SHA256.
+func (ag *AESGCMIO) DeriveKey(info string) *AESGCMIO {
+	return &AESGCMIO{SecretKey: deriveKey(ag.SecretKey, info)}
+}
+
+func (ag *AESGCMIO) deriveIO(info string) IO { return ag.DeriveKey(info) }
+func (ag *AESGCMIO) overhead() int64         { return aesGCMOverhead }
+func (ag *AESGCMIO) secretKey() [32]byte     { return ag.SecretKey }
+
+func (ag *AESGCMIO) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(ag.SecretKey[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (ag *AESGCMIO) WrapReader(r io.Reader) io.Reader {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Reader{nil, 0, err}
+	}
+	aead, err := ag.aead()
+	if err != nil {
+		return Reader{nil, 0, err}
+	}
+	if len(buf) < aead.NonceSize() {
+		return Reader{nil, 0, ErrDecryptFailed}
+	}
+	n := aead.NonceSize()
+	bout, err := aead.Open(nil, buf[:n], buf[n:], nil)
+	if err != nil {
+		return Reader{nil, 0, ErrDecryptFailed}
+	}
+	return bytes.NewReader(bout)
+}
+
+func (ag *AESGCMIO) open(raw []byte) ([]byte, error) {
+	aead, err := ag.aead()
+	if err != nil {
+		return nil, err
+	}
+	n := aead.NonceSize()
+	if len(raw) < n {
+		return nil, ErrDecryptFailed
+	}
+	out, err := aead.Open(make([]byte, 0, len(raw)-n), raw[:n], raw[n:], nil)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return out, nil
+}
+
+func (ag *AESGCMIO) ByteReader(msg []byte) Reader {
+	aead, err := ag.aead()
+	if err != nil {
+		return Reader{bytes.NewReader(nil), 0, err}
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(msg)+aead.Overhead())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	out := aead.Seal(nonce, nonce, msg, nil)
+	return Reader{bytes.NewReader(out), int64(len(out)), err}
+}
+
+// bufferPool holds download buffers, whose content is garbage once the
+// value is opened, so loads do not allocate one per object.
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// maxPooledBuffer keeps the buffers of unusually large objects out of the
+// pool.
+const maxPooledBuffer = 1 << 20
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
@@ -51,3 +51,110 @@ func TestIOWrap(t *testing.T) {
 		t.Errorf("Buffer should be empty when error occurs, got: %v", buf)
 	}
 }
+
+func TestDeriveKey(t *testing.T) {
+	sb := SecretBoxIO{}
+	copy(sb.SecretKey[:], "12345678123456781234567812345678")
+
+	a := sb.DeriveKey("certificates/a")
+	b := sb.DeriveKey("certificates/b")
+	if a.SecretKey == b.SecretKey || a.SecretKey == sb.SecretKey {
+		t.Errorf("derived keys must differ from each other and the master key")
+	}
+	if sb.DeriveKey("certificates/a").SecretKey != a.SecretKey {
+		t.Errorf("key derivation is not deterministic")
+	}
+
+	buf, _ := ioutil.ReadAll(a.ByteReader([]byte("secret")))
+	if out, err := ioutil.ReadAll(b.WrapReader(bytes.NewReader(buf))); err == nil {
+		t.Errorf("value sealed with one derived key opened with another: %s", out)
+	}
+}
+
+func TestAESGCMEncryptDecrypt(t *testing.T) {
+	ag := AESGCMIO{}
+	copy(ag.SecretKey[:], "12345678123456781234567812345678")
+
+	msg := []byte("This is a very important message that shall be encrypted...")
+	r := ag.ByteReader(msg)
+	if r.Len() != int64(len(msg)+aesGCMOverhead) {
+		t.Errorf("unexpected ciphertext length %d", r.Len())
+	}
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Errorf("encrypting failed: %v", err)
+	}
+
+	out, err := ioutil.ReadAll(ag.WrapReader(bytes.NewReader(buf)))
+	if err != nil {
+		t.Errorf("decrypting failed: %v", err)
+	}
+	if string(out) != string(msg) {
+		t.Errorf("did not decrypt, got: %s", out)
+	}
+
+	other := ag.DeriveKey("other")
+	if _, err := ioutil.ReadAll(other.WrapReader(bytes.NewReader(buf))); err == nil {
+		t.Errorf("decrypting with the wrong key should fail")
+	}
+
+	if _, err := ioutil.ReadAll(ag.WrapReader(bytes.NewReader(nil))); err == nil {
+		t.Errorf("reading should fail with empty reader")
+	}
+}
+
+func TestFIPSRequired(t *testing.T) {
+	tests := []struct {
+		gofips, godebug string
+		want            bool
+	}{
+		{"", "", boringCrypto},
+		{"1", "", true},
+		{"0", "", boringCrypto},
+		{"", "http2client=0,fips140=on", true},
+		{"", "fips140=only", true},
+		{"", "fips140=off", boringCrypto},
+	}
+	for _, tt := range tests {
+		t.Setenv("GOFIPS", tt.gofips)
+		t.Setenv("GODEBUG", tt.godebug)
+		if got := fipsRequired(); got != tt.want {
+			t.Errorf("fipsRequired() with GOFIPS=%q GODEBUG=%q = %v, expected %v", tt.gofips, tt.godebug, got, tt.want)
+		}
+	}
+}
+
+func TestSealerOpen(t *testing.T) {
+	var key [32]byte
+	copy(key[:], "12345678123456781234567812345678")
+	msg := []byte("This is a very important message that shall be encrypted...")
+	for _, s := range []sealer{&SecretBoxIO{SecretKey: key}, &AESGCMIO{SecretKey: key}} {
+		raw, err := ioutil.ReadAll(s.ByteReader(msg))
+		if err != nil {
+			t.Fatalf("encrypting failed: %v", err)
+		}
+		buf, err := s.open(raw)
+		if err != nil || !bytes.Equal(buf, msg) {
+			t.Errorf("%T.open() = %q, %v, expected %q", s, buf, err, msg)
+		}
+		if _, err := s.open(nil); err == nil {
+			t.Errorf("%T.open() of nothing succeeded", s)
+		}
+		raw[len(raw)-1] ^= 1
+		if _, err := s.open(raw); err == nil {
+			t.Errorf("%T.open() of tampered value succeeded", s)
+		}
+		if buf, err := s.open(mustRead(t, s.ByteReader(nil))); err != nil || buf == nil || len(buf) != 0 {
+			t.Errorf("%T.open() of empty value = %q, %v", s, buf, err)
+		}
+	}
+}
+
+func mustRead(t testing.TB, r Reader) []byte {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
@@ -0,0 +1,133 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"sync"
+
+	minio "github.com/minio/minio-go/v7"
+	"github.com/sam-lord/certmagic"
+)
+
+// StatConcurrency bounds the parallel Stat calls of StatMany.
+var StatConcurrency = 8
+
+// StatMany returns the KeyInfo of each of keys that exists, as Stat would,
+// stating them in parallel. Keys that do not exist are left out; other
+// errors fail the call.
+func (gs *S3Storage) StatMany(ctx context.Context, keys []string) (map[string]certmagic.KeyInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		sem      = make(chan struct{}, StatConcurrency)
+	)
+	infos := make(map[string]certmagic.KeyInfo, len(keys))
+	for _, k := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(k string) {
+			defer func() { <-sem; wg.Done() }()
+			ki, err := gs.Stat(ctx, k)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				infos[k] = ki
+			case !errors.Is(err, fs.ErrNotExist) && firstErr == nil:
+				firstErr = err
+				cancel()
+			}
+		}(k)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return infos, nil
+}
+
+// ListInfo lists like List, but returns the KeyInfo of each entry as the
+// listing has it, without a request per key: Size is the stored size less
+// the encryption overhead, and Modified the time of the upload, unless the
+// provider lists metadata (MinIO), which makes them exact. Directories of
+// non-recursive listings are not terminal. In the layouts of
+// ShardCertificates and Bundle, the keys are stated with StatMany instead.
+func (gs *S3Storage) ListInfo(ctx context.Context, prefix string, recursive bool) ([]certmagic.KeyInfo, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		if err := gs.access("list", prefix, namespaceOf(prefix+"/"), PermRead); err != nil {
+			return nil, err
+		}
+	}
+	if err := gs.ready(ctx); err != nil {
+		return nil, err
+	}
+	if gs.shardCerts || gs.bundles {
+		return gs.statListed(ctx, prefix, recursive)
+	}
+
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var infos []certmagic.KeyInfo
+	for obj := range gs.s3client.ListObjects(lctx, gs.bucket, minio.ListObjectsOptions{
+		Prefix:       gs.objListPrefix(prefix),
+		Recursive:    recursive,
+		WithMetadata: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if strings.HasSuffix(obj.Key, ".lock") {
+			continue
+		}
+		key := gs.keyName(obj.Key)
+		if prefix == "" && len(gs.readable([]string{key})) == 0 {
+			continue
+		}
+		if strings.HasSuffix(obj.Key, "/") {
+			infos = append(infos, certmagic.KeyInfo{Key: key})
+			continue
+		}
+		listedMetadata(&obj)
+		if gs.undecryptablePolicy != UndecryptableFail && gs.sealedWithUnknownKey(obj) {
+			continue
+		}
+		ki, _ := gs.keyInfo(key, obj)
+		infos = append(infos, ki)
+	}
+	return infos, nil
+}
+
+// statListed answers ListInfo with List and StatMany. Listed keys that
+// cannot be stated are directories.
+func (gs *S3Storage) statListed(ctx context.Context, prefix string, recursive bool) ([]certmagic.KeyInfo, error) {
+	listed, err := gs.List(ctx, prefix, recursive)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, k := range listed {
+		if !strings.HasSuffix(k, ".lock") {
+			keys = append(keys, k)
+		}
+	}
+	stated, err := gs.StatMany(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]certmagic.KeyInfo, 0, len(keys))
+	for _, k := range keys {
+		ki, ok := stated[k]
+		if !ok {
+			ki = certmagic.KeyInfo{Key: k}
+		}
+		infos = append(infos, ki)
+	}
+	return infos, nil
+}
@@ -0,0 +1,64 @@
+package cmgs3
+
+import (
+	"context"
+	"testing"
+)
+
+func TestS3Storage_StatMany(t *testing.T) {
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+	keys := []string{"test/keyinfo/a", "test/keyinfo/sub/b"}
+	for _, key := range keys {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+	defer storage.Delete(ctx, "test/keyinfo")
+
+	infos, err := storage.StatMany(ctx, append(keys, "test/keyinfo/missing"))
+	if err != nil {
+		t.Fatalf("StatMany() failed: %v", err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("StatMany() returned %d keys, expected 2", len(infos))
+	}
+	for _, key := range keys {
+		if ki := infos[key]; ki.Size != int64(len(key)) || !ki.IsTerminal {
+			t.Errorf("StatMany()[%s] = %+v", key, ki)
+		}
+	}
+}
+
+func TestS3Storage_ListInfo(t *testing.T) {
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+	keys := []string{"test/listinfo/a", "test/listinfo/sub/b"}
+	for _, key := range keys {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+	defer storage.Delete(ctx, "test/listinfo")
+
+	infos, err := storage.ListInfo(ctx, "test/listinfo", true)
+	if err != nil {
+		t.Fatalf("ListInfo() failed: %v", err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("recursive ListInfo() = %+v", infos)
+	}
+	for i, ki := range infos {
+		if ki.Key != keys[i] || ki.Size != int64(len(keys[i])) || !ki.IsTerminal || ki.Modified.IsZero() {
+			t.Errorf("ListInfo() entry %d = %+v", i, ki)
+		}
+	}
+
+	infos, err = storage.ListInfo(ctx, "test/listinfo", false)
+	if err != nil {
+		t.Fatalf("ListInfo() failed: %v", err)
+	}
+	if len(infos) != 2 || infos[1].Key != "test/listinfo/sub" || infos[1].IsTerminal {
+		t.Errorf("non-recursive ListInfo() = %+v", infos)
+	}
+}
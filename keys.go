@@ -0,0 +1,202 @@
+package cmgs3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// KeyFilePollInterval is how often EncryptionKeyFile is polled for changes.
+var KeyFilePollInterval = 10 * time.Second
+
+// keyring holds the IO sealing new values and the IOs of retired keys,
+// which are still tried when opening values written before a rotation.
+type keyring struct {
+	mu      sync.RWMutex
+	current IO
+	retired []IO
+}
+
+func (k *keyring) get() IO {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// all returns the current IO followed by the retired ones, newest first.
+func (k *keyring) all() []IO {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return append([]IO{k.current}, k.retired...)
+}
+
+func (k *keyring) rotate(next IO) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.retired = append([]IO{k.current}, k.retired...)
+	k.current = next
+}
+
+// retire adds the sealer of key after the retired IOs, unless the keyring
+// has it already.
+func (k *keyring) retire(key []byte, aesGCM bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, io := range append([]IO{k.current}, k.retired...) {
+		if s, ok := io.(sealer); ok {
+			if sk := s.secretKey(); bytes.Equal(sk[:], key) {
+				return
+			}
+		}
+	}
+	k.retired = append(k.retired, newSealer(key, aesGCM))
+}
+
+// keyIDInfo is the HKDF info from which key IDs are derived, so an ID
+// identifies a key without revealing it.
+const keyIDInfo = "cmgs3 key id v1"
+
+// KeyID returns the ID recorded with values sealed by key, as reported by
+// Inventory. It accepts the same encodings as S3Opts.EncryptionKeyFile.
+func KeyID(key []byte) (string, error) {
+	k, err := parseKey(key)
+	if err != nil {
+		return "", err
+	}
+	var sk [32]byte
+	copy(sk[:], k)
+	return keyID(sk), nil
+}
+
+func keyID(key [32]byte) string {
+	id := deriveKey(key, keyIDInfo)
+	return hex.EncodeToString(id[:8])
+}
+
+// keyIDFor returns the ID of the key sealing the value at key, or "" if it
+// is stored in cleartext.
+func (gs *S3Storage) keyIDFor(key string) string {
+	if gs.isCleartextKey(key) {
+		return ""
+	}
+	if s, ok := gs.keyringFor(key).get().(sealer); ok {
+		return keyID(s.secretKey())
+	}
+	return ""
+}
+
+// parseKey accepts a key of 32 raw bytes, or its hex or base64 encoding.
+// Surrounding whitespace, as left by editors and secret tooling, is ignored.
+func parseKey(buf []byte) ([]byte, error) {
+	if len(buf) == 32 {
+		return buf, nil
+	}
+	s := string(bytes.TrimSpace(buf))
+	if len(s) == 32 {
+		return []byte(s), nil
+	}
+	if k, err := hex.DecodeString(s); err == nil && len(k) == 32 {
+		return k, nil
+	}
+	if k, err := base64.StdEncoding.DecodeString(s); err == nil && len(k) == 32 {
+		return k, nil
+	}
+	return nil, errors.New("encryption key must have exactly 32 bytes")
+}
+
+// parseKeys parses a key file: a single key as parseKey accepts it, or
+// several hex or base64 encoded keys, one per line, the current first.
+func parseKeys(buf []byte) ([][]byte, error) {
+	if key, err := parseKey(buf); err == nil {
+		return [][]byte{key}, nil
+	}
+	var keys [][]byte
+	for _, line := range bytes.Split(buf, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		key, err := parseKey(line)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("encryption key must have exactly 32 bytes")
+	}
+	return keys, nil
+}
+
+// readKeyFile returns the keys of the file at path, the current first, and
+// the checksum of its content.
+func readKeyFile(path string) ([][]byte, [32]byte, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, [32]byte{}, err
+	}
+	keys, err := parseKeys(buf)
+	return keys, sha256.Sum256(buf), err
+}
+
+// ReloadEncryptionKey re-reads S3Opts.EncryptionKeyFile. New values are
+// sealed with its first key; the previous keys, and the others listed in
+// the file, are kept for reading.
+func (gs *S3Storage) ReloadEncryptionKey() error {
+	if gs.keyFile == "" {
+		return errors.New("no encryption key file configured")
+	}
+	keys, sum, err := readKeyFile(gs.keyFile)
+	if err != nil {
+		return err
+	}
+
+	gs.keyFileMu.Lock()
+	defer gs.keyFileMu.Unlock()
+	if sum == gs.keyFileSum {
+		return nil
+	}
+	gs.keyFileSum = sum
+	for _, key := range keys {
+		gs.scrub.addKey(key)
+	}
+	for _, key := range keys[1:] {
+		gs.retireKey(key)
+	}
+	if cur, ok := gs.keys.get().(sealer); ok {
+		if k := cur.secretKey(); bytes.Equal(k[:], keys[0]) {
+			return nil
+		}
+	}
+	gs.rotateKey(keys[0])
+	gs.logf("Encryption key reloaded from %s", gs.keyFile)
+	return nil
+}
+
+// watchKeyFile reloads the key file on SIGHUP and when its content changes,
+// until the storage is closed.
+func (gs *S3Storage) watchKeyFile() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	tick := time.NewTicker(KeyFilePollInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-gs.stop:
+			return
+		case <-hup:
+		case <-tick.C:
+		}
+		if err := gs.ReloadEncryptionKey(); err != nil {
+			gs.logf("Reloading encryption key from %s failed: %v", gs.keyFile, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package cmgs3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// shardedNamespace is the certmagic key namespace that ShardCertificates
+// spreads across hashed sub-prefixes.
+const shardedNamespace = "certificates"
+
+// shardWidth is the number of hex characters of a shard, 2 gives 256 shards.
+const shardWidth = 2
+
+// shardListConcurrency bounds the parallel LIST calls needed to merge shards.
+var shardListConcurrency = 16
+
+func shardOf(component string) string {
+	h := sha256.Sum256([]byte(component))
+	return hex.EncodeToString(h[:])[:shardWidth]
+}
+
+func allShards() []string {
+	n := 1 << (4 * shardWidth)
+	shards := make([]string, n)
+	for i := range shards {
+		shards[i] = hex.EncodeToString([]byte{byte(i)})[2-shardWidth:]
+	}
+	return shards
+}
+
+// shardKey maps certificates/<issuer>/<site>/... to
+// certificates/<shard>/<issuer>/<site>/... with the shard derived from the
+// site, so all files of a site share a shard. Other keys are returned as is.
+func shardKey(key string) string {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 || parts[0] != shardedNamespace {
+		return key
+	}
+	site, _, _ := strings.Cut(parts[2], "/")
+	return parts[0] + "/" + shardOf(site) + "/" + parts[1] + "/" + parts[2]
+}
+
+// unshardKey reverses shardKey.
+func unshardKey(key string) string {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 || parts[0] != shardedNamespace || len(parts[1]) != shardWidth {
+		return key
+	}
+	return parts[0] + "/" + parts[2]
+}
+
+// shardedListPrefixes returns the storage key prefixes that have to be
+// listed to answer a List for prefix in the sharded layout.
+func shardedListPrefixes(prefix string) []string {
+	parts := strings.SplitN(prefix, "/", 3)
+	switch {
+	case parts[0] != shardedNamespace || len(parts) >= 3:
+		return []string{shardKey(prefix)}
+	case len(parts) == 2:
+		var ps []string
+		for _, s := range allShards() {
+			ps = append(ps, shardedNamespace+"/"+s+"/"+parts[1])
+		}
+		return ps
+	default:
+		return nil
+	}
+}
+
+func (gs *S3Storage) listSharded(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	prefixes := shardedListPrefixes(prefix)
+	if prefixes == nil {
+		// The namespace root itself: recursive listings see all shards at
+		// once, direct children are the issuers inside every shard.
+		if recursive {
+			return gs.listObjects(ctx, shardedNamespace, true)
+		}
+		for _, s := range allShards() {
+			prefixes = append(prefixes, shardedNamespace+"/"+s)
+		}
+	}
+	if len(prefixes) == 1 {
+		return gs.listObjects(ctx, prefixes[0], recursive)
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		seen     = make(map[string]bool)
+		sem      = make(chan struct{}, shardListConcurrency)
+	)
+	for _, p := range prefixes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(p string) {
+			defer func() { <-sem; wg.Done() }()
+			keys, err := gs.listObjects(ctx, p, recursive)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for _, k := range keys {
+				seen[k] = true
+			}
+		}(p)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,94 @@
+package cmgs3
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestShardKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		sharded bool
+	}{
+		{"certificates/acme-v02/example.com/example.com.crt", true},
+		{"certificates/acme-v02/example.com", true},
+		{"certificates/acme-v02", false},
+		{"acme/acme-v02/users/me/me.json", false},
+		{"ocsp/example.com-abc", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			sk := shardKey(tt.key)
+			if (sk != tt.key) != tt.sharded {
+				t.Errorf("shardKey(%s) = %s, sharded expected: %v", tt.key, sk, tt.sharded)
+			}
+			if back := unshardKey(sk); back != tt.key {
+				t.Errorf("unshardKey(%s) = %s, expected %s", sk, back, tt.key)
+			}
+		})
+	}
+}
+
+func TestShardKeyKeepsSiteTogether(t *testing.T) {
+	crt := shardKey("certificates/acme-v02/example.com/example.com.crt")
+	key := shardKey("certificates/acme-v02/example.com/example.com.key")
+	if crt[:len("certificates/xx")] != key[:len("certificates/xx")] {
+		t.Errorf("files of one site landed in different shards: %s, %s", crt, key)
+	}
+}
+
+func TestAllShards(t *testing.T) {
+	shards := allShards()
+	if len(shards) != 256 || shards[0] != "00" || shards[255] != "ff" {
+		t.Errorf("unexpected shards: %d, first %s, last %s", len(shards), shards[0], shards[len(shards)-1])
+	}
+}
+
+func TestS3Storage_ShardedList(t *testing.T) {
+	opts := testOpts(false)
+	opts.ObjPrefix = testPrefix + "-sharded"
+	opts.ShardCertificates = true
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	testKeys := []string{
+		"certificates/acme-v02/a.example.com/a.example.com.crt",
+		"certificates/acme-v02/b.example.com/b.example.com.crt",
+		"certificates/zerossl/c.example.com/c.example.com.crt",
+		"acme/acme-v02/users/me/me.json",
+	}
+	for _, key := range testKeys {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store() failed for key %s: %v", key, err)
+		}
+	}
+
+	tests := []struct {
+		prefix    string
+		recursive bool
+		want      []string
+	}{
+		{"certificates", false, []string{"certificates/acme-v02", "certificates/zerossl"}},
+		{"certificates/acme-v02", false, []string{"certificates/acme-v02/a.example.com", "certificates/acme-v02/b.example.com"}},
+		{"certificates/acme-v02/a.example.com", true, []string{"certificates/acme-v02/a.example.com/a.example.com.crt"}},
+		{"certificates", true, testKeys[:3]},
+	}
+	for _, tt := range tests {
+		keys, err := storage.List(ctx, tt.prefix, tt.recursive)
+		if err != nil {
+			t.Fatalf("List(%s) failed: %v", tt.prefix, err)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, tt.want) {
+			t.Errorf("List(%s, %v) = %v, expected %v", tt.prefix, tt.recursive, keys, tt.want)
+		}
+	}
+
+	buf, err := storage.Load(ctx, testKeys[0])
+	if err != nil || string(buf) != testKeys[0] {
+		t.Errorf("Load() of sharded key failed: %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package cmgs3
+
+import "context"
+
+// ready connects the storage if S3Opts.LazyInit deferred it, once
+// connecting succeeds.
+func (gs *S3Storage) ready(ctx context.Context) error {
+	if gs.connected.Load() {
+		return nil
+	}
+	gs.connectMu.Lock()
+	defer gs.connectMu.Unlock()
+	if gs.connected.Load() {
+		return nil
+	}
+	if err := gs.connect(ctx); err != nil {
+		return err
+	}
+	gs.connected.Store(true)
+	return nil
+}
+
+// Ping connects the storage if S3Opts.LazyInit deferred it, and checks
+// that the bucket answers, with a stat of a key that does not exist.
+func (gs *S3Storage) Ping(ctx context.Context) error {
+	if err := gs.ready(ctx); err != nil {
+		return err
+	}
+	_, err := gs.existsErr(ctx, SelfTestPrefix+"/health")
+	return err
+}
@@ -0,0 +1,34 @@
+package cmgs3
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLazyInit(t *testing.T) {
+	opts := testOpts(false)
+	opts.Endpoint = "127.0.0.1:1"
+	opts.MaxRetries = 1
+	opts.LazyInit = true
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() with LazyInit failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := storage.Ping(ctx); err == nil {
+		t.Error("Ping() of an unreachable endpoint succeeded")
+	}
+	if _, err := storage.Load(ctx, "test/lazy"); err == nil {
+		t.Error("Load() of an unreachable endpoint succeeded")
+	}
+	if storage.connected.Load() {
+		t.Error("storage connected although the bucket was not reached")
+	}
+
+	opts.LazyInit = false
+	if _, err := NewS3Storage(opts); err == nil {
+		t.Error("NewS3Storage() of an unreachable endpoint succeeded")
+	}
+}
@@ -0,0 +1,345 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serviceAccountDir  = "/var/run/secrets/kubernetes.io/serviceaccount/"
+	leaseTimeFormat    = "2006-01-02T15:04:05.000000Z07:00"
+	leaseKeyAnnotation = "cmgs3.key"
+
+	// leaseUnlockAttempts bounds the deletes of a lease whose renewal
+	// keeps changing its resourceVersion.
+	leaseUnlockAttempts = 3
+)
+
+// LeaseOpts configures a LeaseLocker. The zero value works inside a pod,
+// using its service account, which needs get, create, update and delete
+// on leases in the namespace.
+type LeaseOpts struct {
+	// APIServer is the base URL of the Kubernetes API, from
+	// KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT by default.
+	APIServer string
+	// Namespace holds the leases, the namespace of the pod by default.
+	Namespace string
+	// Token is the bearer token, the service account token by default. It
+	// is re-read from the service account when empty, as it is rotated.
+	Token string
+	// Identity is the holder identity, the hostname and a random suffix by
+	// default. It must be unique per process.
+	Identity string
+	// LeaseDuration is how long a lease is valid without renewal, 15s by
+	// default. Leases are renewed while they are held.
+	LeaseDuration time.Duration
+	// Client defaults to a client trusting the service account CA.
+	Client *http.Client
+	// Logger receives the log lines of the locker, such as failed
+	// renewals, e.g. the S3Opts.Logger of the storage. The standard logger
+	// by default.
+	Logger *slog.Logger
+}
+
+// LeaseLocker holds locks as coordination.k8s.io/v1 Lease objects.
+type LeaseLocker struct {
+	opts LeaseOpts
+	mu   sync.Mutex
+	held map[string]bool
+	stop chan struct{}
+	once sync.Once
+}
+
+type lease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string            `json:"name"`
+		ResourceVersion string            `json:"resourceVersion,omitempty"`
+		Annotations     map[string]string `json:"annotations,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+	} `json:"spec"`
+}
+
+// expired reports whether the holder of l stopped renewing it.
+func (l *lease) expired(now time.Time) bool {
+	if l.Spec.HolderIdentity == "" {
+		return true
+	}
+	renewed, err := time.Parse(leaseTimeFormat, l.Spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// NewLeaseLocker returns a LeaseLocker, filling in opts from the pod
+// environment. Close stops renewing the held leases.
+func NewLeaseLocker(opts LeaseOpts) (*LeaseLocker, error) {
+	if opts.APIServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("not running in kubernetes, the api server is required")
+		}
+		opts.APIServer = "https://" + net.JoinHostPort(host, port)
+	}
+	opts.APIServer = strings.TrimSuffix(opts.APIServer, "/")
+	if opts.Namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "namespace")
+		if err != nil {
+			return nil, fmt.Errorf("reading namespace: %w", err)
+		}
+		opts.Namespace = strings.TrimSpace(string(ns))
+	}
+	if opts.Identity == "" {
+		host, _ := os.Hostname()
+		opts.Identity = host + "-" + newOwnerID()
+	}
+	if opts.LeaseDuration == 0 {
+		opts.LeaseDuration = 15 * time.Second
+	}
+	if opts.LeaseDuration < time.Second {
+		return nil, errors.New("lease duration must be at least a second")
+	}
+	if opts.Client == nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if ca, err := os.ReadFile(serviceAccountDir + "ca.crt"); err == nil {
+			pool.AppendCertsFromPEM(ca)
+		}
+		opts.Client = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+			Timeout:   30 * time.Second,
+		}
+	}
+	l := &LeaseLocker{opts: opts, held: make(map[string]bool), stop: make(chan struct{})}
+	go l.renew()
+	return l, nil
+}
+
+// leaseName maps key to a valid object name.
+func leaseName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "cmgs3-" + hex.EncodeToString(sum[:12])
+}
+
+func (l *LeaseLocker) path(name string) string {
+	return "/apis/coordination.k8s.io/v1/namespaces/" + l.opts.Namespace + "/leases/" + name
+}
+
+func (l *LeaseLocker) token() string {
+	if l.opts.Token != "" {
+		return l.opts.Token
+	}
+	token, _ := os.ReadFile(serviceAccountDir + "token")
+	return strings.TrimSpace(string(token))
+}
+
+// do sends the request and returns the status code, decoding successful
+// responses into out.
+func (l *LeaseLocker) do(ctx context.Context, method, path string, in, out any) (int, error) {
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, l.opts.APIServer+path, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if token := l.token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := l.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusConflict:
+		return resp.StatusCode, nil
+	case resp.StatusCode >= 300:
+		return resp.StatusCode, fmt.Errorf("kubernetes %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(buf))
+	case out != nil:
+		return resp.StatusCode, json.Unmarshal(buf, out)
+	}
+	return resp.StatusCode, nil
+}
+
+// tryLock takes the lease of key if it is free or expired. Conflicting
+// writes of other holders are reported as not acquired.
+func (l *LeaseLocker) tryLock(ctx context.Context, key string) (bool, error) {
+	name := leaseName(key)
+	var cur lease
+	code, err := l.do(ctx, http.MethodGet, l.path(name), nil, &cur)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if code == http.StatusNotFound {
+		cur = lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		cur.Metadata.Name = name
+		cur.Metadata.Annotations = map[string]string{leaseKeyAnnotation: key}
+	} else if cur.Spec.HolderIdentity != l.opts.Identity && !cur.expired(now) {
+		return false, nil
+	}
+	cur.Spec.HolderIdentity = l.opts.Identity
+	cur.Spec.LeaseDurationSeconds = int(l.opts.LeaseDuration / time.Second)
+	cur.Spec.AcquireTime = now.UTC().Format(leaseTimeFormat)
+	cur.Spec.RenewTime = cur.Spec.AcquireTime
+
+	if code == http.StatusNotFound {
+		code, err = l.do(ctx, http.MethodPost, "/apis/coordination.k8s.io/v1/namespaces/"+l.opts.Namespace+"/leases", &cur, nil)
+	} else {
+		// The resourceVersion makes the update fail if another holder
+		// took the lease meanwhile.
+		code, err = l.do(ctx, http.MethodPut, l.path(name), &cur, nil)
+	}
+	if err != nil || code == http.StatusConflict || code == http.StatusNotFound {
+		return false, err
+	}
+	return true, nil
+}
+
+// Lock takes the lease of key, polling every LockPollInterval while
+// another identity holds it.
+func (l *LeaseLocker) Lock(ctx context.Context, key string) error {
+	for {
+		ok, err := l.tryLock(ctx, key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			l.mu.Lock()
+			l.held[key] = true
+			l.mu.Unlock()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("acquiring lock failed: %w", ctx.Err())
+		case <-time.After(LockPollInterval):
+		}
+	}
+}
+
+// Unlock deletes the lease of key, failing if this identity does not hold
+// it. A renewal between reading and deleting the lease is retried.
+func (l *LeaseLocker) Unlock(ctx context.Context, key string) error {
+	l.mu.Lock()
+	delete(l.held, key)
+	l.mu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		var cur lease
+		code, err := l.do(ctx, http.MethodGet, l.path(leaseName(key)), nil, &cur)
+		if err != nil {
+			return err
+		}
+		if code == http.StatusNotFound || cur.Spec.HolderIdentity != l.opts.Identity {
+			return fmt.Errorf("lock %s not held", key)
+		}
+		precond := map[string]any{
+			"preconditions": map[string]string{"resourceVersion": cur.Metadata.ResourceVersion},
+		}
+		code, err = l.do(ctx, http.MethodDelete, l.path(leaseName(key)), precond, nil)
+		if err != nil {
+			return err
+		}
+		if code != http.StatusConflict {
+			return nil
+		}
+		if attempt == leaseUnlockAttempts {
+			return fmt.Errorf("lock %s changed while unlocking", key)
+		}
+	}
+}
+
+// renew extends the held leases every third of LeaseDuration.
+func (l *LeaseLocker) renew() {
+	tick := time.NewTicker(l.opts.LeaseDuration / 3)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-tick.C:
+		}
+		l.mu.Lock()
+		keys := make([]string, 0, len(l.held))
+		for key := range l.held {
+			keys = append(keys, key)
+		}
+		l.mu.Unlock()
+		for _, key := range keys {
+			ctx, cancel := context.WithTimeout(context.Background(), l.opts.LeaseDuration/3)
+			if err := l.renewLease(ctx, key); err != nil && l.holds(key) {
+				loggerf(l.opts.Logger, slog.LevelWarn, "Renewing lease of %s failed: %v", key, err)
+			}
+			cancel()
+		}
+	}
+}
+
+// holds reports whether key is locked, rather than unlocked while its
+// lease was renewed.
+func (l *LeaseLocker) holds(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.held[key]
+}
+
+func (l *LeaseLocker) renewLease(ctx context.Context, key string) error {
+	var cur lease
+	code, err := l.do(ctx, http.MethodGet, l.path(leaseName(key)), nil, &cur)
+	if err != nil {
+		return err
+	}
+	if code == http.StatusNotFound || cur.Spec.HolderIdentity != l.opts.Identity {
+		return errors.New("lease lost")
+	}
+	cur.Spec.RenewTime = time.Now().UTC().Format(leaseTimeFormat)
+	code, err = l.do(ctx, http.MethodPut, l.path(leaseName(key)), &cur, nil)
+	if err == nil && code != http.StatusOK {
+		err = errors.New("lease lost")
+	}
+	return err
+}
+
+// Close stops renewing the held leases, which expire after LeaseDuration.
+func (l *LeaseLocker) Close() error {
+	l.once.Do(func() { close(l.stop) })
+	return nil
+}
@@ -0,0 +1,179 @@
+package cmgs3
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newFakeAPIServer serves Lease objects with resourceVersion checks like
+// the Kubernetes API server.
+func newFakeAPIServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(fakeAPIServer())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func fakeAPIServer() http.Handler {
+	var (
+		mu      sync.Mutex
+		leases  = make(map[string]*lease)
+		version int
+	)
+	const base = "/apis/coordination.k8s.io/v1/namespaces/test/leases"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, base), "/")
+		var in lease
+		var precond struct {
+			Preconditions struct{ ResourceVersion string }
+		}
+		if r.Method == http.MethodDelete {
+			json.NewDecoder(r.Body).Decode(&precond)
+		} else if r.Method != http.MethodGet {
+			json.NewDecoder(r.Body).Decode(&in)
+		}
+		cur := leases[name]
+		switch r.Method {
+		case http.MethodGet:
+			if cur == nil {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(cur)
+			return
+		case http.MethodPost:
+			name = in.Metadata.Name
+			if leases[name] != nil {
+				http.Error(w, "exists", http.StatusConflict)
+				return
+			}
+		case http.MethodPut:
+			if cur == nil {
+				http.NotFound(w, r)
+				return
+			}
+			if in.Metadata.ResourceVersion != cur.Metadata.ResourceVersion {
+				http.Error(w, "conflict", http.StatusConflict)
+				return
+			}
+		case http.MethodDelete:
+			if cur == nil {
+				http.NotFound(w, r)
+				return
+			}
+			if precond.Preconditions.ResourceVersion != cur.Metadata.ResourceVersion {
+				http.Error(w, "conflict", http.StatusConflict)
+				return
+			}
+			delete(leases, name)
+			w.Write([]byte("{}"))
+			return
+		}
+		version++
+		in.Metadata.ResourceVersion = strconv.Itoa(version)
+		leases[name] = &in
+		json.NewEncoder(w).Encode(&in)
+	})
+}
+
+func TestLeaseLocker(t *testing.T) {
+	srv := newFakeAPIServer(t)
+	ctx := context.Background()
+	newLocker := func(id string) *LeaseLocker {
+		l, err := NewLeaseLocker(LeaseOpts{APIServer: srv.URL, Namespace: "test", Token: "token", Identity: id, LeaseDuration: time.Second})
+		if err != nil {
+			t.Fatalf("NewLeaseLocker() failed: %v", err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return l
+	}
+	a, b := newLocker("a"), newLocker("b")
+
+	if err := a.Lock(ctx, "test/lease"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	lctx, cancel := context.WithTimeout(ctx, 1500*time.Millisecond)
+	defer cancel()
+	if err := b.Lock(lctx, "test/lease"); err == nil {
+		t.Fatal("Lock() of a held lease succeeded, renewal did not keep it")
+	}
+	if err := b.Unlock(ctx, "test/lease"); err == nil {
+		t.Error("Unlock() of a lease held by another identity succeeded")
+	}
+	if err := a.Unlock(ctx, "test/lease"); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+	if err := b.Lock(ctx, "test/lease"); err != nil {
+		t.Fatalf("Lock() after Unlock() failed: %v", err)
+	}
+
+	// A closed locker stops renewing, so its leases expire.
+	b.Close()
+	lctx, cancel = context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if err := a.Lock(lctx, "test/lease"); err != nil {
+		t.Errorf("Lock() of an expired lease failed: %v", err)
+	}
+}
+
+func TestLeaseLocker_UnlockWhileRenewing(t *testing.T) {
+	api := fakeAPIServer()
+	var (
+		l     *LeaseLocker
+		renew sync.Once
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			// Renewed between the read and the delete of Unlock.
+			renew.Do(func() {
+				if err := l.renewLease(r.Context(), "test/lease"); err != nil {
+					t.Errorf("renewLease() failed: %v", err)
+				}
+			})
+		}
+		api.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	l, err := NewLeaseLocker(LeaseOpts{APIServer: srv.URL, Namespace: "test", Token: "token", Identity: "a", LeaseDuration: time.Minute})
+	if err != nil {
+		t.Fatalf("NewLeaseLocker() failed: %v", err)
+	}
+	defer l.Close()
+	ctx := context.Background()
+
+	if err := l.Lock(ctx, "test/lease"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if err := l.Unlock(ctx, "test/lease"); err != nil {
+		t.Errorf("Unlock() during a renewal failed: %v", err)
+	}
+}
+
+func TestNewLeaseLocker_OutsideCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if _, err := NewLeaseLocker(LeaseOpts{}); err == nil {
+		t.Error("NewLeaseLocker() outside a cluster succeeded")
+	}
+}
+
+func TestLeaseName(t *testing.T) {
+	n := leaseName("certificates/acme/Example.COM/example.com.crt")
+	if len(n) > 63 || strings.ToLower(n) != n || strings.ContainsAny(n, "/.") {
+		t.Errorf("leaseName() = %q is not a valid object name", n)
+	}
+	if leaseName("a") == leaseName("b") {
+		t.Error("leaseName() collides")
+	}
+}
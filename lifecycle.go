@@ -0,0 +1,82 @@
+package cmgs3
+
+import (
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// LifecycleTagKey is the object tag S3Opts.LifecycleTags sets on transient
+// objects, with the value LifecycleLock, LifecycleTemp or LifecycleOCSP.
+const LifecycleTagKey = "cmgs3-lifecycle"
+
+const (
+	// LifecycleLock tags lock files and the tickets of FairLocks.
+	LifecycleLock = "lock"
+	// LifecycleTemp tags the sentinels of SelfTest.
+	LifecycleTemp = "temp"
+	// LifecycleOCSP tags OCSP staples, which certmagic fetches again when
+	// they are missing.
+	LifecycleOCSP = "ocsp"
+)
+
+// ocspExpiryDays is how long OCSP staples are kept after they were last
+// written. Staples are refreshed well within their validity of about a
+// week, so older ones are of no use.
+const ocspExpiryDays = 7
+
+// transientTags returns the object tags of a transient object of kind.
+func (gs *S3Storage) transientTags(kind string) map[string]string {
+	if !gs.lifecycleTags {
+		return nil
+	}
+	return map[string]string{LifecycleTagKey: kind}
+}
+
+// setLifecycle sets the tags and expiry of obj, if it is transient.
+func (gs *S3Storage) setLifecycle(obj string, opts *minio.PutObjectOptions) {
+	if !gs.lifecycleTags {
+		return
+	}
+	switch {
+	case strings.HasPrefix(obj, gs.objName(SelfTestPrefix)+"/"):
+		opts.UserTags = gs.transientTags(LifecycleTemp)
+	case strings.HasPrefix(obj, gs.objName("ocsp")+"/"):
+		// Some providers delete objects by their Expires header as well.
+		opts.UserTags = gs.transientTags(LifecycleOCSP)
+		opts.Expires = time.Now().Add(ocspExpiryDays * 24 * time.Hour)
+	}
+}
+
+// LifecycleConfig returns bucket lifecycle rules that remove transient
+// objects a day after they were last written, which is long after any
+// live lock was refreshed, OCSP staples after a week, and abort multipart uploads left incomplete,
+// whose parts cannot be tagged. Apply them with SetBucketLifecycle or
+// merge them into the bucket's rules; they only take effect on objects
+// written with LifecycleTags.
+func LifecycleConfig() *lifecycle.Configuration {
+	config := lifecycle.NewConfiguration()
+	for _, r := range []struct {
+		kind string
+		days lifecycle.ExpirationDays
+	}{
+		{LifecycleLock, 1},
+		{LifecycleTemp, 1},
+		{LifecycleOCSP, ocspExpiryDays},
+	} {
+		config.Rules = append(config.Rules, lifecycle.Rule{
+			ID:         "cmgs3-expire-" + r.kind,
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Tag: lifecycle.Tag{Key: LifecycleTagKey, Value: r.kind}},
+			Expiration: lifecycle.Expiration{Days: r.days},
+		})
+	}
+	config.Rules = append(config.Rules, lifecycle.Rule{
+		ID:                             "cmgs3-abort-uploads",
+		Status:                         "Enabled",
+		AbortIncompleteMultipartUpload: lifecycle.AbortIncompleteMultipartUpload{DaysAfterInitiation: 1},
+	})
+	return config
+}
@@ -0,0 +1,64 @@
+package cmgs3
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestLifecycleConfig(t *testing.T) {
+	buf, err := xml.Marshal(LifecycleConfig())
+	if err != nil {
+		t.Fatalf("marshalling the lifecycle configuration failed: %v", err)
+	}
+	for _, want := range []string{
+		"<Key>" + LifecycleTagKey + "</Key><Value>lock</Value>",
+		"<Key>" + LifecycleTagKey + "</Key><Value>temp</Value>",
+		"<Expiration><Days>7</Days></Expiration><ID>cmgs3-expire-ocsp</ID>",
+		"<DaysAfterInitiation>1</DaysAfterInitiation>",
+	} {
+		if !strings.Contains(string(buf), want) {
+			t.Errorf("lifecycle configuration lacks %s: %s", want, buf)
+		}
+	}
+
+	if tags := (&S3Storage{}).transientTags(LifecycleLock); tags != nil {
+		t.Errorf("transientTags() without LifecycleTags = %v", tags)
+	}
+}
+
+func TestS3Storage_SetLifecycle(t *testing.T) {
+	gs := &S3Storage{prefix: "certmagic", lifecycleTags: true}
+	var opts minio.PutObjectOptions
+	gs.setLifecycle(gs.objName("ocsp/example.com-abc"), &opts)
+	if opts.UserTags[LifecycleTagKey] != LifecycleOCSP || opts.Expires.IsZero() {
+		t.Errorf("OCSP staple options = %v, %v", opts.UserTags, opts.Expires)
+	}
+	opts = minio.PutObjectOptions{}
+	gs.setLifecycle(gs.objName("certificates/ca/example.com/example.com.crt"), &opts)
+	if opts.UserTags != nil || !opts.Expires.IsZero() {
+		t.Errorf("certificate options = %v, %v", opts.UserTags, opts.Expires)
+	}
+}
+
+func TestS3Storage_LifecycleTags(t *testing.T) {
+	opts := testOpts(false)
+	opts.LifecycleTags = true
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if err := storage.Lock(ctx, "test/lifecycle"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	defer storage.Unlock(ctx, "test/lifecycle")
+	tags, err := storage.s3client.GetObjectTagging(ctx, storage.bucket, storage.objLockName("test/lifecycle"), minio.GetObjectTaggingOptions{})
+	if err != nil {
+		t.Fatalf("GetObjectTagging() failed: %v", err)
+	}
+	if got := tags.ToMap()[LifecycleTagKey]; got != LifecycleLock {
+		t.Errorf("lock file tag = %q, expected %q", got, LifecycleLock)
+	}
+}
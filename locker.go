@@ -0,0 +1,236 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Locker provides the locks of S3Storage.Lock and Unlock, see
+// S3Opts.Locker. Calls are serialized per key within the process, and
+// Lock is given a context that expires after LockTimeout.
+type Locker interface {
+	Lock(ctx context.Context, key string) error
+	Unlock(ctx context.Context, key string) error
+}
+
+// ConsulOpts configures a ConsulLocker.
+type ConsulOpts struct {
+	// Address is the base URL of the Consul HTTP API, e.g.
+	// "http://127.0.0.1:8500".
+	Address string
+	// Token is the ACL token, if ACLs are enabled.
+	Token string
+	// Prefix is prepended to the lock keys, "certmagic/locks/" by default.
+	Prefix string
+	// TTL is the session TTL, 15s by default. Locks of a crashed process
+	// are released once it runs out.
+	TTL time.Duration
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+	// Logger receives the log lines of the locker, such as failed
+	// renewals, e.g. the S3Opts.Logger of the storage. The standard logger
+	// by default.
+	Logger *slog.Logger
+}
+
+// ConsulLocker holds locks as Consul KV keys acquired by a session, which
+// is renewed while the locker is open. A session Consul invalidated, e.g.
+// after missing renewals, is replaced by a new one.
+type ConsulLocker struct {
+	opts ConsulOpts
+	stop chan struct{}
+	once sync.Once
+
+	mu      sync.Mutex
+	session string
+}
+
+// NewConsulLocker creates a session with the Consul agent at opts.Address.
+// Close destroys it, releasing all locks.
+func NewConsulLocker(ctx context.Context, opts ConsulOpts) (*ConsulLocker, error) {
+	if opts.Address == "" {
+		return nil, errors.New("consul address is required")
+	}
+	opts.Address = strings.TrimSuffix(opts.Address, "/")
+	if opts.Prefix == "" {
+		opts.Prefix = "certmagic/locks/"
+	}
+	if opts.TTL == 0 {
+		opts.TTL = 15 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	l := &ConsulLocker{opts: opts, stop: make(chan struct{})}
+	if err := l.newSession(ctx, ""); err != nil {
+		return nil, err
+	}
+	go l.renew()
+	return l, nil
+}
+
+func (l *ConsulLocker) currentSession() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.session
+}
+
+// newSession creates a session replacing old, unless another call did so
+// meanwhile.
+func (l *ConsulLocker) newSession(ctx context.Context, old string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.session != old {
+		return nil
+	}
+	host, _ := os.Hostname()
+	body, _ := json.Marshal(map[string]string{
+		"Name":     "certmagic " + host,
+		"TTL":      l.opts.TTL.String(),
+		"Behavior": "delete",
+	})
+	var created struct{ ID string }
+	if err := l.do(ctx, http.MethodPut, "/v1/session/create", body, &created); err != nil {
+		return fmt.Errorf("creating consul session: %w", err)
+	}
+	l.session = created.ID
+	return nil
+}
+
+// consulError is a response of Consul other than 200 OK.
+type consulError struct {
+	method, path string
+	status       string
+	code         int
+	body         []byte
+}
+
+func (e *consulError) Error() string {
+	return fmt.Sprintf("consul %s %s: %s: %s", e.method, e.path, e.status, e.body)
+}
+
+// isConsulStatus reports whether err is a response of Consul with code.
+func isConsulStatus(err error, code int) bool {
+	var ce *consulError
+	return errors.As(err, &ce) && ce.code == code
+}
+
+// isInvalidSession reports whether err is Consul's answer to acquiring
+// with a session it does not know (anymore).
+func isInvalidSession(err error) bool {
+	var ce *consulError
+	return errors.As(err, &ce) && bytes.Contains(ce.body, []byte("invalid session"))
+}
+
+func (l *ConsulLocker) do(ctx context.Context, method, path string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, l.opts.Address+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if l.opts.Token != "" {
+		req.Header.Set("X-Consul-Token", l.opts.Token)
+	}
+	resp, err := l.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &consulError{method, path, resp.Status, resp.StatusCode, bytes.TrimSpace(buf)}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(buf, out)
+}
+
+func (l *ConsulLocker) renew() {
+	tick := time.NewTicker(l.opts.TTL / 2)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-tick.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), l.opts.TTL/2)
+		session := l.currentSession()
+		err := l.do(ctx, http.MethodPut, "/v1/session/renew/"+session, nil, nil)
+		if isConsulStatus(err, http.StatusNotFound) {
+			// Expired, its locks are gone; later ones need a new session.
+			loggerf(l.opts.Logger, slog.LevelInfo, "Consul session %s expired, creating a new one", session)
+			err = l.newSession(ctx, session)
+		}
+		if err != nil {
+			loggerf(l.opts.Logger, slog.LevelWarn, "Renewing consul session failed: %v", err)
+		}
+		cancel()
+	}
+}
+
+func (l *ConsulLocker) kvPath(key, op, session string) string {
+	return "/v1/kv/" + l.opts.Prefix + key + "?" + op + "=" + url.QueryEscape(session)
+}
+
+// Lock acquires key for the session, polling every LockPollInterval while
+// another session holds it.
+func (l *ConsulLocker) Lock(ctx context.Context, key string) error {
+	host, _ := os.Hostname()
+	for {
+		var acquired bool
+		session := l.currentSession()
+		err := l.do(ctx, http.MethodPut, l.kvPath(key, "acquire", session), []byte(host), &acquired)
+		if isInvalidSession(err) {
+			if err := l.newSession(ctx, session); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if acquired {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("acquiring lock failed: %w", ctx.Err())
+		case <-time.After(LockPollInterval):
+		}
+	}
+}
+
+// Unlock releases key, failing if the session does not hold it.
+func (l *ConsulLocker) Unlock(ctx context.Context, key string) error {
+	var released bool
+	if err := l.do(ctx, http.MethodPut, l.kvPath(key, "release", l.currentSession()), nil, &released); err != nil {
+		return err
+	}
+	if !released {
+		return fmt.Errorf("lock %s not held", key)
+	}
+	return nil
+}
+
+// Close destroys the session, releasing its locks.
+func (l *ConsulLocker) Close() error {
+	l.once.Do(func() { close(l.stop) })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return l.do(ctx, http.MethodPut, "/v1/session/destroy/"+l.currentSession(), nil, nil)
+}
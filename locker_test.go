@@ -0,0 +1,209 @@
+package cmgs3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// fakeConsul implements the session and KV lock endpoints used by
+// ConsulLocker.
+type fakeConsul struct {
+	mu       sync.Mutex
+	sessions map[string]bool
+	holders  map[string]string
+	created  int
+	renewals int
+}
+
+func newFakeConsul(t *testing.T) *httptest.Server {
+	fc := &fakeConsul{sessions: make(map[string]bool), holders: make(map[string]string)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		switch p := r.URL.Path; {
+		case p == "/v1/session/create":
+			id := fmt.Sprintf("session-%d", fc.created)
+			fc.created++
+			fc.sessions[id] = true
+			json.NewEncoder(w).Encode(map[string]string{"ID": id})
+		case strings.HasPrefix(p, "/v1/session/renew/"):
+			if !fc.sessions[strings.TrimPrefix(p, "/v1/session/renew/")] {
+				http.NotFound(w, r)
+				return
+			}
+			fc.renewals++
+			w.Write([]byte("[]"))
+		case strings.HasPrefix(p, "/v1/session/destroy/"):
+			id := strings.TrimPrefix(p, "/v1/session/destroy/")
+			delete(fc.sessions, id)
+			for k, h := range fc.holders {
+				if h == id {
+					delete(fc.holders, k)
+				}
+			}
+			w.Write([]byte("true"))
+		case strings.HasPrefix(p, "/v1/kv/"):
+			k := strings.TrimPrefix(p, "/v1/kv/")
+			q := r.URL.Query()
+			if id := q.Get("acquire"); id != "" {
+				if !fc.sessions[id] {
+					http.Error(w, fmt.Sprintf("invalid session %q", id), http.StatusInternalServerError)
+					return
+				}
+				ok := fc.holders[k] == "" || fc.holders[k] == id
+				if ok {
+					fc.holders[k] = id
+				}
+				json.NewEncoder(w).Encode(ok)
+			} else if id := q.Get("release"); id != "" {
+				ok := fc.holders[k] == id
+				if ok {
+					delete(fc.holders, k)
+				}
+				json.NewEncoder(w).Encode(ok)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestConsulLocker(t *testing.T) {
+	srv := newFakeConsul(t)
+	ctx := context.Background()
+	a, err := NewConsulLocker(ctx, ConsulOpts{Address: srv.URL})
+	if err != nil {
+		t.Fatalf("NewConsulLocker() failed: %v", err)
+	}
+	b, err := NewConsulLocker(ctx, ConsulOpts{Address: srv.URL})
+	if err != nil {
+		t.Fatalf("NewConsulLocker() failed: %v", err)
+	}
+	defer b.Close()
+
+	if err := a.Lock(ctx, "test/consul"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	lctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := b.Lock(lctx, "test/consul"); err == nil {
+		t.Fatal("Lock() of a held lock succeeded")
+	}
+	if err := b.Unlock(ctx, "test/consul"); err == nil {
+		t.Error("Unlock() of a lock held by another session succeeded")
+	}
+	if err := a.Unlock(ctx, "test/consul"); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+	if err := b.Lock(ctx, "test/consul"); err != nil {
+		t.Fatalf("Lock() after Unlock() failed: %v", err)
+	}
+	b.Unlock(ctx, "test/consul")
+
+	// Closing releases the locks of the session.
+	if err := a.Lock(ctx, "test/consul"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if err := b.Lock(ctx, "test/consul"); err != nil {
+		t.Errorf("Lock() after Close() of the holder failed: %v", err)
+	}
+}
+
+func TestConsulLocker_ExpiredSession(t *testing.T) {
+	srv := newFakeConsul(t)
+	ctx := context.Background()
+	l, err := NewConsulLocker(ctx, ConsulOpts{Address: srv.URL, TTL: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewConsulLocker() failed: %v", err)
+	}
+	defer l.Close()
+	expire := func() string {
+		session := l.currentSession()
+		if err := l.do(ctx, http.MethodPut, "/v1/session/destroy/"+session, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+
+	// Lock replaces a session Consul no longer knows.
+	expired := expire()
+	if err := l.Lock(ctx, "test/consul"); err != nil {
+		t.Fatalf("Lock() with an expired session failed: %v", err)
+	}
+	if l.currentSession() == expired {
+		t.Error("Lock() kept the expired session")
+	}
+
+	// So does the renewal.
+	expired = expire()
+	deadline := time.Now().Add(5 * time.Second)
+	for l.currentSession() == expired {
+		if time.Now().After(deadline) {
+			t.Fatal("renewal did not replace the expired session")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewConsulLocker_Unreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	if _, err := NewConsulLocker(context.Background(), ConsulOpts{Address: srv.URL}); err == nil {
+		t.Error("NewConsulLocker() without a consul agent succeeded")
+	}
+}
+
+type countingLocker struct {
+	mu      sync.Mutex
+	locks   int
+	unlocks int
+}
+
+func (l *countingLocker) Lock(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.locks++
+	return nil
+}
+
+func (l *countingLocker) Unlock(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.unlocks++
+	return nil
+}
+
+func TestS3Storage_Locker(t *testing.T) {
+	locker := &countingLocker{}
+	opts := testOpts(false)
+	opts.Locker = locker
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if err := storage.Lock(ctx, "test/locker"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if _, err := storage.s3client.StatObject(ctx, testBucket, storage.objLockName("test/locker"), minio.StatObjectOptions{}); err == nil {
+		t.Error("Lock() wrote a lock file despite a Locker")
+	}
+	if err := storage.Unlock(ctx, "test/locker"); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+	if locker.locks != 1 || locker.unlocks != 1 {
+		t.Errorf("Locker saw %d locks and %d unlocks, expected one each", locker.locks, locker.unlocks)
+	}
+}
@@ -0,0 +1,44 @@
+package cmgs3
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLockFile(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	lf, err := ParseLockFile([]byte(created.Format(time.RFC3339)))
+	if err != nil || lf.Version != 0 || !lf.Created.Equal(created) || !lf.Expires.Equal(created.Add(LockExpiration)) {
+		t.Errorf("ParseLockFile() of legacy lock = %+v, %v", lf, err)
+	}
+
+	lf, err = ParseLockFile([]byte(`{"version":2,"owner":"a1","created":"2024-05-01T12:00:00Z","expires":"2024-05-01T12:02:00Z","token":"t","future":true}`))
+	if err != nil || lf.Version != 2 || lf.Owner != "a1" || lf.Token != "t" || !lf.Created.Equal(created) {
+		t.Errorf("ParseLockFile() of newer lock = %+v, %v", lf, err)
+	}
+
+	for _, buf := range []string{"", "garbage", `{"owner":"a1"}`, `{"version":1}`} {
+		if _, err := ParseLockFile([]byte(buf)); err == nil {
+			t.Errorf("ParseLockFile(%q) succeeded", buf)
+		}
+	}
+}
+
+func TestNewLockFile(t *testing.T) {
+	gs := &S3Storage{owner: newOwnerID()}
+	buf, err := gs.newLockFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf, err := ParseLockFile(buf)
+	if err != nil {
+		t.Fatalf("ParseLockFile() failed: %v", err)
+	}
+	if lf.Version != LockFileVersion || lf.Owner != gs.owner || lf.Token == "" || lf.Expires.Sub(lf.Created) != LockExpiration {
+		t.Errorf("newLockFile() = %+v", lf)
+	}
+	other, _ := gs.newLockFile()
+	if lf2, _ := ParseLockFile(other); lf2.Token == lf.Token {
+		t.Error("newLockFile() reused a token")
+	}
+}
@@ -0,0 +1,53 @@
+package cmgs3
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// HeldLock is a lock held by this instance.
+type HeldLock struct {
+	Key      string
+	Acquired time.Time
+	Age      time.Duration
+}
+
+// HeldLocks returns the locks currently held by this instance, oldest
+// first. Locks held far longer than certmagic's operations take are
+// likely leaked by a missing Unlock.
+func (gs *S3Storage) HeldLocks() []HeldLock {
+	now := time.Now()
+	gs.localMu.Lock()
+	locks := make([]HeldLock, 0, len(gs.held))
+	for key, t := range gs.held {
+		locks = append(locks, HeldLock{Key: key, Acquired: t, Age: now.Sub(t)})
+	}
+	gs.localMu.Unlock()
+	sort.Slice(locks, func(i, j int) bool {
+		if !locks[i].Acquired.Equal(locks[j].Acquired) {
+			return locks[i].Acquired.Before(locks[j].Acquired)
+		}
+		return locks[i].Key < locks[j].Key
+	})
+	return locks
+}
+
+// WriteLockMetrics writes the held locks in the Prometheus text format: a
+// gauge of their number and one of the age of each.
+func (gs *S3Storage) WriteLockMetrics(w io.Writer) error {
+	locks := gs.HeldLocks()
+	_, err := fmt.Fprintf(w, "# HELP cmgs3_locks_held Locks currently held by this instance.\n"+
+		"# TYPE cmgs3_locks_held gauge\ncmgs3_locks_held %d\n"+
+		"# HELP cmgs3_lock_age_seconds Time since a held lock was acquired.\n"+
+		"# TYPE cmgs3_lock_age_seconds gauge\n", len(locks))
+	for _, l := range locks {
+		if err != nil {
+			break
+		}
+		_, err = fmt.Fprintf(w, "cmgs3_lock_age_seconds{key=%s} %g\n", strconv.Quote(l.Key), l.Age.Seconds())
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package cmgs3
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestS3Storage_HeldLocks(t *testing.T) {
+	storage := setupTestStorage(t, false)
+	ctx := context.Background()
+
+	for _, key := range []string{"test/held-a", "test/held-b"} {
+		if err := storage.Lock(ctx, key); err != nil {
+			t.Fatalf("Lock() failed: %v", err)
+		}
+	}
+	locks := storage.HeldLocks()
+	if len(locks) != 2 || locks[0].Key != "test/held-a" || locks[1].Key != "test/held-b" {
+		t.Fatalf("HeldLocks() = %v, expected both locks oldest first", locks)
+	}
+
+	var sb strings.Builder
+	if err := storage.WriteLockMetrics(&sb); err != nil {
+		t.Fatalf("WriteLockMetrics() failed: %v", err)
+	}
+	for _, want := range []string{"cmgs3_locks_held 2\n", `cmgs3_lock_age_seconds{key="test/held-a"} `} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("WriteLockMetrics() output lacks %q:\n%s", want, sb.String())
+		}
+	}
+
+	storage.Unlock(ctx, "test/held-a")
+	storage.Unlock(ctx, "test/held-b")
+	if locks := storage.HeldLocks(); len(locks) != 0 {
+		t.Errorf("HeldLocks() after Unlock() = %v", locks)
+	}
+}
@@ -0,0 +1,125 @@
+package cmgs3
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redacted replaces secrets in log lines.
+const redacted = "[REDACTED]"
+
+// minSecretLen is the length below which values are not scrubbed, as they
+// would match all over the log.
+const minSecretLen = 8
+
+// secretParams matches the signature parameters of presigned URLs and the
+// credentials of Authorization headers.
+var secretParams = regexp.MustCompile(`(?i)((?:X-Amz-Signature|X-Amz-Credential|X-Amz-Security-Token)=)[^&\s"']+|(Credential=|Signature=)[^,\s"']+`)
+
+// scrubber removes secrets from log lines: the credentials and encryption
+// keys of a storage, in the encodings they are configured in, and those of
+// presigned URLs and request signatures.
+type scrubber struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// add adds the secrets of opts.
+func (s *scrubber) add(opts S3Opts) {
+	s.addSecret([]byte(opts.AccessKeyID))
+	s.addSecret([]byte(opts.SecretAccessKey))
+	s.addSecret([]byte(opts.SessionToken))
+	s.addKey(opts.EncryptionKey)
+	for _, key := range opts.PreviousEncryptionKeys {
+		s.addKey(key)
+	}
+	for _, ne := range opts.NamespaceEncryption {
+		s.addKey(ne.Key)
+	}
+}
+
+// addKey adds an encryption key, raw and in the encodings of key files.
+func (s *scrubber) addKey(key []byte) {
+	if len(key) == 0 {
+		return
+	}
+	s.addSecret(key)
+	s.addSecret([]byte(hex.EncodeToString(key)))
+	s.addSecret([]byte(base64.StdEncoding.EncodeToString(key)))
+	s.addSecret([]byte(base64.RawStdEncoding.EncodeToString(key)))
+	s.addSecret([]byte(base64.URLEncoding.EncodeToString(key)))
+}
+
+func (s *scrubber) addSecret(secret []byte) {
+	if len(secret) < minSecretLen {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, have := range s.secrets {
+		if have == string(secret) {
+			return
+		}
+	}
+	s.secrets = append(s.secrets, string(secret))
+}
+
+// scrub returns msg without secrets.
+func (s *scrubber) scrub(msg string) string {
+	if s != nil {
+		s.mu.RLock()
+		for _, secret := range s.secrets {
+			msg = strings.ReplaceAll(msg, secret, redacted)
+		}
+		s.mu.RUnlock()
+	}
+	return secretParams.ReplaceAllStringFunc(msg, func(m string) string {
+		i := strings.IndexByte(m, '=')
+		return m[:i+1] + redacted
+	})
+}
+
+// logf logs a line of the storage, scrubbed, to S3Opts.Logger or the
+// standard logger.
+func (gs *S3Storage) logf(format string, args ...any) {
+	gs.logAt(slog.LevelInfo, format, args...)
+}
+
+// warnf logs a warning like logf.
+func (gs *S3Storage) warnf(format string, args ...any) {
+	gs.logAt(slog.LevelWarn, format, args...)
+}
+
+func (gs *S3Storage) logAt(level slog.Level, format string, args ...any) {
+	msg := gs.scrub.scrub(fmt.Sprintf(format, args...))
+	if gs.logger != nil {
+		gs.logger.Log(context.Background(), level, msg)
+		return
+	}
+	if level >= slog.LevelWarn {
+		msg = "WARNING: " + msg
+	}
+	log.Print(msg)
+}
+
+// loggerf logs a line of a locker to its logger, or like logf without one.
+func loggerf(logger *slog.Logger, level slog.Level, format string, args ...any) {
+	if logger == nil {
+		logf(format, args...)
+		return
+	}
+	logger.Log(context.Background(), level, (*scrubber)(nil).scrub(fmt.Sprintf(format, args...)))
+}
+
+// logf logs a line without a storage at hand, scrubbing what can be
+// recognized as secret.
+func logf(format string, args ...any) {
+	log.Print((*scrubber)(nil).scrub(fmt.Sprintf(format, args...)))
+}
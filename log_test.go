@@ -0,0 +1,81 @@
+package cmgs3
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestScrubber(t *testing.T) {
+	key := []byte("12345678901234567890123456789012")
+	s := &scrubber{}
+	s.add(S3Opts{AccessKeyID: "AKIAEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG", EncryptionKey: key, TagHeader: "short"})
+
+	for _, msg := range []string{
+		"secret wJalrXUtnFEMI/K7MDENG in a line",
+		"access key AKIAEXAMPLE",
+		"raw key " + string(key),
+		"hex key " + hex.EncodeToString(key),
+		"base64 key " + base64.StdEncoding.EncodeToString(key),
+		`Get "https://s3.example.com/b/k?X-Amz-Credential=AKIA%2F20240101&X-Amz-Signature=abcdef0123": EOF`,
+		"Authorization: AWS4-HMAC-SHA256 Credential=AKIA/20240101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=abcdef0123",
+	} {
+		got := s.scrub(msg)
+		for _, secret := range []string{"wJalrXUtnFEMI", "AKIA", string(key), hex.EncodeToString(key), base64.StdEncoding.EncodeToString(key), "abcdef0123"} {
+			if strings.Contains(got, secret) {
+				t.Errorf("scrub(%q) = %q, still containing %q", msg, got, secret)
+			}
+		}
+		if !strings.Contains(got, redacted) {
+			t.Errorf("scrub(%q) = %q, expected a redaction", msg, got)
+		}
+	}
+	if got := s.scrub("short values stay"); got != "short values stay" {
+		t.Errorf("scrub() of a line without secrets = %q", got)
+	}
+}
+
+func TestS3Storage_LogsScrubbed(t *testing.T) {
+	var buf bytes.Buffer
+	opts := testOpts(true)
+	opts.LazyInit = true
+	opts.SecretAccessKey = "first-secret-access-key"
+	opts.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Encrypted certificate storage active") {
+		t.Errorf("Logger did not receive the storage's lines: %s", buf.String())
+	}
+
+	storage.logf("failed: %v", errors.New("signing with "+opts.SecretAccessKey+" and "+string(opts.EncryptionKey)))
+	opts.SecretAccessKey = "second-secret-access-key"
+	if err := storage.Reload(opts); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	storage.warnf("failed with %s", opts.SecretAccessKey)
+	for _, secret := range []string{"first-secret-access-key", "second-secret-access-key", string(opts.EncryptionKey)} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("log contains the secret %q:\n%s", secret, buf.String())
+		}
+	}
+	if !strings.Contains(buf.String(), "level=WARN") {
+		t.Errorf("warning not logged at level WARN:\n%s", buf.String())
+	}
+
+	// Without a Logger, lines go to the standard logger, scrubbed alike.
+	var std bytes.Buffer
+	log.SetOutput(&std)
+	defer log.SetOutput(log.Writer())
+	storage.logger = nil
+	storage.logf("failed with %s", opts.SecretAccessKey)
+	if strings.Contains(std.String(), opts.SecretAccessKey) || !strings.Contains(std.String(), redacted) {
+		t.Errorf("standard log = %q", std.String())
+	}
+}
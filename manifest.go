@@ -0,0 +1,185 @@
+package cmgs3
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// ErrManifestSignature is returned by VerifyManifest when the stored
+// manifest is not signed by the given key.
+var ErrManifestSignature = errors.New("manifest signature invalid")
+
+// Manifest lists every stored object with its size and ETag at the time it
+// was written.
+type Manifest struct {
+	Created time.Time                `json:"created"`
+	Objects map[string]ManifestEntry `json:"objects"`
+}
+
+type ManifestEntry struct {
+	Size int64  `json:"size"`
+	ETag string `json:"etag"`
+}
+
+// ManifestDiff lists the keys that differ between the signed manifest and
+// the bucket.
+type ManifestDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// OK reports whether the bucket matches the manifest.
+func (d ManifestDiff) OK() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+type signedManifest struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	Signature []byte          `json:"signature"`
+}
+
+// manifestName is kept beside the prefix rather than under it, so List
+// does not report it as a key.
+func (gs *S3Storage) manifestName() string {
+	return gs.prefix + ".manifest.json"
+}
+
+func (gs *S3Storage) buildManifest(ctx context.Context) (*Manifest, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m := &Manifest{Created: time.Now().UTC(), Objects: make(map[string]ManifestEntry)}
+	for obj := range gs.s3client.ListObjects(ctx, gs.bucket, minio.ListObjectsOptions{
+		Prefix:    gs.prefix + "/",
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if strings.HasSuffix(obj.Key, ".lock") {
+			continue
+		}
+		m.Objects[gs.keyName(obj.Key)] = ManifestEntry{Size: obj.Size, ETag: obj.ETag}
+	}
+	if gs.dedupKey == nil {
+		return m, nil
+	}
+	for obj := range gs.s3client.ListObjects(ctx, gs.bucket, minio.ListObjectsOptions{
+		Prefix:    gs.blobName(""),
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		id := strings.TrimPrefix(obj.Key, gs.blobName(""))
+		m.Objects[blobKey(id)] = ManifestEntry{Size: obj.Size, ETag: obj.ETag}
+	}
+	return m, nil
+}
+
+// WriteManifest lists all objects, signs the manifest with key and stores it.
+func (gs *S3Storage) WriteManifest(ctx context.Context, key ed25519.PrivateKey) (*Manifest, error) {
+	ctx = withDefaultPriority(ctx, PriorityBulk)
+	m, err := gs.buildManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := json.Marshal(signedManifest{Manifest: payload, Signature: ed25519.Sign(key, payload)})
+	if err != nil {
+		return nil, err
+	}
+	r := (&CleartextIO{}).ByteReader(buf)
+	_, err = gs.s3client.PutObject(ctx, gs.bucket, gs.manifestName(), r, r.Len(), minio.PutObjectOptions{
+		ContentType:          "application/json",
+		DisableContentSha256: gs.unsignedPayload,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// VerifyManifest checks the stored manifest's signature against key and
+// compares it with the objects currently in the bucket. Writes since the
+// manifest was created show up as differences too, so it is best run right
+// after a scheduled WriteManifest or against a known quiet period.
+func (gs *S3Storage) VerifyManifest(ctx context.Context, key ed25519.PublicKey) (ManifestDiff, error) {
+	ctx = withDefaultPriority(ctx, PriorityBulk)
+	var diff ManifestDiff
+
+	r, err := gs.s3client.GetObject(ctx, gs.bucket, gs.manifestName(), minio.GetObjectOptions{})
+	if err != nil {
+		return diff, err
+	}
+	defer r.Close()
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return diff, fmt.Errorf("reading manifest: %w", err)
+	}
+	var sm signedManifest
+	if err := json.Unmarshal(buf, &sm); err != nil {
+		return diff, fmt.Errorf("parsing manifest: %w", err)
+	}
+	if !ed25519.Verify(key, sm.Manifest, sm.Signature) {
+		return diff, ErrManifestSignature
+	}
+	var signed Manifest
+	if err := json.Unmarshal(sm.Manifest, &signed); err != nil {
+		return diff, fmt.Errorf("parsing manifest: %w", err)
+	}
+
+	current, err := gs.buildManifest(ctx)
+	if err != nil {
+		return diff, err
+	}
+	for k, e := range current.Objects {
+		if se, ok := signed.Objects[k]; !ok {
+			diff.Added = append(diff.Added, k)
+		} else if se != e {
+			diff.Changed = append(diff.Changed, k)
+		}
+	}
+	for k := range signed.Objects {
+		if _, ok := current.Objects[k]; !ok {
+			diff.Removed = append(diff.Removed, k)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff, nil
+}
+
+// writeManifests rewrites the manifest every interval until the storage is
+// closed.
+func (gs *S3Storage) writeManifests(key ed25519.PrivateKey, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-gs.stop:
+			return
+		case <-tick.C:
+		}
+		ctx, cancel := context.WithTimeout(WithBackground(context.Background()), interval)
+		if _, err := gs.WriteManifest(ctx, key); err != nil {
+			gs.logf("Writing integrity manifest failed: %v", err)
+		}
+		cancel()
+	}
+}
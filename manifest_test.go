@@ -0,0 +1,56 @@
+package cmgs3
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestS3Storage_Manifest(t *testing.T) {
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"test/a", "test/b", "test/c"} {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+	m, err := storage.WriteManifest(ctx, priv)
+	if err != nil {
+		t.Fatalf("WriteManifest() failed: %v", err)
+	}
+	if len(m.Objects) != 3 {
+		t.Errorf("manifest has %d objects, expected 3", len(m.Objects))
+	}
+
+	diff, err := storage.VerifyManifest(ctx, pub)
+	if err != nil {
+		t.Fatalf("VerifyManifest() failed: %v", err)
+	}
+	if !diff.OK() {
+		t.Errorf("VerifyManifest() = %+v, expected no differences", diff)
+	}
+
+	storage.Delete(ctx, "test/a")
+	storage.Store(ctx, "test/b", []byte("replaced"))
+	storage.Store(ctx, "test/d", []byte("added"))
+	diff, err = storage.VerifyManifest(ctx, pub)
+	if err != nil {
+		t.Fatalf("VerifyManifest() failed: %v", err)
+	}
+	want := ManifestDiff{Added: []string{"test/d"}, Removed: []string{"test/a"}, Changed: []string{"test/b"}}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("VerifyManifest() = %+v, expected %+v", diff, want)
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := storage.VerifyManifest(ctx, other); !errors.Is(err, ErrManifestSignature) {
+		t.Errorf("VerifyManifest() with wrong key error = %v, expected ErrManifestSignature", err)
+	}
+}
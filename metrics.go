@@ -0,0 +1,141 @@
+package cmgs3
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriteMetrics writes the metrics of the storage in the Prometheus text
+// format: those of WriteLockMetrics, the latency of operations by key
+// namespace, hedged reads, the read cache and the retry budget.
+func (gs *S3Storage) WriteMetrics(w io.Writer) error {
+	if err := gs.WriteLockMetrics(w); err != nil {
+		return err
+	}
+	if err := gs.latency.write(w); err != nil {
+		return err
+	}
+	if gs.hedgeDelay > 0 {
+		if err := gs.writeHedgeMetrics(w); err != nil {
+			return err
+		}
+	}
+	if gs.cache != nil {
+		cs := gs.CacheStats()
+		_, err := fmt.Fprintf(w, "# HELP cmgs3_cache_hits_total Loads answered from the read cache.\n"+
+			"# TYPE cmgs3_cache_hits_total counter\ncmgs3_cache_hits_total %d\n"+
+			"# HELP cmgs3_cache_misses_total Loads not answered from the read cache.\n"+
+			"# TYPE cmgs3_cache_misses_total counter\ncmgs3_cache_misses_total %d\n"+
+			"# HELP cmgs3_cache_evictions_total Values evicted from the read cache for its size limit.\n"+
+			"# TYPE cmgs3_cache_evictions_total counter\ncmgs3_cache_evictions_total %d\n"+
+			"# HELP cmgs3_cache_bytes Size of the values in the read cache.\n"+
+			"# TYPE cmgs3_cache_bytes gauge\ncmgs3_cache_bytes %d\n",
+			cs.Hits, cs.Misses, cs.Evictions, cs.Bytes)
+		if err != nil {
+			return err
+		}
+	}
+	if gs.retries == nil {
+		return nil
+	}
+	rs := gs.RetryStats()
+	_, err := fmt.Fprintf(w, "# HELP cmgs3_retry_budget_tokens Retries currently available.\n"+
+		"# TYPE cmgs3_retry_budget_tokens gauge\ncmgs3_retry_budget_tokens %g\n"+
+		"# HELP cmgs3_retries_total Requests sent again after a failure.\n"+
+		"# TYPE cmgs3_retries_total counter\ncmgs3_retries_total %d\n"+
+		"# HELP cmgs3_retry_budget_exhausted_total Failures not retried for lack of budget.\n"+
+		"# TYPE cmgs3_retry_budget_exhausted_total counter\ncmgs3_retry_budget_exhausted_total %d\n",
+		rs.Tokens, rs.Retries, rs.Exhausted)
+	return err
+}
+
+// observe records an operation on a key of namespace ns that started at
+// start and returned *err.
+func (gs *S3Storage) observe(op, ns string, start time.Time, err *error) {
+	gs.latency.observe(op, ns, time.Since(start))
+	if gs.counters != nil {
+		gs.counters.count(op, *err)
+	}
+}
+
+// latencyBuckets are the upper bounds of the latency histogram buckets, in
+// seconds.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type latencyKey struct{ op, ns string }
+
+type latencyHistogram struct {
+	buckets []uint64 // cumulative, by latencyBuckets
+	count   uint64
+	sum     float64
+}
+
+// latencyMetrics is a histogram of operation latencies by operation and
+// namespace. The zero value is ready to use.
+type latencyMetrics struct {
+	mu    sync.Mutex
+	hists map[latencyKey]*latencyHistogram
+}
+
+func (m *latencyMetrics) observe(op, ns string, d time.Duration) {
+	k := latencyKey{op: op, ns: strings.TrimSuffix(ns, "/")}
+	s := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hists == nil {
+		m.hists = make(map[latencyKey]*latencyHistogram)
+	}
+	h := m.hists[k]
+	if h == nil {
+		h = &latencyHistogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.hists[k] = h
+	}
+	for i, le := range latencyBuckets {
+		if s <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += s
+}
+
+func (m *latencyMetrics) write(w io.Writer) error {
+	m.mu.Lock()
+	keys := make([]latencyKey, 0, len(m.hists))
+	hists := make(map[latencyKey]latencyHistogram, len(m.hists))
+	for k, h := range m.hists {
+		keys = append(keys, k)
+		hists[k] = latencyHistogram{buckets: append([]uint64(nil), h.buckets...), count: h.count, sum: h.sum}
+	}
+	m.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].op != keys[j].op {
+			return keys[i].op < keys[j].op
+		}
+		return keys[i].ns < keys[j].ns
+	})
+
+	_, err := fmt.Fprint(w, "# HELP cmgs3_operation_duration_seconds Latency of storage operations by key namespace.\n"+
+		"# TYPE cmgs3_operation_duration_seconds histogram\n")
+	for _, k := range keys {
+		if err != nil {
+			break
+		}
+		h := hists[k]
+		labels := "op=" + strconv.Quote(k.op) + ",namespace=" + strconv.Quote(k.ns)
+		var sb strings.Builder
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(&sb, "cmgs3_operation_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, le, h.buckets[i])
+		}
+		fmt.Fprintf(&sb, "cmgs3_operation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(&sb, "cmgs3_operation_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(&sb, "cmgs3_operation_duration_seconds_count{%s} %d\n", labels, h.count)
+		_, err = io.WriteString(w, sb.String())
+	}
+	return err
+}
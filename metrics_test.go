@@ -0,0 +1,30 @@
+package cmgs3
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyMetrics(t *testing.T) {
+	var m latencyMetrics
+	m.observe("load", "certificates/", 20*time.Millisecond)
+	m.observe("load", "certificates/", 2*time.Second)
+	m.observe("lock", LockNamespace, time.Millisecond)
+
+	var sb strings.Builder
+	if err := m.write(&sb); err != nil {
+		t.Fatalf("write() failed: %v", err)
+	}
+	for _, want := range []string{
+		"# TYPE cmgs3_operation_duration_seconds histogram\n",
+		`cmgs3_operation_duration_seconds_bucket{op="load",namespace="certificates",le="0.025"} 1` + "\n",
+		`cmgs3_operation_duration_seconds_bucket{op="load",namespace="certificates",le="+Inf"} 2` + "\n",
+		`cmgs3_operation_duration_seconds_sum{op="load",namespace="certificates"} 2.02` + "\n",
+		`cmgs3_operation_duration_seconds_count{op="lock",namespace="locks"} 1` + "\n",
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("write() output lacks %q:\n%s", want, sb.String())
+		}
+	}
+}
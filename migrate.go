@@ -0,0 +1,312 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"slices"
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// LayoutVersion is the version of the layout marker this package writes.
+const LayoutVersion = 1
+
+// ErrLayoutMismatch means the options do not fit the object layout
+// recorded under ObjPrefix, or a migration of it is in progress; see
+// Migrate.
+var ErrLayoutMismatch = errors.New("options do not match the stored layout")
+
+// migrationCheckpoint is the number of objects migrated between updates of
+// the layout marker, from which an interrupted migration resumes.
+var migrationCheckpoint = 100
+
+// layoutFile records the layout of the objects under a prefix. It is
+// stored in cleartext next to the prefix, like the claim file.
+type layoutFile struct {
+	Version    int              `json:"version"`
+	Sharded    bool             `json:"sharded"`
+	Bundled    bool             `json:"bundled"`
+	Applied    []string         `json:"applied,omitempty"`
+	Migration  *layoutMigration `json:"migration,omitempty"`
+	Updated    time.Time        `json:"updated"`
+	etag       string
+	checkpoint int
+}
+
+// layoutMigration is a migration in progress. Cursor is the last object
+// it migrated.
+type layoutMigration struct {
+	Name   string `json:"name"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// migration upgrades the layout of a prefix to that of the options of gs.
+// Migrations run in order, each at most once; run must be resumable from
+// the cursor and mark the layout as upgraded.
+type migration struct {
+	name   string
+	needed func(l *layoutFile, opts S3Opts) bool
+	run    func(ctx context.Context, gs *S3Storage, l *layoutFile) error
+}
+
+var migrations = []migration{
+	{
+		name:   "shard-certificates",
+		needed: func(l *layoutFile, opts S3Opts) bool { return opts.ShardCertificates && !l.Sharded },
+		run:    migrateShards,
+	},
+	{
+		name: "bundle-sites",
+		needed: func(l *layoutFile, opts S3Opts) bool {
+			return opts.Bundle && !slices.Contains(l.Applied, "bundle-sites")
+		},
+		run: migrateBundles,
+	},
+}
+
+func (gs *S3Storage) layoutName() string {
+	return gs.prefix + ".layout.json"
+}
+
+// readLayout returns the layout marker, or fs.ErrNotExist.
+func (gs *S3Storage) readLayout(ctx context.Context) (*layoutFile, error) {
+	obj, err := gs.s3client.GetObject(ctx, gs.bucket, gs.layoutName(), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	buf, err := ioutil.ReadAll(obj)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, fs.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	oi, err := obj.Stat()
+	if err != nil {
+		return nil, err
+	}
+	l := &layoutFile{etag: oi.ETag}
+	if err := json.Unmarshal(buf, l); err != nil {
+		return nil, fmt.Errorf("invalid layout file %s: %w", gs.layoutName(), err)
+	}
+	return l, nil
+}
+
+// writeLayout writes l if the marker is still the one it was read from.
+func (gs *S3Storage) writeLayout(ctx context.Context, l *layoutFile) error {
+	l.Updated = gs.clock.now().UTC()
+	buf, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	opts := minio.PutObjectOptions{ContentType: "application/json", DisableContentSha256: gs.unsignedPayload}
+	if l.etag == "" {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(l.etag)
+	}
+	info, err := gs.s3client.PutObject(ctx, gs.bucket, gs.layoutName(), bytes.NewReader(buf), int64(len(buf)), opts)
+	if err != nil {
+		return err
+	}
+	l.etag = info.ETag
+	return nil
+}
+
+// checkLayout checks that opts fit the layout recorded for the prefix,
+// recording it first if there is none. Prefixes written before layouts
+// were recorded are taken to have the layout of opts.
+func (gs *S3Storage) checkLayout(ctx context.Context, opts S3Opts) error {
+	if gs.migrating {
+		return nil
+	}
+	l, err := gs.readLayout(ctx)
+	if errors.Is(err, fs.ErrNotExist) {
+		l = &layoutFile{Version: LayoutVersion, Sharded: opts.ShardCertificates, Bundled: opts.Bundle}
+		err = gs.writeLayout(ctx, l)
+		if isPreconditionFailed(err) {
+			// Another instance recorded it meanwhile, check against that.
+			return gs.checkLayout(ctx, opts)
+		} else if err != nil {
+			// E.g. read-only credentials; the layout is checked by others.
+			gs.logf("Layout of %s not recorded: %v", gs.prefix, err)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	switch {
+	case l.Version > LayoutVersion:
+		return fmt.Errorf("%w: %s has layout version %d, newer than %d", ErrLayoutMismatch, gs.prefix, l.Version, LayoutVersion)
+	case l.Migration != nil:
+		return fmt.Errorf("%w: migration %s of %s is in progress", ErrLayoutMismatch, l.Migration.Name, gs.prefix)
+	case l.Sharded != opts.ShardCertificates:
+		return fmt.Errorf("%w: certificates of %s are sharded: %t", ErrLayoutMismatch, gs.prefix, l.Sharded)
+	case l.Bundled && !opts.Bundle:
+		return fmt.Errorf("%w: %s holds bundles", ErrLayoutMismatch, gs.prefix)
+	case opts.Bundle && !l.Bundled:
+		// Bundle mode reads the files stored on their own, but once it
+		// wrote bundles only bundle mode reads all files.
+		l.Bundled = true
+		if err := gs.writeLayout(ctx, l); err != nil && !isPreconditionFailed(err) {
+			gs.logf("Layout of %s not recorded: %v", gs.prefix, err)
+		}
+	}
+	return nil
+}
+
+// Migrate upgrades the layout of the objects under opts.ObjPrefix to the
+// one opts select, e.g. after enabling ShardCertificates or Bundle, and
+// records it, so instances with the new options start. Prefixes without a
+// recorded layout are taken to have the default layout. Migrations record
+// their progress and continue from there if Migrate is run again after an
+// interruption. Instances using the prefix must be stopped meanwhile; those
+// starting fail with ErrLayoutMismatch. Layouts cannot be downgraded.
+func Migrate(ctx context.Context, opts S3Opts) error {
+	ctx = withDefaultPriority(ctx, PriorityBulk)
+	opts.LazyInit = true
+	gs, err := NewS3Storage(opts)
+	if err != nil {
+		return err
+	}
+	defer gs.Close()
+	gs.migrating = true
+	if err := gs.ready(ctx); err != nil {
+		return err
+	}
+
+	l, err := gs.readLayout(ctx)
+	if errors.Is(err, fs.ErrNotExist) {
+		l = &layoutFile{Version: LayoutVersion}
+	} else if err != nil {
+		return err
+	}
+	if l.Version > LayoutVersion {
+		return fmt.Errorf("%w: %s has layout version %d, newer than %d", ErrLayoutMismatch, gs.prefix, l.Version, LayoutVersion)
+	}
+	if l.Sharded && !opts.ShardCertificates || l.Bundled && !opts.Bundle {
+		return fmt.Errorf("%w: layouts cannot be downgraded", ErrLayoutMismatch)
+	}
+	l.Version = LayoutVersion
+
+	for _, m := range migrations {
+		if l.Migration != nil && l.Migration.Name != m.name || l.Migration == nil && !m.needed(l, opts) {
+			continue
+		}
+		if l.Migration == nil {
+			l.Migration = &layoutMigration{Name: m.name}
+			if err := gs.writeLayout(ctx, l); err != nil {
+				return fmt.Errorf("starting migration %s: %w", m.name, err)
+			}
+		}
+		gs.logf("Migrating %s: %s", gs.prefix, m.name)
+		if err := m.run(ctx, gs, l); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		l.Applied = append(l.Applied, m.name)
+		l.Migration = nil
+		if err := gs.writeLayout(ctx, l); err != nil {
+			return fmt.Errorf("completing migration %s: %w", m.name, err)
+		}
+	}
+	if l.Migration != nil {
+		return fmt.Errorf("unknown migration %s in progress", l.Migration.Name)
+	}
+	if l.etag == "" {
+		// Nothing to migrate, but the layout is recorded.
+		return gs.writeLayout(ctx, l)
+	}
+	return nil
+}
+
+// progress records that the migration in progress got to obj, writing the
+// marker every migrationCheckpoint objects.
+func (gs *S3Storage) progress(ctx context.Context, l *layoutFile, obj string) error {
+	l.Migration.Cursor = obj
+	l.checkpoint++
+	if l.checkpoint%migrationCheckpoint != 0 {
+		return nil
+	}
+	return gs.writeLayout(ctx, l)
+}
+
+// migrateShards moves the objects of the certificates namespace to their
+// shards, copying them within S3.
+func migrateShards(ctx context.Context, gs *S3Storage, l *layoutFile) error {
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for obj := range gs.s3client.ListObjects(lctx, gs.bucket, minio.ListObjectsOptions{
+		Prefix:     gs.prefix + "/" + shardedNamespace + "/",
+		Recursive:  true,
+		StartAfter: l.Migration.Cursor,
+	}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		key := strings.TrimPrefix(obj.Key, gs.prefix+"/")
+		if strings.HasSuffix(key, ".lock") || unshardKey(key) != key {
+			continue
+		}
+		if _, err := gs.s3client.CopyObject(ctx, minio.CopyDestOptions{
+			Bucket: gs.bucket,
+			Object: gs.objName(key),
+		}, minio.CopySrcOptions{
+			Bucket:    gs.bucket,
+			Object:    obj.Key,
+			MatchETag: obj.ETag,
+		}); err != nil {
+			return err
+		}
+		if err := gs.s3client.RemoveObject(ctx, gs.bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+		if err := gs.progress(ctx, l, obj.Key); err != nil {
+			return err
+		}
+	}
+	l.Sharded = true
+	return nil
+}
+
+// migrateBundles moves the certificates, keys and metadata of sites stored
+// on their own into bundles.
+func migrateBundles(ctx context.Context, gs *S3Storage, l *layoutFile) error {
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for obj := range gs.s3client.ListObjects(lctx, gs.bucket, minio.ListObjectsOptions{
+		Prefix:     gs.prefix + "/" + shardedNamespace + "/",
+		Recursive:  true,
+		StartAfter: l.Migration.Cursor,
+	}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		key := gs.keyName(obj.Key)
+		if _, _, ok := gs.bundled(key); !ok {
+			continue
+		}
+		// Storing a file in its bundle removes the one on its own.
+		value, err := gs.Load(ctx, key)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := gs.Store(ctx, key, value); err != nil {
+			return err
+		}
+		if err := gs.progress(ctx, l, obj.Key); err != nil {
+			return err
+		}
+	}
+	l.Bundled = true
+	return nil
+}
@@ -0,0 +1,75 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestMigrations_Needed(t *testing.T) {
+	opts := S3Opts{ShardCertificates: true, Bundle: true}
+	needed := func(l *layoutFile) []string {
+		var names []string
+		for _, m := range migrations {
+			if m.needed(l, opts) {
+				names = append(names, m.name)
+			}
+		}
+		return names
+	}
+	if got := needed(&layoutFile{}); len(got) != 2 || got[0] != "shard-certificates" {
+		t.Errorf("migrations of the default layout = %v", got)
+	}
+	// Bundle mode adopted without migrating leaves files to bundle.
+	if got := needed(&layoutFile{Sharded: true, Bundled: true}); len(got) != 1 || got[0] != "bundle-sites" {
+		t.Errorf("migrations of a bundled layout = %v", got)
+	}
+	if got := needed(&layoutFile{Sharded: true, Bundled: true, Applied: []string{"bundle-sites"}}); len(got) != 0 {
+		t.Errorf("migrations of a migrated layout = %v", got)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	opts := testOpts(true)
+	opts.ObjPrefix = testPrefix + "-migrate"
+	flat := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		flat.s3client.RemoveObject(ctx, flat.bucket, flat.layoutName(), minio.RemoveObjectOptions{})
+	})
+
+	site := "certificates/acme/example.com/example.com"
+	for _, ext := range []string{".crt", ".key", ".json"} {
+		if err := flat.Store(ctx, site+ext, []byte(ext)); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+	migrationCheckpoint = 1
+	defer func() { migrationCheckpoint = 100 }()
+
+	opts.ShardCertificates = true
+	opts.Bundle = true
+	if err := Migrate(ctx, opts); err != nil {
+		t.Fatalf("Migrate() failed: %v", err)
+	}
+	migrated, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() after Migrate() failed: %v", err)
+	}
+	defer migrated.Delete(ctx, "certificates")
+	for _, ext := range []string{".crt", ".key", ".json"} {
+		if buf, err := migrated.Load(ctx, site+ext); err != nil || string(buf) != ext {
+			t.Errorf("Load(%s) after Migrate() = %q, %v", ext, buf, err)
+		}
+	}
+	old := testOpts(true)
+	old.ObjPrefix = opts.ObjPrefix
+	if _, err := NewS3Storage(old); !errors.Is(err, ErrLayoutMismatch) {
+		t.Errorf("NewS3Storage() with the old layout = %v, expected ErrLayoutMismatch", err)
+	}
+	if err := Migrate(ctx, old); !errors.Is(err, ErrLayoutMismatch) {
+		t.Errorf("Migrate() to an older layout = %v", err)
+	}
+}
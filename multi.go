@@ -0,0 +1,233 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"sort"
+
+	"github.com/sam-lord/certmagic"
+)
+
+// ShardFunc maps a certmagic key to one of n buckets.
+type ShardFunc func(key string, n int) int
+
+// HashShard is the default ShardFunc, distributing keys by their FNV hash.
+func HashShard(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// MultiStorage presents several S3Storage instances as a single
+// certmagic.Storage. Every key, including lock names, is routed to exactly
+// one of them; List merges the results of all.
+type MultiStorage struct {
+	storages []*S3Storage
+	route    func(key string) (*S3Storage, error)
+}
+
+var _ certmagic.Storage = (*MultiStorage)(nil)
+
+// NewMultiBucketStorage spreads keys over buckets, which must all be
+// reachable with opts. opts.Bucket is ignored. A nil shard uses HashShard.
+// The mapping must stay stable for the lifetime of the data.
+func NewMultiBucketStorage(opts S3Opts, buckets []string, shard ShardFunc) (*MultiStorage, error) {
+	if len(buckets) == 0 {
+		return nil, errors.New("at least one bucket is required")
+	}
+	if shard == nil {
+		shard = HashShard
+	}
+
+	ms := &MultiStorage{}
+	for _, b := range buckets {
+		o := opts
+		o.Bucket = b
+		gs, err := NewS3Storage(o)
+		if err != nil {
+			ms.Close()
+			return nil, err
+		}
+		ms.storages = append(ms.storages, gs)
+	}
+	ms.route = func(key string) (*S3Storage, error) {
+		return ms.storages[shard(key, len(ms.storages))], nil
+	}
+	return ms, nil
+}
+
+// RouteFunc maps a certmagic key to the name of a target passed to
+// NewRoutedStorage, e.g. by tenant or site.
+type RouteFunc func(key string) string
+
+// NewRoutedStorage builds one S3Storage per named target, each of which may
+// use its own endpoint, bucket and region, and sends every key to the target
+// picked by route. Keys routed to an unknown target fail with an error.
+func NewRoutedStorage(targets map[string]S3Opts, route RouteFunc) (*MultiStorage, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("at least one target is required")
+	}
+	if route == nil {
+		return nil, errors.New("route function is required")
+	}
+
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ms := &MultiStorage{}
+	byName := make(map[string]*S3Storage, len(targets))
+	for _, name := range names {
+		gs, err := NewS3Storage(targets[name])
+		if err != nil {
+			ms.Close()
+			return nil, fmt.Errorf("target %s: %w", name, err)
+		}
+		byName[name] = gs
+		ms.storages = append(ms.storages, gs)
+	}
+	ms.route = func(key string) (*S3Storage, error) {
+		name := route(key)
+		gs, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("no storage target %q for key %s", name, key)
+		}
+		return gs, nil
+	}
+	return ms, nil
+}
+
+func (ms *MultiStorage) Lock(ctx context.Context, name string) error {
+	gs, err := ms.route(name)
+	if err != nil {
+		return err
+	}
+	return gs.Lock(ctx, name)
+}
+
+func (ms *MultiStorage) Unlock(ctx context.Context, name string) error {
+	gs, err := ms.route(name)
+	if err != nil {
+		return err
+	}
+	return gs.Unlock(ctx, name)
+}
+
+func (ms *MultiStorage) Store(ctx context.Context, key string, value []byte) error {
+	gs, err := ms.route(key)
+	if err != nil {
+		return err
+	}
+	return gs.Store(ctx, key, value)
+}
+
+func (ms *MultiStorage) Load(ctx context.Context, key string) ([]byte, error) {
+	gs, err := ms.route(key)
+	if err != nil {
+		return nil, err
+	}
+	return gs.Load(ctx, key)
+}
+
+// Delete removes key from the storage it is routed to if it is an object
+// there. Otherwise key is a directory, e.g. a site folder whose files were
+// routed to different storages, and is deleted from all of them.
+func (ms *MultiStorage) Delete(ctx context.Context, key string) error {
+	gs, err := ms.route(key)
+	if err != nil {
+		return err
+	}
+	if _, err := gs.Stat(ctx, key); err == nil {
+		return gs.Delete(ctx, key)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	missing := 0
+	var errs []error
+	for _, gs := range ms.storages {
+		if err := gs.Delete(ctx, key); errors.Is(err, fs.ErrNotExist) {
+			missing++
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if missing == len(ms.storages) {
+		return fs.ErrNotExist
+	}
+	return errors.Join(errs...)
+}
+
+func (ms *MultiStorage) Exists(ctx context.Context, key string) bool {
+	gs, err := ms.route(key)
+	if err != nil {
+		return false
+	}
+	return gs.Exists(ctx, key)
+}
+
+func (ms *MultiStorage) ExistsErr(ctx context.Context, key string) (bool, error) {
+	gs, err := ms.route(key)
+	if err != nil {
+		return false, err
+	}
+	return gs.ExistsErr(ctx, key)
+}
+
+func (ms *MultiStorage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	gs, err := ms.route(key)
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+	return gs.Stat(ctx, key)
+}
+
+func (ms *MultiStorage) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, gs := range ms.storages {
+		keys, err := gs.List(ctx, prefix, recursive)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			seen[k] = true
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Close closes all storages.
+func (ms *MultiStorage) Close() error {
+	var errs []error
+	for _, gs := range ms.storages {
+		errs = append(errs, gs.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// NewNamespaceStorage routes keys by namespace, the first path element with
+// its slash, so that e.g. "acme_accounts/" and "certificates/" can live in
+// buckets with different retention and encryption settings. Keys of
+// namespaces without a target, including lock names, go to the target "";
+// without one they fail with an error.
+func NewNamespaceStorage(targets map[string]S3Opts) (*MultiStorage, error) {
+	return NewRoutedStorage(targets, func(key string) string {
+		if ns := namespaceOf(key); ns != "" {
+			if _, ok := targets[ns]; ok {
+				return ns
+			}
+		}
+		return ""
+	})
+}
@@ -0,0 +1,207 @@
+package cmgs3
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sam-lord/certmagic"
+)
+
+const testBucket2 = "certmagic-test-bucket-2"
+
+func TestMultiStorageImplementsCertmagicStorage(t *testing.T) {
+	var _ certmagic.Storage = (*MultiStorage)(nil)
+}
+
+func TestHashShard(t *testing.T) {
+	counts := make([]int, 4)
+	for i := 0; i < 1000; i++ {
+		key := "certificates/acme/" + string(rune('a'+i%26)) + string(rune('a'+i/26)) + ".example.com"
+		n := HashShard(key, len(counts))
+		if n != HashShard(key, len(counts)) {
+			t.Fatalf("HashShard() is not deterministic for %s", key)
+		}
+		counts[n]++
+	}
+	for i, c := range counts {
+		if c == 0 {
+			t.Errorf("HashShard() never picked shard %d", i)
+		}
+	}
+}
+
+func TestNewMultiBucketStorageNoBuckets(t *testing.T) {
+	_, err := NewMultiBucketStorage(testOpts(false), nil, nil)
+	if err == nil {
+		t.Errorf("NewMultiBucketStorage() expected error without buckets")
+	}
+}
+
+func TestMultiStorage_StoreLoadList(t *testing.T) {
+	storage, err := NewMultiBucketStorage(testOpts(false), []string{testBucket, testBucket2}, nil)
+	if err != nil {
+		t.Skipf("Skipping test due to S3 setup error: %v", err)
+	}
+	ctx := context.Background()
+	for _, gs := range storage.storages {
+		testCleanup(ctx, gs)
+	}
+
+	testKeys := []string{
+		"certificates/acme/a.example.com/a.example.com.crt",
+		"certificates/acme/b.example.com/b.example.com.crt",
+		"certificates/acme/c.example.com/c.example.com.crt",
+		"certificates/acme/d.example.com/d.example.com.crt",
+	}
+	for _, key := range testKeys {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store() failed for key %s: %v", key, err)
+		}
+	}
+
+	for _, key := range testKeys {
+		buf, err := storage.Load(ctx, key)
+		if err != nil {
+			t.Fatalf("Load() failed for key %s: %v", key, err)
+		}
+		if string(buf) != key {
+			t.Errorf("Load() returned %s for key %s", buf, key)
+		}
+	}
+
+	keys, err := storage.List(ctx, "certificates", true)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	sort.Strings(keys)
+	if len(keys) != len(testKeys) {
+		t.Errorf("List() returned %v, expected %v", keys, testKeys)
+	}
+
+	if err := storage.Lock(ctx, "issue_cert_a.example.com"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if err := storage.Unlock(ctx, "issue_cert_a.example.com"); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+}
+
+func TestNewRoutedStorageValidation(t *testing.T) {
+	if _, err := NewRoutedStorage(nil, func(string) string { return "" }); err == nil {
+		t.Errorf("NewRoutedStorage() expected error without targets")
+	}
+	targets := map[string]S3Opts{"eu": testOpts(false)}
+	if _, err := NewRoutedStorage(targets, nil); err == nil {
+		t.Errorf("NewRoutedStorage() expected error without route function")
+	}
+}
+
+func TestMultiStorage_Routed(t *testing.T) {
+	eu := testOpts(false)
+	eu.Bucket = testBucket2
+	targets := map[string]S3Opts{
+		"us": testOpts(false),
+		"eu": eu,
+	}
+	route := func(key string) string {
+		if strings.Contains(key, ".eu/") || strings.HasSuffix(key, ".eu") {
+			return "eu"
+		}
+		if strings.Contains(key, "unroutable") {
+			return "mars"
+		}
+		return "us"
+	}
+	storage, err := NewRoutedStorage(targets, route)
+	if err != nil {
+		t.Skipf("Skipping test due to S3 setup error: %v", err)
+	}
+	ctx := context.Background()
+	for _, gs := range storage.storages {
+		testCleanup(ctx, gs)
+	}
+
+	euKey := "certificates/acme/shop.example.eu/shop.example.eu.crt"
+	usKey := "certificates/acme/shop.example.com/shop.example.com.crt"
+	for _, key := range []string{euKey, usKey} {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store() failed for key %s: %v", key, err)
+		}
+	}
+
+	for _, gs := range storage.storages {
+		wantEU := gs.bucket == testBucket2
+		if gs.Exists(ctx, euKey) != wantEU || gs.Exists(ctx, usKey) == wantEU {
+			t.Errorf("keys were not routed to the expected bucket %s", gs.bucket)
+		}
+	}
+
+	keys, err := storage.List(ctx, "certificates/acme", false)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("List() should merge both targets, got %v", keys)
+	}
+
+	if err := storage.Store(ctx, "unroutable", nil); err == nil {
+		t.Errorf("Store() expected error for key routed to unknown target")
+	}
+
+	// The folder is routed to "us" but holds keys of both targets.
+	if err := storage.Delete(ctx, "certificates/acme"); err != nil {
+		t.Fatalf("Delete() of the folder failed: %v", err)
+	}
+	for _, gs := range storage.storages {
+		if gs.Exists(ctx, euKey) || gs.Exists(ctx, usKey) {
+			t.Errorf("Delete() of the folder left keys in bucket %s", gs.bucket)
+		}
+	}
+}
+
+func TestMultiStorage_Namespaces(t *testing.T) {
+	accounts := testOpts(true)
+	accounts.Bucket = testBucket2
+	storage, err := NewNamespaceStorage(map[string]S3Opts{
+		"":               testOpts(false),
+		"acme_accounts/": accounts,
+	})
+	if err != nil {
+		t.Skipf("Skipping test due to S3 setup error: %v", err)
+	}
+	ctx := context.Background()
+	for _, gs := range storage.storages {
+		testCleanup(ctx, gs)
+	}
+
+	accountKey := "acme_accounts/acme/user/user.key"
+	certKey := "certificates/acme/example.com/example.com.crt"
+	for _, key := range []string{accountKey, certKey} {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store() failed for key %s: %v", key, err)
+		}
+	}
+	for _, gs := range storage.storages {
+		wantAccount := gs.bucket == testBucket2
+		if gs.Exists(ctx, accountKey) != wantAccount || gs.Exists(ctx, certKey) == wantAccount {
+			t.Errorf("keys were not routed to the expected bucket %s", gs.bucket)
+		}
+	}
+
+	if err := storage.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if err := storage.Unlock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+
+	keys, err := storage.List(ctx, "", true)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("List() = %v, expected keys of both buckets", keys)
+	}
+}
@@ -0,0 +1,110 @@
+package cmgs3
+
+import (
+	"sync"
+	"time"
+)
+
+// NegativeCacheMaxKeys bounds the number of missing keys remembered with
+// S3Opts.NegativeCacheTTL.
+var NegativeCacheMaxKeys = 10000
+
+// negCache remembers keys found missing until their TTL expires, so
+// repeated probes of the same key do not each cost a request. Writes bump
+// a generation, so that a lookup which started before a write of its key
+// does not record the key as missing once the write is done.
+type negCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	expires map[string]time.Time
+
+	gen     uint64
+	written map[string]uint64 // generation of the last write of a key
+	floor   uint64            // generation up to which written was pruned
+}
+
+func newNegCache(ttl time.Duration) *negCache {
+	return &negCache{ttl: ttl, expires: make(map[string]time.Time), written: make(map[string]uint64)}
+}
+
+// generation returns the generation to pass to put for a lookup starting
+// now.
+func (c *negCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// missing reports whether key was found missing within the TTL.
+func (c *negCache) missing(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	exp, ok := c.expires[key]
+	if ok && time.Now().After(exp) {
+		delete(c.expires, key)
+		return false
+	}
+	return ok
+}
+
+// put records key as missing, as found by a lookup that started at
+// generation since, unless key was written after that.
+func (c *negCache) put(key string, since uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w, ok := c.written[key]; ok && w > since || !ok && c.floor > since {
+		return
+	}
+	now := time.Now()
+	if len(c.expires) >= NegativeCacheMaxKeys {
+		for k, exp := range c.expires {
+			if now.After(exp) {
+				delete(c.expires, k)
+			}
+		}
+	}
+	// Under a flood of distinct keys, drop arbitrary ones.
+	for k := range c.expires {
+		if len(c.expires) < NegativeCacheMaxKeys {
+			break
+		}
+		delete(c.expires, k)
+	}
+	c.expires[key] = now.Add(c.ttl)
+}
+
+// invalidate forgets key, which is being written.
+func (c *negCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.expires, key)
+	c.gen++
+	if len(c.written) >= NegativeCacheMaxKeys {
+		// Lookups started before are not recorded at all anymore.
+		clear(c.written)
+		c.floor = c.gen
+	}
+	c.written[key] = c.gen
+}
+
+// knownMissing reports whether key was recently found missing.
+func (gs *S3Storage) knownMissing(key string) bool {
+	return gs.negative != nil && gs.negative.missing(key)
+}
+
+// missingGeneration returns the generation to pass to recordMissing for a
+// lookup starting now.
+func (gs *S3Storage) missingGeneration() uint64 {
+	if gs.negative == nil {
+		return 0
+	}
+	return gs.negative.generation()
+}
+
+// recordMissing remembers that key was found missing by a lookup that
+// started at generation since.
+func (gs *S3Storage) recordMissing(key string, since uint64) {
+	if gs.negative != nil {
+		gs.negative.put(key, since)
+	}
+}
@@ -0,0 +1,135 @@
+package cmgs3
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNegCache(t *testing.T) {
+	c := newNegCache(50 * time.Millisecond)
+	if c.missing("a") {
+		t.Error("missing() of an unknown key = true")
+	}
+	c.put("a", c.generation())
+	if !c.missing("a") {
+		t.Error("missing() of a recorded key = false")
+	}
+	c.invalidate("a")
+	if c.missing("a") {
+		t.Error("missing() after invalidate() = true")
+	}
+	c.put("b", c.generation())
+	time.Sleep(60 * time.Millisecond)
+	if c.missing("b") {
+		t.Error("missing() after expiry = true")
+	}
+
+	defer func(n int) { NegativeCacheMaxKeys = n }(NegativeCacheMaxKeys)
+	NegativeCacheMaxKeys = 10
+	for i := 0; i < 100; i++ {
+		c.put(fmt.Sprintf("scan/%d", i), c.generation())
+	}
+	if n := len(c.expires); n > NegativeCacheMaxKeys {
+		t.Errorf("negative cache holds %d keys, expected at most %d", n, NegativeCacheMaxKeys)
+	}
+	if !c.missing("scan/99") {
+		t.Error("latest key was not recorded")
+	}
+}
+
+func TestNegCache_RacingWrite(t *testing.T) {
+	c := newNegCache(time.Minute)
+
+	// A lookup finds the key missing, then a write completes before the
+	// lookup records it.
+	since := c.generation()
+	c.invalidate("a")
+	c.put("a", since)
+	if c.missing("a") {
+		t.Error("lookup that started before a write recorded the key as missing")
+	}
+	c.put("a", c.generation())
+	if !c.missing("a") {
+		t.Error("lookup that started after the write did not record the key")
+	}
+
+	// Other keys' writes do not keep lookups from being recorded.
+	since = c.generation()
+	c.invalidate("b")
+	c.put("c", since)
+	if !c.missing("c") {
+		t.Error("write of another key kept the lookup from being recorded")
+	}
+
+	defer func(n int) { NegativeCacheMaxKeys = n }(NegativeCacheMaxKeys)
+	NegativeCacheMaxKeys = 10
+	since = c.generation()
+	for i := 0; i < 20; i++ {
+		c.invalidate(fmt.Sprintf("write/%d", i))
+	}
+	if n := len(c.written); n > NegativeCacheMaxKeys {
+		t.Errorf("negative cache tracks %d writes, expected at most %d", n, NegativeCacheMaxKeys)
+	}
+	c.put("write/0", since)
+	if c.missing("write/0") {
+		t.Error("lookup that started before pruned writes recorded the key as missing")
+	}
+}
+
+func TestS3Storage_NegativeCacheForget(t *testing.T) {
+	opts := testOpts(false)
+	opts.LazyInit = true
+	opts.NegativeCacheTTL = time.Minute
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	storage.recordMissing("test/dst", storage.missingGeneration())
+	// As after a server-side copy to the key.
+	storage.forget("test/dst")
+	if storage.knownMissing("test/dst") {
+		t.Error("forget() kept the key in the negative cache")
+	}
+}
+
+func TestS3Storage_NegativeCacheCopy(t *testing.T) {
+	opts := testOpts(false)
+	opts.NegativeCacheTTL = time.Minute
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "test/src", []byte("copied")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if storage.Exists(ctx, "test/dst") {
+		t.Fatal("Exists() of a missing key = true")
+	}
+	if err := storage.Copy(ctx, "test/src", "test/dst"); err != nil {
+		t.Fatalf("Copy() failed: %v", err)
+	}
+	if buf, err := storage.Load(ctx, "test/dst"); err != nil || string(buf) != "copied" {
+		t.Errorf("Load() after Copy() = %q, %v", buf, err)
+	}
+}
+
+func TestS3Storage_NegativeCache(t *testing.T) {
+	opts := testOpts(false)
+	opts.NegativeCacheTTL = time.Minute
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if storage.Exists(ctx, "test/negative") {
+		t.Fatal("Exists() of a missing key = true")
+	}
+	if !storage.knownMissing("test/negative") {
+		t.Error("missing key was not remembered")
+	}
+	if err := storage.Store(ctx, "test/negative", []byte("now")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if buf, err := storage.Load(ctx, "test/negative"); err != nil || string(buf) != "now" {
+		t.Errorf("Load() after Store() = %q, %v", buf, err)
+	}
+}
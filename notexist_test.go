@@ -0,0 +1,82 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// TestS3Storage_NotExist checks that every method reports missing keys
+// with fs.ErrNotExist, whichever layers are enabled.
+func TestS3Storage_NotExist(t *testing.T) {
+	configs := map[string]func(*S3Opts){
+		"plain":           func(o *S3Opts) { o.EncryptionKey = nil },
+		"encrypted":       func(o *S3Opts) {},
+		"per-object keys": func(o *S3Opts) { o.PerObjectKeys = true },
+		"cached": func(o *S3Opts) {
+			o.CacheTTL = time.Minute
+			o.NegativeCacheTTL = time.Minute
+		},
+		"compressed": func(o *S3Opts) { o.Compress = true },
+		"dedup":      func(o *S3Opts) { o.Dedup = true },
+		"bundled":    func(o *S3Opts) { o.Bundle = true },
+	}
+	for name, configure := range configs {
+		t.Run(name, func(t *testing.T) {
+			opts := testOpts(true)
+			opts.ObjPrefix = testPrefix + "-notexist-" + name
+			opts.DeleteMissingError = true
+			configure(&opts)
+			storage := setupTestStorageOpts(t, opts)
+			ctx := context.Background()
+			t.Cleanup(func() {
+				for _, obj := range []string{storage.layoutName(), storage.initName()} {
+					storage.s3client.RemoveObject(ctx, storage.bucket, obj, minio.RemoveObjectOptions{})
+				}
+			})
+
+			for _, key := range []string{"test/missing", "certificates/ca/missing.example/missing.example.crt"} {
+				// Twice, so the second call may be answered by the caches.
+				for i := 0; i < 2; i++ {
+					if _, err := storage.Load(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+						t.Errorf("Load(%s) = %v", key, err)
+					}
+					if _, err := storage.LoadRange(ctx, key, 0, 1); !errors.Is(err, fs.ErrNotExist) {
+						t.Errorf("LoadRange(%s) = %v", key, err)
+					}
+					if _, err := storage.Stat(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+						t.Errorf("Stat(%s) = %v", key, err)
+					}
+					if err := storage.Delete(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+						t.Errorf("Delete(%s) = %v", key, err)
+					}
+					if err := storage.Copy(ctx, key, key+".copy"); !errors.Is(err, fs.ErrNotExist) {
+						t.Errorf("Copy(%s) = %v", key, err)
+					}
+					if err := storage.Move(ctx, key, key+".moved"); !errors.Is(err, fs.ErrNotExist) {
+						t.Errorf("Move(%s) = %v", key, err)
+					}
+					if ok, err := storage.ExistsErr(ctx, key); ok || err != nil {
+						t.Errorf("ExistsErr(%s) = %v, %v", key, ok, err)
+					}
+					if infos, err := storage.StatMany(ctx, []string{key}); err != nil || len(infos) != 0 {
+						t.Errorf("StatMany(%s) = %v, %v", key, infos, err)
+					}
+					if _, _, bundled := storage.bundled(key); bundled {
+						continue
+					}
+					if _, _, err := storage.LoadExt(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+						t.Errorf("LoadExt(%s) = %v", key, err)
+					}
+					if _, err := storage.StatExt(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+						t.Errorf("StatExt(%s) = %v", key, err)
+					}
+				}
+			}
+		})
+	}
+}
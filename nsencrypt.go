@@ -0,0 +1,107 @@
+package cmgs3
+
+import "fmt"
+
+// NamespaceEncryption overrides the encryption of a key namespace, see
+// S3Opts.NamespaceEncryption.
+type NamespaceEncryption struct {
+	// Key seals the namespace instead of the storage's key. It must have
+	// 32 bytes; nil uses the storage's key, also once it is reloaded, e.g.
+	// with another Algorithm.
+	Key []byte
+	// Algorithm is "secretbox" or "aes-gcm", by default the storage's.
+	// FIPS mode only allows "aes-gcm".
+	Algorithm string
+	// Cleartext stores the namespace unencrypted, e.g. for OCSP staples.
+	Cleartext bool
+}
+
+// newNamespaceKeys returns the keyrings of the namespaces that are sealed
+// differently from the storage, and the namespaces stored in cleartext.
+func newNamespaceKeys(policies map[string]NamespaceEncryption, storageKey []byte, fips bool) (map[string]*keyring, map[string]bool, error) {
+	rings := make(map[string]*keyring)
+	clear := make(map[string]bool)
+	for ns, p := range policies {
+		if p.Cleartext {
+			if p.Key != nil || p.Algorithm != "" {
+				return nil, nil, fmt.Errorf("namespace %q: cleartext takes no key or algorithm", ns)
+			}
+			clear[ns] = true
+			continue
+		}
+		key := p.Key
+		if key == nil {
+			key = storageKey
+		}
+		if len(key) != 32 {
+			return nil, nil, fmt.Errorf("namespace %q: encryption key must have exactly 32 bytes", ns)
+		}
+		aesGCM, err := namespaceAESGCM(ns, p, fips)
+		if err != nil {
+			return nil, nil, err
+		}
+		rings[ns] = &keyring{current: newSealer(key, aesGCM)}
+	}
+	return rings, clear, nil
+}
+
+// namespaceAESGCM returns whether the namespace ns is sealed with AES-GCM
+// instead of secretbox.
+func namespaceAESGCM(ns string, p NamespaceEncryption, fips bool) (bool, error) {
+	switch p.Algorithm {
+	case "":
+		return fips, nil
+	case "aes-gcm":
+		return true, nil
+	case "secretbox":
+		if fips {
+			return false, fmt.Errorf("namespace %q: secretbox is not allowed in FIPS mode", ns)
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("namespace %q: unknown algorithm %q", ns, p.Algorithm)
+	}
+}
+
+// rotateKey makes key the current key of the storage and of the
+// namespaces sealed with the storage's key, e.g. with another Algorithm.
+func (gs *S3Storage) rotateKey(key []byte) {
+	gs.keys.rotate(newSealer(key, gs.fips))
+	for ns, p := range gs.nsPolicies {
+		if r := gs.nsKeys[ns]; r != nil && p.Key == nil {
+			aesGCM, _ := namespaceAESGCM(ns, p, gs.fips)
+			r.rotate(newSealer(key, aesGCM))
+		}
+	}
+}
+
+// retireKey keeps key for opening values of the storage and of the
+// namespaces sealed with the storage's key.
+func (gs *S3Storage) retireKey(key []byte) {
+	gs.keys.retire(key, gs.fips)
+	for ns, p := range gs.nsPolicies {
+		if r := gs.nsKeys[ns]; r != nil && p.Key == nil {
+			aesGCM, _ := namespaceAESGCM(ns, p, gs.fips)
+			r.retire(key, aesGCM)
+		}
+	}
+}
+
+// keyringFor returns the keyring sealing the value at key.
+func (gs *S3Storage) keyringFor(key string) *keyring {
+	if r := gs.nsKeys[namespaceOf(key)]; r != nil {
+		return r
+	}
+	return gs.keys
+}
+
+// openKeys returns the IOs to try when opening the value at key: those of
+// its namespace, then the storage's, which sealed values written before
+// the namespace had its own.
+func (gs *S3Storage) openKeys(key string) []IO {
+	keys := gs.keys.all()
+	if r := gs.nsKeys[namespaceOf(key)]; r != nil {
+		keys = append(r.all(), keys...)
+	}
+	return keys
+}
@@ -0,0 +1,113 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestS3Storage_NamespaceEncryption(t *testing.T) {
+	policies := map[string]NamespaceEncryption{
+		"private/": {Key: []byte("abcdefghabcdefghabcdefghabcdefgh"), Algorithm: "aes-gcm"},
+		"ocsp/":    {Cleartext: true},
+	}
+	plain := setupTestStorage(t, true)
+	opts := testOpts(true)
+	opts.NamespaceEncryption = policies
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	for _, key := range []string{"private/key", "ocsp/staple", "certificates/cert"} {
+		if err := storage.Store(ctx, key, []byte("value of "+key)); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+		if v, err := storage.Load(ctx, key); err != nil || string(v) != "value of "+key {
+			t.Errorf("Load(%s) = %q, %v", key, v, err)
+		}
+	}
+
+	obj, err := storage.s3client.GetObject(ctx, testBucket, storage.objName("ocsp/staple"), minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := mustRead(t, Reader{obj, 0, nil})
+	obj.Close()
+	if string(raw) != "value of ocsp/staple" {
+		t.Errorf("cleartext namespace stored %q", raw)
+	}
+	if _, err := plain.Load(ctx, "private/key"); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("Load() with the storage key of a namespace key value: %v", err)
+	}
+	if v, err := plain.Load(ctx, "certificates/cert"); err != nil || string(v) != "value of certificates/cert" {
+		t.Errorf("Load() of a value under the storage key = %q, %v", v, err)
+	}
+
+	// Values from before the namespace had its own key stay readable.
+	if err := plain.Store(ctx, "private/old", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := storage.Load(ctx, "private/old"); err != nil || string(v) != "old" {
+		t.Errorf("Load() of a value sealed before = %q, %v", v, err)
+	}
+
+	// Copies across namespaces are sealed for the destination.
+	if err := storage.Copy(ctx, "private/key", "certificates/copy"); err != nil {
+		t.Fatalf("Copy() failed: %v", err)
+	}
+	if v, err := plain.Load(ctx, "certificates/copy"); err != nil || string(v) != "value of private/key" {
+		t.Errorf("Load() of the copy = %q, %v", v, err)
+	}
+}
+
+func TestNewS3Storage_NamespaceEncryption(t *testing.T) {
+	for _, p := range []NamespaceEncryption{
+		{Key: []byte("short")},
+		{Algorithm: "rot13"},
+		{Cleartext: true, Algorithm: "aes-gcm"},
+	} {
+		opts := testOpts(true)
+		opts.NamespaceEncryption = map[string]NamespaceEncryption{"private/": p}
+		if _, err := NewS3Storage(opts); err == nil {
+			t.Errorf("NewS3Storage() accepted %+v", p)
+		}
+	}
+	opts := testOpts(false)
+	opts.NamespaceEncryption = map[string]NamespaceEncryption{"private/": {}}
+	if _, err := NewS3Storage(opts); err == nil {
+		t.Error("NewS3Storage() accepted a namespace without any key")
+	}
+}
+
+func TestS3Storage_NamespaceEncryptionReload(t *testing.T) {
+	own := []byte("abcdefghabcdefghabcdefghabcdefgh")
+	opts := testOpts(true)
+	opts.LazyInit = true
+	opts.NamespaceEncryption = map[string]NamespaceEncryption{
+		"private/": {Key: own},
+		"acme/":    {Algorithm: "aes-gcm"},
+	}
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+
+	next := opts
+	next.EncryptionKey = []byte("abcdefghijklmnopqrstuvwxyz012345")
+	if err := storage.Reload(next); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	for key, want := range map[string][]byte{"acme/x": next.EncryptionKey, "private/x": own} {
+		r := storage.keyringFor(key)
+		if s, ok := r.get().(sealer); !ok {
+			t.Errorf("keyring of %s is not sealing", key)
+		} else if k := s.secretKey(); !bytes.Equal(k[:], want) {
+			t.Errorf("keyring of %s seals with the wrong key after Reload()", key)
+		}
+	}
+	if n := len(storage.keyringFor("acme/x").all()); n != 2 {
+		t.Errorf("namespace keyring has %d keys after Reload(), expected the previous one kept", n)
+	}
+}
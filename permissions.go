@@ -0,0 +1,69 @@
+package cmgs3
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// Permission is the access an instance has to a key namespace.
+type Permission uint8
+
+const (
+	PermRead Permission = 1 << iota
+	PermWrite
+
+	PermReadWrite = PermRead | PermWrite
+)
+
+// LockNamespace is the namespace Lock and Unlock are checked against, for
+// lock names carry no namespace of their own.
+const LockNamespace = "locks/"
+
+// PermissionError is returned for operations on namespaces the instance is
+// not permitted to access. It matches fs.ErrPermission.
+type PermissionError struct {
+	Op        string
+	Key       string
+	Namespace string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("%s %s: no permission for namespace %q", e.Op, e.Key, e.Namespace)
+}
+
+func (e *PermissionError) Is(target error) bool {
+	return target == fs.ErrPermission
+}
+
+// namespaceOf returns the first path element of key with its slash, e.g.
+// "certificates/", or "" for top-level keys.
+func namespaceOf(key string) string {
+	i := strings.Index(key, "/")
+	if i < 0 {
+		return ""
+	}
+	return key[:i+1]
+}
+
+func (gs *S3Storage) access(op, key, ns string, p Permission) error {
+	if gs.perms == nil || gs.perms[ns]&p == p {
+		return nil
+	}
+	return &PermissionError{Op: op, Key: key, Namespace: ns}
+}
+
+// readable filters keys returned by a listing of the root. Non-recursive
+// listings return namespaces without their slash.
+func (gs *S3Storage) readable(keys []string) []string {
+	if gs.perms == nil {
+		return keys
+	}
+	out := keys[:0]
+	for _, k := range keys {
+		if gs.perms[namespaceOf(k)]&PermRead != 0 || (!strings.Contains(k, "/") && gs.perms[k+"/"]&PermRead != 0) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
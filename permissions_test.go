@@ -0,0 +1,75 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"reflect"
+	"testing"
+)
+
+func TestNamespaceOf(t *testing.T) {
+	tests := map[string]string{
+		"certificates/acme/example.com/example.com.crt": "certificates/",
+		"acme_accounts/acme/user/user.key":              "acme_accounts/",
+		"last_clean.json":                               "",
+	}
+	for key, want := range tests {
+		if got := namespaceOf(key); got != want {
+			t.Errorf("namespaceOf(%q) = %q, expected %q", key, got, want)
+		}
+	}
+}
+
+func TestS3Storage_Namespaces(t *testing.T) {
+	writer := setupTestStorage(t, false)
+	ctx := context.Background()
+	for _, key := range []string{"certificates/a.crt", "acme_accounts/user.key"} {
+		if err := writer.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+
+	opts := testOpts(false)
+	opts.Namespaces = map[string]Permission{"certificates/": PermRead}
+	reader, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+
+	if _, err := reader.Load(ctx, "certificates/a.crt"); err != nil {
+		t.Errorf("Load() of readable namespace failed: %v", err)
+	}
+	_, err = reader.Load(ctx, "acme_accounts/user.key")
+	var pe *PermissionError
+	if !errors.As(err, &pe) || pe.Namespace != "acme_accounts/" || !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Load() of denied namespace error = %v, expected PermissionError", err)
+	}
+	if reader.Exists(ctx, "acme_accounts/user.key") {
+		t.Error("Exists() reported key in denied namespace")
+	}
+	if err := reader.Store(ctx, "certificates/a.crt", []byte("x")); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Store() to read-only namespace error = %v, expected permission error", err)
+	}
+	if err := reader.Delete(ctx, "certificates/a.crt"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Delete() in read-only namespace error = %v, expected permission error", err)
+	}
+	if err := reader.Lock(ctx, "issue_cert_a"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Lock() without lock permission error = %v, expected permission error", err)
+	}
+	if _, err := reader.List(ctx, "acme_accounts", true); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("List() of denied namespace error = %v, expected permission error", err)
+	}
+
+	keys, err := reader.List(ctx, "", true)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if want := []string{"certificates/a.crt"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() = %v, expected %v", keys, want)
+	}
+	keys, _ = reader.List(ctx, "", false)
+	if want := []string{"certificates"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() non-recursive = %v, expected %v", keys, want)
+	}
+}
@@ -0,0 +1,103 @@
+package cmgs3
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PostgresLocker holds locks as Postgres session-level advisory locks.
+// Each held lock pins a connection of the pool, so the locks of a crashed
+// process are released when its connections drop. The caller provides
+// the *sql.DB and thus the driver.
+type PostgresLocker struct {
+	db *sql.DB
+	// Namespace is hashed into the advisory lock keys, so several
+	// deployments can share a database. It must be set before use.
+	Namespace string
+
+	mu    sync.Mutex
+	conns map[string]*sql.Conn
+}
+
+// NewPostgresLocker returns a PostgresLocker using db, which should allow
+// at least as many open connections as locks are held at once.
+func NewPostgresLocker(db *sql.DB) *PostgresLocker {
+	return &PostgresLocker{db: db, conns: make(map[string]*sql.Conn)}
+}
+
+// advisoryKey maps key to the 64-bit key space of advisory locks.
+func (l *PostgresLocker) advisoryKey(key string) int64 {
+	sum := sha256.Sum256([]byte(l.Namespace + "\x00" + key))
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
+
+// Lock takes the advisory lock of key on a dedicated connection, polling
+// every LockPollInterval while another session holds it.
+func (l *PostgresLocker) Lock(ctx context.Context, key string) error {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.advisoryKey(key)).Scan(&acquired); err != nil {
+			conn.Close()
+			return err
+		}
+		if acquired {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			return fmt.Errorf("acquiring lock failed: %w", ctx.Err())
+		case <-time.After(LockPollInterval):
+		}
+	}
+
+	l.mu.Lock()
+	old := l.conns[key]
+	l.conns[key] = conn
+	l.mu.Unlock()
+	if old != nil {
+		// Re-locking a held key must not leak the first connection, nor
+		// return it to the pool still holding the lock.
+		l.release(ctx, old, key)
+	}
+	return nil
+}
+
+// Unlock releases the advisory lock of key and returns its connection to
+// the pool.
+func (l *PostgresLocker) Unlock(ctx context.Context, key string) error {
+	l.mu.Lock()
+	conn := l.conns[key]
+	delete(l.conns, key)
+	l.mu.Unlock()
+	if conn == nil {
+		return fmt.Errorf("lock %s not held", key)
+	}
+	return l.release(ctx, conn, key)
+}
+
+// release releases the advisory lock of key held on conn and returns conn
+// to the pool, or discards it if the lock may still be held.
+func (l *PostgresLocker) release(ctx context.Context, conn *sql.Conn, key string) error {
+	var released bool
+	err := conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1)", l.advisoryKey(key)).Scan(&released)
+	if err != nil {
+		// Discard the connection, ending the session and its locks.
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+	conn.Close()
+	if err == nil && !released {
+		err = fmt.Errorf("lock %s not held", key)
+	}
+	return err
+}
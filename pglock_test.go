@@ -0,0 +1,156 @@
+package cmgs3
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePG emulates session-level advisory locks: locks belong to a
+// connection and are released when it closes.
+type fakePG struct {
+	mu      sync.Mutex
+	holders map[int64]*fakePGConn
+	shared  bool // grant every lock without taking it
+}
+
+type fakePGConn struct{ pg *fakePG }
+
+func (pg *fakePG) Open(string) (driver.Conn, error) { return &fakePGConn{pg: pg}, nil }
+
+func (c *fakePGConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakePGConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *fakePGConn) Close() error {
+	c.pg.mu.Lock()
+	defer c.pg.mu.Unlock()
+	for k, h := range c.pg.holders {
+		if h == c {
+			delete(c.pg.holders, k)
+		}
+	}
+	return nil
+}
+
+func (c *fakePGConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.pg.mu.Lock()
+	defer c.pg.mu.Unlock()
+	k := args[0].Value.(int64)
+	var ok bool
+	switch query {
+	case "SELECT pg_try_advisory_lock($1)":
+		if c.pg.shared {
+			ok = true
+		} else if h := c.pg.holders[k]; h == nil || h == c {
+			c.pg.holders[k] = c
+			ok = true
+		}
+	case "SELECT pg_advisory_unlock($1)":
+		if c.pg.holders[k] == c {
+			delete(c.pg.holders, k)
+			ok = true
+		}
+	default:
+		return nil, errors.New("unexpected query " + query)
+	}
+	return &fakePGRow{value: ok}, nil
+}
+
+type fakePGRow struct {
+	value bool
+	done  bool
+}
+
+func (r *fakePGRow) Columns() []string { return []string{"result"} }
+func (r *fakePGRow) Close() error      { return nil }
+
+func (r *fakePGRow) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.value
+	return nil
+}
+
+var registerFakePG sync.Once
+
+func TestPostgresLocker(t *testing.T) {
+	pg := &fakePG{holders: make(map[int64]*fakePGConn)}
+	registerFakePG.Do(func() { sql.Register("fakepg", pg) })
+	db, err := sql.Open("fakepg", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	a, b := NewPostgresLocker(db), NewPostgresLocker(db)
+
+	if err := a.Lock(ctx, "test/pg"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	lctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := b.Lock(lctx, "test/pg"); err == nil {
+		t.Fatal("Lock() of a held lock succeeded")
+	}
+	if err := b.Unlock(ctx, "test/pg"); err == nil {
+		t.Error("Unlock() of a lock held by another locker succeeded")
+	}
+	if err := a.Unlock(ctx, "test/pg"); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+	if err := b.Lock(ctx, "test/pg"); err != nil {
+		t.Fatalf("Lock() after Unlock() failed: %v", err)
+	}
+	defer b.Unlock(ctx, "test/pg")
+
+	other := NewPostgresLocker(db)
+	other.Namespace = "other"
+	if err := other.Lock(ctx, "test/pg"); err != nil {
+		t.Errorf("Lock() in another namespace failed: %v", err)
+	}
+	other.Unlock(ctx, "test/pg")
+
+	if n := db.Stats().InUse; n != 1 {
+		t.Errorf("%d connections in use, expected one for the held lock", n)
+	}
+}
+
+// Connect and Driver make fakePG a driver.Connector, for tests that look
+// into its state without registering another driver.
+func (pg *fakePG) Connect(context.Context) (driver.Conn, error) { return pg.Open("") }
+func (pg *fakePG) Driver() driver.Driver                        { return pg }
+
+func TestPostgresLocker_Relock(t *testing.T) {
+	pg := &fakePG{holders: make(map[int64]*fakePGConn)}
+	db := sql.OpenDB(pg)
+	defer db.Close()
+	ctx := context.Background()
+	l := NewPostgresLocker(db)
+	k := l.advisoryKey("test/relock")
+
+	if err := l.Lock(ctx, "test/relock"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	// Have the second session get the lock while the first holds it.
+	pg.mu.Lock()
+	pg.shared = true
+	pg.mu.Unlock()
+	if err := l.Lock(ctx, "test/relock"); err != nil {
+		t.Fatalf("re-Lock() failed: %v", err)
+	}
+	pg.mu.Lock()
+	pg.shared = false
+	_, held := pg.holders[k]
+	pg.mu.Unlock()
+	if held {
+		t.Error("re-Lock() returned the first connection to the pool holding the lock")
+	}
+	l.Unlock(ctx, "test/relock")
+}
@@ -0,0 +1,55 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// PresignGet returns a URL that fetches the object of key from S3 until
+// expiry, at most seven days, so trusted tools can read it without the
+// storage's credentials. The object is served as stored: encrypted values
+// need the encryption key, and compressed ones decompressing. Keys stored
+// in bundles or deduplicated cannot be presigned.
+func (gs *S3Storage) PresignGet(ctx context.Context, key string, expiry time.Duration) (*url.URL, error) {
+	if err := gs.presignable("presign get", key, PermRead); err != nil {
+		return nil, err
+	}
+	if err := gs.ready(ctx); err != nil {
+		return nil, err
+	}
+	return gs.s3client.PresignedGetObject(ctx, gs.bucket, gs.objName(key), expiry, nil)
+}
+
+// PresignPut returns a URL that stores the object of key in S3 until
+// expiry, at most seven days. The body is stored as is, so it must be
+// sealed as the storage would if key is encrypted.
+func (gs *S3Storage) PresignPut(ctx context.Context, key string, expiry time.Duration) (*url.URL, error) {
+	if err := gs.presignable("presign put", key, PermWrite); err != nil {
+		return nil, err
+	}
+	if err := gs.ready(ctx); err != nil {
+		return nil, err
+	}
+	return gs.s3client.PresignedPutObject(ctx, gs.bucket, gs.objName(key), expiry)
+}
+
+// presignable checks that key may be accessed with p, and that its value
+// is an object of its own.
+func (gs *S3Storage) presignable(op, key string, p Permission) error {
+	if err := gs.access(op, key, namespaceOf(key), p); err != nil {
+		return err
+	}
+	if _, mrap := parseMRAP(gs.currentOpts().Bucket); mrap {
+		return errors.New("presigned URLs are not supported for Multi-Region Access Points")
+	}
+	if _, _, ok := gs.bundled(key); ok {
+		return fmt.Errorf("%s is stored in a bundle", key)
+	}
+	if gs.isDedupKey(key) {
+		return fmt.Errorf("%s is stored deduplicated", key)
+	}
+	return nil
+}
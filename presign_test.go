@@ -0,0 +1,55 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestS3Storage_PresignRejects(t *testing.T) {
+	opts := testOpts(false)
+	opts.LazyInit = true
+	opts.Bundle = true
+	opts.Dedup = true
+	opts.DedupKey = func(key string) bool { return strings.HasSuffix(key, ".key") }
+	opts.Namespaces = map[string]Permission{"certificates/": PermRead, "acme/": PermRead | PermWrite}
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	ctx := context.Background()
+	var perr *PermissionError
+	if _, err := storage.PresignPut(ctx, "certificates/ca/a.com/a.com.json", time.Minute); !errors.As(err, &perr) {
+		t.Errorf("PresignPut() without write permission = %v", err)
+	}
+	if _, err := storage.PresignGet(ctx, "certificates/ca/a.com/a.com.crt", time.Minute); err == nil || !strings.Contains(err.Error(), "bundle") {
+		t.Errorf("PresignGet() of a bundled key = %v", err)
+	}
+	if _, err := storage.PresignGet(ctx, "acme/ca/users/a/a.key", time.Minute); err == nil || !strings.Contains(err.Error(), "deduplicated") {
+		t.Errorf("PresignGet() of a deduplicated key = %v", err)
+	}
+}
+
+func TestS3Storage_PresignGet(t *testing.T) {
+	storage := setupTestStorage(t, false)
+	ctx := context.Background()
+	if err := storage.Store(ctx, "test/presigned.pem", []byte("presigned")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	u, err := storage.PresignGet(ctx, "test/presigned.pem", time.Minute)
+	if err != nil {
+		t.Fatalf("PresignGet() failed: %v", err)
+	}
+	resp, err := http.Get(u.String())
+	if err != nil {
+		t.Fatalf("GET of the presigned URL failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if buf, _ := io.ReadAll(resp.Body); string(buf) != "presigned" {
+		t.Errorf("presigned URL returned %d %q", resp.StatusCode, buf)
+	}
+}
@@ -0,0 +1,156 @@
+package cmgs3
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Priority orders the S3 requests of operations waiting for a request slot
+// of MaxConcurrentRequests or AdaptiveConcurrency, so that bulk work never
+// holds back the loads of TLS handshakes. Without a limit it has no
+// effect.
+type Priority int
+
+const (
+	// PriorityBulk is the class of bulk tools: Migrate, Archive, Scan,
+	// ReconcileIndex, inventories, manifests, Bench and StressTest, and of
+	// all operations of contexts marked with WithBackground.
+	PriorityBulk Priority = iota
+	// PriorityMaintenance is the class of Store, Delete, List, Stat,
+	// Prefetch and the other operations, and of requests without a class.
+	PriorityMaintenance
+	// PriorityLock is the class of Lock and Unlock.
+	PriorityLock
+	// PriorityCritical is the class of Load, LoadRange and LoadExt, which
+	// TLS handshakes wait on.
+	PriorityCritical
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityBulk:
+		return "bulk"
+	case PriorityMaintenance:
+		return "maintenance"
+	case PriorityLock:
+		return "lock"
+	case PriorityCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose operations are of class p instead
+// of the class of the operation.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFrom returns the class set with WithPriority, or PriorityBulk
+// for contexts marked with WithBackground, or PriorityMaintenance.
+func PriorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	if IsBackground(ctx) {
+		return PriorityBulk
+	}
+	return PriorityMaintenance
+}
+
+// withDefaultPriority returns ctx with class p unless it has one, or
+// PriorityBulk if it is marked with WithBackground.
+func withDefaultPriority(ctx context.Context, p Priority) context.Context {
+	if _, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return ctx
+	}
+	if IsBackground(ctx) {
+		p = PriorityBulk
+	}
+	return WithPriority(ctx, p)
+}
+
+// priorityGate bounds the requests in flight, handing free slots to the
+// waiting requests by priority and then in arrival order.
+type priorityGate struct {
+	mu       sync.Mutex
+	limit    int
+	inflight int
+	seq      uint64
+	waiters  []*gateWaiter // by priority, then seq
+}
+
+type gateWaiter struct {
+	prio    Priority
+	seq     uint64
+	ready   chan struct{}
+	granted bool
+}
+
+// acquire waits for a slot for a request of class prio.
+func (g *priorityGate) acquire(ctx context.Context, prio Priority) error {
+	g.mu.Lock()
+	if g.inflight < g.limit {
+		g.inflight++
+		g.mu.Unlock()
+		return nil
+	}
+	w := &gateWaiter{prio: prio, seq: g.seq, ready: make(chan struct{})}
+	g.seq++
+	i := sort.Search(len(g.waiters), func(i int) bool { return g.waiters[i].prio < prio })
+	g.waiters = append(g.waiters, nil)
+	copy(g.waiters[i+1:], g.waiters[i:])
+	g.waiters[i] = w
+	g.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		if w.granted {
+			// Granted meanwhile, passed on.
+			g.inflight--
+		} else {
+			for i, o := range g.waiters {
+				if o == w {
+					g.waiters = append(g.waiters[:i], g.waiters[i+1:]...)
+					break
+				}
+			}
+		}
+		g.grant()
+		g.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (g *priorityGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inflight--
+	g.grant()
+}
+
+// setLimit changes the number of slots; requests in flight beyond it keep
+// theirs.
+func (g *priorityGate) setLimit(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.limit = n
+	g.grant()
+}
+
+// grant hands free slots to waiters. g.mu must be held.
+func (g *priorityGate) grant() {
+	for g.inflight < g.limit && len(g.waiters) > 0 {
+		w := g.waiters[0]
+		g.waiters = g.waiters[1:]
+		w.granted = true
+		g.inflight++
+		close(w.ready)
+	}
+}
@@ -0,0 +1,120 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriorityGate(t *testing.T) {
+	g := &priorityGate{limit: 1}
+	ctx := context.Background()
+	g.acquire(ctx, PriorityBulk)
+
+	var mu sync.Mutex
+	var order []Priority
+	var wg sync.WaitGroup
+	waiting := func(n int) {
+		for {
+			g.mu.Lock()
+			l := len(g.waiters)
+			g.mu.Unlock()
+			if l == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i, p := range []Priority{PriorityBulk, PriorityMaintenance, PriorityCritical, PriorityLock, PriorityCritical} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.acquire(ctx, p)
+			mu.Lock()
+			order = append(order, p)
+			mu.Unlock()
+			g.release()
+		}()
+		waiting(i + 1)
+	}
+
+	// A waiter giving up does not hold back the others.
+	cctx, cancel := context.WithCancel(ctx)
+	gaveUp := make(chan error)
+	go func() { gaveUp <- g.acquire(cctx, PriorityCritical) }()
+	waiting(6)
+	cancel()
+	if err := <-gaveUp; !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire() = %v, expected the cancellation", err)
+	}
+
+	g.release()
+	wg.Wait()
+	want := []Priority{PriorityCritical, PriorityCritical, PriorityLock, PriorityMaintenance, PriorityBulk}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("slots handed out in order %v, expected %v", order, want)
+		}
+	}
+	if g.inflight != 0 || len(g.waiters) != 0 {
+		t.Errorf("%d in flight and %d waiting after all released", g.inflight, len(g.waiters))
+	}
+}
+
+func TestPriorityFrom(t *testing.T) {
+	ctx := context.Background()
+	if p := PriorityFrom(ctx); p != PriorityMaintenance {
+		t.Errorf("PriorityFrom() without a class = %v", p)
+	}
+	if p := PriorityFrom(withDefaultPriority(ctx, PriorityCritical)); p != PriorityCritical {
+		t.Errorf("PriorityFrom() of a default = %v", p)
+	}
+	if p := PriorityFrom(withDefaultPriority(WithPriority(ctx, PriorityBulk), PriorityCritical)); p != PriorityBulk {
+		t.Errorf("PriorityFrom() = %v, expected WithPriority to take precedence", p)
+	}
+	if p := PriorityFrom(withDefaultPriority(WithBackground(ctx), PriorityCritical)); p != PriorityBulk {
+		t.Errorf("PriorityFrom() of background work = %v", p)
+	}
+	if p := PriorityFrom(WithPriority(WithBackground(ctx), PriorityLock)); p != PriorityLock {
+		t.Errorf("PriorityFrom() = %v, expected WithPriority to take precedence over WithBackground", p)
+	}
+}
+
+func TestS3Storage_Priority(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[Priority]bool)
+	opts := testOpts(false)
+	opts.LazyInit = true
+	opts.MaxRetries = 1
+	opts.MaxConcurrentRequests = 4
+	opts.TracePropagator = func(ctx context.Context, h http.Header) {
+		mu.Lock()
+		seen[PriorityFrom(ctx)] = true
+		mu.Unlock()
+	}
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The requests fail without S3, but carry the class of their operation.
+	for _, tt := range []struct {
+		op   func(ctx context.Context)
+		want Priority
+	}{
+		{func(ctx context.Context) { storage.Load(ctx, "certificates/a") }, PriorityCritical},
+		{func(ctx context.Context) { storage.Lock(ctx, "certificates/a") }, PriorityLock},
+		{func(ctx context.Context) { storage.Load(WithPriority(ctx, PriorityBulk), "certificates/a") }, PriorityBulk},
+	} {
+		clear(seen)
+		tt.op(ctx)
+		if len(seen) != 1 || !seen[tt.want] {
+			t.Errorf("requests of class %v, expected %v", seen, tt.want)
+		}
+	}
+}
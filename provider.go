@@ -0,0 +1,114 @@
+package cmgs3
+
+import (
+	"errors"
+	"fmt"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// providerPreset holds the defaults and limits of an S3 implementation, see
+// S3Opts.Provider.
+type providerPreset struct {
+	endpoint    string // the global endpoint, if there is one
+	region      string
+	lookup      minio.BucketLookupType
+	retryBudget float64
+
+	conditionalWrites bool // If-None-Match and If-Match on PUT
+	trailingChecksums bool
+	objectLock        bool
+	acceleration      bool
+}
+
+var providerPresets = map[string]providerPreset{
+	"aws": {
+		endpoint:          "s3.amazonaws.com",
+		conditionalWrites: true,
+		trailingChecksums: true,
+		objectLock:        true,
+		acceleration:      true,
+	},
+	"minio": {
+		lookup:            minio.BucketLookupPath,
+		conditionalWrites: true,
+		trailingChecksums: true,
+		objectLock:        true,
+	},
+	"ceph": {
+		lookup:            minio.BucketLookupPath,
+		conditionalWrites: true,
+		objectLock:        true,
+	},
+	// R2 and B2 shed load with 429 and 503 responses, persistent retries
+	// only make it worse.
+	"r2": {
+		region:            "auto",
+		lookup:            minio.BucketLookupPath,
+		retryBudget:       5,
+		conditionalWrites: true,
+	},
+	"b2": {
+		retryBudget: 5,
+		objectLock:  true,
+	},
+	"gcs": {
+		endpoint: "storage.googleapis.com",
+		region:   "auto",
+	},
+	"wasabi": {
+		endpoint:   "s3.wasabisys.com",
+		objectLock: true,
+	},
+	"spaces": {
+		lookup: minio.BucketLookupDNS,
+	},
+}
+
+// applyProvider fills in the defaults of opts.Provider and rejects options
+// it does not support. It returns the bucket addressing style to use.
+func applyProvider(opts S3Opts) (S3Opts, minio.BucketLookupType, error) {
+	if opts.Provider == "" {
+		return opts, minio.BucketLookupAuto, nil
+	}
+	p, ok := providerPresets[opts.Provider]
+	if !ok {
+		return opts, 0, fmt.Errorf("unknown provider %q", opts.Provider)
+	}
+	if opts.Endpoint == "" {
+		if p.endpoint == "" {
+			return opts, 0, fmt.Errorf("provider %s needs an endpoint", opts.Provider)
+		}
+		opts.Endpoint = p.endpoint
+	}
+	if opts.Region == "" {
+		opts.Region = p.region
+	}
+	if opts.RetryBudget == 0 && opts.MaxRetries == 0 {
+		opts.RetryBudget = p.retryBudget
+	}
+	if p.conditionalWrites && opts.Locker == nil {
+		opts.ConditionalLocks = true
+	}
+
+	var errs []error
+	unsupported := func(option string) {
+		errs = append(errs, fmt.Errorf("%s is not supported by provider %s", option, opts.Provider))
+	}
+	if !p.conditionalWrites && opts.ConditionalLocks {
+		unsupported("ConditionalLocks")
+	}
+	if !p.conditionalWrites && opts.Index {
+		unsupported("Index")
+	}
+	if !p.trailingChecksums && opts.Checksum != "" {
+		unsupported("Checksum")
+	}
+	if !p.objectLock && opts.AuditBucket != "" {
+		unsupported("AuditBucket")
+	}
+	if !p.acceleration && opts.TransferAcceleration {
+		unsupported("TransferAcceleration")
+	}
+	return opts, p.lookup, errors.Join(errs...)
+}
@@ -0,0 +1,81 @@
+package cmgs3
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestApplyProvider(t *testing.T) {
+	opts, lookup, err := applyProvider(S3Opts{Provider: "gcs"})
+	if err != nil {
+		t.Fatalf("applyProvider(gcs) failed: %v", err)
+	}
+	if opts.Endpoint != "storage.googleapis.com" || opts.Region != "auto" || lookup != minio.BucketLookupAuto {
+		t.Errorf("applyProvider(gcs) = %q, %q, %v", opts.Endpoint, opts.Region, lookup)
+	}
+	if opts.ConditionalLocks {
+		t.Error("applyProvider(gcs) enabled conditional locks")
+	}
+
+	opts, lookup, err = applyProvider(S3Opts{Provider: "r2", Endpoint: "abc.r2.cloudflarestorage.com"})
+	if err != nil {
+		t.Fatalf("applyProvider(r2) failed: %v", err)
+	}
+	if lookup != minio.BucketLookupPath || opts.RetryBudget == 0 || !opts.ConditionalLocks {
+		t.Errorf("applyProvider(r2) = %+v, %v", opts, lookup)
+	}
+
+	// Explicit settings win over the preset.
+	opts, _, _ = applyProvider(S3Opts{Provider: "r2", Endpoint: "r2", Region: "eu", MaxRetries: 3, Locker: &countingLocker{}})
+	if opts.Region != "eu" || opts.RetryBudget != 0 || opts.ConditionalLocks {
+		t.Errorf("applyProvider(r2) overrode the options: %+v", opts)
+	}
+
+	for _, tt := range []S3Opts{
+		{Provider: "s4"},
+		{Provider: "r2"},
+		{Provider: "wasabi", Index: true},
+		{Provider: "gcs", Checksum: "CRC32C"},
+		{Provider: "spaces", Endpoint: "nyc3.digitaloceanspaces.com", AuditBucket: "audit"},
+		{Provider: "minio", TransferAcceleration: true},
+	} {
+		if _, _, err := applyProvider(tt); err == nil {
+			t.Errorf("applyProvider(%+v) succeeded", tt)
+		}
+	}
+	_, _, err = applyProvider(S3Opts{Provider: "gcs", Index: true, AuditBucket: "audit"})
+	if err == nil || !strings.Contains(err.Error(), "Index") || !strings.Contains(err.Error(), "AuditBucket") {
+		t.Errorf("applyProvider() = %v, expected all unsupported options", err)
+	}
+}
+
+func TestS3Storage_ConditionalLocks(t *testing.T) {
+	opts := testOpts(false)
+	opts.Provider = "minio"
+	storage := setupTestStorageOpts(t, opts)
+	if !storage.conditionalLocks {
+		t.Fatal("minio preset did not enable conditional locks")
+	}
+	ctx := context.Background()
+
+	if err := storage.Lock(ctx, "test/conditional"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	// Another instance that saw no lock file loses the race.
+	if err := storage.putLockFile(ctx, "test/conditional", ""); err != errNotFirst {
+		t.Errorf("putLockFile() over a held lock = %v, expected errNotFirst", err)
+	}
+	if err := storage.putLockFile(ctx, "test/conditional", `"stale"`); err != errNotFirst {
+		t.Errorf("putLockFile() over a changed lock = %v, expected errNotFirst", err)
+	}
+	if err := storage.Unlock(ctx, "test/conditional"); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+	if err := storage.Lock(ctx, "test/conditional"); err != nil {
+		t.Fatalf("Lock() after Unlock() failed: %v", err)
+	}
+	storage.Unlock(ctx, "test/conditional")
+}
@@ -0,0 +1,275 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sam-lord/certmagic"
+)
+
+// proxyMaxRequest bounds the size of a request to ProxyHandler.
+const proxyMaxRequest = 16 << 20
+
+// proxyRequest is the body of a request to ProxyHandler, POSTed to
+// /v1/<operation>. Token is the one lock returned, for unlock.
+type proxyRequest struct {
+	Key       string `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	Recursive bool   `json:"recursive,omitempty"`
+	Token     string `json:"token,omitempty"`
+}
+
+// proxyResponse is the body of an answer of ProxyHandler. NotExist marks
+// errors wrapping fs.ErrNotExist.
+type proxyResponse struct {
+	Value    []byte             `json:"value,omitempty"`
+	Exists   bool               `json:"exists,omitempty"`
+	Keys     []string           `json:"keys,omitempty"`
+	KeyInfo  *certmagic.KeyInfo `json:"key_info,omitempty"`
+	Token    string             `json:"token,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	NotExist bool               `json:"not_exist,omitempty"`
+}
+
+// ProxyHandler serves the operations of storage to ProxyClients, so that
+// nodes can share a storage whose credentials only the proxy holds; see
+// cmd/cmgs3-proxy. It does no authentication itself and is meant to be
+// served with TLS requiring client certificates. Locks taken through it
+// are held by the proxy, and only released by the client that took them,
+// which lock hands a token to; a lock not released after LockExpiration,
+// e.g. of a node that died, is released when it is requested again, and
+// its token no longer releases it.
+//
+// Operations are POSTed as JSON to /v1/<operation>, over HTTP/2 where the
+// client supports it, rather than served with gRPC, which would add
+// grpc-go and protobuf to the dependencies of every user of the module.
+// The protocol is experimental and may change.
+func ProxyHandler(storage certmagic.Storage) http.Handler {
+	p := &proxy{storage: storage, locks: make(map[string]proxyLock)}
+	return http.HandlerFunc(p.serve)
+}
+
+type proxy struct {
+	storage certmagic.Storage
+	mu      sync.Mutex
+	locks   map[string]proxyLock
+}
+
+// proxyLock is a lock held by the proxy for a client.
+type proxyLock struct {
+	token    string
+	acquired time.Time
+}
+
+func (p *proxy) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req proxyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, proxyMaxRequest)).Decode(&req); err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, proxyResponse{Error: err.Error()})
+		return
+	}
+	ctx := r.Context()
+	var resp proxyResponse
+	var err error
+	switch strings.TrimPrefix(r.URL.Path, "/v1/") {
+	case "store":
+		err = p.storage.Store(ctx, req.Key, req.Value)
+	case "load":
+		resp.Value, err = p.storage.Load(ctx, req.Key)
+	case "delete":
+		err = p.storage.Delete(ctx, req.Key)
+	case "exists":
+		resp.Exists = p.storage.Exists(ctx, req.Key)
+	case "list":
+		resp.Keys, err = p.storage.List(ctx, req.Key, req.Recursive)
+	case "stat":
+		var ki certmagic.KeyInfo
+		if ki, err = p.storage.Stat(ctx, req.Key); err == nil {
+			resp.KeyInfo = &ki
+		}
+	case "lock":
+		resp.Token, err = p.lock(ctx, req.Key)
+	case "unlock":
+		err = p.unlock(ctx, req.Key, req.Token)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	code := http.StatusOK
+	if err != nil {
+		resp = proxyResponse{Error: err.Error(), NotExist: errors.Is(err, fs.ErrNotExist)}
+		code = http.StatusInternalServerError
+		if resp.NotExist {
+			code = http.StatusNotFound
+		}
+	}
+	writeAdminJSON(w, code, resp)
+}
+
+func (p *proxy) lock(ctx context.Context, key string) (string, error) {
+	p.mu.Lock()
+	l, held := p.locks[key]
+	stale := held && time.Since(l.acquired) > LockExpiration
+	if stale {
+		delete(p.locks, key)
+	}
+	p.mu.Unlock()
+	if stale {
+		if err := p.storage.Unlock(ctx, key); err != nil {
+			return "", fmt.Errorf("releasing stale lock %s: %w", key, err)
+		}
+	}
+	if err := p.storage.Lock(ctx, key); err != nil {
+		return "", err
+	}
+	token := newOwnerID()
+	p.mu.Lock()
+	p.locks[key] = proxyLock{token: token, acquired: time.Now()}
+	p.mu.Unlock()
+	return token, nil
+}
+
+// unlock releases the lock of key if token is the one it was taken with.
+func (p *proxy) unlock(ctx context.Context, key, token string) error {
+	p.mu.Lock()
+	l, held := p.locks[key]
+	if !held || l.token != token {
+		p.mu.Unlock()
+		return fmt.Errorf("lock %s not held", key)
+	}
+	delete(p.locks, key)
+	p.mu.Unlock()
+	return p.storage.Unlock(ctx, key)
+}
+
+// ProxyClient is a certmagic.Storage using a ProxyHandler.
+type ProxyClient struct {
+	url    string
+	client *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string // of the held locks
+}
+
+var _ certmagic.Storage = (*ProxyClient)(nil)
+
+// NewProxyClient returns a client of the ProxyHandler at url, e.g.
+// "https://proxy.internal:8443", connecting with tlsConfig, which should
+// hold the client certificate.
+func NewProxyClient(url string, tlsConfig *tls.Config) *ProxyClient {
+	return &ProxyClient{
+		url:    strings.TrimSuffix(url, "/"),
+		tokens: make(map[string]string),
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   tlsConfig,
+			ForceAttemptHTTP2: true,
+		}},
+	}
+}
+
+func (c *ProxyClient) call(ctx context.Context, op string, req proxyRequest) (*proxyResponse, error) {
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/v1/"+op, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	hresp, err := c.client.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer hresp.Body.Close()
+	var resp proxyResponse
+	if err := json.NewDecoder(io.LimitReader(hresp.Body, proxyMaxRequest)).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("proxy %s: %s: %w", op, hresp.Status, err)
+	}
+	switch {
+	case resp.NotExist:
+		return nil, fmt.Errorf("%s: %w", resp.Error, fs.ErrNotExist)
+	case resp.Error != "":
+		return nil, errors.New(resp.Error)
+	case hresp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("proxy %s: %s", op, hresp.Status)
+	}
+	return &resp, nil
+}
+
+func (c *ProxyClient) Store(ctx context.Context, key string, value []byte) error {
+	_, err := c.call(ctx, "store", proxyRequest{Key: key, Value: value})
+	return err
+}
+
+func (c *ProxyClient) Load(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.call(ctx, "load", proxyRequest{Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+func (c *ProxyClient) Delete(ctx context.Context, key string) error {
+	_, err := c.call(ctx, "delete", proxyRequest{Key: key})
+	return err
+}
+
+func (c *ProxyClient) Exists(ctx context.Context, key string) bool {
+	resp, err := c.call(ctx, "exists", proxyRequest{Key: key})
+	return err == nil && resp.Exists
+}
+
+func (c *ProxyClient) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	resp, err := c.call(ctx, "list", proxyRequest{Key: prefix, Recursive: recursive})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Keys, nil
+}
+
+func (c *ProxyClient) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	resp, err := c.call(ctx, "stat", proxyRequest{Key: key})
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+	if resp.KeyInfo == nil {
+		return certmagic.KeyInfo{}, fmt.Errorf("proxy stat %s: no key info", key)
+	}
+	return *resp.KeyInfo, nil
+}
+
+func (c *ProxyClient) Lock(ctx context.Context, key string) error {
+	resp, err := c.call(ctx, "lock", proxyRequest{Key: key})
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.tokens[key] = resp.Token
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *ProxyClient) Unlock(ctx context.Context, key string) error {
+	c.mu.Lock()
+	token := c.tokens[key]
+	delete(c.tokens, key)
+	c.mu.Unlock()
+	_, err := c.call(ctx, "unlock", proxyRequest{Key: key, Token: token})
+	return err
+}
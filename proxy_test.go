@@ -0,0 +1,131 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sam-lord/certmagic"
+)
+
+func setupTestProxy(t *testing.T) (*ProxyClient, certmagic.Storage) {
+	backend := &certmagic.FileStorage{Path: t.TempDir()}
+	srv := httptest.NewTLSServer(ProxyHandler(backend))
+	t.Cleanup(srv.Close)
+	return NewProxyClient(srv.URL, srv.Client().Transport.(*http.Transport).TLSClientConfig), backend
+}
+
+func TestProxyClient(t *testing.T) {
+	client, backend := setupTestProxy(t)
+	ctx := context.Background()
+
+	if err := client.Store(ctx, "certificates/example.com/example.com.crt", []byte("cert")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if v, err := backend.Load(ctx, "certificates/example.com/example.com.crt"); err != nil || string(v) != "cert" {
+		t.Errorf("backend has %q, %v", v, err)
+	}
+	if v, err := client.Load(ctx, "certificates/example.com/example.com.crt"); err != nil || string(v) != "cert" {
+		t.Errorf("Load() = %q, %v", v, err)
+	}
+	if !client.Exists(ctx, "certificates/example.com/example.com.crt") {
+		t.Error("Exists() = false")
+	}
+	ki, err := client.Stat(ctx, "certificates/example.com/example.com.crt")
+	if err != nil || ki.Size != 4 || !ki.IsTerminal {
+		t.Errorf("Stat() = %+v, %v", ki, err)
+	}
+	keys, err := client.List(ctx, "certificates", true)
+	if err != nil || !slices.Contains(keys, "certificates/example.com/example.com.crt") {
+		t.Errorf("List() = %v, %v", keys, err)
+	}
+	if err := client.Delete(ctx, "certificates/example.com/example.com.crt"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if _, err := client.Load(ctx, "certificates/example.com/example.com.crt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Load() of a deleted key = %v, expected fs.ErrNotExist", err)
+	}
+	if client.Exists(ctx, "certificates/example.com/example.com.crt") {
+		t.Error("Exists() of a deleted key = true")
+	}
+}
+
+func TestProxyClient_Lock(t *testing.T) {
+	client, _ := setupTestProxy(t)
+	ctx := context.Background()
+
+	if err := client.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	lctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := client.Lock(lctx, "issue_cert_example.com"); err == nil {
+		t.Fatal("Lock() of a held lock succeeded")
+	}
+	if err := client.Unlock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("Unlock() failed: %v", err)
+	}
+	if err := client.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("Lock() after Unlock() failed: %v", err)
+	}
+	client.Unlock(ctx, "issue_cert_example.com")
+}
+
+func TestProxyHandler_StaleLock(t *testing.T) {
+	defer func(d time.Duration) { LockExpiration = d }(LockExpiration)
+	LockExpiration = 50 * time.Millisecond
+	client, _ := setupTestProxy(t)
+	ctx := context.Background()
+
+	if err := client.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	// The node holding the lock died.
+	time.Sleep(2 * LockExpiration)
+	lctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := client.Lock(lctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("Lock() of a stale lock failed: %v", err)
+	}
+	client.Unlock(ctx, "issue_cert_example.com")
+}
+
+func TestProxyHandler_LockOwner(t *testing.T) {
+	defer func(d time.Duration) { LockExpiration = d }(LockExpiration)
+	LockExpiration = 50 * time.Millisecond
+	a, _ := setupTestProxy(t)
+	b := NewProxyClient(a.url, a.client.Transport.(*http.Transport).TLSClientConfig)
+	ctx := context.Background()
+
+	if err := a.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if err := b.Unlock(ctx, "issue_cert_example.com"); err == nil {
+		t.Fatal("Unlock() of a lock another client holds succeeded")
+	}
+
+	// Taken over once stale, the first holder can no longer release it.
+	time.Sleep(2 * LockExpiration)
+	if err := b.Lock(ctx, "issue_cert_example.com"); err != nil {
+		t.Fatalf("Lock() of a stale lock failed: %v", err)
+	}
+	if err := a.Unlock(ctx, "issue_cert_example.com"); err == nil {
+		t.Error("Unlock() of a lock taken over succeeded")
+	}
+	if err := b.Unlock(ctx, "issue_cert_example.com"); err != nil {
+		t.Errorf("Unlock() failed: %v", err)
+	}
+}
+
+func TestProxyHandler_Method(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ProxyHandler(&certmagic.FileStorage{Path: t.TempDir()}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/load", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET answered with %d", rec.Code)
+	}
+}
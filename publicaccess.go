@@ -0,0 +1,130 @@
+package cmgs3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	minio "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// CheckPublicAccess looks for signs that the bucket is readable by anyone:
+// a bucket policy granting read or list access to every principal, and
+// whether an anonymous request may list the storage prefix. It returns one
+// warning per finding; an empty result does not prove the bucket private,
+// as ACLs and account-level settings are beyond what the API exposes.
+func (gs *S3Storage) CheckPublicAccess(ctx context.Context) ([]string, error) {
+	var warnings []string
+
+	policy, err := gs.s3client.GetBucketPolicy(ctx, gs.bucket)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("bucket policy of %s could not be inspected: %v", gs.bucket, err))
+	} else {
+		warnings = append(warnings, publicPolicyStatements(gs.bucket, policy)...)
+	}
+
+	// A plain transport, which neither signs requests like the one of
+	// Multi-Region Access Points nor spends the storage's request limits.
+	transport, err := newBaseTransport(gs.currentOpts())
+	if err != nil {
+		return nil, err
+	}
+	defer transport.CloseIdleConnections()
+	anonOpts := gs.clientOpts
+	anonOpts.Creds = credentials.NewStatic("", "", "", credentials.SignatureAnonymous)
+	anonOpts.Transport = transport
+	anon, err := minio.New(gs.endpoint, &anonOpts)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// An empty listing without an error was allowed just as well.
+	public := true
+	for obj := range anon.ListObjects(ctx, gs.bucket, minio.ListObjectsOptions{Prefix: gs.prefix + "/", MaxKeys: 1}) {
+		public = obj.Err == nil
+		break
+	}
+	if public {
+		warnings = append(warnings, fmt.Sprintf("bucket %s can be listed anonymously", gs.bucket))
+	}
+	return warnings, nil
+}
+
+func (gs *S3Storage) warnPublicAccess(ctx context.Context) {
+	warnings, err := gs.CheckPublicAccess(ctx)
+	if err != nil {
+		gs.logf("Checking public access of bucket %s failed: %v", gs.bucket, err)
+		return
+	}
+	for _, w := range warnings {
+		gs.warnf("private keys may be publicly accessible: %s", w)
+	}
+}
+
+type policyDocument struct {
+	Statement []struct {
+		Effect    string
+		Principal json.RawMessage
+		Action    json.RawMessage
+	}
+}
+
+// publicPolicyStatements returns a warning for each Allow statement of
+// policy that grants read or list access to any principal.
+func publicPolicyStatements(bucket, policy string) []string {
+	if policy == "" {
+		return nil
+	}
+	var doc policyDocument
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return []string{fmt.Sprintf("bucket policy of %s could not be parsed: %v", bucket, err)}
+	}
+
+	var warnings []string
+	for i, st := range doc.Statement {
+		if st.Effect != "Allow" || !isAnyPrincipal(st.Principal) {
+			continue
+		}
+		for _, a := range stringOrList(st.Action) {
+			if isReadAction(a) {
+				warnings = append(warnings, fmt.Sprintf("bucket policy of %s grants %s to everyone (statement %d)", bucket, a, i))
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+func isAnyPrincipal(raw json.RawMessage) bool {
+	var p struct{ AWS json.RawMessage }
+	if json.Unmarshal(raw, &p) == nil && p.AWS != nil {
+		raw = p.AWS
+	}
+	for _, s := range stringOrList(raw) {
+		if s == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func isReadAction(action string) bool {
+	switch strings.ToLower(action) {
+	case "*", "s3:*", "s3:get*", "s3:getobject", "s3:list*", "s3:listbucket":
+		return true
+	}
+	return false
+}
+
+func stringOrList(raw json.RawMessage) []string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return []string{s}
+	}
+	var l []string
+	json.Unmarshal(raw, &l)
+	return l
+}
@@ -0,0 +1,45 @@
+package cmgs3
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPublicPolicyStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   int
+	}{
+		{"no policy", "", 0},
+		{"public read", `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::b/*"}]}`, 1},
+		{"public list for AWS any", `{"Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:PutObject","s3:ListBucket"]}]}`, 1},
+		{"specific principal", `{"Statement":[{"Effect":"Allow","Principal":{"AWS":["arn:aws:iam::1:root"]},"Action":"s3:*"}]}`, 0},
+		{"public deny", `{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*"}]}`, 0},
+		{"public write only", `{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:PutObject"}]}`, 0},
+		{"unparsable", `{`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := publicPolicyStatements("b", tt.policy); len(got) != tt.want {
+				t.Errorf("publicPolicyStatements() = %v, expected %d warnings", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3Storage_CheckPublicAccess(t *testing.T) {
+	storage := setupTestStorage(t, false)
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "test/private.pem", []byte("private")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	warnings, err := storage.CheckPublicAccess(ctx)
+	if err != nil {
+		t.Fatalf("CheckPublicAccess() failed: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("CheckPublicAccess() reported private test bucket as public: %v", warnings)
+	}
+}
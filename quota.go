@@ -0,0 +1,152 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// QuotaRefreshInterval is how often usage is recounted from the bucket,
+// picking up writes of other instances.
+var QuotaRefreshInterval = 5 * time.Minute
+
+// ErrQuotaExceeded matches every *QuotaError.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// QuotaError is returned by Store when a write would exceed S3Opts.MaxObjects
+// or S3Opts.MaxBytes.
+type QuotaError struct {
+	Limit string // "objects" or "bytes"
+	Max   int64
+	Usage int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: %d of %d %s", e.Usage, e.Max, e.Limit)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// quota tracks the objects and bytes stored under the prefix, including
+// dedup blobs. Between refreshes it is kept up to date by this instance's
+// writes; value sizes are counted before encryption.
+type quota struct {
+	maxObjects int64
+	maxBytes   int64
+
+	refreshMu sync.Mutex // held by the one caller recounting
+
+	mu        sync.Mutex
+	objects   int64
+	bytes     int64
+	refreshed time.Time
+}
+
+func (gs *S3Storage) usage(ctx context.Context) (objects, bytes int64, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for _, prefix := range []string{gs.prefix + "/", gs.blobName("")} {
+		blobs := prefix == gs.blobName("")
+		for obj := range gs.s3client.ListObjects(ctx, gs.bucket, minio.ListObjectsOptions{
+			Prefix:       prefix,
+			Recursive:    true,
+			WithMetadata: true,
+		}) {
+			if obj.Err != nil {
+				return 0, 0, obj.Err
+			}
+			if strings.HasSuffix(obj.Key, ".lock") {
+				continue
+			}
+			listedMetadata(&obj)
+			key := gs.keyName(obj.Key)
+			if blobs {
+				key = blobKey(strings.TrimPrefix(obj.Key, prefix))
+			}
+			ki, _ := gs.keyInfo(key, obj)
+			objects++
+			bytes += ki.Size
+		}
+	}
+	return objects, bytes, nil
+}
+
+// reserveQuota accounts for storing size bytes at key, or returns a
+// *QuotaError if that would exceed a limit. Writes that do not grow usage
+// are always allowed. The returned func undoes the reservation.
+func (gs *S3Storage) reserveQuota(ctx context.Context, key string, size int64) (func(), error) {
+	q := gs.quota
+	oi, err := gs.s3client.StatObject(ctx, gs.bucket, gs.objName(key), minio.StatObjectOptions{})
+	var dObjects, dBytes int64 = 1, size
+	if err == nil {
+		ki, _ := gs.keyInfo(key, oi)
+		dObjects, dBytes = 0, size-ki.Size
+	} else if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return nil, err
+	}
+	if err := gs.refreshQuota(ctx); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxObjects > 0 && dObjects > 0 && q.objects+dObjects > q.maxObjects {
+		return nil, &QuotaError{Limit: "objects", Max: q.maxObjects, Usage: q.objects}
+	}
+	if q.maxBytes > 0 && dBytes > 0 && q.bytes+dBytes > q.maxBytes {
+		return nil, &QuotaError{Limit: "bytes", Max: q.maxBytes, Usage: q.bytes}
+	}
+	q.objects += dObjects
+	q.bytes += dBytes
+	return func() { q.add(-dObjects, -dBytes) }, nil
+}
+
+// refreshQuota recounts the usage if it is older than QuotaRefreshInterval.
+// Only one caller recounts; the others go on with the previous counts
+// meanwhile, unless there are none yet.
+func (gs *S3Storage) refreshQuota(ctx context.Context) error {
+	q := gs.quota
+	q.mu.Lock()
+	refreshed := q.refreshed
+	q.mu.Unlock()
+	if time.Since(refreshed) <= QuotaRefreshInterval {
+		return nil
+	}
+	if refreshed.IsZero() {
+		q.refreshMu.Lock()
+	} else if !q.refreshMu.TryLock() {
+		return nil
+	}
+	defer q.refreshMu.Unlock()
+	q.mu.Lock()
+	fresh := time.Since(q.refreshed) <= QuotaRefreshInterval
+	q.mu.Unlock()
+	if fresh {
+		// Recounted by the caller holding refreshMu before.
+		return nil
+	}
+
+	objects, bytes, err := gs.usage(ctx)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	q.objects, q.bytes, q.refreshed = objects, bytes, time.Now()
+	q.mu.Unlock()
+	return nil
+}
+
+func (q *quota) add(objects, bytes int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.objects += objects
+	q.bytes += bytes
+}
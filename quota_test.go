@@ -0,0 +1,86 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestS3Storage_Quota(t *testing.T) {
+	opts := testOpts(false)
+	opts.MaxObjects = 2
+	opts.MaxBytes = 100
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	for _, key := range []string{"test/1", "test/2"} {
+		if err := storage.Store(ctx, key, []byte("value")); err != nil {
+			t.Fatalf("Store(%s) failed: %v", key, err)
+		}
+	}
+	err := storage.Store(ctx, "test/3", []byte("value"))
+	var qe *QuotaError
+	if !errors.As(err, &qe) || qe.Limit != "objects" || !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Store() beyond MaxObjects error = %v, expected objects QuotaError", err)
+	}
+	if err := storage.Store(ctx, "test/1", []byte("overwrite")); err != nil {
+		t.Errorf("overwriting within quota failed: %v", err)
+	}
+	if err := storage.Store(ctx, "test/2", make([]byte, 101)); !errors.As(err, &qe) || qe.Limit != "bytes" {
+		t.Errorf("Store() beyond MaxBytes error = %v, expected bytes QuotaError", err)
+	}
+
+	if err := storage.Delete(ctx, "test/2"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if err := storage.Store(ctx, "test/3", []byte("value")); err != nil {
+		t.Errorf("Store() after Delete freed quota failed: %v", err)
+	}
+}
+
+func TestS3Storage_QuotaStatError(t *testing.T) {
+	opts := testOpts(false)
+	opts.LazyInit = true
+	opts.MaxRetries = 1
+	opts.MaxObjects = 10
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	storage.quota.refreshed = time.Now()
+
+	// The endpoint does not resolve, which says nothing about the key.
+	if _, err := storage.reserveQuota(context.Background(), "test/1", 5); err == nil {
+		t.Error("reserveQuota() counted a key that could not be stat'ed as new")
+	}
+	if storage.quota.objects != 0 {
+		t.Errorf("reserveQuota() reserved %d objects after failing", storage.quota.objects)
+	}
+}
+
+func TestS3Storage_QuotaRefreshConcurrent(t *testing.T) {
+	opts := testOpts(false)
+	opts.LazyInit = true
+	opts.MaxRetries = 1
+	opts.MaxObjects = 10
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	storage.quota.refreshed = time.Now().Add(-2 * QuotaRefreshInterval)
+
+	// Another caller is recounting; this one goes on with the old counts.
+	storage.quota.refreshMu.Lock()
+	defer storage.quota.refreshMu.Unlock()
+	done := make(chan error, 1)
+	go func() { done <- storage.refreshQuota(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("refreshQuota() failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("refreshQuota() waited for the recount in progress")
+	}
+}
@@ -0,0 +1,19 @@
+package cmgs3
+
+import "sync"
+
+// regions caches bucket regions by endpoint and bucket, so constructing
+// many storages for the same bucket costs one lookup.
+var regions sync.Map
+
+func cachedRegion(endpoint, bucket string) string {
+	r, _ := regions.Load(endpoint + "/" + bucket)
+	s, _ := r.(string)
+	return s
+}
+
+func cacheRegion(endpoint, bucket, region string) {
+	if region != "" {
+		regions.Store(endpoint+"/"+bucket, region)
+	}
+}
@@ -0,0 +1,103 @@
+package cmgs3
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Registry holds named storages built from one configuration document,
+// e.g. one per environment or tenant.
+type Registry struct {
+	storages map[string]*S3Storage
+}
+
+// registryDoc is the document read by NewRegistry.
+type registryDoc struct {
+	Defaults json.RawMessage
+	Storages map[string]json.RawMessage
+}
+
+// NewRegistry builds the storages of the JSON document read from r:
+//
+//	{
+//		"Defaults": {"Endpoint": "s3.example.com", "AccessKeyID": "..."},
+//		"Storages": {
+//			"prod": {"Bucket": "certs", "ObjPrefix": "prod"},
+//			"staging": {"Bucket": "certs", "ObjPrefix": "staging"}
+//		}
+//	}
+//
+// Each storage has the S3Opts fields of Defaults, overridden by its own.
+// Fields are named as in S3Opts and encoded as by encoding/json, so
+// durations are in nanoseconds and keys in base64; funcs and interfaces
+// cannot be set. Unknown fields are an error.
+func NewRegistry(r io.Reader) (*Registry, error) {
+	var doc registryDoc
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("storage registry: %w", err)
+	}
+	if len(doc.Storages) == 0 {
+		return nil, errors.New("storage registry: at least one storage is required")
+	}
+
+	reg := &Registry{storages: make(map[string]*S3Storage, len(doc.Storages))}
+	for _, name := range sortedKeys(doc.Storages) {
+		// Decoded afresh for each storage, which must not share slices
+		// or maps with another.
+		var opts S3Opts
+		for _, raw := range []json.RawMessage{doc.Defaults, doc.Storages[name]} {
+			if len(raw) == 0 {
+				continue
+			}
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&opts); err != nil {
+				reg.Close()
+				return nil, fmt.Errorf("storage %s: %w", name, err)
+			}
+		}
+		gs, err := NewS3Storage(opts)
+		if err != nil {
+			reg.Close()
+			return nil, fmt.Errorf("storage %s: %w", name, err)
+		}
+		reg.storages[name] = gs
+	}
+	return reg, nil
+}
+
+// Get returns the storage called name, or nil if there is none.
+func (reg *Registry) Get(name string) *S3Storage {
+	return reg.storages[name]
+}
+
+// Names returns the names of the storages, sorted.
+func (reg *Registry) Names() []string {
+	return sortedKeys(reg.storages)
+}
+
+// Close closes all storages.
+func (reg *Registry) Close() error {
+	var errs []error
+	for _, name := range reg.Names() {
+		if err := reg.storages[name].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("storage %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,60 @@
+package cmgs3
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewRegistry(t *testing.T) {
+	doc := `{
+		"Defaults": {"Endpoint": "` + testEndpoint + `", "Bucket": "` + testBucket + `", "LazyInit": true, "ObjPrefix": "default"},
+		"Storages": {
+			"prod": {"ObjPrefix": "prod", "MaxLoadSize": 1024},
+			"staging": {}
+		}
+	}`
+	reg, err := NewRegistry(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("NewRegistry() failed: %v", err)
+	}
+	defer reg.Close()
+	if names := reg.Names(); len(names) != 2 || names[0] != "prod" || names[1] != "staging" {
+		t.Errorf("Names() = %v", names)
+	}
+	prod, staging := reg.Get("prod"), reg.Get("staging")
+	if prod == nil || staging == nil || reg.Get("dev") != nil {
+		t.Fatalf("Get() = %v, %v, %v", prod, staging, reg.Get("dev"))
+	}
+	if prod.prefix != "prod" || prod.maxLoadSize.Load() != 1024 || prod.bucket != testBucket {
+		t.Errorf("prod storage has prefix %q, MaxLoadSize %d and bucket %q", prod.prefix, prod.maxLoadSize.Load(), prod.bucket)
+	}
+	if staging.prefix != "default" || staging.maxLoadSize.Load() != 0 {
+		t.Errorf("staging storage has prefix %q and MaxLoadSize %d", staging.prefix, staging.maxLoadSize.Load())
+	}
+
+	for _, bad := range []string{
+		`{"Storages": {}}`,
+		`{"Storages": {"a": {"Bukcet": "x"}}}`,
+		`{"Storages": {"a": {"Endpoint": "` + testEndpoint + `", "Bucket": "` + testBucket + `", "LazyInit": true, "EncryptionKey": "c2hvcnQ="}}}`,
+	} {
+		if _, err := NewRegistry(strings.NewReader(bad)); err == nil {
+			t.Errorf("NewRegistry(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestTransportPool(t *testing.T) {
+	var pool transportPool
+	opts := testOpts(false)
+	a, err := pool.get(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := pool.get(opts); a != b {
+		t.Error("get() with the same options returned another transport")
+	}
+	opts.IPFamily = "ipv4"
+	if c, _ := pool.get(opts); c == a {
+		t.Error("get() with another IP family returned the same transport")
+	}
+}
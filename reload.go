@@ -0,0 +1,142 @@
+package cmgs3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// reloadableOpts are the S3Opts fields Reload may change.
+var reloadableOpts = map[string]bool{
+	"AccessKeyID":            true,
+	"SecretAccessKey":        true,
+	"SessionToken":           true,
+	"EncryptionKey":          true,
+	"PreviousEncryptionKeys": true,
+	"MaxLoadSize":            true,
+	"MaxStoreSize":           true,
+	"MaxObjects":             true,
+	"MaxBytes":               true,
+}
+
+// staticCreds provides static credentials that Reload can replace.
+type staticCreds struct {
+	mu    sync.Mutex
+	value credentials.Value
+}
+
+func newStaticCreds(accessKey, secretKey, sessionToken string) *staticCreds {
+	c := &staticCreds{}
+	c.set(accessKey, secretKey, sessionToken)
+	return c
+}
+
+func (c *staticCreds) set(accessKey, secretKey, sessionToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value = credentials.Value{AccessKeyID: accessKey, SecretAccessKey: secretKey, SessionToken: sessionToken, SignerType: credentials.SignatureV4}
+	if accessKey == "" || secretKey == "" {
+		c.value = credentials.Value{SignerType: credentials.SignatureAnonymous}
+	}
+}
+
+func (c *staticCreds) Retrieve() (credentials.Value, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value, nil
+}
+
+func (c *staticCreds) RetrieveWithCredContext(*credentials.CredContext) (credentials.Value, error) {
+	return c.Retrieve()
+}
+
+// IsExpired is false, Reload expires the credentials using them instead.
+func (c *staticCreds) IsExpired() bool {
+	return false
+}
+
+// Reload applies opts to the running storage. The credentials, the
+// encryption key and the size and quota limits may change; the previous
+// key is kept for reading until the process restarts, after which it needs
+// to be among the PreviousEncryptionKeys. Operations in flight finish with
+// the settings they started with. Changes of any other option need a new
+// storage and fail, leaving the storage as it was.
+func (gs *S3Storage) Reload(opts S3Opts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	opts, _, err := applyProvider(opts)
+	if err != nil {
+		return err
+	}
+	gs.optsMu.Lock()
+	defer gs.optsMu.Unlock()
+	old := gs.opts
+
+	var fixed []string
+	ov, nv := reflect.ValueOf(old), reflect.ValueOf(opts)
+	for i := 0; i < ov.NumField(); i++ {
+		name := ov.Type().Field(i).Name
+		if !reloadableOpts[name] && !sameOpt(ov.Field(i), nv.Field(i)) {
+			fixed = append(fixed, name)
+		}
+	}
+	if len(fixed) != 0 {
+		return fmt.Errorf("%s cannot be changed by Reload", strings.Join(fixed, ", "))
+	}
+	credsChanged := opts.AccessKeyID != old.AccessKeyID || opts.SecretAccessKey != old.SecretAccessKey ||
+		opts.SessionToken != old.SessionToken
+	if _, mrap := parseMRAP(opts.Bucket); mrap && credsChanged {
+		return errors.New("credentials of Multi-Region Access Points cannot be changed by Reload")
+	}
+	keyChanged := !bytes.Equal(opts.EncryptionKey, old.EncryptionKey)
+	if keyChanged && (len(opts.EncryptionKey) == 0) != (len(old.EncryptionKey) == 0) {
+		return errors.New("encryption cannot be enabled or disabled by Reload")
+	}
+	if (opts.MaxObjects > 0 || opts.MaxBytes > 0) != (gs.quota != nil) {
+		return errors.New("quotas cannot be enabled or disabled by Reload")
+	}
+
+	gs.scrub.add(opts)
+	if credsChanged {
+		gs.creds.set(opts.AccessKeyID, opts.SecretAccessKey, opts.SessionToken)
+		gs.clientOpts.Creds.Expire()
+	}
+	for _, key := range opts.PreviousEncryptionKeys {
+		gs.retireKey(key)
+	}
+	if keyChanged {
+		gs.rotateKey(opts.EncryptionKey)
+		gs.logf("Encryption key reloaded")
+	}
+	gs.maxLoadSize.Store(opts.MaxLoadSize)
+	gs.maxStoreSize.Store(opts.MaxStoreSize)
+	if q := gs.quota; q != nil {
+		q.mu.Lock()
+		q.maxObjects, q.maxBytes = opts.MaxObjects, opts.MaxBytes
+		q.mu.Unlock()
+	}
+	gs.opts = opts
+	return nil
+}
+
+// sameOpt reports whether two values of an S3Opts field are equal. Funcs
+// are equal if they are the same func.
+func sameOpt(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Func {
+		return a.Pointer() == b.Pointer()
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// currentOpts returns the options as last set by NewS3Storage or Reload.
+func (gs *S3Storage) currentOpts() S3Opts {
+	gs.optsMu.Lock()
+	defer gs.optsMu.Unlock()
+	return gs.opts
+}
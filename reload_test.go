@@ -0,0 +1,83 @@
+package cmgs3
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestS3Storage_Reload(t *testing.T) {
+	opts := testOpts(true)
+	opts.LazyInit = true
+	opts.MaxLoadSize = 1 << 10
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+
+	next := opts
+	next.AccessKeyID, next.SecretAccessKey = "rotated-id", "rotated-secret"
+	next.EncryptionKey = []byte("abcdefghijklmnopqrstuvwxyz012345")
+	next.MaxLoadSize = 1 << 20
+	if err := storage.Reload(next); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	if v, _ := storage.clientOpts.Creds.Get(); v.AccessKeyID != "rotated-id" || v.SecretAccessKey != "rotated-secret" {
+		t.Errorf("credentials after Reload() = %+v", v)
+	}
+	if s, ok := storage.keys.get().(sealer); !ok {
+		t.Error("Reload() disabled encryption")
+	} else if k := s.secretKey(); !bytes.Equal(k[:], next.EncryptionKey) {
+		t.Error("Reload() did not rotate the encryption key")
+	}
+	if n := len(storage.keys.all()); n != 2 {
+		t.Errorf("keyring has %d keys after Reload(), expected the previous one kept", n)
+	}
+	if storage.maxLoadSize.Load() != next.MaxLoadSize || storage.currentOpts().MaxLoadSize != next.MaxLoadSize {
+		t.Error("Reload() did not apply MaxLoadSize")
+	}
+
+	fixed := next
+	fixed.Bucket = "other-bucket"
+	fixed.MaxLoadSize = 1
+	if err := storage.Reload(fixed); err == nil || !strings.Contains(err.Error(), "Bucket") {
+		t.Errorf("Reload() changing the bucket = %v", err)
+	}
+	if storage.maxLoadSize.Load() != next.MaxLoadSize {
+		t.Error("failed Reload() applied MaxLoadSize")
+	}
+
+	cleartext := next
+	cleartext.EncryptionKey = nil
+	if err := storage.Reload(cleartext); err == nil {
+		t.Error("Reload() disabling encryption succeeded")
+	}
+	if err := storage.Reload(next); err != nil {
+		t.Errorf("Reload() of the same options = %v", err)
+	}
+}
+
+func TestS3Storage_SessionToken(t *testing.T) {
+	opts := testOpts(false)
+	opts.LazyInit = true
+	opts.SessionToken = "first-session-token"
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	if v, _ := storage.clientOpts.Creds.Get(); v.SessionToken != opts.SessionToken {
+		t.Errorf("credentials = %+v, expected the session token", v)
+	}
+	if redactOpts(opts)["SessionToken"] != "REDACTED" {
+		t.Error("session token not redacted")
+	}
+
+	// Temporary credentials are renewed with all three parts.
+	opts.AccessKeyID, opts.SecretAccessKey, opts.SessionToken = "ASIANEXT", "next-secret", "next-session-token"
+	if err := storage.Reload(opts); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	if v, _ := storage.clientOpts.Creds.Get(); v.AccessKeyID != "ASIANEXT" || v.SessionToken != opts.SessionToken {
+		t.Errorf("credentials after Reload() = %+v", v)
+	}
+}
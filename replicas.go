@@ -0,0 +1,183 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// ReadReplica is a replica of the bucket, e.g. the destination of S3
+// Cross-Region Replication or MinIO bucket replication, that Load may read
+// from. It is accessed with the storage's credentials, ObjPrefix, Resolver
+// and IPFamily, but not its EndpointIPs and PinnedSPKI, which are those of
+// the bucket's endpoint.
+type ReadReplica struct {
+	Endpoint string
+	Bucket   string
+	// Region is looked up from the bucket if empty.
+	Region string
+	// PinnedSPKI restricts Endpoint like S3Opts.PinnedSPKI does the
+	// bucket's endpoint.
+	PinnedSPKI []string
+}
+
+var (
+	// ReplicaProbeInterval is how often the latency of the replicas is
+	// measured, and replicas taken out after failures are checked again.
+	ReplicaProbeInterval = 30 * time.Second
+	// ReplicaDownTime is how long a failed replica is tried last.
+	ReplicaDownTime = time.Minute
+)
+
+// replica is the bucket or one of its replicas, with the latency of its
+// recent reads.
+type replica struct {
+	endpoint string
+	client   *minio.Client
+	bucket   string
+	primary  bool
+
+	mu        sync.Mutex
+	latency   time.Duration
+	downUntil time.Time
+}
+
+func (r *replica) observe(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.latency == 0 {
+		r.latency = d
+	} else {
+		r.latency = (7*r.latency + 3*d) / 10
+	}
+	r.downUntil = time.Time{}
+}
+
+func (r *replica) fail() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downUntil = time.Now().Add(ReplicaDownTime)
+}
+
+func (r *replica) state(now time.Time) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.latency, now.Before(r.downUntil)
+}
+
+// newReplicas returns the bucket followed by its replicas.
+func (gs *S3Storage) newReplicas(opts S3Opts) ([]*replica, error) {
+	rs := []*replica{{endpoint: opts.Endpoint, client: gs.s3client, bucket: gs.bucket, primary: true}}
+	for _, rr := range opts.ReadReplicas {
+		if rr.Endpoint == "" || rr.Bucket == "" {
+			return nil, errors.New("read replicas need an endpoint and a bucket")
+		}
+		o := opts
+		o.Endpoint = rr.Endpoint
+		o.EndpointIPs = nil
+		o.PinnedSPKI = rr.PinnedSPKI
+		co := gs.clientOpts
+		co.Region = rr.Region
+		if co.Region == "" {
+			co.Region = cachedRegion(rr.Endpoint, rr.Bucket)
+		}
+		var err error
+		if co.Transport, err = newTransport(o, gs.clock, gs.retries, gs.transports); err != nil {
+			return nil, err
+		}
+		client, err := minio.New(rr.Endpoint, &co)
+		if err != nil {
+			return nil, err
+		}
+		client.SetS3EnableDualstack(!opts.DisableDualStack)
+		rs = append(rs, &replica{endpoint: rr.Endpoint, client: client, bucket: rr.Bucket})
+	}
+	return rs, nil
+}
+
+// readOrder returns the replicas to read from, healthy ones by latency
+// first, the bucket itself winning ties.
+func (gs *S3Storage) readOrder() []*replica {
+	now := time.Now()
+	type ranked struct {
+		r       *replica
+		latency time.Duration
+		down    bool
+	}
+	rs := make([]ranked, len(gs.replicas))
+	for i, r := range gs.replicas {
+		l, down := r.state(now)
+		rs[i] = ranked{r, l, down}
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		if rs[i].down != rs[j].down {
+			return !rs[i].down
+		}
+		return rs[i].latency < rs[j].latency
+	})
+	out := make([]*replica, len(rs))
+	for i, r := range rs {
+		out[i] = r.r
+	}
+	return out
+}
+
+// getReplicated reads obj from the replicas in order, usually readOrder,
+// failing over to the next. A replica missing the object may lag behind,
+// the bucket itself decides whether it exists.
+func (gs *S3Storage) getReplicated(ctx context.Context, order []*replica, obj string, into *bytes.Buffer) ([]byte, minio.ObjectInfo, error) {
+	var lastErr error
+	for _, r := range order {
+		start := time.Now()
+		raw, oi, err := gs.getObjectFrom(ctx, r.client, r.bucket, obj, into)
+		if err == nil {
+			r.observe(time.Since(start))
+			return raw, oi, nil
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrObjectTooLarge) {
+			return nil, oi, err
+		}
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			if r.primary {
+				return nil, oi, err
+			}
+			r.observe(time.Since(start))
+		} else {
+			r.fail()
+		}
+		lastErr = err
+	}
+	return nil, minio.ObjectInfo{}, lastErr
+}
+
+// probeReplicas measures the latency of every replica until Close.
+func (gs *S3Storage) probeReplicas() {
+	probe := func() {
+		for _, r := range gs.replicas {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			start := time.Now()
+			if ok, err := r.client.BucketExists(ctx, r.bucket); err != nil || !ok {
+				r.fail()
+			} else {
+				r.observe(time.Since(start))
+			}
+			cancel()
+		}
+	}
+	probe()
+	tick := time.NewTicker(ReplicaProbeInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-gs.stop:
+			return
+		case <-tick.C:
+			probe()
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestS3Storage_ReadReplicas(t *testing.T) {
+	opts := testOpts(false)
+	opts.Bucket = testBucket2
+	mirror := setupTestStorageOpts(t, opts)
+	storage := setupTestStorage(t, false)
+	ctx := context.Background()
+
+	unreachable, err := minio.New("127.0.0.1:1", &minio.Options{Creds: storage.clientOpts.Creds, Secure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := &replica{client: storage.s3client, bucket: testBucket, primary: true, latency: time.Second}
+	fast := &replica{client: mirror.s3client, bucket: testBucket2, latency: time.Millisecond}
+	down := &replica{client: unreachable, bucket: testBucket2, latency: time.Microsecond}
+	storage.replicas = []*replica{primary, fast, down}
+
+	if err := storage.Store(ctx, "test/replicated", []byte("primary")); err != nil {
+		t.Fatal(err)
+	}
+	// Replication has not caught up yet.
+	if v, err := storage.Load(ctx, "test/replicated"); err != nil || string(v) != "primary" {
+		t.Errorf("Load() of a value missing from the replica = %q, %v", v, err)
+	}
+	if _, down := down.state(time.Now()); !down {
+		t.Error("failed replica not taken out")
+	}
+
+	if err := mirror.Store(ctx, "test/replicated", []byte("replica")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := storage.Load(ctx, "test/replicated"); err != nil || string(v) != "replica" {
+		t.Errorf("Load() = %q, %v, expected the faster replica's value", v, err)
+	}
+	if order := storage.readOrder(); order[0] != fast || order[2] != down {
+		t.Errorf("readOrder() does not prefer the fast replica and demote the failed one")
+	}
+
+	if _, err := storage.Load(ctx, "test/not-replicated"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Load() of a missing key error = %v, expected fs.ErrNotExist", err)
+	}
+}
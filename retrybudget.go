@@ -0,0 +1,164 @@
+package cmgs3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrRetryBudgetExhausted is returned for a failed request that was not
+// retried because the retry budget is used up, see S3Opts.RetryBudget.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryBudgetBurst is the number of retries the budget holds when full.
+var RetryBudgetBurst = 10.0
+
+const (
+	// maxReplayBody is the largest request body buffered to be retried.
+	maxReplayBody = 1 << 20
+	retryUnit     = 100 * time.Millisecond
+	retryCap      = time.Second
+)
+
+// RetryStats describes the retry budget.
+type RetryStats struct {
+	// Tokens is the number of retries currently available.
+	Tokens float64
+	// Retries counts requests sent again after a failure.
+	Retries uint64
+	// Exhausted counts failures not retried for lack of budget.
+	Exhausted uint64
+}
+
+// retryBudget is a token bucket of retries shared by all requests of a
+// storage, refilled at rate per second. Retries are taken from it instead
+// of the client retrying every request on its own, so an outage does not
+// multiply the request rate.
+type retryBudget struct {
+	rate     float64
+	attempts int
+
+	mu    sync.Mutex
+	stats RetryStats
+	last  time.Time
+}
+
+func newRetryBudget(rate float64, attempts int) *retryBudget {
+	if attempts <= 0 {
+		attempts = 10
+	}
+	return &retryBudget{rate: rate, attempts: attempts, stats: RetryStats{Tokens: RetryBudgetBurst}, last: time.Now()}
+}
+
+// take takes a retry from the budget, if there is one.
+func (b *retryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.stats.Tokens = min(RetryBudgetBurst, b.stats.Tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.stats.Tokens < 1 {
+		b.stats.Exhausted++
+		return false
+	}
+	b.stats.Tokens--
+	b.stats.Retries++
+	return true
+}
+
+func (b *retryBudget) snapshot() RetryStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.stats
+	s.Tokens = min(RetryBudgetBurst, s.Tokens+time.Since(b.last).Seconds()*b.rate)
+	return s
+}
+
+// budgetTransport retries failed requests while the budget allows. The
+// client itself is configured not to retry.
+type budgetTransport struct {
+	budget *retryBudget
+	base   http.RoundTripper
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		if req.ContentLength < 0 || req.ContentLength > maxReplayBody {
+			return t.base.RoundTrip(req)
+		}
+		buf, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(buf)), nil }
+		req.Body, _ = req.GetBody()
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if !retryable(req, resp, err) || attempt == t.budget.attempts {
+			return resp, err
+		}
+		if !t.budget.take() {
+			if err == nil {
+				resp.Body.Close()
+				err = errors.New(resp.Status)
+			}
+			return nil, fmt.Errorf("%w: %w", ErrRetryBudgetExhausted, err)
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		backoff := min(retryCap, retryUnit<<(attempt-1))
+		timer := time.NewTimer(backoff/2 + rand.N(backoff/2))
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// retryable reports whether the outcome of req is worth another attempt,
+// by the rules the S3 client applies to its own retries.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, 499,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout, 520:
+		return true
+	}
+	return false
+}
+
+// RetryStats returns the state of the retry budget, or the zero value
+// without S3Opts.RetryBudget.
+func (gs *S3Storage) RetryStats() RetryStats {
+	if gs.retries == nil {
+		return RetryStats{}
+	}
+	return gs.retries.snapshot()
+}
@@ -0,0 +1,81 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBudgetTransport(t *testing.T) {
+	old := RetryBudgetBurst
+	RetryBudgetBurst = 3
+	defer func() { RetryBudgetBurst = old }()
+
+	var calls, failures atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "" && string(body) != "value" {
+			t.Errorf("request body %q, expected the value on every attempt", body)
+		}
+		if failures.Add(-1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	budget := newRetryBudget(0, 5)
+	rt := &budgetTransport{budget: budget, base: http.DefaultTransport}
+	do := func(body string) (*http.Response, error) {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPut, srv.URL, io.NopCloser(strings.NewReader(body)))
+		req.ContentLength = int64(len(body))
+		return rt.RoundTrip(req)
+	}
+
+	failures.Store(2)
+	resp, err := do("value")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("RoundTrip() = %v, %v, expected success after retries", resp, err)
+	}
+	resp.Body.Close()
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d attempts, expected 3", n)
+	}
+
+	// One retry is left, the outage uses it up.
+	failures.Store(100)
+	if _, err := do(""); !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Errorf("RoundTrip() error = %v, expected ErrRetryBudgetExhausted", err)
+	}
+	if _, err := do(""); !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Errorf("RoundTrip() error = %v, expected ErrRetryBudgetExhausted", err)
+	}
+	st := budget.snapshot()
+	if st.Retries != 3 || st.Exhausted != 2 || st.Tokens != 0 {
+		t.Errorf("stats = %+v, expected 3 retries and 2 exhaustions", st)
+	}
+}
+
+func TestS3Storage_RetryBudget(t *testing.T) {
+	opts := testOpts(false)
+	opts.RetryBudget = 1
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "test/retry-budget", []byte("value")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if v, err := storage.Load(ctx, "test/retry-budget"); err != nil || string(v) != "value" {
+		t.Fatalf("Load() = %q, %v", v, err)
+	}
+	var sb strings.Builder
+	storage.WriteMetrics(&sb)
+	if !strings.Contains(sb.String(), "cmgs3_retry_budget_tokens ") {
+		t.Errorf("WriteMetrics() lacks the retry budget:\n%s", sb.String())
+	}
+}
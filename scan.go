@@ -0,0 +1,198 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+	"github.com/sam-lord/certmagic"
+)
+
+// ScanTTL is how long metadata gathered by a scan answers Stat and the
+// existence check of Load.
+var ScanTTL = time.Minute
+
+// scanCache holds what recursive listings learned about keys, so the Stat
+// and Load calls of a following maintenance cycle need no HEAD requests.
+type scanCache struct {
+	mu      sync.Mutex
+	entries map[string]scanEntry
+}
+
+type scanEntry struct {
+	ki      certmagic.KeyInfo
+	exact   bool // ki is what Stat would return
+	expires time.Time
+}
+
+func newScanCache() *scanCache {
+	return &scanCache{entries: make(map[string]scanEntry)}
+}
+
+func (c *scanCache) record(key string, ki certmagic.KeyInfo, exact bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = scanEntry{ki: ki, exact: exact, expires: time.Now().Add(ScanTTL)}
+}
+
+func (c *scanCache) lookup(key string) (scanEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok && time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return e, false
+	}
+	return e, ok
+}
+
+func (c *scanCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// scanned reports whether a recent listing saw key.
+func (gs *S3Storage) scanned(key string) bool {
+	if gs.scans == nil {
+		return false
+	}
+	_, ok := gs.scans.lookup(key)
+	return ok
+}
+
+// keyInfo derives the KeyInfo of key from oi. It reports whether the result
+// is exact, which needs the metadata written by Store.
+func (gs *S3Storage) keyInfo(key string, oi minio.ObjectInfo) (certmagic.KeyInfo, bool) {
+	ki := certmagic.KeyInfo{
+		Key:        key,
+		Size:       oi.Size,
+		Modified:   oi.LastModified,
+		IsTerminal: true,
+	}
+	n, err := strconv.ParseInt(oi.UserMetadata[metaPlaintextLength], 10, 64)
+	exact := err == nil
+	if exact {
+		ki.Size = n
+	} else if s, ok := gs.ioFor(key).(sealer); ok && ki.Size >= s.overhead() {
+		// Written before the length was recorded.
+		ki.Size -= s.overhead()
+	}
+	if mt, err := time.Parse(time.RFC3339Nano, oi.UserMetadata[metaModified]); err == nil {
+		ki.Modified = mt
+	}
+	return ki, exact
+}
+
+// listedMetadata converts the metadata of listings, which MinIO returns
+// with the X-Amz-Meta- prefix, to the form of StatObject.
+func listedMetadata(oi *minio.ObjectInfo) {
+	um := make(minio.StringMap, len(oi.UserMetadata))
+	for k, v := range oi.UserMetadata {
+		if len(k) > len("X-Amz-Meta-") && strings.EqualFold(k[:len("X-Amz-Meta-")], "X-Amz-Meta-") {
+			um[http.CanonicalHeaderKey(k[len("X-Amz-Meta-"):])] = v
+		}
+	}
+	oi.UserMetadata = um
+}
+
+// Scan returns the KeyInfo of every key under prefix, as Stat would, with
+// as few requests as possible: metadata comes with the listing where the
+// provider supports it (MinIO), and is fetched per key otherwise. Like
+// List, it sees the shards of ShardCertificates and the files in bundles,
+// and leaves out values Undecryptable has skipped.
+func (gs *S3Storage) Scan(ctx context.Context, prefix string) ([]certmagic.KeyInfo, error) {
+	ctx = withDefaultPriority(ctx, PriorityBulk)
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		if err := gs.access("list", prefix, namespaceOf(prefix+"/"), PermRead); err != nil {
+			return nil, err
+		}
+	}
+	if err := gs.ready(ctx); err != nil {
+		return nil, err
+	}
+
+	prefixes := []string{prefix}
+	if gs.shardCerts {
+		// The shards are scanned one after the other, which bulk work
+		// can afford.
+		if prefixes = shardedListPrefixes(prefix); prefixes == nil {
+			prefixes = []string{shardedNamespace}
+		}
+	}
+	var infos []certmagic.KeyInfo
+	seen := make(map[string]bool)
+	for _, p := range prefixes {
+		if err := gs.scanPrefix(ctx, p, prefix == "", func(ki certmagic.KeyInfo) {
+			if !seen[ki.Key] {
+				seen[ki.Key] = true
+				infos = append(infos, ki)
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+// scanPrefix lists the objects under the storage key prefix for Scan and
+// hands the KeyInfo of every key to add.
+func (gs *S3Storage) scanPrefix(ctx context.Context, prefix string, root bool, add func(certmagic.KeyInfo)) error {
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for obj := range gs.s3client.ListObjects(lctx, gs.bucket, minio.ListObjectsOptions{
+		Prefix:       gs.objListPrefix(prefix),
+		Recursive:    true,
+		WithMetadata: true,
+	}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if strings.HasSuffix(obj.Key, ".lock") {
+			continue
+		}
+		key := gs.keyName(obj.Key)
+		if root && len(gs.readable([]string{key})) == 0 {
+			continue
+		}
+		listedMetadata(&obj)
+		if gs.undecryptablePolicy != UndecryptableFail && gs.sealedWithUnknownKey(obj) {
+			continue
+		}
+		if gs.bundles && isBundleKey(key) {
+			files, err := gs.loadBundle(ctx, key, false)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			base := strings.TrimSuffix(key, bundleSuffix)
+			for ext, f := range files {
+				add(certmagic.KeyInfo{Key: base + ext, Modified: f.Modified, Size: int64(len(f.Value)), IsTerminal: true})
+			}
+			continue
+		}
+		ki, exact := gs.keyInfo(key, obj)
+		if !exact {
+			oi, err := gs.s3client.StatObject(ctx, gs.bucket, obj.Key, minio.StatObjectOptions{})
+			if err != nil {
+				// Removed since it was listed.
+				continue
+			}
+			ki, _ = gs.keyInfo(key, oi)
+		}
+		if gs.scans != nil {
+			gs.scans.record(key, ki, true)
+		}
+		add(ki)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"reflect"
+	"sort"
+	"testing"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestS3Storage_Scan(t *testing.T) {
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+	values := map[string]string{"test/scan/a": "aaa", "test/scan/b": "bbbbbb"}
+	for k, v := range values {
+		if err := storage.Store(ctx, k, []byte(v)); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+
+	infos, err := storage.Scan(ctx, "test/scan")
+	if err != nil {
+		t.Fatalf("Scan() failed: %v", err)
+	}
+	if len(infos) != len(values) {
+		t.Fatalf("Scan() returned %d keys, expected %d", len(infos), len(values))
+	}
+	for _, ki := range infos {
+		want, err := storage.Stat(ctx, ki.Key)
+		if err != nil {
+			t.Fatalf("Stat() failed: %v", err)
+		}
+		if ki.Size != int64(len(values[ki.Key])) || !ki.Modified.Equal(want.Modified) {
+			t.Errorf("Scan() = %+v, Stat() = %+v", ki, want)
+		}
+	}
+}
+
+func TestS3Storage_CoalesceScans(t *testing.T) {
+	opts := testOpts(true)
+	opts.CoalesceScans = true
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+	if err := storage.Store(ctx, "test/coalesce", []byte("value")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if _, err := storage.List(ctx, "test", true); err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+
+	// Removed behind the storage's back, Stat is answered from the listing.
+	if err := storage.s3client.RemoveObject(ctx, testBucket, storage.objName("test/coalesce"), minio.RemoveObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ki, err := storage.Stat(ctx, "test/coalesce")
+	if err != nil || ki.Size != 5 {
+		t.Errorf("Stat() = %+v, %v, expected value from listing", ki, err)
+	}
+	if _, err := storage.Load(ctx, "test/coalesce"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Load() of removed key error = %v, expected fs.ErrNotExist", err)
+	}
+
+	if err := storage.Store(ctx, "test/coalesce", []byte("longer value")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if ki, err := storage.Stat(ctx, "test/coalesce"); err != nil || ki.Size != 12 {
+		t.Errorf("Stat() after Store = %+v, %v, expected fresh size", ki, err)
+	}
+}
+
+func TestS3Storage_ScanShardedBundled(t *testing.T) {
+	opts := testOpts(false)
+	opts.ObjPrefix = testPrefix + "-scan-sharded"
+	opts.ShardCertificates = true
+	opts.Bundle = true
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		storage.s3client.RemoveObject(ctx, storage.bucket, storage.layoutName(), minio.RemoveObjectOptions{})
+	})
+
+	keys := []string{
+		"certificates/acme-v02/a.example.com/a.example.com.crt",
+		"certificates/acme-v02/a.example.com/a.example.com.key",
+		"certificates/acme-v02/b.example.com/b.example.com.crt",
+	}
+	for _, key := range keys {
+		if err := storage.Store(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Store(%s) failed: %v", key, err)
+		}
+	}
+
+	for _, prefix := range []string{"", "certificates", "certificates/acme-v02"} {
+		infos, err := storage.Scan(ctx, prefix)
+		if err != nil {
+			t.Fatalf("Scan(%q) failed: %v", prefix, err)
+		}
+		var got []string
+		for _, ki := range infos {
+			got = append(got, ki.Key)
+			if ki.Size != int64(len(ki.Key)) {
+				t.Errorf("Scan(%q) size of %s = %d, expected %d", prefix, ki.Key, ki.Size, len(ki.Key))
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, keys) {
+			t.Errorf("Scan(%q) = %v, expected %v", prefix, got, keys)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package cmgs3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"time"
+)
+
+// SelfTestPrefix is the namespace of the sentinel keys written by SelfTest.
+const SelfTestPrefix = "diagnostics"
+
+// SelfTestStep is the outcome of one operation of a SelfTest run.
+type SelfTestStep struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// SelfTestReport lists the steps of a SelfTest run in order.
+type SelfTestReport struct {
+	Key   string
+	Steps []SelfTestStep
+}
+
+// OK reports whether every step succeeded.
+func (r SelfTestReport) OK() bool {
+	for _, s := range r.Steps {
+		if s.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Err joins the errors of failed steps.
+func (r SelfTestReport) Err() error {
+	var errs []error
+	for _, s := range r.Steps {
+		if s.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name, s.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SelfTest exercises every storage operation on a sentinel key under
+// SelfTestPrefix, e.g. as a readiness check. All steps are run and
+// reported even if earlier ones fail; the sentinel is removed at the end.
+func (gs *S3Storage) SelfTest(ctx context.Context) SelfTestReport {
+	var id [8]byte
+	rand.Read(id[:])
+	dir := SelfTestPrefix + "/" + hex.EncodeToString(id[:])
+	key := dir + "/sentinel"
+	value := []byte("cmgs3 self-test " + time.Now().UTC().Format(time.RFC3339Nano))
+
+	rep := SelfTestReport{Key: key}
+	step := func(name string, f func() error) {
+		start := time.Now()
+		err := f()
+		rep.Steps = append(rep.Steps, SelfTestStep{Name: name, Duration: time.Since(start), Err: err})
+	}
+
+	step("lock", func() error { return gs.Lock(ctx, key) })
+	step("store", func() error { return gs.Store(ctx, key, value) })
+	step("load", func() error {
+		buf, err := gs.Load(ctx, key)
+		if err == nil && !bytes.Equal(buf, value) {
+			err = errors.New("loaded value differs from stored value")
+		}
+		return err
+	})
+	step("stat", func() error {
+		ki, err := gs.Stat(ctx, key)
+		if err == nil && ki.Size != int64(len(value)) {
+			err = fmt.Errorf("size %d, expected %d", ki.Size, len(value))
+		}
+		return err
+	})
+	step("list", func() error {
+		keys, err := gs.List(ctx, dir, true)
+		if err == nil && !slices.Contains(keys, key) {
+			err = errors.New("sentinel not listed")
+		}
+		return err
+	})
+	step("unlock", func() error { return gs.Unlock(ctx, key) })
+	step("delete", func() error {
+		if err := gs.Delete(ctx, key); err != nil {
+			return err
+		}
+		if _, err := gs.Load(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("sentinel still loadable after delete: %v", err)
+		}
+		return nil
+	})
+	return rep
+}
@@ -0,0 +1,30 @@
+package cmgs3
+
+import (
+	"context"
+	"testing"
+)
+
+func TestS3Storage_SelfTest(t *testing.T) {
+	storage := setupTestStorage(t, true)
+	rep := storage.SelfTest(context.Background())
+	if !rep.OK() {
+		t.Fatalf("SelfTest() failed: %v", rep.Err())
+	}
+	if len(rep.Steps) != 7 {
+		t.Errorf("SelfTest() reported %d steps, expected 7", len(rep.Steps))
+	}
+	if storage.Exists(context.Background(), rep.Key) {
+		t.Error("SelfTest() left its sentinel behind")
+	}
+}
+
+func TestS3Storage_SelfTestDenied(t *testing.T) {
+	opts := testOpts(false)
+	opts.Namespaces = map[string]Permission{SelfTestPrefix + "/": PermRead}
+	storage := setupTestStorageOpts(t, opts)
+	rep := storage.SelfTest(context.Background())
+	if rep.OK() || rep.Err() == nil {
+		t.Error("SelfTest() succeeded without write permission")
+	}
+}
@@ -0,0 +1,145 @@
+package cmgs3
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+)
+
+// sigV4AAlgorithm is the algorithm of AWS Signature Version 4A, which
+// Multi-Region Access Points require: requests are signed with an ECDSA
+// key derived from the secret key, valid in a set of regions.
+const sigV4AAlgorithm = "AWS4-ECDSA-P256-SHA256"
+
+// mrapEndpoint serves all Multi-Region Access Points, addressed by alias.
+const mrapEndpoint = "accesspoint.s3-global.amazonaws.com"
+
+// parseMRAP returns the alias of a Multi-Region Access Point ARN, such as
+// arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap.
+func parseMRAP(arn string) (string, bool) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "s3" || parts[3] != "" {
+		return "", false
+	}
+	alias, ok := strings.CutPrefix(parts[5], "accesspoint/")
+	return alias, ok && strings.HasSuffix(alias, ".mrap")
+}
+
+var p256NMinusTwo = new(big.Int).Sub(elliptic.P256().Params().N, big.NewInt(2))
+
+// deriveSigV4AKey derives the signing key of a key pair as specified for
+// SigV4A: candidates from the NIST SP 800-108 counter mode KDF with
+// HMAC-SHA256 until one is below n-1.
+func deriveSigV4AKey(accessKey, secretKey string) (*ecdsa.PrivateKey, error) {
+	curve := elliptic.P256()
+	bitLen := curve.Params().BitSize
+	mac := hmac.New(sha256.New, []byte("AWS4A"+secretKey))
+	for counter := 1; counter <= 0xff; counter++ {
+		mac.Reset()
+		binary.Write(mac, binary.BigEndian, uint32(1))
+		mac.Write([]byte(sigV4AAlgorithm))
+		mac.Write([]byte{0})
+		mac.Write([]byte(accessKey))
+		mac.Write([]byte{byte(counter)})
+		binary.Write(mac, binary.BigEndian, uint32(bitLen))
+		d := new(big.Int).SetBytes(mac.Sum(nil)[:bitLen/8])
+		if d.Cmp(p256NMinusTwo) < 0 {
+			d.Add(d, big.NewInt(1))
+			priv := &ecdsa.PrivateKey{D: d}
+			priv.PublicKey.Curve = curve
+			priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+			return priv, nil
+		}
+	}
+	return nil, errors.New("no sigv4a key candidate")
+}
+
+// sigV4ATransport signs requests, which the client sends unsigned, with
+// SigV4A valid in all regions.
+type sigV4ATransport struct {
+	accessKey    string
+	sessionToken string
+	key          *ecdsa.PrivateKey
+	clock        *serverClock
+	base         http.RoundTripper
+}
+
+func (t *sigV4ATransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if _, err := t.sign(req); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// sign sets the signature headers of req and returns the signed string.
+func (t *sigV4ATransport) sign(req *http.Request) (string, error) {
+	now := t.clock.now().UTC()
+	date := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Region-Set", "*")
+	if t.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", t.sessionToken)
+	}
+	payload := req.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		payload = "UNSIGNED-PAYLOAD"
+		req.Header.Set("X-Amz-Content-Sha256", payload)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	names := []string{"host"}
+	for k := range req.Header {
+		if k != "Authorization" && k != "User-Agent" && k != "Accept-Encoding" {
+			names = append(names, strings.ToLower(k))
+		}
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, k := range names {
+		v := host
+		if k != "host" {
+			vals := req.Header.Values(k)
+			for i := range vals {
+				vals[i] = strings.Join(strings.Fields(vals[i]), " ")
+			}
+			v = strings.Join(vals, ",")
+		}
+		headers.WriteString(k + ":" + v + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		s3utils.EncodePath(req.URL.Path),
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		headers.String(),
+		signed,
+		payload,
+	}, "\n")
+
+	scope := date[:8] + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	stringToSign := sigV4AAlgorithm + "\n" + date + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	digest := sha256.Sum256([]byte(stringToSign))
+	sig, err := ecdsa.SignASN1(rand.Reader, t.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", sigV4AAlgorithm+" Credential="+t.accessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+hex.EncodeToString(sig))
+	return stringToSign, nil
+}
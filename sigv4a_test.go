@@ -0,0 +1,93 @@
+package cmgs3
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseMRAP(t *testing.T) {
+	tests := []struct {
+		arn   string
+		alias string
+		ok    bool
+	}{
+		{"arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap", "mfzwi23gnjvgw.mrap", true},
+		{"arn:aws:s3:us-east-1:123456789012:accesspoint/single", "", false},
+		{"arn:aws:s3::123456789012:accesspoint/no-suffix", "", false},
+		{"certmagic-bucket", "", false},
+	}
+	for _, tt := range tests {
+		if alias, ok := parseMRAP(tt.arn); ok != tt.ok || ok && alias != tt.alias {
+			t.Errorf("parseMRAP(%q) = %q, %v", tt.arn, alias, ok)
+		}
+	}
+}
+
+func TestDeriveSigV4AKey(t *testing.T) {
+	// The key derivation test vector of the AWS SDKs.
+	key, err := deriveSigV4AKey("AKISORANDOMAASORANDOM", "q+jcrXGc+0zWN6uzclKVhvMmUsIfRPa4rlRandom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := fmt.Sprintf("%x", key.D.Bytes()); d != "7fd3bd010c0d9c292141c2b77bfbde1042c92e6836fff749d1269ec890fca1bd" {
+		t.Errorf("derived key %s", d)
+	}
+}
+
+func TestSigV4ATransport(t *testing.T) {
+	var auth, regions string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, regions = r.Header.Get("Authorization"), r.Header.Get("X-Amz-Region-Set")
+	}))
+	defer srv.Close()
+
+	key, _ := deriveSigV4AKey("AKID", "secret")
+	rt := &sigV4ATransport{accessKey: "AKID", key: key, clock: &serverClock{}, base: http.DefaultTransport}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/certificates/a?versionId=1", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if req.Header.Get("Authorization") != "" {
+		t.Error("RoundTrip() modified the request")
+	}
+
+	m := regexp.MustCompile(`^AWS4-ECDSA-P256-SHA256 Credential=AKID/\d{8}/s3/aws4_request, SignedHeaders=(\S+), Signature=([0-9a-f]+)$`).FindStringSubmatch(auth)
+	if m == nil {
+		t.Fatalf("Authorization = %q", auth)
+	}
+	if m[1] != "host;x-amz-content-sha256;x-amz-date;x-amz-region-set" {
+		t.Errorf("SignedHeaders = %s", m[1])
+	}
+	if regions != "*" {
+		t.Errorf("X-Amz-Region-Set = %q", regions)
+	}
+
+	req, _ = http.NewRequest(http.MethodPut, srv.URL+"/certificates/a", nil)
+	signed, err := rt.sign(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = regexp.MustCompile(`Signature=([0-9a-f]+)$`).FindStringSubmatch(req.Header.Get("Authorization"))
+	sig, _ := hex.DecodeString(m[1])
+	digest := sha256.Sum256([]byte(signed))
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Error("signature does not verify")
+	}
+
+	// Temporary credentials send their token, signed.
+	rt.sessionToken = "token"
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/certificates/a", nil)
+	rt.sign(req)
+	if req.Header.Get("X-Amz-Security-Token") != "token" || !strings.Contains(req.Header.Get("Authorization"), ";x-amz-security-token,") {
+		t.Errorf("token not signed: %q", req.Header.Get("Authorization"))
+	}
+}
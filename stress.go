@@ -0,0 +1,138 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sam-lord/certmagic"
+)
+
+// StressOpts configures a StressTest run.
+type StressOpts struct {
+	// Workers is the number of goroutines competing for the lock.
+	Workers int
+	// Iterations is the number of critical sections each worker enters.
+	Iterations int
+	// Key is the storage key used as lock name and counter object.
+	Key string
+}
+
+// StressReport summarizes a StressTest run.
+type StressReport struct {
+	Operations int
+	// Overlaps counts critical sections entered while another worker held the lock.
+	Overlaps int
+	// LostUpdates is the difference between the increments stored and the
+	// final counter value; failed locks and steps are not expected to count.
+	LostUpdates int
+	// Corruptions counts loads inside a critical section that did not match the preceding store.
+	Corruptions int
+	Errors      []error
+}
+
+// OK reports whether the run observed neither exclusion nor integrity violations.
+func (r StressReport) OK() bool {
+	return r.Overlaps == 0 && r.LostUpdates == 0 && r.Corruptions == 0 && len(r.Errors) == 0
+}
+
+// StressTest hammers Lock/Store/Load on s from opts.Workers goroutines. Every
+// worker increments a shared counter inside the lock; any overlap of critical
+// sections, lost increment or mismatched read-back is recorded in the report.
+func StressTest(ctx context.Context, s certmagic.Storage, opts StressOpts) (StressReport, error) {
+	ctx = withDefaultPriority(ctx, PriorityBulk)
+	if opts.Workers <= 0 || opts.Iterations <= 0 {
+		return StressReport{}, errors.New("workers and iterations must be positive")
+	}
+	if opts.Key == "" {
+		opts.Key = "stress/counter"
+	}
+	if err := s.Store(ctx, opts.Key, []byte("0")); err != nil {
+		return StressReport{}, err
+	}
+
+	var (
+		rep        StressReport
+		increments int
+		mu         sync.Mutex
+		inside     int32
+		wg         sync.WaitGroup
+	)
+	record := func(f func(r *StressReport)) {
+		mu.Lock()
+		f(&rep)
+		mu.Unlock()
+	}
+
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < opts.Iterations; i++ {
+				if err := s.Lock(ctx, opts.Key); err != nil {
+					record(func(r *StressReport) { r.Errors = append(r.Errors, fmt.Errorf("worker %d: lock: %w", w, err)) })
+					continue
+				}
+				if atomic.AddInt32(&inside, 1) > 1 {
+					record(func(r *StressReport) { r.Overlaps++ })
+				}
+				stored, err := stressStep(ctx, s, opts.Key)
+				atomic.AddInt32(&inside, -1)
+				if uerr := s.Unlock(ctx, opts.Key); uerr != nil && err == nil {
+					err = fmt.Errorf("unlock: %w", uerr)
+				}
+				record(func(r *StressReport) {
+					r.Operations++
+					if stored {
+						increments++
+					}
+					if errors.Is(err, errStressCorrupt) {
+						r.Corruptions++
+					} else if err != nil {
+						r.Errors = append(r.Errors, fmt.Errorf("worker %d: %w", w, err))
+					}
+				})
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	buf, err := s.Load(ctx, opts.Key)
+	if err != nil {
+		return rep, err
+	}
+	n, err := strconv.Atoi(string(buf))
+	if err != nil {
+		return rep, fmt.Errorf("counter object corrupted: %q", buf)
+	}
+	rep.LostUpdates = increments - n
+	return rep, s.Delete(ctx, opts.Key)
+}
+
+var errStressCorrupt = errors.New("read-back mismatch")
+
+// stressStep increments the counter at key, reporting whether the
+// incremented value was stored.
+func stressStep(ctx context.Context, s certmagic.Storage, key string) (bool, error) {
+	buf, err := s.Load(ctx, key)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	n, _ := strconv.Atoi(string(buf))
+	next := []byte(strconv.Itoa(n + 1))
+	if err := s.Store(ctx, key, next); err != nil {
+		return false, err
+	}
+	buf, err = s.Load(ctx, key)
+	if err != nil {
+		return true, err
+	}
+	if string(buf) != string(next) {
+		return true, errStressCorrupt
+	}
+	return true, nil
+}
@@ -0,0 +1,68 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sam-lord/certmagic"
+)
+
+func TestStressTest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping stress test in short mode")
+	}
+
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+
+	rep, err := StressTest(ctx, storage, StressOpts{
+		Workers:    4,
+		Iterations: 5,
+		Key:        "test/stress",
+	})
+	if err != nil {
+		t.Fatalf("StressTest() failed: %v", err)
+	}
+	if rep.Operations != 20 {
+		t.Errorf("StressTest() ran %d operations, expected 20", rep.Operations)
+	}
+	if !rep.OK() {
+		t.Errorf("StressTest() found violations: %+v", rep)
+	}
+}
+
+func TestStressTestInvalidOpts(t *testing.T) {
+	_, err := StressTest(context.Background(), nil, StressOpts{})
+	if err == nil {
+		t.Errorf("StressTest() expected error for zero workers")
+	}
+}
+
+// failingLocks fails every other Lock.
+type failingLocks struct {
+	certmagic.Storage
+	n atomic.Int32
+}
+
+func (s *failingLocks) Lock(ctx context.Context, key string) error {
+	if s.n.Add(1)%2 == 0 {
+		return errors.New("lock failed")
+	}
+	return s.Storage.Lock(ctx, key)
+}
+
+func TestStressTestFailedLocks(t *testing.T) {
+	s := &failingLocks{Storage: &certmagic.FileStorage{Path: t.TempDir()}}
+	rep, err := StressTest(context.Background(), s, StressOpts{Workers: 2, Iterations: 4})
+	if err != nil {
+		t.Fatalf("StressTest() failed: %v", err)
+	}
+	if len(rep.Errors) != 4 {
+		t.Errorf("StressTest() reported %d errors, expected 4 failed locks", len(rep.Errors))
+	}
+	if rep.LostUpdates != 0 {
+		t.Errorf("StressTest() counted %d failed locks as lost updates", rep.LostUpdates)
+	}
+}
@@ -0,0 +1,69 @@
+package cmgs3
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Tags are labels attached to a context with WithTags, such as the tenant
+// or the cause of an operation. They are added to log lines, audit records
+// and, if S3Opts.TagHeader is set, to the S3 requests made for it.
+type Tags map[string]string
+
+type tagsKey struct{}
+
+// WithTags returns a context carrying tags in addition to those of ctx.
+func WithTags(ctx context.Context, tags Tags) context.Context {
+	merged := make(Tags)
+	for k, v := range TagsFrom(ctx) {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, tagsKey{}, merged)
+}
+
+// TagsFrom returns the tags attached to ctx.
+func TagsFrom(ctx context.Context) Tags {
+	t, _ := ctx.Value(tagsKey{}).(Tags)
+	return t
+}
+
+// String formats the tags as sorted key=value pairs separated by commas.
+func (t Tags) String() string {
+	pairs := make([]string, 0, len(t))
+	for k, v := range t {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// logTags formats the tags of ctx for appending to a log line.
+func logTags(ctx context.Context) string {
+	t := TagsFrom(ctx)
+	if len(t) == 0 {
+		return ""
+	}
+	return " [" + t.String() + "]"
+}
+
+// tagTransport sends the tags of each request's context in a header. The
+// header is not signed, so it must not start with X-Amz-.
+type tagTransport struct {
+	header string
+	base   http.RoundTripper
+}
+
+func (t *tagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tags := TagsFrom(req.Context())
+	if len(tags) == 0 {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(t.header, tags.String())
+	return t.base.RoundTrip(req)
+}
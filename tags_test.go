@@ -0,0 +1,61 @@
+package cmgs3
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestWithTags(t *testing.T) {
+	ctx := WithTags(context.Background(), Tags{"tenant": "a", "cause": "renew"})
+	ctx = WithTags(ctx, Tags{"cause": "maintenance"})
+	if got := TagsFrom(ctx).String(); got != "cause=maintenance,tenant=a" {
+		t.Errorf("TagsFrom() = %q", got)
+	}
+	if got := logTags(context.Background()); got != "" {
+		t.Errorf("logTags() without tags = %q", got)
+	}
+}
+
+type recordingTransport struct{ req *http.Request }
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.req = req
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestTagTransport(t *testing.T) {
+	rec := &recordingTransport{}
+	tt := &tagTransport{header: "X-Request-Tags", base: rec}
+
+	ctx := WithTags(context.Background(), Tags{"tenant": "a"})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://example.com/", nil)
+	if _, err := tt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.req.Header.Get("X-Request-Tags"); got != "tenant=a" {
+		t.Errorf("tag header = %q, expected tenant=a", got)
+	}
+	if req.Header.Get("X-Request-Tags") != "" {
+		t.Error("RoundTrip() modified the caller's request")
+	}
+}
+
+func TestS3Storage_TagHeader(t *testing.T) {
+	opts := testOpts(false)
+	opts.TagHeader = "X-Request-Tags"
+	storage := setupTestStorageOpts(t, opts)
+	ctx := WithTags(context.Background(), Tags{"tenant": "a"})
+
+	if err := storage.Store(ctx, "test/tagged", []byte("value")); err != nil {
+		t.Fatalf("Store() with tags failed: %v", err)
+	}
+	if _, err := storage.Load(ctx, "test/tagged"); err != nil {
+		t.Fatalf("Load() with tags failed: %v", err)
+	}
+
+	opts.TagHeader = "X-Amz-Tags"
+	if _, err := NewS3Storage(opts); err == nil {
+		t.Error("NewS3Storage() expected error for X-Amz- tag header")
+	}
+}
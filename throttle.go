@@ -0,0 +1,110 @@
+package cmgs3
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SlowDownBackoff is the initial pause after a provider asks to slow down
+// without saying for how long. It doubles with every further request to
+// slow down, up to SlowDownMaxBackoff, and resets on success.
+var (
+	SlowDownBackoff    = time.Second
+	SlowDownMaxBackoff = time.Minute
+)
+
+// throttleTransport holds back all requests of a client for as long as the
+// provider asked after rate limiting one: AWS 503 SlowDown, 429 Too Many
+// Requests and Backblaze B2's transaction cap. These are still returned to
+// the client and retried by it, but the pause applies to every operation,
+// so bulk work throttles itself instead of being limited ever harder.
+type throttleTransport struct {
+	base http.RoundTripper
+
+	mu      sync.Mutex
+	until   time.Time
+	backoff time.Duration
+}
+
+func (t *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	wait := time.Until(t.until)
+	t.mu.Unlock()
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if !isSlowDown(resp) {
+		if resp.StatusCode < 300 {
+			t.mu.Lock()
+			t.backoff = 0
+			t.mu.Unlock()
+		}
+		return resp, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pause, ok := retryAfter(resp.Header.Get("Retry-After"))
+	if !ok {
+		t.backoff *= 2
+		if t.backoff == 0 {
+			t.backoff = SlowDownBackoff
+		}
+		if t.backoff > SlowDownMaxBackoff {
+			t.backoff = SlowDownMaxBackoff
+		}
+		pause = t.backoff
+	}
+	if until := time.Now().Add(pause); until.After(t.until) {
+		t.until = until
+	}
+	return resp, nil
+}
+
+// isSlowDown reports whether resp asks the client to send fewer requests.
+// Error bodies are small, the body is read and restored to tell.
+func isSlowDown(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable, http.StatusForbidden:
+	default:
+		return false
+	}
+	buf, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), resp.Body), resp.Body}
+	return bytes.Contains(buf, []byte("<Code>SlowDown</Code>")) ||
+		bytes.Contains(bytes.ToLower(buf), []byte("transaction cap exceeded"))
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
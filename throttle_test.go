@@ -0,0 +1,78 @@
+package cmgs3
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const slowDownBody = `<?xml version="1.0" encoding="UTF-8"?><Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`
+
+func TestRetryAfter(t *testing.T) {
+	if d, ok := retryAfter("3"); !ok || d != 3*time.Second {
+		t.Errorf("retryAfter(3) = %v, %v", d, ok)
+	}
+	if d, ok := retryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); !ok || d < 59*time.Minute {
+		t.Errorf("retryAfter(date) = %v, %v", d, ok)
+	}
+	if _, ok := retryAfter("soon"); ok {
+		t.Error("retryAfter(soon) expected failure")
+	}
+}
+
+func TestThrottleTransport(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, slowDownBody)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, slowDownBody)
+		case 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, "<Error><Code>InternalError</Code></Error>")
+		default:
+			io.WriteString(w, "ok")
+		}
+	}))
+	defer srv.Close()
+
+	backoff := SlowDownBackoff
+	SlowDownBackoff = 100 * time.Millisecond
+	defer func() { SlowDownBackoff = backoff }()
+	tt := &throttleTransport{base: http.DefaultTransport}
+	get := func() (*http.Response, time.Duration) {
+		start := time.Now()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		resp, err := tt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, time.Since(start)
+	}
+
+	resp, _ := get()
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "SlowDown") {
+		t.Errorf("response body not preserved: %q", body)
+	}
+	// Held back by Retry-After, then paused by the default backoff.
+	if _, d := get(); d < 900*time.Millisecond {
+		t.Errorf("request after Retry-After: 1 took %v", d)
+	}
+	if _, d := get(); d < 90*time.Millisecond {
+		t.Errorf("request after SlowDown took %v, expected backoff", d)
+	}
+	// Other 503s are left to the client's retries.
+	if _, d := get(); d > 90*time.Millisecond {
+		t.Errorf("request after InternalError took %v, expected no pause", d)
+	}
+}
@@ -0,0 +1,38 @@
+package cmgs3
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// TracePropagator adds the trace context of ctx to the headers of an S3
+// request, e.g. with OpenTelemetry:
+//
+//	func(ctx context.Context, h http.Header) {
+//		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+//	}
+type TracePropagator func(ctx context.Context, h http.Header)
+
+// traceTransport sends the headers of a TracePropagator with each request.
+// They are added after the request is signed, so X-Amz- headers, which S3
+// requires to be signed, are dropped.
+type traceTransport struct {
+	propagate TracePropagator
+	base      http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := make(http.Header)
+	t.propagate(req.Context(), h)
+	if len(h) == 0 {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for k, v := range h {
+		if !strings.HasPrefix(strings.ToLower(k), "x-amz-") {
+			req.Header[k] = v
+		}
+	}
+	return t.base.RoundTrip(req)
+}
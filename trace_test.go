@@ -0,0 +1,46 @@
+package cmgs3
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+type traceKey struct{}
+
+func TestTraceTransport(t *testing.T) {
+	rec := &recordingTransport{}
+	tt := &traceTransport{
+		propagate: func(ctx context.Context, h http.Header) {
+			if id, ok := ctx.Value(traceKey{}).(string); ok {
+				h.Set("Traceparent", id)
+				h.Set("X-Amz-Trace", id)
+			}
+		},
+		base: rec,
+	}
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := context.WithValue(context.Background(), traceKey{}, traceparent)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://example.com/", nil)
+	if _, err := tt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.req.Header.Get("Traceparent"); got != traceparent {
+		t.Errorf("traceparent = %q, expected %q", got, traceparent)
+	}
+	if rec.req.Header.Get("X-Amz-Trace") != "" {
+		t.Error("RoundTrip() sent an unsigned X-Amz- header")
+	}
+	if req.Header.Get("Traceparent") != "" {
+		t.Error("RoundTrip() modified the caller's request")
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if _, err := tt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if rec.req != req {
+		t.Error("RoundTrip() without trace context copied the request")
+	}
+}
@@ -0,0 +1,256 @@
+package cmgs3
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// newTransport returns the HTTP transport for opts. Its connections are
+// shared through pool, if not nil.
+func newTransport(opts S3Opts, clock *serverClock, budget *retryBudget, pool *transportPool) (http.RoundTripper, error) {
+	var base *http.Transport
+	var err error
+	if pool != nil {
+		base, err = pool.get(opts)
+	} else {
+		base, err = newBaseTransport(opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rt http.RoundTripper = &clockTransport{clock: clock, base: base}
+	if _, mrap := parseMRAP(opts.Bucket); mrap {
+		key, err := deriveSigV4AKey(opts.AccessKeyID, opts.SecretAccessKey)
+		if err != nil {
+			return nil, err
+		}
+		rt = &sigV4ATransport{accessKey: opts.AccessKeyID, sessionToken: opts.SessionToken, key: key, clock: clock, base: rt}
+	}
+	if opts.TagHeader != "" {
+		if strings.HasPrefix(strings.ToLower(opts.TagHeader), "x-amz-") {
+			return nil, errors.New("tag header must not start with X-Amz-")
+		}
+		rt = &tagTransport{header: opts.TagHeader, base: rt}
+	}
+	if opts.TracePropagator != nil {
+		rt = &traceTransport{propagate: opts.TracePropagator, base: rt}
+	}
+	if opts.AdaptiveConcurrency {
+		limit := opts.MaxConcurrentRequests
+		if limit <= 0 {
+			limit = AdaptiveMaxRequests
+		}
+		rt = &adaptiveTransport{limiter: newAIMDLimiter(limit), base: rt}
+	} else if opts.MaxConcurrentRequests > 0 {
+		rt = &limitTransport{gate: &priorityGate{limit: opts.MaxConcurrentRequests}, base: rt}
+	}
+	// Outside the limit, so requests held back do not occupy request slots.
+	rt = &throttleTransport{base: rt}
+	if budget != nil {
+		rt = &budgetTransport{budget: budget, base: rt}
+	}
+	return rt, nil
+}
+
+// newBaseTransport returns the transport making the connections for opts.
+func newBaseTransport(opts S3Opts) (*http.Transport, error) {
+	base, err := minio.DefaultTransport(true)
+	if err != nil {
+		return nil, err
+	}
+	family, err := ipFamily(opts.IPFamily)
+	if err != nil {
+		return nil, err
+	}
+	ips := ipsOfFamily(opts.EndpointIPs, family)
+	if len(opts.EndpointIPs) != 0 && len(ips) == 0 {
+		return nil, fmt.Errorf("no endpoint IPs of family %s", opts.IPFamily)
+	}
+	if opts.Resolver != nil || len(ips) != 0 || family != "" || opts.FallbackDelay != 0 {
+		base.DialContext = endpointDialer(opts.Resolver, ips, family, opts.FallbackDelay)
+	}
+	if len(opts.PinnedSPKI) != 0 {
+		verify, err := verifyPins(opts.PinnedSPKI)
+		if err != nil {
+			return nil, err
+		}
+		base.TLSClientConfig.VerifyConnection = verify
+	}
+	return base, nil
+}
+
+// transportPool shares the base transports, and so the connection pools,
+// of storages connecting to the same endpoint the same way.
+type transportPool struct {
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+// sharedTransports are the transports of all storages of the process
+// without S3Opts.DedicatedTransport.
+var sharedTransports transportPool
+
+func (p *transportPool) get(opts S3Opts) (*http.Transport, error) {
+	key := fmt.Sprintf("%s|%p|%q|%s|%d|%q", opts.Endpoint, opts.Resolver, opts.EndpointIPs,
+		opts.IPFamily, opts.FallbackDelay, opts.PinnedSPKI)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t := p.transports[key]; t != nil {
+		return t, nil
+	}
+	t, err := newBaseTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+	if p.transports == nil {
+		p.transports = make(map[string]*http.Transport)
+	}
+	p.transports[key] = t
+	return t, nil
+}
+
+// ipFamily returns the network suffix of S3Opts.IPFamily, "4" or "6", or
+// nothing for both.
+func ipFamily(family string) (string, error) {
+	switch family {
+	case "":
+		return "", nil
+	case "ipv4":
+		return "4", nil
+	case "ipv6":
+		return "6", nil
+	}
+	return "", fmt.Errorf("invalid IP family %q", family)
+}
+
+// ipsOfFamily returns the ips of family, all of them if it is empty.
+func ipsOfFamily(ips []string, family string) []string {
+	if family == "" {
+		return ips
+	}
+	var out []string
+	for _, ip := range ips {
+		addr := net.ParseIP(ip)
+		if addr != nil && (addr.To4() != nil) == (family == "4") {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// endpointDialer dials the given IPs, in order, instead of resolving the
+// host, or resolves it with resolver. TLS still verifies the host name.
+// Connections are restricted to family if set; otherwise resolved hosts
+// are dialed over IPv6 and IPv4 in parallel after fallback, as in Happy
+// Eyeballs.
+func endpointDialer(resolver *net.Resolver, ips []string, family string, fallback time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{
+		Timeout:       30 * time.Second,
+		KeepAlive:     30 * time.Second,
+		Resolver:      resolver,
+		FallbackDelay: fallback,
+	}
+	dial := d.DialContext
+	if family != "" {
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if network == "tcp" {
+				network += family
+			}
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	if len(ips) == 0 {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		var errs []error
+		for _, ip := range ips {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+// SPKIPin returns the pin of cert for S3Opts.PinnedSPKI: the base64
+// encoded SHA-256 of its SubjectPublicKeyInfo.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verifyPins returns a TLS VerifyConnection accepting only verified chains
+// that contain a certificate with one of pins. Unverified certificates the
+// peer sends along are not considered, as anyone can send those.
+func verifyPins(pins []string) (func(tls.ConnectionState) error, error) {
+	want := make(map[string]bool, len(pins))
+	for _, p := range pins {
+		if b, err := base64.StdEncoding.DecodeString(p); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid SPKI pin %q", p)
+		}
+		want[p] = true
+	}
+	return func(cs tls.ConnectionState) error {
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				if want[SPKIPin(cert)] {
+					return nil
+				}
+			}
+		}
+		return errors.New("endpoint certificate matches no pinned SPKI")
+	}, nil
+}
+
+// limitTransport bounds the number of requests in flight, handing slots to
+// waiting requests by Priority. A request counts until its response body
+// is closed, as downloads stream from it.
+type limitTransport struct {
+	gate *priorityGate
+	base http.RoundTripper
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.gate.acquire(req.Context(), PriorityFrom(req.Context())); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.gate.release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: t.gate.release}
+	return resp, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
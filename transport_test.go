@@ -0,0 +1,252 @@
+package cmgs3
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type slowTransport struct {
+	active, peak atomic.Int32
+}
+
+func (st *slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := st.active.Add(1)
+	for {
+		p := st.peak.Load()
+		if n <= p || st.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	st.active.Add(-1)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestLimitTransport(t *testing.T) {
+	base := &slowTransport{}
+	lt := &limitTransport{gate: &priorityGate{limit: 2}, base: base}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+			resp, err := lt.RoundTrip(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	if p := base.peak.Load(); p > 2 {
+		t.Errorf("%d requests in flight, expected at most 2", p)
+	}
+
+	// A full limit gives way to the request's context.
+	lt.gate.acquire(context.Background(), PriorityCritical)
+	lt.gate.acquire(context.Background(), PriorityCritical)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://example.com/", nil)
+	if _, err := lt.RoundTrip(req); err != context.DeadlineExceeded {
+		t.Errorf("RoundTrip() error = %v, expected deadline exceeded", err)
+	}
+}
+
+func TestS3Storage_MaxConcurrentRequests(t *testing.T) {
+	opts := testOpts(true)
+	opts.MaxConcurrentRequests = 1
+	storage := setupTestStorageOpts(t, opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("test/limited/%d", i)
+			if err := storage.Lock(ctx, key); err != nil {
+				t.Errorf("Lock() failed: %v", err)
+				return
+			}
+			defer storage.Unlock(ctx, key)
+			if err := storage.Store(ctx, key, []byte(key)); err != nil {
+				t.Errorf("Store() failed: %v", err)
+			}
+			if _, err := storage.Load(ctx, key); err != nil {
+				t.Errorf("Load() failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestEndpointDialer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ctx := context.Background()
+
+	// Nothing listens on 127.0.0.2, the mapping falls through to the next IP.
+	conn, err := endpointDialer(nil, []string{"127.0.0.2", "127.0.0.1"}, "", 0)(ctx, "tcp", net.JoinHostPort("s3.unresolvable.invalid", port))
+	if err != nil {
+		t.Fatalf("dialing mapped IPs failed: %v", err)
+	}
+	conn.Close()
+
+	errResolve := errors.New("resolver used")
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errResolve
+		},
+	}
+	if _, err := endpointDialer(resolver, nil, "", 0)(ctx, "tcp", net.JoinHostPort("s3.example.com", port)); err == nil || !strings.Contains(err.Error(), errResolve.Error()) {
+		t.Errorf("dial error = %v, expected custom resolver to be used", err)
+	}
+}
+
+func TestEndpointDialer_IPFamily(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	ctx := context.Background()
+
+	if _, err := endpointDialer(nil, nil, "6", 0)(ctx, "tcp", ln.Addr().String()); err == nil {
+		t.Error("dialing an IPv4 address restricted to IPv6 succeeded")
+	}
+	conn, err := endpointDialer(nil, nil, "4", 0)(ctx, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing an IPv4 address restricted to IPv4 failed: %v", err)
+	}
+	conn.Close()
+
+	ips := []string{"127.0.0.1", "::1", "192.0.2.1", "2001:db8::1"}
+	if got := ipsOfFamily(ips, "6"); strings.Join(got, " ") != "::1 2001:db8::1" {
+		t.Errorf("ipsOfFamily(6) = %v", got)
+	}
+	if got := ipsOfFamily(ips, ""); len(got) != len(ips) {
+		t.Errorf("ipsOfFamily() = %v, expected all IPs", got)
+	}
+
+	for _, opts := range []S3Opts{
+		{IPFamily: "ip6"},
+		{IPFamily: "ipv6", EndpointIPs: []string{"127.0.0.1"}},
+	} {
+		if _, err := newTransport(opts, &serverClock{}, nil, nil); err == nil {
+			t.Errorf("newTransport() with IPFamily %q and EndpointIPs %v succeeded", opts.IPFamily, opts.EndpointIPs)
+		}
+	}
+}
+
+func TestS3Storage_EndpointIPs(t *testing.T) {
+	opts := testOpts(false)
+	if _, err := NewS3Storage(opts); err != nil {
+		t.Skipf("Skipping test due to S3 setup error: %v", err)
+	}
+	host := strings.Split(testEndpoint, ":")[0]
+	addrs, err := net.LookupHost(host)
+	if err != nil || len(addrs) == 0 {
+		t.Skipf("cannot resolve %s: %v", host, err)
+	}
+	opts.EndpointIPs = addrs
+	storage := setupTestStorageOpts(t, opts)
+	if err := storage.Store(context.Background(), "test/mapped", []byte("value")); err != nil {
+		t.Errorf("Store() through mapped endpoint IPs failed: %v", err)
+	}
+}
+
+func TestVerifyPins(t *testing.T) {
+	if _, err := verifyPins([]string{"not a pin"}); err == nil {
+		t.Error("verifyPins() expected error for malformed pin")
+	}
+}
+
+func TestS3Storage_PinnedSPKI(t *testing.T) {
+	if _, err := NewS3Storage(testOpts(false)); err != nil {
+		t.Skipf("Skipping test due to S3 setup error: %v", err)
+	}
+	conn, err := tls.Dial("tcp", net.JoinHostPort(testEndpoint, "443"), nil)
+	if err != nil {
+		t.Skipf("cannot connect to endpoint: %v", err)
+	}
+	chain := conn.ConnectionState().VerifiedChains[0]
+	conn.Close()
+
+	opts := testOpts(false)
+	opts.PinnedSPKI = []string{SPKIPin(chain[len(chain)-1])}
+	setupTestStorageOpts(t, opts)
+
+	opts.PinnedSPKI = []string{base64.StdEncoding.EncodeToString(make([]byte, 32))}
+	opts.MaxRetries = 1
+	if _, err := NewS3Storage(opts); err == nil {
+		t.Error("NewS3Storage() succeeded with an endpoint not matching the pin")
+	}
+}
+
+func TestNewS3Storage_SharedTransport(t *testing.T) {
+	opts := testOpts(false)
+	opts.LazyInit = true
+	a, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	opts.ObjPrefix = "other"
+	b, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	if a.transports != &sharedTransports || b.transports != &sharedTransports {
+		t.Error("storages do not share their transports")
+	}
+	shared, _ := sharedTransports.get(opts)
+	if base, _ := sharedTransports.get(testOpts(false)); base != shared {
+		t.Error("storages of the same endpoint got different transports")
+	}
+
+	opts.DedicatedTransport = true
+	c, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("NewS3Storage() failed: %v", err)
+	}
+	if c.transports != nil {
+		t.Error("storage with DedicatedTransport uses the shared transports")
+	}
+}
@@ -0,0 +1,60 @@
+package cmgs3
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+// UndecryptablePolicy is how Load and List treat values sealed with a key
+// this instance does not have, e.g. while a key rotation is rolled out
+// across a fleet. See S3Opts.Undecryptable.
+type UndecryptablePolicy uint8
+
+const (
+	// UndecryptableFail has Load return ErrDecryptFailed.
+	UndecryptableFail UndecryptablePolicy = iota
+	// UndecryptableSkip has Load log the key and return ErrDecryptFailed,
+	// and listings leave out keys sealed with an unknown key, logging them.
+	UndecryptableSkip
+	// UndecryptableMissing has Load report such values as fs.ErrNotExist,
+	// wrapped with ErrDecryptFailed, and listings leave them out, as if
+	// they did not exist.
+	UndecryptableMissing
+)
+
+// undecryptable applies the policy to err, a failure to open the value of
+// key.
+func (gs *S3Storage) undecryptable(key string, err error) error {
+	if !errors.Is(err, ErrDecryptFailed) {
+		return err
+	}
+	switch gs.undecryptablePolicy {
+	case UndecryptableSkip:
+		gs.logf("Cannot decrypt %s, the key may not be rolled out yet: %v", key, err)
+	case UndecryptableMissing:
+		return fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	}
+	return err
+}
+
+// sealedWithUnknownKey reports whether a listed object carries the key ID
+// of a key this instance does not have. Only providers returning metadata
+// in listings (MinIO) tell; objects without a key ID are not reported.
+func (gs *S3Storage) sealedWithUnknownKey(obj minio.ObjectInfo) bool {
+	id := obj.UserMetadata[metaKeyID]
+	if id == "" {
+		return false
+	}
+	for _, k := range gs.openKeys(gs.keyName(obj.Key)) {
+		if s, ok := k.(sealer); ok && keyID(s.secretKey()) == id {
+			return false
+		}
+	}
+	if gs.undecryptablePolicy == UndecryptableSkip {
+		gs.logf("Skipping %s, sealed with unknown key %s", obj.Key, id)
+	}
+	return true
+}
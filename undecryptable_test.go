@@ -0,0 +1,63 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+func TestS3Storage_Undecryptable(t *testing.T) {
+	other := func(policy UndecryptablePolicy) *S3Storage {
+		opts := testOpts(true)
+		opts.EncryptionKey = []byte("abcdefghabcdefghabcdefghabcdefgh")
+		opts.Undecryptable = policy
+		return setupTestStorageOpts(t, opts)
+	}
+	fail, skip, missing := other(UndecryptableFail), other(UndecryptableSkip), other(UndecryptableMissing)
+	storage := setupTestStorage(t, true)
+	ctx := context.Background()
+	if err := storage.Store(ctx, "test/undecryptable", []byte("secret")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+	if err := skip.Store(ctx, "test/decryptable", []byte("value")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		storage *S3Storage
+		missing bool
+		listed  bool
+	}{
+		{"fail", fail, false, true},
+		{"skip", skip, false, false},
+		{"missing", missing, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.storage.Load(ctx, "test/undecryptable")
+			if !errors.Is(err, ErrDecryptFailed) || errors.Is(err, fs.ErrNotExist) != tt.missing {
+				t.Errorf("Load() error = %v", err)
+			}
+			keys, err := tt.storage.List(ctx, "test", true)
+			if err != nil {
+				t.Fatalf("List() failed: %v", err)
+			}
+			if slices.Contains(keys, "test/undecryptable") != tt.listed || !slices.Contains(keys, "test/decryptable") {
+				t.Errorf("List() = %v", keys)
+			}
+			var iterKeys []string
+			for k, err := range tt.storage.ListIter(ctx, "test", true) {
+				if err != nil {
+					t.Fatalf("ListIter() failed: %v", err)
+				}
+				iterKeys = append(iterKeys, k)
+			}
+			if slices.Contains(iterKeys, "test/undecryptable") != tt.listed {
+				t.Errorf("ListIter() = %v", iterKeys)
+			}
+		})
+	}
+}
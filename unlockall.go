@@ -0,0 +1,61 @@
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// unlockAllTimeout bounds releasing the held locks on shutdown.
+const unlockAllTimeout = 5 * time.Second
+
+// UnlockAll releases all locks held by this instance.
+func (gs *S3Storage) UnlockAll(ctx context.Context) error {
+	var errs []error
+	for _, l := range gs.HeldLocks() {
+		errs = append(errs, gs.Unlock(ctx, l.Key))
+	}
+	return errors.Join(errs...)
+}
+
+// ReleaseLocksOnSignal releases the held locks when the process receives
+// one of sigs, SIGINT and SIGTERM by default, and then re-raises the
+// signal with the default handling restored, terminating the process.
+// It is meant for programs that do not handle these signals themselves;
+// those should Close the storage with UnlockOnClose during shutdown
+// instead. The returned function removes the handler.
+func (gs *S3Storage) ReleaseLocksOnSignal(sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+	go func() {
+		select {
+		case <-done:
+			return
+		case sig := <-ch:
+			ctx, cancel := context.WithTimeout(context.Background(), unlockAllTimeout)
+			if err := gs.UnlockAll(ctx); err != nil {
+				gs.logf("Releasing locks on %v failed: %v", sig, err)
+			}
+			cancel()
+			signal.Reset(sigs...)
+			if p, err := os.FindProcess(os.Getpid()); err != nil || p.Signal(sig) != nil {
+				os.Exit(2)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
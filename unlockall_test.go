@@ -0,0 +1,28 @@
+package cmgs3
+
+import (
+	"context"
+	"testing"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestS3Storage_UnlockOnClose(t *testing.T) {
+	opts := testOpts(false)
+	opts.UnlockOnClose = true
+	storage := setupTestStorageOpts(t, opts)
+	ctx := context.Background()
+
+	if err := storage.Lock(ctx, "test/unlock-on-close"); err != nil {
+		t.Fatalf("Lock() failed: %v", err)
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, err := storage.s3client.StatObject(ctx, testBucket, storage.objLockName("test/unlock-on-close"), minio.StatObjectOptions{}); err == nil {
+		t.Error("Close() left the lock file behind")
+	}
+	if locks := storage.HeldLocks(); len(locks) != 0 {
+		t.Errorf("HeldLocks() after Close() = %v", locks)
+	}
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+package cmgs3
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio-go/v7"
+)
+
+func TestS3Storage_ReleaseLocksOnSignal(t *testing.T) {
+	storage := setupTestStorage(t, false)
+	ctx := context.Background()
+	if os.Getenv("CMGS3_SIGNAL_CHILD") == "1" {
+		if err := storage.Lock(ctx, "test/unlock-on-signal"); err != nil {
+			t.Fatalf("Lock() failed: %v", err)
+		}
+		storage.ReleaseLocksOnSignal()
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		time.Sleep(10 * time.Second)
+		t.Fatal("process survived SIGTERM")
+	}
+
+	stop := storage.ReleaseLocksOnSignal()
+	stop()
+	stop()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestS3Storage_ReleaseLocksOnSignal$")
+	cmd.Env = append(os.Environ(), "CMGS3_SIGNAL_CHILD=1")
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Sys().(syscall.WaitStatus).Signal() != syscall.SIGTERM {
+		t.Fatalf("child did not terminate by SIGTERM: %v\n%s", err, out)
+	}
+	if _, err := storage.s3client.StatObject(ctx, testBucket, storage.objLockName("test/unlock-on-signal"), minio.StatObjectOptions{}); err == nil {
+		t.Error("the signal handler left the lock file behind")
+	}
+}
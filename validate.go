@@ -0,0 +1,156 @@
+package cmgs3
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+)
+
+// ConfigError is a problem with the S3Opts field Field.
+type ConfigError struct {
+	Field string
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// Validate reports the problems of opts that show without contacting S3,
+// all of them joined, each as a *ConfigError. NewS3Storage validates the
+// options first.
+func (opts S3Opts) Validate() error {
+	var errs []error
+	invalid := func(field string, err error) {
+		errs = append(errs, &ConfigError{Field: field, Err: err})
+	}
+	applied, _, err := applyProvider(opts)
+	if err != nil {
+		invalid("Provider", err)
+	} else {
+		opts = applied
+	}
+
+	_, mrap := parseMRAP(opts.Bucket)
+	if opts.Endpoint == "" && !mrap {
+		invalid("Endpoint", errors.New("required"))
+	} else if opts.Endpoint != "" {
+		if err := checkEndpoint(opts.Endpoint); err != nil {
+			invalid("Endpoint", err)
+		}
+	}
+	if !mrap {
+		if err := s3utils.CheckValidBucketName(opts.Bucket); err != nil {
+			invalid("Bucket", err)
+		}
+	}
+	for i, rr := range opts.ReadReplicas {
+		if rr.Endpoint == "" || rr.Bucket == "" {
+			invalid(fmt.Sprintf("ReadReplicas[%d]", i), errors.New("read replicas need an endpoint and a bucket"))
+		}
+		if _, err := verifyPins(rr.PinnedSPKI); err != nil {
+			invalid(fmt.Sprintf("ReadReplicas[%d].PinnedSPKI", i), err)
+		}
+	}
+	if opts.CredentialChain && mrap {
+		invalid("CredentialChain", errors.New("cannot be used with Multi-Region Access Points, which are signed with the static keys"))
+	}
+	if opts.TransferAcceleration {
+		if mrap || !s3utils.IsAmazonEndpoint(url.URL{Host: opts.Endpoint}) {
+			invalid("TransferAcceleration", errors.New("transfer acceleration requires an AWS S3 endpoint"))
+		} else if strings.Contains(opts.Bucket, ".") {
+			invalid("TransferAcceleration", errors.New("transfer acceleration does not support bucket names with dots"))
+		}
+	}
+	family, err := ipFamily(opts.IPFamily)
+	if err != nil {
+		invalid("IPFamily", err)
+	} else if len(opts.EndpointIPs) != 0 && len(ipsOfFamily(opts.EndpointIPs, family)) == 0 {
+		invalid("EndpointIPs", fmt.Errorf("no endpoint IPs of family %s", opts.IPFamily))
+	}
+	if opts.DisableDualStack && opts.IPFamily == "ipv6" {
+		invalid("DisableDualStack", errors.New("IPv6 needs the dual-stack endpoints"))
+	}
+	if _, err := verifyPins(opts.PinnedSPKI); err != nil {
+		invalid("PinnedSPKI", err)
+	}
+	if strings.HasPrefix(strings.ToLower(opts.TagHeader), "x-amz-") {
+		invalid("TagHeader", errors.New("tag header must not start with X-Amz-"))
+	}
+
+	if opts.LockPrefix != "" && strings.HasPrefix(opts.LockPrefix+"/", opts.ObjPrefix+"/") {
+		invalid("LockPrefix", errors.New("lock prefix must not be within the object prefix"))
+	}
+	if opts.ProtectForeign && opts.ClusterID == "" {
+		invalid("ProtectForeign", errors.New("protecting foreign objects requires a cluster ID"))
+	}
+	if opts.Bundle && opts.Conflict != ConflictOverwrite {
+		invalid("Bundle", errors.New("bundles only support ConflictOverwrite"))
+	}
+	if opts.MultipartThreshold != 0 && opts.MultipartThreshold < minPartSize {
+		invalid("MultipartThreshold", errors.New("multipart threshold must be at least 5 MiB"))
+	}
+	if f := opts.InventoryFormat; f != "" && f != "csv" && f != "json" {
+		invalid("InventoryFormat", fmt.Errorf("unsupported inventory format %q", f))
+	}
+	if opts.ArchivePrefix != "" || opts.ArchiveStorageClass != "" {
+		if opts.Bundle {
+			invalid("Bundle", errors.New("bundled certificates cannot be archived"))
+		}
+		if p := strings.Trim(opts.ArchivePrefix, "/"); p == "certificates" || strings.HasPrefix(p, "certificates/") {
+			invalid("ArchivePrefix", errors.New("archive prefix must not be within the certificates"))
+		}
+	}
+	if _, err := parseChecksum(opts.Checksum); err != nil {
+		invalid("Checksum", err)
+	}
+
+	key := opts.EncryptionKey
+	keyOK := true
+	if opts.EncryptionKeyFile != "" {
+		if len(key) != 0 {
+			invalid("EncryptionKeyFile", errors.New("only one of encryption key and encryption key file may be set"))
+		} else if keys, _, err := readKeyFile(opts.EncryptionKeyFile); err != nil {
+			invalid("EncryptionKeyFile", err)
+			keyOK = false
+		} else {
+			key = keys[0]
+		}
+	} else if len(key) != 0 && len(key) != 32 {
+		invalid("EncryptionKey", errors.New("encryption key must have exactly 32 bytes"))
+		keyOK = false
+	}
+	for i, prev := range opts.PreviousEncryptionKeys {
+		if len(prev) != 32 {
+			invalid(fmt.Sprintf("PreviousEncryptionKeys[%d]", i), errors.New("encryption key must have exactly 32 bytes"))
+		} else if len(key) == 0 {
+			invalid(fmt.Sprintf("PreviousEncryptionKeys[%d]", i), errors.New("previous keys need an encryption key"))
+		}
+	}
+	if keyOK {
+		if _, _, err := newNamespaceKeys(opts.NamespaceEncryption, key, opts.FIPSMode || fipsRequired()); err != nil {
+			invalid("NamespaceEncryption", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkEndpoint checks that endpoint is a host name or IP address with an
+// optional port, as the S3 client takes it.
+func checkEndpoint(endpoint string) error {
+	u, err := url.Parse("https://" + endpoint)
+	if err != nil || u.Path != "" || u.RawQuery != "" || u.User != nil {
+		return fmt.Errorf("%q is not a host name with an optional port", endpoint)
+	}
+	if host := u.Hostname(); !s3utils.IsValidDomain(host) && !s3utils.IsValidIP(host) {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	return nil
+}
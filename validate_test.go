@@ -0,0 +1,66 @@
+package cmgs3
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestS3Opts_Validate(t *testing.T) {
+	if err := testOpts(true).Validate(); err != nil {
+		t.Errorf("Validate() of the test options = %v", err)
+	}
+
+	opts := S3Opts{
+		Endpoint:           "https://" + testEndpoint + "/",
+		EncryptionKey:      []byte("short"),
+		EncryptionKeyFile:  filepath.Join(t.TempDir(), "missing"),
+		MultipartThreshold: 1 << 20,
+		InventoryFormat:    "xml",
+		ArchivePrefix:      "certificates/archive",
+		IPFamily:           "ipv5",
+	}
+	err := opts.Validate()
+	var fields []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var ce *ConfigError
+		if !errors.As(e, &ce) {
+			t.Fatalf("Validate() returned %T, expected *ConfigError", e)
+		}
+		fields = append(fields, ce.Field)
+	}
+	want := []string{"Endpoint", "Bucket", "IPFamily", "MultipartThreshold", "InventoryFormat", "ArchivePrefix", "EncryptionKeyFile"}
+	if len(fields) != len(want) {
+		t.Fatalf("Validate() reported %v, expected %v", fields, want)
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Errorf("Validate() reported %v, expected %v", fields, want)
+			break
+		}
+	}
+
+	opts = testOpts(false)
+	opts.Provider = "nonexistent"
+	opts.Checksum = "md4"
+	var ce *ConfigError
+	if err := opts.Validate(); !errors.As(err, &ce) || ce.Field != "Provider" {
+		t.Errorf("Validate() with an unknown provider = %v", err)
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	for endpoint, ok := range map[string]bool{
+		"s3.amazonaws.com":         true,
+		"minio.internal:9000":      true,
+		"10.0.0.1:9000":            true,
+		"https://s3.amazonaws.com": false,
+		"s3.amazonaws.com/bucket":  false,
+		"user@s3.amazonaws.com":    false,
+		"bad host":                 false,
+	} {
+		if err := checkEndpoint(endpoint); (err == nil) != ok {
+			t.Errorf("checkEndpoint(%q) = %v", endpoint, err)
+		}
+	}
+}